@@ -17,10 +17,13 @@ limitations under the License.
 package backend
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
@@ -29,6 +32,7 @@ import (
 	"k8s.io/kubernetes/pkg/kubelet/util"
 
 	"github.com/Mirantis/cri-dockerd/core"
+	"github.com/Mirantis/cri-dockerd/metrics"
 )
 
 // maxMsgSize use 16MB as the default message size limit.
@@ -79,9 +83,11 @@ func (s *CriDockerService) Start() error {
 		return fmt.Errorf("cri-dockerd failed to listen on %q: %v", s.endpoint, err)
 	}
 	// Create the grpc backend and register runtime and image services.
+	metrics.Register()
 	s.server = grpc.NewServer(
 		grpc.MaxRecvMsgSize(maxMsgSize),
 		grpc.MaxSendMsgSize(maxMsgSize),
+		grpc.ChainUnaryInterceptor(instrumentUnary, translateErrors),
 	)
 
 	runtimeapi.RegisterRuntimeServiceServer(s.server, s.service)
@@ -101,3 +107,60 @@ func (s *CriDockerService) Start() error {
 	handleNotify()
 	return nil
 }
+
+// instrumentUnary records the latency and the outcome of the CRI calls.
+func instrumentUnary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	metrics.CRIOperationsLatency.WithLabelValues(
+		path.Base(info.FullMethod),
+		outcome,
+	).Observe(
+		metrics.SinceInSeconds(start),
+	)
+	return resp, err
+}
+
+// translateErrors maps the errors returned by the CRI calls to their gRPC
+// codes, as the docker client errors would otherwise all surface as Unknown.
+func translateErrors(
+	ctx context.Context,
+	req interface{},
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	return resp, core.ToGRPCError(err)
+}
+
+// Stop stops the cri-dockerd grpc backend. New calls are refused right away,
+// while in-flight calls are given up to the grace period to complete. The
+// calls still running after that are cancelled.
+func (s *CriDockerService) Stop(gracePeriod time.Duration) {
+	if s.server == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		logrus.Info("All in-flight calls completed")
+	case <-time.After(gracePeriod):
+		logrus.Warningf("In-flight calls did not complete within %v, cancelling them", gracePeriod)
+		s.server.Stop()
+		<-stopped
+	}
+	s.service.Stop()
+}
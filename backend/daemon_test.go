@@ -0,0 +1,210 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics/testutil"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/core"
+	"github.com/Mirantis/cri-dockerd/metrics"
+)
+
+// slowPullService is a docker service whose image pulls take pullDuration, or
+// until they are cancelled.
+type slowPullService struct {
+	core.DockerService
+	pullDuration time.Duration
+	pulling      chan struct{}
+	pullResult   chan error
+}
+
+func (s *slowPullService) Start() error {
+	return nil
+}
+
+func (s *slowPullService) Stop() {}
+
+func (s *slowPullService) PullImage(
+	ctx context.Context,
+	r *runtimeapi.PullImageRequest,
+) (*runtimeapi.PullImageResponse, error) {
+	close(s.pulling)
+	select {
+	case <-time.After(s.pullDuration):
+		s.pullResult <- nil
+		return &runtimeapi.PullImageResponse{ImageRef: r.GetImage().GetImage()}, nil
+	case <-ctx.Done():
+		s.pullResult <- ctx.Err()
+		return nil, ctx.Err()
+	}
+}
+
+func TestStopGracePeriod(t *testing.T) {
+	for desc, test := range map[string]struct {
+		pullDuration  time.Duration
+		gracePeriod   time.Duration
+		expectedCode  codes.Code
+		expectedError error
+	}{
+		"pull completes within the grace period": {
+			pullDuration: 100 * time.Millisecond,
+			gracePeriod:  10 * time.Second,
+			expectedCode: codes.OK,
+		},
+		"pull cancelled after the grace period": {
+			pullDuration:  time.Hour,
+			gracePeriod:   100 * time.Millisecond,
+			expectedCode:  codes.Unavailable,
+			expectedError: context.Canceled,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		service := &slowPullService{
+			pullDuration: test.pullDuration,
+			pulling:      make(chan struct{}),
+			pullResult:   make(chan error, 1),
+		}
+		endpoint := "unix://" + filepath.Join(t.TempDir(), "cri-dockerd.sock")
+		server := NewCriDockerServer(endpoint, service)
+		require.NoError(t, server.Start())
+
+		conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		client := runtimeapi.NewImageServiceClient(conn)
+
+		pullErr := make(chan error, 1)
+		go func() {
+			_, err := client.PullImage(context.Background(), &runtimeapi.PullImageRequest{
+				Image: &runtimeapi.ImageSpec{Image: "busybox"},
+			})
+			pullErr <- err
+		}()
+		<-service.pulling
+
+		start := time.Now()
+		server.Stop(test.gracePeriod)
+		assert.Less(t, time.Since(start), test.gracePeriod+time.Second)
+
+		// The in-flight pull either completed or saw its context cancelled.
+		assert.Equal(t, test.expectedError, <-service.pullResult)
+		assert.Equal(t, test.expectedCode, status.Code(<-pullErr))
+
+		// New calls are refused once stopped.
+		_, err = client.PullImage(context.Background(), &runtimeapi.PullImageRequest{
+			Image: &runtimeapi.ImageSpec{Image: "busybox"},
+		})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		conn.Close()
+	}
+}
+
+// versionService is a docker service which only answers Version, and fails to
+// remove images.
+type versionService struct {
+	core.DockerService
+}
+
+func (s *versionService) Start() error {
+	return nil
+}
+
+func (s *versionService) Stop() {}
+
+func (s *versionService) Version(
+	_ context.Context,
+	_ *runtimeapi.VersionRequest,
+) (*runtimeapi.VersionResponse, error) {
+	return &runtimeapi.VersionResponse{RuntimeName: "docker"}, nil
+}
+
+func (s *versionService) RemoveImage(
+	_ context.Context,
+	_ *runtimeapi.RemoveImageRequest,
+) (*runtimeapi.RemoveImageResponse, error) {
+	return nil, fmt.Errorf("image is in use")
+}
+
+func TestCRIOperationsMetrics(t *testing.T) {
+	endpoint := "unix://" + filepath.Join(t.TempDir(), "cri-dockerd.sock")
+	server := NewCriDockerServer(endpoint, &versionService{})
+	require.NoError(t, server.Start())
+	defer server.Stop(0)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go metrics.Serve(l)
+
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	runtimeClient := runtimeapi.NewRuntimeServiceClient(conn)
+	imageClient := runtimeapi.NewImageServiceClient(conn)
+
+	for i := 0; i < 2; i++ {
+		_, err = runtimeClient.Version(context.Background(), &runtimeapi.VersionRequest{})
+		require.NoError(t, err)
+	}
+	_, err = imageClient.RemoveImage(context.Background(), &runtimeapi.RemoveImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "busybox"},
+	})
+	require.Error(t, err)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", l.Addr()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	families, err := testutil.TextToMetricFamilies(resp.Body)
+	require.NoError(t, err)
+	family, ok := families["cri_dockerd_"+metrics.CRIOperationsLatencyKey]
+	require.True(t, ok, "missing CRI operations metrics")
+
+	counts := map[string]uint64{}
+	for _, m := range family.GetMetric() {
+		var operation, outcome string
+		for _, label := range m.GetLabel() {
+			switch label.GetName() {
+			case "operation":
+				operation = label.GetValue()
+			case "outcome":
+				outcome = label.GetValue()
+			}
+		}
+		counts[operation+"/"+outcome] = m.GetHistogram().GetSampleCount()
+	}
+	assert.Equal(t, uint64(2), counts["Version/success"])
+	assert.Equal(t, uint64(1), counts["RemoveImage/error"])
+	assert.NotContains(t, counts, "Version/error")
+}
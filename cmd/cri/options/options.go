@@ -24,6 +24,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/network"
 
 	"github.com/spf13/pflag"
 )
@@ -121,9 +123,27 @@ func NewContainerRuntimeOptions() *config.ContainerRuntimeOptions {
 		ImagePullProgressDeadline: metav1.Duration{Duration: 1 * time.Minute},
 		NetworkPluginName:         "cni",
 
+		AllowedProcMountTypes:       []string{"Default"},
+		ContainerCreateRetries:      3,
+		ContainerCreateRetryBackoff: metav1.Duration{Duration: 100 * time.Millisecond},
+		ContainerStartRetries:       3,
+		ContainerStartRetryBackoff:  metav1.Duration{Duration: 100 * time.Millisecond},
+		ImagePullRetryBackoff:       metav1.Duration{Duration: time.Second},
+		ShutdownGracePeriod:         metav1.Duration{Duration: 30 * time.Second},
+		SandboxLifecycleLogLevel:    "info",
+		ContainerLogReadBufferSize:  libdocker.DefaultLogReadBufferSize,
+		DefaultMountPropagation:     "private",
+		ContainerRestartPolicy:      "no",
+		SandboxSeccompProfile:       config.SeccompProfileRuntimeDefault,
+		SandboxApparmorProfile:      config.AppArmorBetaProfileRuntimeDefault,
+		CDISpecDirs:                 []string{"/etc/cdi", "/var/run/cdi"},
+
 		CNIBinDir:   cniBinDir,
 		CNIConfDir:  cniConfDir,
 		CNICacheDir: "/var/lib/cni/cache",
+
+		CNIOperationTimeout: metav1.Duration{Duration: network.CNITimeoutSec * time.Second},
+		ManageLoopback:      true,
 	}
 
 	if runtime.GOOS == "windows" {
@@ -25,6 +25,7 @@ import (
 	"github.com/Mirantis/cri-dockerd/cmd/version"
 	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/core"
+	"github.com/Mirantis/cri-dockerd/metrics"
 	"github.com/Mirantis/cri-dockerd/streaming"
 	"github.com/sirupsen/logrus"
 
@@ -149,9 +150,10 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 
 	// Initialize docker client configuration.
 	dockerClientConfig := &config.ClientConfig{
-		DockerEndpoint:            r.DockerEndpoint,
-		RuntimeRequestTimeout:     r.RuntimeRequestTimeout.Duration,
-		ImagePullProgressDeadline: r.ImagePullProgressDeadline.Duration,
+		DockerEndpoint:             r.DockerEndpoint,
+		RuntimeRequestTimeout:      r.RuntimeRequestTimeout.Duration,
+		ImagePullProgressDeadline:  r.ImagePullProgressDeadline.Duration,
+		ContainerLogReadBufferSize: r.ContainerLogReadBufferSize,
 	}
 
 	// Initialize network plugin settings.
@@ -163,10 +165,51 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 		PluginCacheDir:     f.CNICacheDir,
 		MTU:                int(f.NetworkPluginMTU),
 		NonMasqueradeCIDR:  f.NonMasqueradeCIDR,
+
+		CNIOperationTimeout: f.CNIOperationTimeout.Duration,
+		ManageLoopback:      f.ManageLoopback,
 	}
 
 	config.IPv6DualStackEnabled = f.IPv6DualStackEnabled
 
+	runtimeSettings := config.RuntimeSettings{
+		AllowedProcMountTypes:       r.AllowedProcMountTypes,
+		DefaultAddCapabilities:      r.DefaultAddCapabilities,
+		DefaultDropCapabilities:     r.DefaultDropCapabilities,
+		DefaultEnv:                  r.DefaultEnv,
+		ContainerCreateRetries:      r.ContainerCreateRetries,
+		ContainerCreateRetryBackoff: r.ContainerCreateRetryBackoff.Duration,
+		ContainerStartRetries:       r.ContainerStartRetries,
+		ContainerStartRetryBackoff:  r.ContainerStartRetryBackoff.Duration,
+		ImagePullRetries:            r.ImagePullRetries,
+		ImagePullRetryBackoff:       r.ImagePullRetryBackoff.Duration,
+		MaxConcurrentSandboxCreates: r.MaxConcurrentSandboxCreates,
+		ValidateCommandAgainstImage: r.ValidateCommandAgainstImage,
+		StrictImagePlatform:         r.StrictImagePlatform,
+
+		EnableStartupDelayAnnotation: r.EnableStartupDelayAnnotation,
+		SandboxLifecycleLogLevel:     r.SandboxLifecycleLogLevel,
+		MinDockerAPIVersion:          r.MinDockerAPIVersion,
+		AllowedRuntimeAnnotations:    r.AllowedRuntimeAnnotations,
+		DefaultMountPropagation:      r.DefaultMountPropagation,
+		PruneDanglingImagesInterval:  r.PruneDanglingImagesInterval.Duration,
+		SandboxImageTarball:          r.SandboxImageTarball,
+		MountHostTimezone:            r.MountHostTimezone,
+		MaxAnnotationLabelBytes:      r.MaxAnnotationLabelBytes,
+		DefaultStopTimeout:           r.DefaultStopTimeout.Duration,
+		ReportZombieProcesses:        r.ReportZombieProcesses,
+		DefaultInit:                  r.DefaultInit,
+		ContainerRestartPolicy:       r.ContainerRestartPolicy,
+		ExecOutputFlushInterval:      r.ExecOutputFlushInterval.Duration,
+		SandboxSeccompProfile:        r.SandboxSeccompProfile,
+		SandboxApparmorProfile:       r.SandboxApparmorProfile,
+		AllowedHostPaths:             r.AllowedHostPaths,
+		StrictDNSLimits:              r.StrictDNSLimits,
+		AnnotationToLabel:            r.AnnotationToLabel,
+		CDISpecDirs:                  r.CDISpecDirs,
+		RetainExitedContainers:       r.RetainExitedContainers,
+	}
+
 	var resolvedAddr string
 	if r.StreamingBindAddr != "" {
 		// See whether a port was specified as part of the declaration
@@ -191,6 +234,7 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 		StreamCreationTimeout:           streaming.DefaultConfig.StreamCreationTimeout,
 		SupportedRemoteCommandProtocols: streaming.DefaultConfig.SupportedRemoteCommandProtocols,
 		SupportedPortForwardProtocols:   streaming.DefaultConfig.SupportedPortForwardProtocols,
+		PortAutoSelect:                  r.StreamingPortAutoSelect,
 	}
 
 	// Standalone cri-dockerd will always start the local streaming backend.
@@ -202,6 +246,7 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 		f.RuntimeCgroups,
 		r.CgroupDriver,
 		r.CriDockerdRootDirectory,
+		&runtimeSettings,
 	)
 	if err != nil {
 		return err
@@ -217,6 +262,19 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 		return err
 	}
 
+	if r.MetricsListenAddress != "" {
+		l, err := net.Listen("tcp", r.MetricsListenAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %q for metrics: %v", r.MetricsListenAddress, err)
+		}
+		logrus.Infof("Serving metrics on %s", l.Addr())
+		go func() {
+			if err := metrics.Serve(l); err != nil {
+				logrus.Errorf("Failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
 	logrus.Info("Starting the GRPC backend for the Docker CRI interface.")
 	server := backend.NewCriDockerServer(f.RemoteRuntimeEndpoint, ds)
 	if err := server.Start(); err != nil {
@@ -224,5 +282,7 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 	}
 
 	<-stopCh
+	logrus.Info("Stopping the GRPC backend for the Docker CRI interface.")
+	server.Stop(r.ShutdownGracePeriod.Duration)
 	return nil
 }
@@ -38,6 +38,9 @@ type ContainerRuntimeOptions struct {
 	// PodSandboxImage is the image whose network/ipc namespaces
 	// containers in each pod will use.
 	PodSandboxImage string
+	// SandboxImageTarball is the path of a docker image archive holding the
+	// pod sandbox image, loaded when the image can't be pulled.
+	SandboxImageTarball string
 	// DockerEndpoint is the path to the docker endpoint to communicate with.
 	DockerEndpoint string
 	// If no pulling progress is made before the deadline imagePullProgressDeadline,
@@ -54,6 +57,120 @@ type ContainerRuntimeOptions struct {
 	// StreamingBindAddr is the address to bind the CRI streaming server to.
 	// If not specified, it will bind to all addresses
 	StreamingBindAddr string
+	// StreamingPortAutoSelect binds the CRI streaming server to an ephemeral
+	// port when the port of StreamingBindAddr is in use.
+	StreamingPortAutoSelect bool
+
+	// AllowedProcMountTypes lists the proc mount types ("Default", "Unmasked")
+	// containers are allowed to request. Unmasked reduces isolation, so it
+	// has to be opted in explicitly.
+	AllowedProcMountTypes []string
+	// DefaultAddCapabilities are added to every container, unless the
+	// container drops them explicitly.
+	DefaultAddCapabilities []string
+	// DefaultDropCapabilities are dropped from every container, unless the
+	// container adds them explicitly.
+	DefaultDropCapabilities []string
+	// DefaultEnv lists KEY=VALUE environment variables set in every
+	// container, unless the container defines the same key.
+	DefaultEnv []string
+	// ContainerCreateRetries is the number of times the creation of a
+	// container is retried when docker fails with a transient lock error.
+	ContainerCreateRetries int
+	// ContainerCreateRetryBackoff is the initial delay between container
+	// creation retries. It doubles after each retry.
+	ContainerCreateRetryBackoff v1.Duration
+	// ContainerStartRetries is the number of times the start of a container
+	// is retried when docker fails with a transient error.
+	ContainerStartRetries int
+	// ContainerStartRetryBackoff is the initial delay between container
+	// start retries. It doubles after each retry.
+	ContainerStartRetryBackoff v1.Duration
+	// ImagePullRetries is the number of times an image pull is retried when
+	// it fails with a transient error.
+	ImagePullRetries int
+	// ImagePullRetryBackoff is the initial delay between image pull retries.
+	// It doubles after each retry, and up to 50% of jitter is added.
+	ImagePullRetryBackoff v1.Duration
+	// MaxConcurrentSandboxCreates limits the number of sandboxes created at
+	// once. Zero means no limit.
+	MaxConcurrentSandboxCreates int
+	// ValidateCommandAgainstImage makes the creation of a container fail when
+	// neither the container nor its image define a command to run.
+	ValidateCommandAgainstImage bool
+	// StrictImagePlatform makes the creation of a container fail when its
+	// image was built for another platform than the node's, instead of only
+	// warning about it.
+	StrictImagePlatform bool
+	// EnableStartupDelayAnnotation makes StartContainer honor the startup delay
+	// annotation of pods. It is meant for testing only.
+	EnableStartupDelayAnnotation bool
+	// ShutdownGracePeriod is how long in-flight calls are given to complete
+	// when cri-dockerd is stopped, before they are cancelled.
+	ShutdownGracePeriod v1.Duration
+	// MetricsListenAddress is the address on which the Prometheus metrics are
+	// served, empty to not serve them.
+	MetricsListenAddress string
+	// SandboxLifecycleLogLevel is the log level (e.g. info, debug) at which
+	// the stop and the removal of sandboxes are logged.
+	SandboxLifecycleLogLevel string
+	// MinDockerAPIVersion is the oldest Docker API version (e.g. 1.43) the
+	// daemon may offer, empty to only require the version cri-dockerd needs.
+	MinDockerAPIVersion string
+	// ContainerLogReadBufferSize is the size in bytes of the buffer the
+	// container logs are read through when served.
+	ContainerLogReadBufferSize int
+	// AllowedRuntimeAnnotations lists the container annotation keys forwarded
+	// to the OCI runtime, a trailing "*" matching any suffix.
+	AllowedRuntimeAnnotations []string
+	// DefaultMountPropagation is the propagation of the container mounts which
+	// do not request one: private, rslave or rshared.
+	DefaultMountPropagation string
+	// PruneDanglingImagesInterval is the interval between prunes of the
+	// dangling images which no container uses, 0 to never prune them.
+	PruneDanglingImagesInterval v1.Duration
+	// MountHostTimezone mounts the timezone of the node in the containers
+	// which neither mount their own nor set TZ.
+	MountHostTimezone bool
+	// MaxAnnotationLabelBytes is the size above which the label of a container
+	// annotation is kept in a side file instead, 0 for no limit.
+	MaxAnnotationLabelBytes int
+	// DefaultStopTimeout is the grace period docker gives the containers it
+	// stops on its own, for containers whose pod and image don't set one.
+	DefaultStopTimeout v1.Duration
+	// ReportZombieProcesses reports the number of zombie processes of
+	// containers in their verbose status.
+	ReportZombieProcesses bool
+	// DefaultInit runs an init process in the containers of the pods which
+	// don't choose with their annotation.
+	DefaultInit bool
+	// ContainerRestartPolicy is the docker restart policy of the sandboxes
+	// and containers, no as Kubernetes restarts them itself.
+	ContainerRestartPolicy string
+	// ExecOutputFlushInterval coalesces the output of exec sessions without
+	// a TTY written within the interval. Zero disables the buffering.
+	ExecOutputFlushInterval v1.Duration
+	// SandboxSeccompProfile is the seccomp profile of the pod infra
+	// containers, which only run a trivial process.
+	SandboxSeccompProfile string
+	// SandboxApparmorProfile is the apparmor profile of the pod infra
+	// containers.
+	SandboxApparmorProfile string
+	// AllowedHostPaths lists the host path prefixes containers may bind
+	// mount. Empty allows any host path.
+	AllowedHostPaths []string
+	// StrictDNSLimits fails the creation of the sandboxes of the pods with
+	// more nameservers than the resolver uses, instead of dropping them.
+	StrictDNSLimits bool
+	// AnnotationToLabel maps the keys of the pod annotations copied to the
+	// labels of the docker containers to the keys of these labels.
+	AnnotationToLabel map[string]string
+	// CDISpecDirs are the directories of the Container Device Interface
+	// specs, the later ones taking precedence.
+	CDISpecDirs []string
+	// RetainExitedContainers is the number of most recent exited containers
+	// kept for debugging in each running pod, 0 to keep none.
+	RetainExitedContainers int
 
 	// Network plugin options.
 
@@ -78,6 +195,12 @@ type ContainerRuntimeOptions struct {
 	// CNICacheDir is the full path of the directory in which CNI should store
 	// cache files
 	CNICacheDir string
+	// CNIOperationTimeout is the deadline of each CNI ADD, DEL or CHECK of a
+	// pod network.
+	CNIOperationTimeout v1.Duration
+	// ManageLoopback makes cri-dockerd bring up the loopback interface of the
+	// pods with CNI, rather than leave it to the CNI network config.
+	ManageLoopback bool
 	// HairpinMode is the mode used to allow endpoints of a Service to load
 	// balance back to themselves if they should try to access their own Service
 	HairpinMode HairpinMode
@@ -106,6 +229,12 @@ func (s *ContainerRuntimeOptions) AddFlags(fs *pflag.FlagSet) {
 		s.PodSandboxImage,
 		"The image whose network/ipc namespaces containers in each pod will use",
 	)
+	fs.StringVar(
+		&s.SandboxImageTarball,
+		"pod-infra-container-image-tarball",
+		s.SandboxImageTarball,
+		"The path of a docker image archive, as written by docker save, to load the pod infra container image from when it can't be pulled, e.g. on air-gapped nodes.",
+	)
 	fs.StringVar(
 		&s.DockerEndpoint,
 		"docker-endpoint",
@@ -116,7 +245,7 @@ func (s *ContainerRuntimeOptions) AddFlags(fs *pflag.FlagSet) {
 		&s.ImagePullProgressDeadline.Duration,
 		"image-pull-progress-deadline",
 		s.ImagePullProgressDeadline.Duration,
-		"If no pulling progress is made before this deadline, the image pulling will be cancelled.",
+		"If no pulling progress is made before this deadline, the image pulling will be cancelled. It also bounds the loading of the sandbox image archive.",
 	)
 	fs.DurationVar(
 		&s.RuntimeRequestTimeout.Duration,
@@ -131,6 +260,228 @@ func (s *ContainerRuntimeOptions) AddFlags(fs *pflag.FlagSet) {
 		s.StreamingBindAddr,
 		"The address to bind the CRI streaming server to. If not specified, it will bind to all addresses.",
 	)
+	fs.BoolVar(
+		&s.StreamingPortAutoSelect,
+		"streaming-port-auto-select",
+		s.StreamingPortAutoSelect,
+		"Bind the CRI streaming server to an ephemeral port when the port of the streaming bind address is in use, instead of failing. The streaming URLs use the port bound.",
+	)
+	fs.StringSliceVar(
+		&s.AllowedProcMountTypes,
+		"allowed-proc-mount-types",
+		s.AllowedProcMountTypes,
+		"Comma-separated list of proc mount types (Default, Unmasked) containers are allowed to request. Unmasked disables the masking of /proc paths and reduces isolation.",
+	)
+	fs.StringSliceVar(
+		&s.DefaultAddCapabilities,
+		"default-add-capabilities",
+		s.DefaultAddCapabilities,
+		"Comma-separated list of capabilities added to all containers. Capabilities dropped by a container take precedence.",
+	)
+	fs.StringSliceVar(
+		&s.DefaultDropCapabilities,
+		"default-drop-capabilities",
+		s.DefaultDropCapabilities,
+		"Comma-separated list of capabilities dropped from all containers. Capabilities added by a container take precedence.",
+	)
+	fs.StringArrayVar(
+		&s.DefaultEnv,
+		"default-env",
+		s.DefaultEnv,
+		"KEY=VALUE environment variable set in all containers, unless the container defines the same key. May be repeated.",
+	)
+	fs.IntVar(
+		&s.ContainerCreateRetries,
+		"container-create-retries",
+		s.ContainerCreateRetries,
+		"The number of times the creation of a container is retried when docker fails with a transient lock error.",
+	)
+	fs.DurationVar(
+		&s.ContainerCreateRetryBackoff.Duration,
+		"container-create-retry-backoff",
+		s.ContainerCreateRetryBackoff.Duration,
+		"The initial delay between container creation retries, doubled after each retry.",
+	)
+	fs.IntVar(
+		&s.ContainerStartRetries,
+		"container-start-retries",
+		s.ContainerStartRetries,
+		"The number of times the start of a container is retried when docker fails with a transient error, such as a busy device.",
+	)
+	fs.DurationVar(
+		&s.ContainerStartRetryBackoff.Duration,
+		"container-start-retry-backoff",
+		s.ContainerStartRetryBackoff.Duration,
+		"The initial delay between container start retries, doubled after each retry.",
+	)
+	fs.IntVar(
+		&s.ImagePullRetries,
+		"image-pull-retries",
+		s.ImagePullRetries,
+		"The number of times an image pull is retried on transient errors (timeouts, registry server errors, rate limiting).",
+	)
+	fs.DurationVar(
+		&s.ImagePullRetryBackoff.Duration,
+		"image-pull-retry-backoff",
+		s.ImagePullRetryBackoff.Duration,
+		"The initial delay between image pull retries, doubled after each retry with added jitter.",
+	)
+	fs.IntVar(
+		&s.MaxConcurrentSandboxCreates,
+		"max-concurrent-sandbox-creates",
+		s.MaxConcurrentSandboxCreates,
+		"The maximum number of sandboxes created concurrently, 0 for no limit. Requests over the limit wait until their deadline.",
+	)
+	fs.BoolVar(
+		&s.ValidateCommandAgainstImage,
+		"validate-command-against-image",
+		s.ValidateCommandAgainstImage,
+		"Reject the creation of containers without command or args whose image defines neither an entrypoint nor a cmd.",
+	)
+	fs.BoolVar(
+		&s.StrictImagePlatform,
+		"strict-image-platform",
+		s.StrictImagePlatform,
+		"Reject the creation of containers whose image was built for another OS or architecture than the node's, instead of logging a warning.",
+	)
+	fs.BoolVar(
+		&s.EnableStartupDelayAnnotation,
+		"enable-startup-delay-annotation",
+		s.EnableStartupDelayAnnotation,
+		"Delay the start of containers by the duration set in the cri-dockerd.mirantis.com/startup-delay annotation of their pod. For testing purposes only.",
+	)
+	fs.DurationVar(
+		&s.ShutdownGracePeriod.Duration,
+		"shutdown-grace-period",
+		s.ShutdownGracePeriod.Duration,
+		"How long in-flight calls are given to complete on shutdown before they are cancelled. New calls are refused during that time.",
+	)
+	fs.StringVar(
+		&s.MetricsListenAddress,
+		"metrics-listen-address",
+		s.MetricsListenAddress,
+		"The address (e.g. 127.0.0.1:9102) on which the Prometheus metrics are served at /metrics. The metrics are not served if empty.",
+	)
+	fs.StringVar(
+		&s.SandboxLifecycleLogLevel,
+		"sandbox-lifecycle-log-level",
+		s.SandboxLifecycleLogLevel,
+		"The log level (error, warning, info, debug or trace) at which the stop and the removal of sandboxes are logged.",
+	)
+	fs.StringVar(
+		&s.MinDockerAPIVersion,
+		"min-docker-api-version",
+		s.MinDockerAPIVersion,
+		"The minimum Docker API version (e.g. 1.43) the daemon must support, cri-dockerd fails to start against an older daemon. Defaults to the minimum supported by cri-dockerd, which cannot be lowered.",
+	)
+	fs.IntVar(
+		&s.ContainerLogReadBufferSize,
+		"container-log-read-buffer-size",
+		s.ContainerLogReadBufferSize,
+		"The size in bytes of the buffer the container logs are read through when served. Sizes below 4096 are raised to it.",
+	)
+	fs.StringSliceVar(
+		&s.AllowedRuntimeAnnotations,
+		"allowed-runtime-annotations",
+		s.AllowedRuntimeAnnotations,
+		"Comma-separated list of container annotation keys forwarded to the OCI runtime, a trailing * matching any suffix (e.g. run.oci.*). Other annotations are not forwarded.",
+	)
+	fs.StringVar(
+		&s.DefaultMountPropagation,
+		"default-mount-propagation",
+		s.DefaultMountPropagation,
+		"The propagation of the container mounts which do not request one: private, rslave or rshared. As private is the default of CRI mounts, mounts explicitly requesting it get the default too.",
+	)
+	fs.DurationVar(
+		&s.PruneDanglingImagesInterval.Duration,
+		"prune-dangling-images-interval",
+		s.PruneDanglingImagesInterval.Duration,
+		"The interval between prunes of the dangling images, with neither a tag nor a digest, which no container uses. The sandbox image is never pruned. 0 disables the prunes.",
+	)
+	fs.BoolVar(
+		&s.MountHostTimezone,
+		"mount-host-timezone",
+		s.MountHostTimezone,
+		"Mount the /etc/localtime of the node read-only in Linux containers and point their TZ at it, unless the pod mounts /etc/localtime or the pod or the image sets TZ itself.",
+	)
+	fs.IntVar(
+		&s.MaxAnnotationLabelBytes,
+		"max-annotation-label-bytes",
+		s.MaxAnnotationLabelBytes,
+		"The size in bytes above which a container annotation is kept in a file of the cri-dockerd root directory rather than in a docker label. 0 keeps all annotations in labels.",
+	)
+	fs.DurationVar(
+		&s.DefaultStopTimeout.Duration,
+		"default-stop-timeout",
+		s.DefaultStopTimeout.Duration,
+		"The grace period docker gives the containers it stops on its own, such as on its shutdown, when neither the stop-timeout annotation of their pod nor their image set one. 0 leaves the docker default.",
+	)
+	fs.BoolVar(
+		&s.ReportZombieProcesses,
+		"report-zombie-processes",
+		s.ReportZombieProcesses,
+		"Report the number of zombie processes of Linux containers in their verbose status, read from their /proc, to spot containers lacking an init process.",
+	)
+	fs.BoolVar(
+		&s.DefaultInit,
+		"default-init",
+		s.DefaultInit,
+		"Run the docker init process as PID 1 of containers, reaping zombies and forwarding signals, unless their pod sets the cri-dockerd.mirantis.com/init annotation to false.",
+	)
+	fs.StringVar(
+		&s.ContainerRestartPolicy,
+		"container-restart-policy",
+		s.ContainerRestartPolicy,
+		"The docker restart policy of sandboxes and containers: no, always, unless-stopped or on-failure[:max-retries]. Kubernetes restarts them itself, so anything but no is only meant for specialized setups.",
+	)
+	fs.DurationVar(
+		&s.ExecOutputFlushInterval.Duration,
+		"exec-output-flush-interval",
+		s.ExecOutputFlushInterval.Duration,
+		"Buffer the output of exec sessions without a TTY, flushing it after this interval, to send fewer and larger frames. Zero disables the buffering.",
+	)
+	fs.StringVar(
+		&s.SandboxSeccompProfile,
+		"pod-infra-container-seccomp-profile",
+		s.SandboxSeccompProfile,
+		"The seccomp profile of the pod infra containers: runtime/default, unconfined for compatibility, or localhost/<path> for a custom profile.",
+	)
+	fs.StringVar(
+		&s.SandboxApparmorProfile,
+		"pod-infra-container-apparmor-profile",
+		s.SandboxApparmorProfile,
+		"The apparmor profile of the pod infra containers: runtime/default, unconfined for compatibility, or localhost/<name> for a loaded profile.",
+	)
+	fs.StringSliceVar(
+		&s.AllowedHostPaths,
+		"allowed-host-paths",
+		s.AllowedHostPaths,
+		"Comma-separated list of host paths below which containers may bind mount host paths. The kubelet root directory must be listed for the pod volumes. Empty allows any host path.",
+	)
+	fs.BoolVar(
+		&s.StrictDNSLimits,
+		"strict-dns-limits",
+		s.StrictDNSLimits,
+		"Fail the creation of the sandboxes of the pods with more than 3 nameservers, instead of dropping the extra ones with a warning.",
+	)
+	fs.StringToStringVar(
+		&s.AnnotationToLabel,
+		"annotation-to-label",
+		s.AnnotationToLabel,
+		"Comma-separated list of annotation=label pairs, copying the values of the pod annotations to the labels of the docker containers of the pod. The labels of the containers and the internal ones are never overwritten.",
+	)
+	fs.StringSliceVar(
+		&s.CDISpecDirs,
+		"cdi-spec-dirs",
+		s.CDISpecDirs,
+		"Comma-separated list of the directories of the Container Device Interface specs resolving the CDI devices of the containers, the later ones taking precedence.",
+	)
+	fs.IntVar(
+		&s.RetainExitedContainers,
+		"retain-exited-containers",
+		s.RetainExitedContainers,
+		"The number of most recent exited containers kept for debugging in each running pod, whose removals by the kubelet garbage collection are ignored until the pod stops. 0 keeps none.",
+	)
 	// Network plugin settings for Docker.
 	fs.StringVar(
 		&s.PodCIDR,
@@ -168,6 +519,18 @@ func (s *ContainerRuntimeOptions) AddFlags(fs *pflag.FlagSet) {
 		s.CNICacheDir,
 		"The full path of the directory in which CNI should store cache files.",
 	)
+	fs.DurationVar(
+		&s.CNIOperationTimeout.Duration,
+		"cni-operation-timeout",
+		s.CNIOperationTimeout.Duration,
+		"The deadline of each CNI operation on a pod network. A pod whose network setup times out is removed from the network again.",
+	)
+	fs.BoolVar(
+		&s.ManageLoopback,
+		"manage-loopback",
+		s.ManageLoopback,
+		"Bring up the loopback interface of the pods using CNI networking. Disable it when the CNI network config manages the loopback interface itself.",
+	)
 	fs.Int32Var(
 		&s.NetworkPluginMTU,
 		"network-plugin-mtu",
@@ -51,6 +51,109 @@ type NetworkPluginSettings struct {
 	PluginCacheDir string
 	// MTU is the desired MTU for network devices created by the plugin.
 	MTU int
+	// CNIOperationTimeout is the deadline of each CNI operation.
+	CNIOperationTimeout time.Duration
+	// ManageLoopback makes the CNI plugin bring up the loopback interface of
+	// the pods, rather than leave it to the CNI network config.
+	ManageLoopback bool
+}
+
+// RuntimeSettings is the subset of cri-dockerd runtime args consulted when
+// sandboxes and containers are created.
+type RuntimeSettings struct {
+	// AllowedProcMountTypes lists the proc mount types containers may request.
+	AllowedProcMountTypes []string
+	// DefaultAddCapabilities are added to all containers.
+	DefaultAddCapabilities []string
+	// DefaultDropCapabilities are dropped from all containers.
+	DefaultDropCapabilities []string
+	// DefaultEnv are the KEY=VALUE variables set in all containers.
+	DefaultEnv []string
+	// ContainerCreateRetries is the number of retries of a container creation
+	// failing with a transient error.
+	ContainerCreateRetries int
+	// ContainerCreateRetryBackoff is the initial delay between retries.
+	ContainerCreateRetryBackoff time.Duration
+	// ContainerStartRetries is the number of retries of a container start
+	// failing with a transient error.
+	ContainerStartRetries int
+	// ContainerStartRetryBackoff is the initial delay between start retries.
+	ContainerStartRetryBackoff time.Duration
+	// ImagePullRetries is the number of retries of an image pull failing with
+	// a transient error.
+	ImagePullRetries int
+	// ImagePullRetryBackoff is the initial delay between image pull retries.
+	ImagePullRetryBackoff time.Duration
+	// MaxConcurrentSandboxCreates limits the number of concurrent sandbox
+	// creations, 0 for no limit.
+	MaxConcurrentSandboxCreates int
+	// ValidateCommandAgainstImage rejects containers with nothing to run.
+	ValidateCommandAgainstImage bool
+	// StrictImagePlatform rejects containers whose image platform doesn't
+	// match the node.
+	StrictImagePlatform bool
+	// EnableStartupDelayAnnotation enables the startup delay annotation.
+	EnableStartupDelayAnnotation bool
+	// SandboxLifecycleLogLevel is the logrus level at which the stop and the
+	// removal of sandboxes are logged.
+	SandboxLifecycleLogLevel string
+	// MinDockerAPIVersion is the minimum Docker API version the daemon must
+	// offer, empty for libdocker.MinimumDockerAPIVersion.
+	MinDockerAPIVersion string
+	// AllowedRuntimeAnnotations are the container annotation keys forwarded
+	// to the OCI runtime, a trailing "*" matching any suffix.
+	AllowedRuntimeAnnotations []string
+	// DefaultMountPropagation is the propagation of the container mounts which
+	// do not request one: private, rslave or rshared.
+	DefaultMountPropagation string
+	// PruneDanglingImagesInterval is the interval between prunes of the
+	// dangling images, 0 to never prune them.
+	PruneDanglingImagesInterval time.Duration
+	// SandboxImageTarball is the path of a docker image archive to load the
+	// sandbox image from when it can't be pulled.
+	SandboxImageTarball string
+	// MountHostTimezone mounts the timezone of the node in the containers
+	// which neither mount their own nor set TZ.
+	MountHostTimezone bool
+	// MaxAnnotationLabelBytes is the size above which the label of a container
+	// annotation is kept in a side file instead, 0 for no limit.
+	MaxAnnotationLabelBytes int
+	// DefaultStopTimeout is the grace period docker gives the containers it
+	// stops on its own, for containers whose pod and image don't set one.
+	DefaultStopTimeout time.Duration
+	// ReportZombieProcesses reports the number of zombie processes of
+	// containers in their verbose status.
+	ReportZombieProcesses bool
+	// DefaultInit runs an init process in the containers of the pods which
+	// don't choose with their annotation.
+	DefaultInit bool
+	// ContainerRestartPolicy is the docker restart policy of the sandboxes
+	// and containers, empty for no.
+	ContainerRestartPolicy string
+	// ExecOutputFlushInterval coalesces the output of exec sessions without
+	// a TTY written within the interval, zero to disable the buffering.
+	ExecOutputFlushInterval time.Duration
+	// SandboxSeccompProfile is the seccomp profile of the sandboxes:
+	// runtime/default, unconfined or localhost/<path>.
+	SandboxSeccompProfile string
+	// SandboxApparmorProfile is the apparmor profile of the sandboxes:
+	// runtime/default, unconfined or localhost/<name>.
+	SandboxApparmorProfile string
+	// AllowedHostPaths lists the host paths below which containers may bind
+	// mount host paths, empty to allow any.
+	AllowedHostPaths []string
+	// StrictDNSLimits fails the sandbox creation of the pods with more
+	// nameservers than the resolver uses, instead of dropping them.
+	StrictDNSLimits bool
+	// AnnotationToLabel maps the keys of the pod annotations copied to the
+	// docker container labels to the keys of these labels.
+	AnnotationToLabel map[string]string
+	// CDISpecDirs are the directories of the CDI specs resolving the CDI
+	// devices of the containers, the later ones taking precedence.
+	CDISpecDirs []string
+	// RetainExitedContainers is the number of most recent exited containers
+	// of each running sandbox which are not removed, 0 to remove them all.
+	RetainExitedContainers int
 }
 
 // enableIPv6DualStack allows dual-homed pods
@@ -61,6 +164,9 @@ type ClientConfig struct {
 	DockerEndpoint            string
 	RuntimeRequestTimeout     time.Duration
 	ImagePullProgressDeadline time.Duration
+	// ContainerLogReadBufferSize is the size in bytes of the buffer the
+	// container logs are read through.
+	ContainerLogReadBufferSize int
 
 	// Configuration for fake docker client
 	EnableSleep       bool
@@ -76,6 +182,7 @@ func NewDockerClientFromConfig(config *ClientConfig) libdocker.DockerClientInter
 			config.DockerEndpoint,
 			config.RuntimeRequestTimeout,
 			config.ImagePullProgressDeadline,
+			config.ContainerLogReadBufferSize,
 		)
 		return client
 	}
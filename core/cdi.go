@@ -0,0 +1,297 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dockerbackend "github.com/docker/docker/api/types/backend"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockermount "github.com/docker/docker/api/types/mount"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// cdiAnnotationPrefix is the prefix of the container annotations listing
+	// comma-separated CDI devices, as set by the device plugins.
+	cdiAnnotationPrefix = "cdi.k8s.io/"
+	// defaultCDIDevicePermissions are the cgroup permissions of the CDI
+	// device nodes which don't set any.
+	defaultCDIDevicePermissions = "rwm"
+)
+
+// cdiSpec is a Container Device Interface spec file, declaring the devices of
+// a kind and the edits they make to the containers using them.
+type cdiSpec struct {
+	Kind           string            `json:"kind"`
+	Devices        []cdiDevice       `json:"devices"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	Env         []string        `json:"env"`
+	DeviceNodes []cdiDeviceNode `json:"deviceNodes"`
+	Mounts      []cdiMount      `json:"mounts"`
+	Hooks       []interface{}   `json:"hooks"`
+}
+
+type cdiDeviceNode struct {
+	Path        string `json:"path"`
+	HostPath    string `json:"hostPath"`
+	Permissions string `json:"permissions"`
+}
+
+type cdiMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Type          string   `json:"type"`
+	Options       []string `json:"options"`
+}
+
+// resolvedCDIDevice is a CDI device with the spec declaring it.
+type resolvedCDIDevice struct {
+	device *cdiDevice
+	spec   *cdiSpec
+}
+
+// cdiSpecCache keeps the parsed CDI spec files, reparsed only once modified.
+type cdiSpecCache struct {
+	sync.Mutex
+	specs map[string]cachedCDISpec
+}
+
+type cachedCDISpec struct {
+	modTime time.Time
+	size    int64
+	spec    *cdiSpec
+}
+
+// cdiDeviceNames returns the fully qualified names of the CDI devices of the
+// container, from its config and its CDI annotations, without duplicates.
+func cdiDeviceNames(config *runtimeapi.ContainerConfig) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, device := range config.GetCDIDevices() {
+		add(device.GetName())
+	}
+	var keys []string
+	for key := range config.GetAnnotations() {
+		if strings.HasPrefix(key, cdiAnnotationPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, name := range strings.Split(config.GetAnnotations()[key], ",") {
+			add(name)
+		}
+	}
+	return names
+}
+
+// loadDevices loads the CDI devices declared by the spec files of the
+// directories, by fully qualified name. The devices of the later directories
+// override the ones of the earlier ones. Missing directories are skipped.
+func (c *cdiSpecCache) loadDevices(specDirs []string) (map[string]resolvedCDIDevice, error) {
+	c.Lock()
+	defer c.Unlock()
+	specs := make(map[string]cachedCDISpec)
+	devices := make(map[string]resolvedCDIDevice)
+	for _, dir := range specDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read the CDI spec directory %q: %v", dir, err)
+		}
+		for _, entry := range entries {
+			switch filepath.Ext(entry.Name()) {
+			case ".json", ".yaml", ".yml":
+			default:
+				continue
+			}
+			if entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			info, err := entry.Info()
+			if err != nil {
+				return nil, fmt.Errorf("failed to stat the CDI spec %q: %v", path, err)
+			}
+			cached, ok := c.specs[path]
+			if !ok || !cached.modTime.Equal(info.ModTime()) || cached.size != info.Size() {
+				spec, err := parseCDISpec(path)
+				if err != nil {
+					return nil, err
+				}
+				cached = cachedCDISpec{modTime: info.ModTime(), size: info.Size(), spec: spec}
+			}
+			specs[path] = cached
+			for i := range cached.spec.Devices {
+				device := &cached.spec.Devices[i]
+				devices[cached.spec.Kind+"="+device.Name] = resolvedCDIDevice{
+					device: device,
+					spec:   cached.spec,
+				}
+			}
+		}
+	}
+	// Only the spec files still present are kept.
+	c.specs = specs
+	return devices, nil
+}
+
+// parseCDISpec reads and parses a CDI spec file.
+func parseCDISpec(path string) (*cdiSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CDI spec %q: %v", path, err)
+	}
+	spec := &cdiSpec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("failed to parse the CDI spec %q: %v", path, err)
+	}
+	if spec.Kind == "" {
+		return nil, fmt.Errorf("invalid CDI spec %q: no kind", path)
+	}
+	return spec, nil
+}
+
+// applyCDIDevices resolves the CDI devices of the container against the spec
+// files, and applies their device nodes, mounts and environment variables to
+// the container. Unknown devices, and devices needing hooks, which docker
+// can't run, fail the creation.
+func (ds *dockerService) applyCDIDevices(
+	createConfig *dockerbackend.ContainerCreateConfig,
+	config *runtimeapi.ContainerConfig,
+) error {
+	names := cdiDeviceNames(config)
+	if len(names) == 0 {
+		return nil
+	}
+	devices, err := ds.cdiSpecs.loadDevices(ds.runtimeSettings.CDISpecDirs)
+	if err != nil {
+		return err
+	}
+	var edits []*cdiContainerEdits
+	appliedSpecs := make(map[*cdiSpec]bool)
+	for _, name := range names {
+		if !strings.Contains(name, "/") || !strings.Contains(name, "=") {
+			return fmt.Errorf("invalid CDI device name %q: must be vendor/class=name", name)
+		}
+		resolved, ok := devices[name]
+		if !ok {
+			return fmt.Errorf("unknown CDI device %q", name)
+		}
+		if len(resolved.spec.ContainerEdits.Hooks) > 0 || len(resolved.device.ContainerEdits.Hooks) > 0 {
+			return fmt.Errorf("unsupported CDI device %q: docker can't run its hooks", name)
+		}
+		// The edits of the spec apply once for all its devices.
+		if !appliedSpecs[resolved.spec] {
+			appliedSpecs[resolved.spec] = true
+			edits = append(edits, &resolved.spec.ContainerEdits)
+		}
+		edits = append(edits, &resolved.device.ContainerEdits)
+	}
+
+	hc := createConfig.HostConfig
+	for _, e := range edits {
+		createConfig.Config.Env = append(createConfig.Config.Env, e.Env...)
+		for _, node := range e.DeviceNodes {
+			hc.Resources.Devices = append(hc.Resources.Devices, makeCDIDeviceMapping(node))
+		}
+		for _, m := range e.Mounts {
+			mount, err := makeCDIMount(m)
+			if err != nil {
+				return err
+			}
+			hc.Mounts = append(hc.Mounts, mount)
+		}
+	}
+	return nil
+}
+
+// makeCDIDeviceMapping returns the docker device of a CDI device node, at the
+// same path on the host unless it sets one.
+func makeCDIDeviceMapping(node cdiDeviceNode) dockercontainer.DeviceMapping {
+	hostPath := node.HostPath
+	if hostPath == "" {
+		hostPath = node.Path
+	}
+	permissions := node.Permissions
+	if permissions == "" {
+		permissions = defaultCDIDevicePermissions
+	}
+	return dockercontainer.DeviceMapping{
+		PathOnHost:        hostPath,
+		PathInContainer:   node.Path,
+		CgroupPermissions: permissions,
+	}
+}
+
+// makeCDIMount returns the docker bind mount of a CDI mount. The read-only and
+// propagation options are kept, the other bind options are docker's default.
+func makeCDIMount(m cdiMount) (dockermount.Mount, error) {
+	if m.Type != "" && m.Type != "bind" {
+		return dockermount.Mount{}, fmt.Errorf(
+			"unsupported type %q of the CDI mount of %q",
+			m.Type,
+			m.ContainerPath,
+		)
+	}
+	mount := dockermount.Mount{
+		Type:   dockermount.TypeBind,
+		Source: m.HostPath,
+		Target: m.ContainerPath,
+	}
+	for _, option := range m.Options {
+		switch propagation := dockermount.Propagation(option); propagation {
+		case dockermount.PropagationPrivate,
+			dockermount.PropagationRPrivate,
+			dockermount.PropagationShared,
+			dockermount.PropagationRShared,
+			dockermount.PropagationSlave,
+			dockermount.PropagationRSlave:
+			mount.BindOptions = &dockermount.BindOptions{Propagation: propagation}
+		default:
+			if option == "ro" {
+				mount.ReadOnly = true
+			}
+		}
+	}
+	return mount, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// externalAnnotationsDir is the directory, below the cri-dockerd root
+// directory, holding the annotations of containers too large for labels.
+const externalAnnotationsDir = "annotations"
+
+// splitOversizedAnnotations returns the annotations whose label would exceed
+// maxBytes apart from the others. A non-positive maxBytes keeps them all.
+func splitOversizedAnnotations(
+	annotations map[string]string,
+	maxBytes int,
+) (kept, oversized map[string]string) {
+	if maxBytes <= 0 {
+		return annotations, nil
+	}
+	kept = make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		if len(annotationPrefix)+len(k)+len(v) > maxBytes {
+			if oversized == nil {
+				oversized = make(map[string]string)
+			}
+			oversized[k] = v
+			continue
+		}
+		kept[k] = v
+	}
+	return kept, oversized
+}
+
+// externalAnnotationsLabel returns the value of the label marking a container
+// whose oversized annotations are kept in a side file: their sorted keys.
+func externalAnnotationsLabel(oversized map[string]string) string {
+	keys := make([]string, 0, len(oversized))
+	for k := range oversized {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// externalAnnotationsPath returns the side file of the annotations of the
+// container.
+func (ds *dockerService) externalAnnotationsPath(containerID string) string {
+	return filepath.Join(ds.annotationsDir, containerID+".json")
+}
+
+// writeExternalAnnotations writes the oversized annotations of the created
+// container to its side file.
+func (ds *dockerService) writeExternalAnnotations(
+	containerID string,
+	oversized map[string]string,
+) error {
+	data, err := json.Marshal(oversized)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ds.annotationsDir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(ds.externalAnnotationsPath(containerID), data, 0o600)
+}
+
+// restoreExternalAnnotations adds the annotations kept in the side file of the
+// container, if its labels reference one, to its other annotations.
+func (ds *dockerService) restoreExternalAnnotations(
+	containerID string,
+	labels map[string]string,
+	annotations map[string]string,
+) {
+	if _, ok := labels[externalAnnotationsLabelKey]; !ok {
+		return
+	}
+	data, err := os.ReadFile(ds.externalAnnotationsPath(containerID))
+	if err == nil {
+		var oversized map[string]string
+		if err = json.Unmarshal(data, &oversized); err == nil {
+			for k, v := range oversized {
+				annotations[k] = v
+			}
+			return
+		}
+	}
+	logrus.Warningf("Failed to restore the annotations of container %s: %v", containerID, err)
+}
+
+// removeExternalAnnotations removes the side file of the annotations of the
+// removed container, if any.
+func (ds *dockerService) removeExternalAnnotations(containerID string) error {
+	if ds.annotationsDir == "" {
+		return nil
+	}
+	err := os.Remove(ds.externalAnnotationsPath(containerID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the annotations of container %q: %v", containerID, err)
+	}
+	return nil
+}
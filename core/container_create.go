@@ -19,21 +19,38 @@ package core
 import (
 	"context"
 	"fmt"
+	"math"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Mirantis/cri-dockerd/libdocker"
+	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	"github.com/docker/docker/api/types/container"
+	dockermount "github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/strslice"
 	"github.com/opencontainers/selinux/go-selinux/label"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
+// nodeOS and nodeArch are the platform of the node, which images are checked
+// against.
+var (
+	nodeOS   = runtime.GOOS
+	nodeArch = runtime.GOARCH
+)
+
 // CreateContainer creates a new container in the given PodSandbox
 // Docker cannot store the log to an arbitrary location (yet), so we create an
 // symlink at LogPath, linking to the actual path of the log.
 func (ds *dockerService) CreateContainer(
-	_ context.Context,
+	ctx context.Context,
 	r *v1.CreateContainerRequest,
 ) (*v1.CreateContainerResponse, error) {
 	podSandboxID := r.PodSandboxId
@@ -47,13 +64,27 @@ func (ds *dockerService) CreateContainer(
 		return nil, fmt.Errorf("sandbox config is nil for container %q", config.Metadata.Name)
 	}
 
-	labels := makeLabels(config.GetLabels(), config.GetAnnotations())
+	annotations, oversizedAnnotations := splitOversizedAnnotations(
+		config.GetAnnotations(),
+		ds.runtimeSettings.MaxAnnotationLabelBytes,
+	)
+	labels := makeLabels(config.GetLabels(), annotations)
+	if len(oversizedAnnotations) > 0 {
+		labels[externalAnnotationsLabelKey] = externalAnnotationsLabel(oversizedAnnotations)
+	}
 	// Apply a the container type label.
 	labels[containerTypeLabelKey] = containerTypeLabelContainer
 	// Write the container log path in the labels.
 	labels[containerLogPathLabelKey] = filepath.Join(sandboxConfig.LogDirectory, config.LogPath)
 	// Write the sandbox ID in the labels.
 	labels[sandboxIDLabelKey] = podSandboxID
+	addAnnotationLabels(labels, sandboxConfig.GetAnnotations(), ds.runtimeSettings.AnnotationToLabel)
+
+	// Kubernetes restarts the containers itself, docker must not race it.
+	restartPolicy, err := parseRestartPolicy(ds.runtimeSettings.ContainerRestartPolicy)
+	if err != nil {
+		return nil, err
+	}
 
 	apiVersion, err := ds.getDockerAPIVersion()
 	if err != nil {
@@ -65,19 +96,56 @@ func (ds *dockerService) CreateContainer(
 		image = iSpec.Image
 	}
 	containerName := makeContainerName(sandboxConfig, config)
-	mounts := config.GetMounts()
+	setFullNameLabel(labels, containerName, makeContainerFullName(sandboxConfig, config))
+	var imageInspect *dockertypes.ImageInspect
+	if image != "" {
+		imageInspect, err = ds.client.InspectImageByRef(image)
+		if err != nil {
+			logrus.Debugf("Unable to inspect image %q: %v", image, err)
+		}
+	}
+	if imageInspect != nil {
+		if ds.runtimeSettings.ValidateCommandAgainstImage &&
+			len(config.Command) == 0 && len(config.Args) == 0 {
+			if err := validateImageCommand(image, imageInspect); err != nil {
+				return nil, fmt.Errorf("invalid command for container %q: %v", config.Metadata.Name, err)
+			}
+		}
+		if err := ds.checkImagePlatform(image, imageInspect); err != nil {
+			return nil, fmt.Errorf("invalid image for container %q: %v", config.Metadata.Name, err)
+		}
+	}
+	mounts, tmpfs, err := splitTmpfsMounts(
+		config.GetMounts(),
+		sandboxConfig.GetAnnotations()[tmpfsSizeAnnotationKey],
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tmpfs mounts for container %q: %v", config.Metadata.Name, err)
+	}
+	if err := checkAllowedHostPaths(mounts, ds.runtimeSettings.AllowedHostPaths); err != nil {
+		return nil, fmt.Errorf("invalid mounts for container %q: %v", config.Metadata.Name, err)
+	}
 	terminationMessagePath, _ := config.Annotations["io.kubernetes.container.terminationMessagePath"]
 
 	sandboxInfo, err := ds.client.InspectContainer(r.GetPodSandboxId())
 	if err != nil {
 		return nil, fmt.Errorf("unable to get container's sandbox ID: %v", err)
 	}
+	// Inherit the correlation id of the sandbox.
+	correlationID := sandboxInfo.Config.Labels[correlationIDLabelKey]
+	labels[correlationIDLabelKey] = correlationID
+	if ds.runtimeSettings.EnableStartupDelayAnnotation {
+		if delay := startupDelay(sandboxConfig.GetAnnotations()); delay > 0 {
+			labels[startupDelayLabelKey] = delay.String()
+		}
+	}
+
 	createConfig := dockerbackend.ContainerCreateConfig{
 		Name: containerName,
 		Config: &container.Config{
 			Entrypoint: strslice.StrSlice(config.Command),
 			Cmd:        strslice.StrSlice(config.Args),
-			Env:        libdocker.GenerateEnvList(config.GetEnvs()),
+			Env:        makeContainerEnv(ds.runtimeSettings.DefaultEnv, config.GetEnvs()),
 			Image:      image,
 			WorkingDir: config.WorkingDir,
 			Labels:     labels,
@@ -92,14 +160,33 @@ func (ds *dockerService) CreateContainer(
 			},
 		},
 		HostConfig: &container.HostConfig{
-			Mounts: libdocker.GenerateMountBindings(mounts, terminationMessagePath),
-			RestartPolicy: container.RestartPolicy{
-				Name: "no",
-			},
-			Runtime: sandboxInfo.HostConfig.Runtime,
+			Mounts:        libdocker.GenerateMountBindings(mounts, terminationMessagePath),
+			Tmpfs:         tmpfs,
+			RestartPolicy: restartPolicy,
+			Runtime:       sandboxInfo.HostConfig.Runtime,
 		},
 	}
 
+	stopTimeout, err := ds.resolveStopTimeout(sandboxConfig.GetAnnotations(), imageInspect)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stop timeout for container %q: %v", config.Metadata.Name, err)
+	}
+	createConfig.Config.StopTimeout = stopTimeout
+
+	withInit, err := ds.resolveInit(sandboxConfig.GetAnnotations())
+	if err != nil {
+		return nil, fmt.Errorf("invalid init for container %q: %v", config.Metadata.Name, err)
+	}
+	createConfig.HostConfig.Init = withInit
+
+	applyDefaultMountPropagation(createConfig.HostConfig.Mounts, ds.defaultMountPropagation)
+
+	// Keep the anonymous volumes of the image from shadowing the CRI mounts.
+	if len(mounts) > 0 && imageInspect != nil && imageInspect.Config != nil {
+		hc := createConfig.HostConfig
+		hc.Mounts = append(hc.Mounts, makeImageVolumeMounts(imageInspect.Config.Volumes, hc.Mounts)...)
+	}
+
 	// Only request relabeling if the pod provides an SELinux context. If the pod
 	// does not provide an SELinux context relabeling will label the volume with
 	// the container's randomly allocated MCS label. This would restrict access
@@ -127,6 +214,7 @@ func (ds *dockerService) CreateContainer(
 		&createConfig,
 		config,
 		sandboxConfig,
+		imageInspect,
 		podSandboxID,
 		securityOptSeparator,
 		apiVersion,
@@ -134,6 +222,21 @@ func (ds *dockerService) CreateContainer(
 	if err != nil {
 		return nil, fmt.Errorf("failed to update container create config: %v", err)
 	}
+	if limit, ok := sandboxConfig.GetAnnotations()[ephemeralStorageLimitAnnotationKey]; ok {
+		storageOpt, err := ds.makeStorageOpt(limit)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to apply the ephemeral storage limit of container %q: %v",
+				config.Metadata.Name,
+				err,
+			)
+		}
+		hc.StorageOpt = storageOpt
+	}
+	hc.Annotations = filterRuntimeAnnotations(
+		config.GetAnnotations(),
+		ds.runtimeSettings.AllowedRuntimeAnnotations,
+	)
 	// Set devices for container.
 	devices := make([]container.DeviceMapping, len(config.Devices))
 	for i, device := range config.Devices {
@@ -144,6 +247,13 @@ func (ds *dockerService) CreateContainer(
 		}
 	}
 	hc.Resources.Devices = devices
+	if err := ds.applyCDIDevices(&createConfig, config); err != nil {
+		return nil, fmt.Errorf(
+			"failed to apply the CDI devices of container %q: %v",
+			config.Metadata.Name,
+			err,
+		)
+	}
 
 	securityOpts, err := ds.getSecurityOpts(
 		config.GetLinux().GetSecurityContext().GetSeccomp(),
@@ -164,7 +274,7 @@ func (ds *dockerService) CreateContainer(
 		return nil, err
 	}
 
-	createResp, createErr := ds.client.CreateContainer(createConfig)
+	createResp, createErr := ds.createContainerWithRetry(ctx, createConfig)
 	if createErr != nil {
 		createResp, createErr = recoverFromCreationConflictIfNeeded(
 			ds.client,
@@ -173,6 +283,23 @@ func (ds *dockerService) CreateContainer(
 		)
 	}
 
+	if createResp != nil && len(oversizedAnnotations) > 0 {
+		if err := ds.writeExternalAnnotations(createResp.ID, oversizedAnnotations); err != nil {
+			createErr = fmt.Errorf(
+				"failed to write the annotations of container %q: %v",
+				config.Metadata.Name,
+				err,
+			)
+			if rmErr := ds.client.RemoveContainer(
+				createResp.ID,
+				container.RemoveOptions{Force: true},
+			); rmErr != nil {
+				logrus.Errorf("Failed to remove container %s: %v", createResp.ID, rmErr)
+			}
+			createResp = nil
+		}
+	}
+
 	if createResp != nil {
 		containerID := createResp.ID
 
@@ -182,6 +309,9 @@ func (ds *dockerService) CreateContainer(
 			// registry keys); instead, we'll clean up when the container gets removed
 			ds.setContainerCleanupInfo(containerID, cleanupInfo)
 		}
+		containerLogger(correlationID, containerID).
+			WithField("podSandboxID", podSandboxID).
+			Infof("Created container %s", containerName)
 		return &v1.CreateContainerResponse{ContainerId: containerID}, nil
 	}
 
@@ -191,3 +321,413 @@ func (ds *dockerService) CreateContainer(
 
 	return nil, createErr
 }
+
+// filterRuntimeAnnotations returns the annotations whose key is allowed to be
+// forwarded to the OCI runtime, or nil if there are none. A trailing "*" in an
+// allowed key matches any suffix.
+func filterRuntimeAnnotations(annotations map[string]string, allowed []string) map[string]string {
+	var result map[string]string
+	for k, v := range annotations {
+		for _, a := range allowed {
+			prefix, isPrefix := strings.CutSuffix(a, "*")
+			if k == a || (isPrefix && strings.HasPrefix(k, prefix)) {
+				if result == nil {
+					result = make(map[string]string)
+				}
+				result[k] = v
+				break
+			}
+		}
+	}
+	return result
+}
+
+// validateImageCommand verifies that the image defines a command to run, for
+// containers which do not set any.
+func validateImageCommand(image string, imageInspect *dockertypes.ImageInspect) error {
+	if imageInspect.Config == nil {
+		return nil
+	}
+	if len(imageInspect.Config.Entrypoint) == 0 && len(imageInspect.Config.Cmd) == 0 {
+		return fmt.Errorf(
+			"no command specified: neither the container nor the image %q set a command or args",
+			image,
+		)
+	}
+	return nil
+}
+
+// checkImagePlatform warns when the image was built for another platform than
+// the node's, as its containers would then likely fail with exec format errors.
+// The container is rejected instead in strict mode.
+func (ds *dockerService) checkImagePlatform(
+	image string,
+	imageInspect *dockertypes.ImageInspect,
+) error {
+	if err := imagePlatformMismatch(imageInspect, nodeOS, nodeArch); err != nil {
+		if ds.runtimeSettings.StrictImagePlatform {
+			return err
+		}
+		logrus.Warningf("Containers of image %q may fail to start: %v", image, err)
+	}
+	return nil
+}
+
+// imagePlatformMismatch returns an error if the image was built for another
+// platform than the given one. Images not telling their platform match any.
+func imagePlatformMismatch(imageInspect *dockertypes.ImageInspect, os, arch string) error {
+	imageOS, imageArch := imageInspect.Os, normalizeArch(imageInspect.Architecture)
+	if (imageOS == "" || imageOS == os) && (imageArch == "" || imageArch == arch) {
+		return nil
+	}
+	imagePlatform := imageOS + "/" + imageArch
+	if imageInspect.Variant != "" {
+		imagePlatform += "/" + imageInspect.Variant
+	}
+	return fmt.Errorf(
+		"image platform %s does not match the node platform %s/%s",
+		imagePlatform,
+		os,
+		arch,
+	)
+}
+
+// normalizeArch returns the GOARCH name of the architectures some images
+// report under their kernel name.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	case "i386", "i686":
+		return "386"
+	}
+	return arch
+}
+
+// resolveStopTimeout returns the grace period, in seconds, docker gives a
+// container when it stops it on its own: the one of the pod annotation, else
+// the one of the image, else the default one. It returns nil if none is set.
+func (ds *dockerService) resolveStopTimeout(
+	podAnnotations map[string]string,
+	imageInspect *dockertypes.ImageInspect,
+) (*int, error) {
+	if value, ok := podAnnotations[stopTimeoutAnnotationKey]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid %s annotation %q", stopTimeoutAnnotationKey, value)
+		}
+		seconds := int(math.Ceil(timeout.Seconds()))
+		return &seconds, nil
+	}
+	if imageInspect != nil && imageInspect.Config != nil && imageInspect.Config.StopTimeout != nil {
+		seconds := *imageInspect.Config.StopTimeout
+		return &seconds, nil
+	}
+	if timeout := ds.runtimeSettings.DefaultStopTimeout; timeout > 0 {
+		seconds := int(math.Ceil(timeout.Seconds()))
+		return &seconds, nil
+	}
+	return nil, nil
+}
+
+// resolveInit returns whether docker runs an init process in the containers
+// of the pod: as set by its annotation, else by the default. It returns nil to
+// leave it to docker.
+func (ds *dockerService) resolveInit(podAnnotations map[string]string) (*bool, error) {
+	if value, ok := podAnnotations[initAnnotationKey]; ok {
+		withInit, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %v", initAnnotationKey, value, err)
+		}
+		return &withInit, nil
+	}
+	if ds.runtimeSettings.DefaultInit {
+		withInit := true
+		return &withInit, nil
+	}
+	return nil, nil
+}
+
+// validateDefaultEnv checks that the default environment variables are in the
+// KEY=VALUE form, and that no key is set twice.
+func validateDefaultEnv(defaultEnv []string) error {
+	keys := make(map[string]bool, len(defaultEnv))
+	for _, env := range defaultEnv {
+		key, _, ok := strings.Cut(env, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid default environment variable %q: must be KEY=VALUE", env)
+		}
+		if keys[key] {
+			return fmt.Errorf("default environment variable %q is set more than once", key)
+		}
+		keys[key] = true
+	}
+	return nil
+}
+
+// parseRestartPolicy returns the docker restart policy of the containers, as
+// no, always, unless-stopped or on-failure[:max-retries]. Empty is no.
+func parseRestartPolicy(policy string) (container.RestartPolicy, error) {
+	if policy == "" {
+		return container.RestartPolicy{Name: container.RestartPolicyDisabled}, nil
+	}
+	name, retries, hasRetries := strings.Cut(policy, ":")
+	mode := container.RestartPolicyMode(name)
+	switch {
+	case hasRetries && mode == container.RestartPolicyOnFailure:
+		count, err := strconv.Atoi(retries)
+		if err == nil && count >= 0 {
+			return container.RestartPolicy{Name: mode, MaximumRetryCount: count}, nil
+		}
+	case !hasRetries && (mode == container.RestartPolicyDisabled ||
+		mode == container.RestartPolicyAlways ||
+		mode == container.RestartPolicyUnlessStopped ||
+		mode == container.RestartPolicyOnFailure):
+		return container.RestartPolicy{Name: mode}, nil
+	}
+	return container.RestartPolicy{}, fmt.Errorf(
+		"invalid container restart policy %q: must be no, always, unless-stopped or on-failure[:max-retries]",
+		policy,
+	)
+}
+
+// checkAllowedHostPaths checks that the host paths of the bind mounts are below
+// the allowed host paths, if any.
+func checkAllowedHostPaths(mounts []*v1.Mount, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, m := range mounts {
+		if m.HostPath == "" {
+			continue
+		}
+		if !hostPathAllowed(m.HostPath, allowed) {
+			return fmt.Errorf(
+				"host path %q mounted at %q is not below the allowed host paths %v",
+				m.HostPath,
+				m.ContainerPath,
+				allowed,
+			)
+		}
+	}
+	return nil
+}
+
+// hostPathAllowed returns whether the host path is below one of the allowed
+// paths. Symlinks are resolved first, so that they can't lead out of them.
+func hostPathAllowed(hostPath string, allowed []string) bool {
+	path := resolveHostPath(hostPath)
+	for _, prefix := range allowed {
+		rel, err := filepath.Rel(resolveHostPath(prefix), path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveHostPath returns the cleaned host path, with its symlinks resolved if
+// it exists.
+func resolveHostPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
+// parseMountPropagation returns the docker propagation matching the default
+// mount propagation setting. Private, the default, leaves it to dockerd.
+func parseMountPropagation(propagation string) (dockermount.Propagation, error) {
+	switch propagation {
+	case "", "private":
+		return "", nil
+	case "rslave":
+		return dockermount.PropagationRSlave, nil
+	case "rshared":
+		return dockermount.PropagationRShared, nil
+	}
+	return "", fmt.Errorf(
+		"invalid default mount propagation %q: must be private, rslave or rshared",
+		propagation,
+	)
+}
+
+// applyDefaultMountPropagation sets the default propagation on the bind mounts
+// which do not request one. The CRI cannot tell a mount requesting private
+// propagation from one requesting none, so both get the default.
+func applyDefaultMountPropagation(mounts []dockermount.Mount, propagation dockermount.Propagation) {
+	if propagation == "" {
+		return
+	}
+	for i := range mounts {
+		m := &mounts[i]
+		if m.Type == dockermount.TypeBind && m.BindOptions != nil && m.BindOptions.Propagation == "" {
+			m.BindOptions.Propagation = propagation
+		}
+	}
+}
+
+// validateAnnotationToLabel checks that the pod annotations are not copied to
+// the internal labels, nor to the labels holding the container annotations.
+func validateAnnotationToLabel(mapping map[string]string) error {
+	for annotation, label := range mapping {
+		if label == "" || isInternalLabelKey(label) || strings.HasPrefix(label, annotationPrefix) ||
+			strings.Contains(label, ",") {
+			return fmt.Errorf("invalid label %q for the pod annotation %q: reserved", label, annotation)
+		}
+	}
+	return nil
+}
+
+// addAnnotationLabels copies the values of the pod annotations to the labels
+// they are mapped to. The labels already set, among which the internal ones,
+// are kept. The copied labels are listed in an internal label, so that they
+// are not reported back as CRI labels.
+func addAnnotationLabels(labels, podAnnotations, mapping map[string]string) {
+	var copied []string
+	for annotation, label := range mapping {
+		value, ok := podAnnotations[annotation]
+		if !ok {
+			continue
+		}
+		if _, exists := labels[label]; exists || isInternalLabelKey(label) {
+			logrus.Debugf("Not copying the pod annotation %q to the label %q already set", annotation, label)
+			continue
+		}
+		labels[label] = value
+		copied = append(copied, label)
+	}
+	if len(copied) > 0 {
+		sort.Strings(copied)
+		labels[annotationLabelsLabelKey] = strings.Join(copied, ",")
+	}
+}
+
+// makeContainerEnv returns the environment of a container: the default
+// variables, in the configured order, followed by the variables of the
+// container in its order. Default variables whose key is defined by the
+// container are left out, so the container's value wins.
+func makeContainerEnv(defaultEnv []string, envs []*v1.KeyValue) []string {
+	containerKeys := make(map[string]bool, len(envs))
+	for _, env := range envs {
+		containerKeys[env.Key] = true
+	}
+	var result []string
+	for _, env := range defaultEnv {
+		key, _, _ := strings.Cut(env, "=")
+		if !containerKeys[key] {
+			result = append(result, env)
+		}
+	}
+	return append(result, libdocker.GenerateEnvList(envs)...)
+}
+
+// splitTmpfsMounts separates the mounts without host path, which are backed by
+// a tmpfs, from the bind mounts. It returns the bind mounts, and the options of
+// the tmpfs mounts by container path, with the given size if not empty.
+func splitTmpfsMounts(mounts []*v1.Mount, size string) ([]*v1.Mount, map[string]string, error) {
+	var sizeOpt string
+	if size != "" {
+		quantity, err := resource.ParseQuantity(size)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid size %q: %v", size, err)
+		}
+		if quantity.Sign() <= 0 {
+			return nil, nil, fmt.Errorf("invalid size %q: must be positive", size)
+		}
+		sizeOpt = "size=" + strconv.FormatInt(quantity.Value(), 10)
+	}
+	var binds []*v1.Mount
+	var tmpfs map[string]string
+	for _, m := range mounts {
+		if m.HostPath != "" {
+			binds = append(binds, m)
+			continue
+		}
+		opts := []string{"rw"}
+		if m.Readonly {
+			opts[0] = "ro"
+		}
+		if sizeOpt != "" {
+			opts = append(opts, sizeOpt)
+		}
+		if tmpfs == nil {
+			tmpfs = make(map[string]string)
+		}
+		tmpfs[m.ContainerPath] = strings.Join(opts, ",")
+	}
+	return binds, tmpfs, nil
+}
+
+// storageQuotaDrivers are the graph drivers able to limit the size of the
+// writable layer of containers.
+var storageQuotaDrivers = map[string]bool{
+	"overlay2":      true,
+	"btrfs":         true,
+	"zfs":           true,
+	"devicemapper":  true,
+	"windowsfilter": true,
+}
+
+// makeStorageOpt returns the storage options limiting the size of the writable
+// layer of a container to the given resource quantity. It fails if the graph
+// driver of docker doesn't support it.
+func (ds *dockerService) makeStorageOpt(limit string) (map[string]string, error) {
+	quantity, err := resource.ParseQuantity(limit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid limit %q: %v", limit, err)
+	}
+	if quantity.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid limit %q: must be positive", limit)
+	}
+	info, err := ds.getDockerInfo()
+	if err != nil {
+		return nil, err
+	}
+	supported := storageQuotaDrivers[info.Driver]
+	if info.Driver == "overlay2" {
+		// Overlay2 only enforces quotas on xfs, mounted with project quotas.
+		supported = false
+		for _, status := range info.DriverStatus {
+			if status[0] == "Backing Filesystem" && status[1] == "xfs" {
+				supported = true
+			}
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("storage driver %q does not support storage quotas", info.Driver)
+	}
+	return map[string]string{"size": strconv.FormatInt(quantity.Value(), 10)}, nil
+}
+
+// createContainerWithRetry creates the container, retrying with an exponential
+// backoff as long as docker fails with a transient lock error. Other errors,
+// including name conflicts, are returned right away, and so is the last error
+// when the context is done while waiting to retry.
+func (ds *dockerService) createContainerWithRetry(
+	ctx context.Context,
+	createConfig dockerbackend.ContainerCreateConfig,
+) (*container.CreateResponse, error) {
+	backoff := ds.runtimeSettings.ContainerCreateRetryBackoff
+	for retry := 0; ; retry++ {
+		createResp, err := ds.client.CreateContainer(createConfig)
+		if err == nil || retry >= ds.runtimeSettings.ContainerCreateRetries ||
+			!transientCreateRE.MatchString(err.Error()) {
+			return createResp, err
+		}
+		logrus.Infof(
+			"Transient error creating container %s, retrying in %v: %v",
+			createConfig.Name,
+			backoff,
+			err,
+		)
+		select {
+		case <-ctx.Done():
+			return createResp, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
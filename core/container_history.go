@@ -0,0 +1,92 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// maxContainerRuns is the number of runs kept in the history of a container.
+const maxContainerRuns = 2
+
+// containerRun holds the timestamps of a run of a container.
+type containerRun struct {
+	StartedAt  time.Time `json:"startedAt,omitempty"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+}
+
+// containerHistoryCache remembers the recent runs of containers, as docker only
+// reports the timestamps of the latest one.
+type containerHistoryCache struct {
+	sync.Mutex
+	runs map[string][]containerRun
+}
+
+func newContainerHistoryCache() *containerHistoryCache {
+	return &containerHistoryCache{
+		runs: make(map[string][]containerRun),
+	}
+}
+
+// observe records the timestamps reported by docker for a container, and
+// returns the previous run of the container, if any.
+func (c *containerHistoryCache) observe(
+	containerID string,
+	startedAt, finishedAt time.Time,
+) (containerRun, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if startedAt.IsZero() {
+		return containerRun{}, false
+	}
+	runs := c.runs[containerID]
+	if n := len(runs); n > 0 && runs[n-1].StartedAt.Equal(startedAt) {
+		// Still the same run, which may have finished since.
+		if finishedAt.After(startedAt) {
+			runs[n-1].FinishedAt = finishedAt
+		}
+	} else {
+		// A new run started. Until it finishes, docker keeps reporting the
+		// finish time of the previous run.
+		if !finishedAt.IsZero() && finishedAt.Before(startedAt) {
+			if n == 0 {
+				runs = append(runs, containerRun{})
+			}
+			runs[len(runs)-1].FinishedAt = finishedAt
+		}
+		run := containerRun{StartedAt: startedAt}
+		if finishedAt.After(startedAt) {
+			run.FinishedAt = finishedAt
+		}
+		runs = append(runs, run)
+		if len(runs) > maxContainerRuns {
+			runs = runs[len(runs)-maxContainerRuns:]
+		}
+	}
+	c.runs[containerID] = runs
+	if len(runs) < 2 {
+		return containerRun{}, false
+	}
+	return runs[len(runs)-2], true
+}
+
+func (c *containerHistoryCache) remove(containerID string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.runs, containerID)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+)
+
+// containerEventsRetryInterval is the delay before watching the docker events
+// again after the stream failed.
+const containerEventsRetryInterval = time.Second
+
+// inspectInvalidatingActions are the actions of the container events which
+// change what an inspection of the container returns.
+var inspectInvalidatingActions = map[dockerevents.Action]bool{
+	dockerevents.ActionStart:   true,
+	dockerevents.ActionRestart: true,
+	dockerevents.ActionStop:    true,
+	dockerevents.ActionDie:     true,
+	dockerevents.ActionOOM:     true,
+	dockerevents.ActionPause:   true,
+	dockerevents.ActionUnPause: true,
+	dockerevents.ActionUpdate:  true,
+	dockerevents.ActionRename:  true,
+	dockerevents.ActionDestroy: true,
+}
+
+// containerInspectCache keeps the last inspection of containers for their
+// status, as long as no docker event invalidated it. It is only used while
+// the docker events are watched. The zero value is ready to use.
+type containerInspectCache struct {
+	sync.Mutex
+	// watching is set while the docker events are watched.
+	watching bool
+	inspects map[string]*dockertypes.ContainerJSON
+	// generation is incremented by every invalidation, so that an inspection
+	// which raced with an event is not cached.
+	generation uint64
+}
+
+// get returns the cached inspection of the container, if any, and otherwise
+// the generation to store a new inspection with.
+func (c *containerInspectCache) get(containerID string) (*dockertypes.ContainerJSON, uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.watching {
+		return nil, c.generation
+	}
+	return c.inspects[containerID], c.generation
+}
+
+// store caches the inspection of the container, unless the cache was
+// invalidated since the given generation.
+func (c *containerInspectCache) store(
+	containerID string,
+	r *dockertypes.ContainerJSON,
+	generation uint64,
+) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.watching || generation != c.generation {
+		return
+	}
+	if c.inspects == nil {
+		c.inspects = make(map[string]*dockertypes.ContainerJSON)
+	}
+	c.inspects[containerID] = r
+}
+
+// invalidate drops the cached inspection of the container.
+func (c *containerInspectCache) invalidate(containerID string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.inspects, containerID)
+	c.generation++
+}
+
+// setWatching enables or disables the cache, dropping all the inspections.
+func (c *containerInspectCache) setWatching(watching bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.watching = watching
+	c.inspects = nil
+	c.generation++
+}
+
+// inspectContainerForStatus returns the cached inspection of the container,
+// inspecting it if needed. As the events carry the full ids of containers,
+// only the inspections of containers requested by their full id are cached.
+func (ds *dockerService) inspectContainerForStatus(containerID string) (*dockertypes.ContainerJSON, error) {
+	r, generation := ds.containerInspectCache.get(containerID)
+	if r != nil {
+		return r, nil
+	}
+	r, err := ds.client.InspectContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+	if r.ID == containerID {
+		ds.containerInspectCache.store(containerID, r, generation)
+	}
+	return r, nil
+}
+
+// startContainerEventWatch watches the docker container events, to invalidate
+// the cached inspections, for as long as cri-dockerd runs.
+func (ds *dockerService) startContainerEventWatch() {
+	for {
+		err := ds.watchContainerEvents()
+		logrus.Errorf("Stopped watching the docker container events: %v", err)
+		time.Sleep(containerEventsRetryInterval)
+	}
+}
+
+// watchContainerEvents enables the inspection cache and invalidates its entries
+// on the docker container events, until the event stream fails.
+func (ds *dockerService) watchContainerEvents() error {
+	// The events since the stream is requested are replayed, so that none is
+	// missed until it is established.
+	opts := dockertypes.EventsOptions{
+		Since:   strconv.FormatInt(time.Now().Unix(), 10),
+		Filters: filters.NewArgs(filters.Arg("type", string(dockerevents.ContainerEventType))),
+	}
+	events, errs := ds.client.Events(opts)
+	ds.containerInspectCache.setWatching(true)
+	defer ds.containerInspectCache.setWatching(false)
+	for {
+		select {
+		case event := <-events:
+			if inspectInvalidatingActions[event.Action] {
+				ds.containerInspectCache.invalidate(event.Actor.ID)
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
@@ -18,6 +18,7 @@ package core
 
 import (
 	"context"
+	"sort"
 
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	dockercontainer "github.com/docker/docker/api/types/container"
@@ -44,7 +45,9 @@ func (ds *dockerService) ListContainers(
 			f.Add("id", filter.Id)
 		}
 		if filter.State != nil {
-			f.Add("status", toDockerContainerStatus(filter.GetState().State))
+			for _, status := range toDockerContainerStatuses(filter.GetState().State) {
+				f.Add("status", status)
+			}
 		}
 		if filter.PodSandboxId != "" {
 			f.AddLabel(sandboxIDLabelKey, filter.PodSandboxId)
@@ -70,9 +73,20 @@ func (ds *dockerService) ListContainers(
 			logrus.Infof("Unable to convert docker container %v to runtime API container: %v", c, err)
 			continue
 		}
+		ds.restoreExternalAnnotations(c.ID, c.Labels, converted.Annotations)
+		// Docker narrows the list by status already, but it has no notion of
+		// the unknown state, and some of its statuses don't map to the
+		// requested one.
+		if filter.GetState() != nil && converted.State != filter.GetState().State {
+			continue
+		}
 
 		result = append(result, converted)
 	}
+	// Docker lists the most recent containers first, keep it that way.
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedAt > result[j].CreatedAt
+	})
 
 	return &v1.ListContainersResponse{Containers: result}, nil
 }
@@ -19,43 +19,129 @@ package core
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
-// RemoveContainer removes the container.
+// RemoveContainer removes the container. The most recent exited containers of
+// a running sandbox are kept when configured, for debugging: their removals
+// by the garbage collection of the kubelet are no-ops until the sandbox is
+// stopped. Other removals, e.g. with crictl, are carried out.
 func (ds *dockerService) RemoveContainer(
 	_ context.Context,
 	r *v1.RemoveContainerRequest,
 ) (*v1.RemoveContainerResponse, error) {
+	if ds.retainExitedContainer(r.ContainerId) {
+		logrus.Infof(
+			"Retaining exited container %s for debugging until its sandbox is stopped",
+			r.ContainerId,
+		)
+		return &v1.RemoveContainerResponse{}, nil
+	}
+	if err := ds.removeContainer(r.ContainerId); err != nil {
+		return nil, err
+	}
+	return &v1.RemoveContainerResponse{}, nil
+}
+
+// removeContainer removes the container and its side data.
+func (ds *dockerService) removeContainer(containerID string) error {
 	// Ideally, log lifecycle should be independent of container lifecycle.
 	// However, docker will remove container log after container is removed,
 	// we can't prevent that now, so we also clean up the symlink here.
-	err := ds.removeContainerLogSymlink(r.ContainerId)
+	err := ds.removeContainerLogSymlink(containerID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	errors := ds.performPlatformSpecificContainerForContainer(r.ContainerId)
+	errors := ds.performPlatformSpecificContainerForContainer(containerID)
 	if len(errors) != 0 {
-		return nil, fmt.Errorf(
+		return fmt.Errorf(
 			"failed to run platform-specific clean ups for container %q: %v",
-			r.ContainerId,
+			containerID,
 			errors,
 		)
 	}
 	err = ds.client.RemoveContainer(
-		r.ContainerId,
+		containerID,
 		dockercontainer.RemoveOptions{RemoveVolumes: true, Force: true},
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to remove container %q: %v", r.ContainerId, err)
+		return fmt.Errorf("failed to remove container %q: %v", containerID, err)
+	}
+	ds.seccompDenialCache.remove(containerID)
+	ds.containerHistoryCache.remove(containerID)
+	ds.containerInspectCache.invalidate(containerID)
+	if ds.streamingRuntime != nil {
+		ds.streamingRuntime.ForgetContainer(containerID)
+	}
+	ds.containerStatsCache.removePeakMemory(containerID)
+	if err := ds.removeExternalAnnotations(containerID); err != nil {
+		logrus.Warning(err)
 	}
+	return nil
+}
 
-	return &v1.RemoveContainerResponse{}, nil
+// retainExitedContainer tells whether the exited container is among the most
+// recent exited containers of its sandbox which are retained. Nothing is
+// retained once the sandbox is stopped, so that the kubelet can remove the
+// containers of deleted pods. Only the removals made by the kubelet are
+// retained: the kubelet deletes the log of a container before removing it, so
+// a container whose log is still in place is removed by someone else.
+// Deciding costs two inspections, usually cached, and a list of the containers
+// of the sandbox per removal when retention is enabled.
+func (ds *dockerService) retainExitedContainer(containerID string) bool {
+	retained := ds.runtimeSettings.RetainExitedContainers
+	if retained <= 0 {
+		return false
+	}
+	info, err := ds.inspectContainerForStatus(containerID)
+	if err != nil || info.State == nil || info.Config == nil ||
+		dockerStateToRuntimeAPIContainerState(info.State.Status) != v1.ContainerState_CONTAINER_EXITED {
+		return false
+	}
+	logPath := info.Config.Labels[containerLogPathLabelKey]
+	if logPath == "" {
+		return false
+	}
+	if _, err := ds.os.Stat(logPath); err == nil {
+		return false
+	}
+	sandboxID := info.Config.Labels[sandboxIDLabelKey]
+	sandbox, err := ds.inspectContainerForStatus(sandboxID)
+	if err != nil || sandbox.State == nil || !sandbox.State.Running {
+		return false
+	}
+
+	opts := dockercontainer.ListOptions{All: true, Filters: filters.NewArgs()}
+	f := NewDockerFilter(&opts.Filters)
+	f.AddLabel(containerTypeLabelKey, containerTypeLabelContainer)
+	f.AddLabel(sandboxIDLabelKey, sandboxID)
+	containers, err := ds.client.ListContainers(opts)
+	if err != nil {
+		return false
+	}
+	var exited []types.Container
+	for _, c := range containers {
+		if toRuntimeAPIContainerState(c.Status) == v1.ContainerState_CONTAINER_EXITED {
+			exited = append(exited, c)
+		}
+	}
+	sort.SliceStable(exited, func(i, j int) bool {
+		return exited[i].Created > exited[j].Created
+	})
+	for i := 0; i < len(exited) && i < retained; i++ {
+		if exited[i].ID == containerID {
+			return true
+		}
+	}
+	return false
 }
 
 func (ds *dockerService) getContainerCleanupInfo(containerID string) (*containerCleanupInfo, bool) {
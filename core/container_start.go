@@ -19,18 +19,40 @@ package core
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
 // StartContainer starts the container.
 func (ds *dockerService) StartContainer(
-	_ context.Context,
+	ctx context.Context,
 	r *v1.StartContainerRequest,
 ) (*v1.StartContainerResponse, error) {
-	err := ds.client.StartContainer(r.ContainerId)
+	if ds.runtimeSettings.EnableStartupDelayAnnotation {
+		if err := ds.waitStartupDelay(ctx, r.ContainerId); err != nil {
+			return nil, fmt.Errorf("failed to start container %q: %v", r.ContainerId, err)
+		}
+	}
+	err := ds.startContainerWithRetry(ctx, r.ContainerId)
+	ds.containerInspectCache.invalidate(r.ContainerId)
+
+	// The inspection of the started container serves the log symlink, the
+	// hugepage limits and the correlation id of the logs.
+	info, inspectErr := ds.client.InspectContainer(r.ContainerId)
+	if inspectErr != nil {
+		return nil, fmt.Errorf(
+			"failed to get container %q log path: failed to inspect container %q: %v",
+			r.ContainerId,
+			r.ContainerId,
+			inspectErr,
+		)
+	}
+	logger := containerLogger(getCorrelationID(info), r.ContainerId)
 
 	// Create container log symlink for all containers (including failed ones).
-	if linkError := ds.createContainerLogSymlink(r.ContainerId); linkError != nil {
+	if linkError := ds.linkContainerLog(info); linkError != nil {
 		// Do not stop the container if we failed to create symlink because:
 		//   1. This is not a critical failure.
 		//   2. We don't have enough information to properly stop container here.
@@ -40,12 +62,88 @@ func (ds *dockerService) StartContainer(
 
 	if err != nil {
 		err = transformStartContainerError(err)
+		logger.Errorf("Failed to start container: %v", err)
 		return nil, fmt.Errorf("failed to start container %q: %v", r.ContainerId, err)
 	}
 
+	if err := ds.applyHugepageLimits(info); err != nil {
+		logger.Errorf("Failed to apply hugepage limits, stopping the container: %v", err)
+		if stopErr := ds.client.StopContainer(r.ContainerId, 0); stopErr != nil {
+			logger.Errorf("Failed to stop container: %v", stopErr)
+		}
+		ds.containerInspectCache.invalidate(r.ContainerId)
+		return nil, fmt.Errorf("failed to apply hugepage limits of container %q: %v", r.ContainerId, err)
+	}
+
+	logger.Info("Started container")
 	return &v1.StartContainerResponse{}, nil
 }
 
+// startupDelay returns the duration set in the startup delay annotation of the
+// pod, if any. An invalid duration is ignored.
+func startupDelay(podAnnotations map[string]string) time.Duration {
+	value, ok := podAnnotations[startupDelayAnnotationKey]
+	if !ok {
+		return 0
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay < 0 {
+		logrus.Warningf("Ignoring invalid startup delay %q", value)
+		return 0
+	}
+	return delay
+}
+
+// waitStartupDelay waits for the startup delay recorded when the container
+// was created, if any.
+func (ds *dockerService) waitStartupDelay(ctx context.Context, containerID string) error {
+	info, err := ds.client.InspectContainer(containerID)
+	if err != nil {
+		return err
+	}
+	value, ok := info.Config.Labels[startupDelayLabelKey]
+	if !ok {
+		return nil
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Delaying the start of container %s by %v", containerID, delay)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// startContainerWithRetry starts the container, retrying with an exponential
+// backoff as long as docker fails with a transient error. Other errors, such
+// as a missing image or an invalid configuration, are returned right away.
+func (ds *dockerService) startContainerWithRetry(ctx context.Context, containerID string) error {
+	backoff := ds.runtimeSettings.ContainerStartRetryBackoff
+	for retry := 0; ; retry++ {
+		err := ds.client.StartContainer(containerID)
+		if err == nil || retry >= ds.runtimeSettings.ContainerStartRetries ||
+			!transientStartRE.MatchString(err.Error()) {
+			return err
+		}
+		logrus.Infof(
+			"Transient error starting container %s, retrying in %v: %v",
+			containerID,
+			backoff,
+			err,
+		)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
 // transformStartContainerError does regex parsing on returned error
 // for where container runtimes are giving less than ideal error messages.
 func transformStartContainerError(err error) error {
@@ -19,18 +19,38 @@ package core
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
 	"github.com/Mirantis/cri-dockerd/libdocker"
+	dockertypes "github.com/docker/docker/api/types"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
 // ContainerStatus inspects the docker container and returns the status.
+// Concurrent identical requests for a container are coalesced, so that they
+// inspect it only once and share the response, which must not be modified.
 func (ds *dockerService) ContainerStatus(
 	_ context.Context,
 	req *v1.ContainerStatusRequest,
+) (*v1.ContainerStatusResponse, error) {
+	key := fmt.Sprintf("%s/%t", req.ContainerId, req.Verbose)
+	resp, err, _ := ds.containerStatusGroup.Do(key, func() (interface{}, error) {
+		return ds.containerStatus(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*v1.ContainerStatusResponse), nil
+}
+
+func (ds *dockerService) containerStatus(
+	req *v1.ContainerStatusRequest,
 ) (*v1.ContainerStatusResponse, error) {
 	containerID := req.ContainerId
-	r, err := ds.client.InspectContainer(containerID)
+	r, err := ds.inspectContainerForStatus(containerID)
 	if err != nil {
 		return nil, err
 	}
@@ -58,63 +78,78 @@ func (ds *dockerService) ContainerStatus(
 
 	// Convert the mounts.
 	mounts := make([]*v1.Mount, 0, len(r.Mounts))
+	requestedPropagations := requestedMountPropagations(r.HostConfig)
 	for i := range r.Mounts {
 		m := r.Mounts[i]
 		readonly := !m.RW
+		propagation, ok := requestedPropagations[filepath.Clean(m.Destination)]
+		if !ok {
+			propagation = m.Propagation
+		}
 		mounts = append(mounts, &v1.Mount{
 			HostPath:      m.Source,
 			ContainerPath: m.Destination,
 			Readonly:      readonly,
+			Propagation:   toRuntimeAPIMountPropagation(propagation),
 			// Note: Can't set SeLinuxRelabel
 		})
 	}
 	// Interpret container states.
-	var state v1.ContainerState
+	state := inspectToRuntimeAPIContainerState(r.State, finishedAt)
+	previousRun, hasPreviousRun := ds.containerHistoryCache.observe(containerID, startedAt, finishedAt)
 	var reason, message string
-	if r.State.Running {
-		// Container is running.
-		state = v1.ContainerState_CONTAINER_RUNNING
-	} else {
-		// Container is *not* running. We need to get more details.
-		//    * Case 1: container has run and exited with non-zero finishedAt
-		//              time.
-		//    * Case 2: container has failed to start; it has a zero finishedAt
-		//              time, but a non-zero exit code.
-		//    * Case 3: container has been created, but not started (yet).
-		if !finishedAt.IsZero() { // Case 1
-			state = v1.ContainerState_CONTAINER_EXITED
-			switch {
-			case r.State.OOMKilled:
-				// Note: if an application handles OOMKilled gracefully, the
-				// exit code could be zero.
-				reason = "OOMKilled"
-			case r.State.ExitCode == 0:
-				reason = "Completed"
-			default:
-				reason = "Error"
-			}
-		} else if r.State.ExitCode != 0 { // Case 2
-			state = v1.ContainerState_CONTAINER_EXITED
+	if state != v1.ContainerState_CONTAINER_RUNNING {
+		message = r.State.Error
+	}
+	if state == v1.ContainerState_CONTAINER_EXITED {
+		// The container has either run and exited with a non-zero finishedAt
+		// time, or it has failed to start; then it has a zero finishedAt time,
+		// but a non-zero exit code.
+		switch {
+		case finishedAt.IsZero() && r.State.ExitCode != 0:
 			// Adjust finshedAt and startedAt time to createdAt time to avoid
 			// the confusion.
 			finishedAt, startedAt = createdAt, createdAt
 			reason = "ContainerCannotRun"
-		} else { // Case 3
-			state = v1.ContainerState_CONTAINER_CREATED
+		case r.State.OOMKilled:
+			// Note: if an application handles OOMKilled gracefully, the
+			// exit code could be zero.
+			reason = "OOMKilled"
+		case r.State.ExitCode == 0:
+			reason = "Completed"
+		default:
+			reason = "Error"
+			// Docker reports the exit code of a container terminated by a
+			// signal as 128+signal, like shells do.
+			if signal, ok := exitSignal(r.State.ExitCode); ok {
+				reason = "Signaled"
+				if message == "" {
+					message = fmt.Sprintf("Terminated by signal %s", signal)
+				}
+			}
 		}
-		message = r.State.Error
+	}
+
+	if state == v1.ContainerState_CONTAINER_RUNNING {
+		// Docker reports the finish time of the previous run of restarted
+		// containers, which is available in the verbose status instead.
+		finishedAt = time.Time{}
 	}
 
 	// Convert to unix timestamps.
 	ct, st, ft := createdAt.UnixNano(), startedAt.UnixNano(), finishedAt.UnixNano()
 	exitCode := int32(r.State.ExitCode)
 
-	metadata, err := parseContainerName(r.Name)
+	metadata, err := parseContainerName(fullName(r.Name, r.Config.Labels))
 	if err != nil {
 		return nil, err
 	}
 
 	labels, annotations := extractLabels(r.Config.Labels)
+	ds.restoreExternalAnnotations(r.ID, r.Config.Labels, annotations)
+	if r.State.Paused {
+		annotations[pausedAnnotationKey] = "true"
+	}
 	imageName := r.Config.Image
 	if ir != nil && len(ir.RepoTags) > 0 {
 		imageName = ir.RepoTags[0]
@@ -138,7 +173,17 @@ func (ds *dockerService) ContainerStatus(
 	}
 	res := v1.ContainerStatusResponse{Status: status}
 	if req.GetVerbose() {
-		containerInfo, err := containerInspectToRuntimeAPIContainerInfo(r)
+		var previous *containerRun
+		if hasPreviousRun {
+			previous = &previousRun
+		}
+		containerInfo, err := containerInspectToRuntimeAPIContainerInfo(
+			r,
+			ir,
+			ds.seccompDenialCache.get(containerID),
+			previous,
+			ds.zombieProcesses(r),
+		)
 		if err != nil {
 			return nil, err
 		}
@@ -146,3 +191,46 @@ func (ds *dockerService) ContainerStatus(
 	}
 	return &res, nil
 }
+
+// zombieProcesses returns the number of zombie processes in the running
+// container, if they are to be reported.
+func (ds *dockerService) zombieProcesses(r *dockertypes.ContainerJSON) *int {
+	if !ds.runtimeSettings.ReportZombieProcesses || !r.State.Running || r.State.Pid == 0 {
+		return nil
+	}
+	zombies, err := countZombieProcesses(r.State.Pid)
+	if err != nil {
+		logrus.Debugf("Failed to count the zombie processes of container %s: %v", r.ID, err)
+		return nil
+	}
+	return &zombies
+}
+
+// signalNames are the names of the signals commonly terminating containers.
+var signalNames = map[int]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	6:  "SIGABRT",
+	7:  "SIGBUS",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	11: "SIGSEGV",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+}
+
+// exitSignal returns the name of the signal which terminated a container,
+// from its exit code following the 128+signal convention.
+func exitSignal(exitCode int) (string, bool) {
+	signal := exitCode - 128
+	if signal < 1 || signal > 64 {
+		return "", false
+	}
+	if name, ok := signalNames[signal]; ok {
+		return name, true
+	}
+	return strconv.Itoa(signal), true
+}
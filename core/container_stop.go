@@ -18,26 +18,88 @@ package core
 
 import (
 	"context"
+	"fmt"
 	"time"
 
-	"github.com/Mirantis/cri-dockerd/libdocker"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
 )
 
 // StopContainer stops a running container with a grace period (i.e., timeout).
+// A container still running after its stop failed is stopped once more without
+// a grace period, and force removed only if it survives that kill too.
 func (ds *dockerService) StopContainer(
 	_ context.Context,
 	r *v1.StopContainerRequest,
 ) (*v1.StopContainerResponse, error) {
-	err := ds.client.StopContainer(r.ContainerId, time.Duration(r.Timeout)*time.Second)
+	// The labels of the container don't change, so a cached inspection serves
+	// the correlation id and the sandbox of the container.
+	info, _ := ds.inspectContainerForStatus(r.ContainerId)
+	logger := containerLogger(getCorrelationID(info), r.ContainerId)
+	// A zero timeout kills the container right away, as the kubelet asks for
+	// when the grace period is over. The stop timeout recorded at creation
+	// only serves the stops docker makes on its own.
+	timeout := time.Duration(r.Timeout) * time.Second
+	var podSandboxID string
+	if info != nil && info.Config != nil {
+		podSandboxID = info.Config.Labels[sandboxIDLabelKey]
+	}
+	err := ds.client.StopContainer(r.ContainerId, timeout)
+	ds.containerInspectCache.invalidate(r.ContainerId)
 	if err != nil {
+		logger.Errorf("Failed to stop container: %v", err)
 		if libdocker.IsContainerNotFoundError(err) {
-			err = status.Error(codes.NotFound, err.Error())
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if !ds.containerRunning(r.ContainerId) {
+			return nil, err
+		}
+		logger.Warn("Container is still running after its stop, killing it")
+		err = ds.client.StopContainer(r.ContainerId, 0)
+		ds.containerInspectCache.invalidate(r.ContainerId)
+		if err != nil && ds.containerRunning(r.ContainerId) {
+			logger.Errorf("Failed to kill container: %v", err)
+			if err := ds.forceRemoveStuckContainer(logger, r.ContainerId); err != nil {
+				return nil, err
+			}
+			ds.setContainerStopped(podSandboxID, r.ContainerId)
+			return &v1.StopContainerResponse{}, nil
 		}
-		return nil, err
 	}
+	ds.setContainerStopped(podSandboxID, r.ContainerId)
+	logger.Info("Stopped container")
 	return &v1.StopContainerResponse{}, nil
 }
+
+// containerRunning tells whether docker still reports the container running.
+func (ds *dockerService) containerRunning(containerID string) bool {
+	info, err := ds.client.InspectContainer(containerID)
+	return err == nil && info.State != nil && info.State.Running
+}
+
+// forceRemoveStuckContainer force removes a container which survived its stop
+// and its kill, e.g. stuck in an uninterruptible state, so that the kubelet
+// doesn't wait on it forever. The removal is attempted once. The container
+// never exited, so it has no exit code, and its status, including its
+// termination message, is lost with it: the kubelet sees it as gone.
+func (ds *dockerService) forceRemoveStuckContainer(logger *logrus.Entry, containerID string) error {
+	logger.Warn(
+		"Container is still running after its kill, force removing it: its status and termination message are lost",
+	)
+	err := ds.client.RemoveContainer(
+		containerID,
+		dockercontainer.RemoveOptions{RemoveVolumes: true, Force: true},
+	)
+	ds.containerInspectCache.invalidate(containerID)
+	if err != nil {
+		logger.Errorf("Failed to force remove the stuck container: %v", err)
+		return fmt.Errorf("failed to force remove the stuck container %q: %v", containerID, err)
+	}
+	logger.Warn("Force removed the stuck container")
+	return nil
+}
@@ -17,15 +17,28 @@ limitations under the License.
 package core
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
 	dockerimage "github.com/docker/docker/api/types/image"
+	dockermount "github.com/docker/docker/api/types/mount"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -225,6 +238,272 @@ func TestListContainers(t *testing.T) {
 	assert.Equal(t, expected, listResp.Containers)
 }
 
+// TestListContainersFilter checks that the state and sandbox filters are passed
+// on to docker, and that the matching containers are still listed most recent
+// first.
+func TestListContainersFilter(t *testing.T) {
+	ds, fDocker, fClock := newTestDockerService()
+
+	sandboxIDs := []string{}
+	for i := 0; i < 2; i++ {
+		sConfig := makeSandboxConfig(fmt.Sprintf("foo%d", i), "bar", fmt.Sprintf("%d", i), 0)
+		resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: sConfig})
+		require.NoError(t, err)
+		sandboxIDs = append(sandboxIDs, resp.PodSandboxId)
+	}
+	// Containers 0 and 2 run in the first sandbox, container 1 in the second.
+	containerIDs := []string{}
+	for i := 0; i < 3; i++ {
+		fClock.Step(time.Second)
+		sConfig := makeSandboxConfig(fmt.Sprintf("foo%d", i%2), "bar", fmt.Sprintf("%d", i%2), 0)
+		config := makeContainerConfig(sConfig, fmt.Sprintf("c%d", i), "image", 0, nil, nil)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  sandboxIDs[i%2],
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		_, err = ds.StartContainer(
+			getTestCTX(),
+			&runtimeapi.StartContainerRequest{ContainerId: createResp.ContainerId},
+		)
+		require.NoError(t, err)
+		containerIDs = append(containerIDs, createResp.ContainerId)
+	}
+	// Stopping the most recent container moves it behind the running ones in
+	// the list of the fake client.
+	_, err := ds.StopContainer(
+		getTestCTX(),
+		&runtimeapi.StopContainerRequest{ContainerId: containerIDs[2]},
+	)
+	require.NoError(t, err)
+
+	for desc, test := range map[string]struct {
+		filter         *runtimeapi.ContainerFilter
+		expectedIDs    []string
+		expectedFilter map[string][]string
+	}{
+		"running containers": {
+			filter: &runtimeapi.ContainerFilter{
+				State: &runtimeapi.ContainerStateValue{
+					State: runtimeapi.ContainerState_CONTAINER_RUNNING,
+				},
+			},
+			expectedIDs:    []string{containerIDs[1], containerIDs[0]},
+			expectedFilter: map[string][]string{"status": {"running", "paused"}},
+		},
+		"exited containers": {
+			filter: &runtimeapi.ContainerFilter{
+				State: &runtimeapi.ContainerStateValue{
+					State: runtimeapi.ContainerState_CONTAINER_EXITED,
+				},
+			},
+			expectedIDs:    []string{containerIDs[2]},
+			expectedFilter: map[string][]string{"status": {"exited"}},
+		},
+		"containers of a sandbox": {
+			filter:      &runtimeapi.ContainerFilter{PodSandboxId: sandboxIDs[0]},
+			expectedIDs: []string{containerIDs[2], containerIDs[0]},
+			expectedFilter: map[string][]string{
+				"label": {sandboxIDLabelKey + "=" + sandboxIDs[0]},
+			},
+		},
+		"running containers of a sandbox": {
+			filter: &runtimeapi.ContainerFilter{
+				PodSandboxId: sandboxIDs[0],
+				State: &runtimeapi.ContainerStateValue{
+					State: runtimeapi.ContainerState_CONTAINER_RUNNING,
+				},
+			},
+			expectedIDs: []string{containerIDs[0]},
+			expectedFilter: map[string][]string{
+				"label":  {sandboxIDLabelKey + "=" + sandboxIDs[0]},
+				"status": {"running", "paused"},
+			},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		client := &listFilterRecordingClient{DockerClientInterface: fDocker}
+		ds.client = client
+		resp, err := ds.ListContainers(
+			getTestCTX(),
+			&runtimeapi.ListContainersRequest{Filter: test.filter},
+		)
+		require.NoError(t, err)
+		ids := []string{}
+		for _, c := range resp.Containers {
+			ids = append(ids, c.Id)
+		}
+		assert.Equal(t, test.expectedIDs, ids)
+		for key, values := range test.expectedFilter {
+			for _, value := range values {
+				assert.True(t, client.filters.ExactMatch(key, value), "missing filter %s=%s", key, value)
+			}
+		}
+	}
+}
+
+// listFilterRecordingClient records the filters of the last container listing.
+type listFilterRecordingClient struct {
+	libdocker.DockerClientInterface
+	filters filters.Args
+}
+
+func (c *listFilterRecordingClient) ListContainers(
+	options dockercontainer.ListOptions,
+) ([]dockertypes.Container, error) {
+	c.filters = options.Filters
+	return c.DockerClientInterface.ListContainers(options)
+}
+
+// TestContainerStatusCoalesced tests that concurrent status requests for the
+// same container inspect it only once.
+func TestContainerStatusCoalesced(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil),
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	id := createResp.ContainerId
+
+	client := &blockingInspectClient{
+		DockerClientInterface: fDocker,
+		id:                    id,
+		inspecting:            make(chan struct{}),
+		release:               make(chan struct{}),
+	}
+	ds.client = client
+
+	const calls = 50
+	var wg sync.WaitGroup
+	responses := make(chan *runtimeapi.ContainerStatusResponse, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := ds.ContainerStatus(
+				getTestCTX(),
+				&runtimeapi.ContainerStatusRequest{ContainerId: id},
+			)
+			assert.NoError(t, err)
+			responses <- resp
+		}()
+	}
+	<-client.inspecting
+	// Give the other calls time to pile up behind the first one.
+	time.Sleep(100 * time.Millisecond)
+	close(client.release)
+	wg.Wait()
+	close(responses)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&client.inspects))
+	for resp := range responses {
+		require.NotNil(t, resp)
+		assert.Equal(t, id, resp.Status.Id)
+	}
+
+	// Later requests inspect the container again.
+	_, err = ds.ContainerStatus(getTestCTX(), &runtimeapi.ContainerStatusRequest{ContainerId: id})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&client.inspects))
+}
+
+// blockingInspectClient counts the inspections of a container, which block
+// until released.
+type blockingInspectClient struct {
+	libdocker.DockerClientInterface
+	id         string
+	inspects   int32
+	inspecting chan struct{}
+	release    chan struct{}
+}
+
+func (c *blockingInspectClient) InspectContainer(id string) (*dockertypes.ContainerJSON, error) {
+	if id == c.id && atomic.AddInt32(&c.inspects, 1) == 1 {
+		close(c.inspecting)
+		<-c.release
+	}
+	return c.DockerClientInterface.InspectContainer(id)
+}
+
+// TestContainerStatusInspectCache tests that the status of a container comes
+// from its cached inspection while the docker events are watched, until an
+// event invalidates it.
+func TestContainerStatusInspectCache(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil),
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	id := createResp.ContainerId
+
+	watchErr := make(chan error, 1)
+	go func() { watchErr <- ds.watchContainerEvents() }()
+	require.Eventually(t, func() bool {
+		ds.containerInspectCache.Lock()
+		defer ds.containerInspectCache.Unlock()
+		return ds.containerInspectCache.watching
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+	require.NoError(t, err)
+	status := func() runtimeapi.ContainerState {
+		resp, err := ds.ContainerStatus(getTestCTX(), &runtimeapi.ContainerStatusRequest{ContainerId: id})
+		require.NoError(t, err)
+		return resp.Status.State
+	}
+	assert.Equal(t, runtimeapi.ContainerState_CONTAINER_RUNNING, status())
+
+	// The container exits behind the back of cri-dockerd, its cached
+	// inspection being kept until the die event.
+	fDocker.Lock()
+	running := fDocker.ContainerMap[id]
+	exited := *running
+	base := *running.ContainerJSONBase
+	base.State = &dockertypes.ContainerState{
+		Status:     "exited",
+		ExitCode:   1,
+		StartedAt:  running.State.StartedAt,
+		FinishedAt: time.Now().Format(time.RFC3339Nano),
+	}
+	exited.ContainerJSONBase = &base
+	fDocker.ContainerMap[id] = &exited
+	fDocker.Unlock()
+	assert.Equal(t, runtimeapi.ContainerState_CONTAINER_RUNNING, status())
+
+	fDocker.InjectEvent(dockerevents.Message{
+		Type:   dockerevents.ContainerEventType,
+		Action: dockerevents.ActionDie,
+		Actor:  dockerevents.Actor{ID: id},
+	})
+	assert.Eventually(t, func() bool {
+		return status() == runtimeapi.ContainerState_CONTAINER_EXITED
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The cache is dropped when the events are no longer watched.
+	streamErr := fmt.Errorf("stream closed")
+	fDocker.InjectEventError(streamErr)
+	assert.Equal(t, streamErr, <-watchErr)
+	ds.containerInspectCache.Lock()
+	assert.False(t, ds.containerInspectCache.watching)
+	assert.Empty(t, ds.containerInspectCache.inspects)
+	ds.containerInspectCache.Unlock()
+}
+
 // TestContainerStatus tests the basic lifecycle operations and verify that
 // the status returned reflects the operations performed.
 func TestContainerStatus(t *testing.T) {
@@ -402,94 +681,2279 @@ func TestContainerLogPath(t *testing.T) {
 	assert.Equal(t, []string{kubeletContainerLogPath, kubeletContainerLogPath}, fakeOS.Removes)
 }
 
-// TestContainerCreationConflict tests the logic to work around docker container
-// creation naming conflict bug.
-func TestContainerCreationConflict(t *testing.T) {
+// TestContainerLogPathStaleSymlink tests that a log symlink left by a previous
+// attempt is replaced when the container starts.
+func TestContainerLogPathStaleSymlink(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	ds.os = config.RealOS{}
+	podLogPath := t.TempDir()
 	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
-	config := makeContainerConfig(
-		sConfig,
-		"pause",
-		"iamimage",
-		0,
-		map[string]string{},
-		map[string]string{},
+	sConfig.LogDirectory = podLogPath
+	containerConfig := makeContainerConfig(sConfig, "pause", "iamimage", 0, nil, nil)
+	containerConfig.LogPath = "0.log"
+	kubeletContainerLogPath := filepath.Join(podLogPath, containerConfig.LogPath)
+
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        containerConfig,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	id := createResp.ContainerId
+
+	dockerContainerLogPath := filepath.Join(t.TempDir(), "container.log")
+	c, err := fDocker.InspectContainer(id)
+	require.NoError(t, err)
+	c.LogPath = dockerContainerLogPath
+
+	// A previous attempt left a symlink to the log of another container.
+	staleLogPath := filepath.Join(t.TempDir(), "stale.log")
+	require.NoError(t, os.Symlink(staleLogPath, kubeletContainerLogPath))
+
+	_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+	require.NoError(t, err)
+	target, err := os.Readlink(kubeletContainerLogPath)
+	require.NoError(t, err)
+	assert.Equal(t, dockerContainerLogPath, target)
+
+	_, err = ds.StopContainer(getTestCTX(), &runtimeapi.StopContainerRequest{ContainerId: id})
+	require.NoError(t, err)
+	_, err = ds.RemoveContainer(getTestCTX(), &runtimeapi.RemoveContainerRequest{ContainerId: id})
+	require.NoError(t, err)
+	_, err = os.Lstat(kubeletContainerLogPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestContainerCorrelationID tests that containers inherit the correlation id
+// of their sandbox, and that it is not exposed as a CRI label.
+func TestContainerCorrelationID(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	sandbox, err := fDocker.InspectContainer(runSandboxResp.PodSandboxId)
+	require.NoError(t, err)
+	correlationID := sandbox.Config.Labels[correlationIDLabelKey]
+	require.NotEmpty(t, correlationID)
+
+	for _, name := range []string{"app", "sidecar"} {
+		config := makeContainerConfig(sConfig, name, "iamimage", 0, nil, nil)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		id := createResp.ContainerId
+
+		c, err := fDocker.InspectContainer(id)
+		require.NoError(t, err)
+		assert.Equal(t, correlationID, c.Config.Labels[correlationIDLabelKey])
+		assert.Equal(t, correlationID, getCorrelationID(c))
+
+		resp, err := ds.ContainerStatus(
+			getTestCTX(),
+			&runtimeapi.ContainerStatusRequest{ContainerId: id},
+		)
+		require.NoError(t, err)
+		assert.NotContains(t, resp.Status.Labels, correlationIDLabelKey)
+	}
+
+	// A second sandbox gets its own correlation id.
+	sConfig2 := makeSandboxConfig("foo2", "bar", "2", 0)
+	runSandboxResp2, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig2,
+	})
+	require.NoError(t, err)
+	sandbox2, err := fDocker.InspectContainer(runSandboxResp2.PodSandboxId)
+	require.NoError(t, err)
+	assert.NotEqual(t, correlationID, getCorrelationID(sandbox2))
+}
+
+// TestCreateContainerLongNames tests that the sandboxes and containers whose
+// names exceed the docker name length limit get shortened names, and that
+// their metadata is still reported.
+func TestCreateContainerLongNames(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig(strings.Repeat("p", 253), strings.Repeat("n", 63), "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	sandboxID := runSandboxResp.PodSandboxId
+	sandbox, err := fDocker.InspectContainer(sandboxID)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(sandbox.Name), maxNameLength)
+
+	config := makeContainerConfig(sConfig, strings.Repeat("c", 63), "iamimage", 1, nil, nil)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxID,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	c, err := fDocker.InspectContainer(createResp.ContainerId)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(c.Name), maxNameLength)
+
+	sandboxStatus, err := ds.PodSandboxStatus(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatusRequest{PodSandboxId: sandboxID},
 	)
-	containerName := makeContainerName(sConfig, config)
-	conflictError := fmt.Errorf(
-		"Error response from daemon: Conflict. The name \"/%s\" is already in use by container %q. You have to remove (or rename) that container to be able to reuse that name",
-		containerName,
-		containerID,
+	require.NoError(t, err)
+	assert.Equal(t, sConfig.Metadata, sandboxStatus.Status.Metadata)
+	assert.NotContains(t, sandboxStatus.Status.Labels, fullNameLabelKey)
+
+	listSandboxResp, err := ds.ListPodSandbox(getTestCTX(), &runtimeapi.ListPodSandboxRequest{})
+	require.NoError(t, err)
+	require.Len(t, listSandboxResp.Items, 1)
+	assert.Equal(t, sConfig.Metadata, listSandboxResp.Items[0].Metadata)
+
+	status, err := ds.ContainerStatus(
+		getTestCTX(),
+		&runtimeapi.ContainerStatusRequest{ContainerId: createResp.ContainerId},
 	)
-	noContainerError := fmt.Errorf("Error response from daemon: No such container: %s", containerID)
+	require.NoError(t, err)
+	assert.Equal(t, config.Metadata, status.Status.Metadata)
+	assert.NotContains(t, status.Status.Labels, fullNameLabelKey)
+
+	listResp, err := ds.ListContainers(getTestCTX(), &runtimeapi.ListContainersRequest{})
+	require.NoError(t, err)
+	require.Len(t, listResp.Containers, 1)
+	assert.Equal(t, config.Metadata, listResp.Containers[0].Metadata)
+}
+
+// TestContainerCreationTransientError tests that the creation of a container is
+// retried when docker fails with a transient lock error.
+func TestContainerCreationTransientError(t *testing.T) {
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	config := makeContainerConfig(sConfig, "pause", "iamimage", 0, nil, nil)
+	lockError := fmt.Errorf("Error response from daemon: database is locked")
 	randomError := fmt.Errorf("random error")
+	// The sandbox run calls "list", "inspect_image", "pull", "create", "start",
+	// then the container creation inspects its image and sandbox.
+	sandBoxCalls := []string{
+		"list", "inspect_image", "pull", "create", "start", "inspect_image", "inspect_container",
+	}
 
-	// sandBox run called "inspect_image", "pull", "create", "start", "inspect_container",
-	sandBoxCalls := []string{"inspect_image", "pull", "create", "start", "inspect_container"}
 	for desc, test := range map[string]struct {
-		createError  error
-		removeError  error
-		expectError  error
-		expectCalls  []string
-		expectFields int
+		retries     int
+		canceled    bool
+		createError error
+		expectError error
+		expectCalls []string
 	}{
-		// sandBox run called "inspect_image", "pull", "create", "start", "inspect_container",
-		"no create error": {
-			expectCalls:  append(sandBoxCalls, []string{"create"}...),
-			expectFields: 6,
+		"transient error succeeds on retry": {
+			retries:     2,
+			createError: lockError,
+			expectCalls: append(sandBoxCalls, []string{"create", "create"}...),
 		},
-		"random create error": {
-			createError: randomError,
-			expectError: randomError,
+		"transient error without retries": {
+			createError: lockError,
+			expectError: lockError,
 			expectCalls: append(sandBoxCalls, []string{"create"}...),
 		},
-		"conflict create error with successful remove": {
-			createError: conflictError,
-			expectError: conflictError,
-			expectCalls: append(sandBoxCalls, []string{"create", "remove"}...),
-		},
-		"conflict create error with random remove error": {
-			createError: conflictError,
-			removeError: randomError,
-			expectError: conflictError,
-			expectCalls: append(sandBoxCalls, []string{"create", "remove"}...),
+		"canceled context stops the retries": {
+			retries:     2,
+			canceled:    true,
+			createError: lockError,
+			expectError: lockError,
+			expectCalls: append(sandBoxCalls, []string{"create"}...),
 		},
-		"conflict create error with no such container remove error": {
-			createError:  conflictError,
-			removeError:  noContainerError,
-			expectCalls:  append(sandBoxCalls, []string{"create", "remove", "create"}...),
-			expectFields: 7,
+		"random error is not retried": {
+			retries:     2,
+			createError: randomError,
+			expectError: randomError,
+			expectCalls: append(sandBoxCalls, []string{"create"}...),
 		},
 	} {
 		t.Logf("TestCase: %s", desc)
 		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.ContainerCreateRetries = test.retries
+		ds.runtimeSettings.ContainerCreateRetryBackoff = time.Millisecond
 
 		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
 			Config: sConfig,
 		})
-		if err != nil {
-			require.EqualError(t, err, test.expectError.Error())
-			continue
-		}
+		require.NoError(t, err)
 
-		if test.createError != nil {
-			fDocker.InjectError("create", test.createError)
-		}
-		if test.removeError != nil {
-			fDocker.InjectError("remove", test.removeError)
+		ctx := getTestCTX()
+		if test.canceled {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(ctx)
+			cancel()
+			ds.runtimeSettings.ContainerCreateRetryBackoff = time.Hour
 		}
-
-		req := &runtimeapi.CreateContainerRequest{
+		fDocker.InjectError("create", test.createError)
+		_, err = ds.CreateContainer(ctx, &runtimeapi.CreateContainerRequest{
 			PodSandboxId:  runSandboxResp.PodSandboxId,
 			Config:        config,
 			SandboxConfig: sConfig,
-		}
-		createResp, err := ds.CreateContainer(getTestCTX(), req)
-		require.Equal(t, test.expectError, err)
+		})
+		assert.Equal(t, test.expectError, err)
 		assert.NoError(t, fDocker.AssertCalls(test.expectCalls))
-		if err == nil {
-			c, err := fDocker.InspectContainer(createResp.ContainerId)
-			assert.NoError(t, err)
-			assert.Len(t, strings.Split(c.Name, nameDelimiter), test.expectFields)
-		}
 	}
 }
+
+// TestCreateContainerNamespaceTarget checks that a container joining the
+// namespaces of another container of the pod gets them through docker, and
+// that the target given by name is resolved without changing the request.
+func TestCreateContainerNamespaceTarget(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sandboxIDs := []string{}
+	sConfigs := []*runtimeapi.PodSandboxConfig{}
+	for i := 0; i < 2; i++ {
+		sConfig := makeSandboxConfig(fmt.Sprintf("foo%d", i), "bar", fmt.Sprintf("%d", i), 0)
+		resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: sConfig})
+		require.NoError(t, err)
+		sandboxIDs = append(sandboxIDs, resp.PodSandboxId)
+		sConfigs = append(sConfigs, sConfig)
+	}
+	targetResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxIDs[0],
+		Config:        makeContainerConfig(sConfigs[0], "app", "iamimage", 0, nil, nil),
+		SandboxConfig: sConfigs[0],
+	})
+	require.NoError(t, err)
+	targetID := targetResp.ContainerId
+	targetNSMode := "container:" + targetID
+	// Docker resolves the names of containers to their id.
+	fDocker.ContainerMap["target"] = fDocker.ContainerMap[targetID]
+	sandboxNSMode := "container:" + sandboxIDs[0]
+
+	for desc, test := range map[string]struct {
+		nsOpts      *runtimeapi.NamespaceOption
+		sandbox     int
+		expectError bool
+		expectedPid string
+		expectedNet string
+		expectedIpc string
+	}{
+		"target pid namespace": {
+			nsOpts: &runtimeapi.NamespaceOption{
+				Pid:      runtimeapi.NamespaceMode_TARGET,
+				TargetId: targetID,
+			},
+			expectedPid: targetNSMode,
+			expectedNet: sandboxNSMode,
+			expectedIpc: sandboxNSMode,
+		},
+		"target network namespace": {
+			nsOpts: &runtimeapi.NamespaceOption{
+				Pid:      runtimeapi.NamespaceMode_POD,
+				Network:  runtimeapi.NamespaceMode_TARGET,
+				TargetId: targetID,
+			},
+			expectedPid: sandboxNSMode,
+			expectedNet: targetNSMode,
+			expectedIpc: sandboxNSMode,
+		},
+		"target ipc namespace": {
+			nsOpts: &runtimeapi.NamespaceOption{
+				Pid:      runtimeapi.NamespaceMode_POD,
+				Ipc:      runtimeapi.NamespaceMode_TARGET,
+				TargetId: targetID,
+			},
+			expectedPid: sandboxNSMode,
+			expectedNet: sandboxNSMode,
+			expectedIpc: targetNSMode,
+		},
+		"all target namespaces": {
+			nsOpts: &runtimeapi.NamespaceOption{
+				Pid:      runtimeapi.NamespaceMode_TARGET,
+				Network:  runtimeapi.NamespaceMode_TARGET,
+				Ipc:      runtimeapi.NamespaceMode_TARGET,
+				TargetId: targetID,
+			},
+			expectedPid: targetNSMode,
+			expectedNet: targetNSMode,
+			expectedIpc: targetNSMode,
+		},
+		"target by name": {
+			nsOpts: &runtimeapi.NamespaceOption{
+				Pid:      runtimeapi.NamespaceMode_TARGET,
+				TargetId: "target",
+			},
+			expectedPid: targetNSMode,
+			expectedNet: sandboxNSMode,
+			expectedIpc: sandboxNSMode,
+		},
+		"unknown target": {
+			nsOpts: &runtimeapi.NamespaceOption{
+				Pid:      runtimeapi.NamespaceMode_TARGET,
+				TargetId: "unknown",
+			},
+			expectError: true,
+		},
+		"target in another sandbox": {
+			nsOpts: &runtimeapi.NamespaceOption{
+				Pid:      runtimeapi.NamespaceMode_TARGET,
+				TargetId: targetID,
+			},
+			sandbox:     1,
+			expectError: true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		requestedTarget := test.nsOpts.TargetId
+		config := makeContainerConfig(sConfigs[test.sandbox], "debugger", "iamimage", 0, nil, nil)
+		config.Linux = &runtimeapi.LinuxContainerConfig{
+			SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+				NamespaceOptions: test.nsOpts,
+			},
+		}
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  sandboxIDs[test.sandbox],
+			Config:        config,
+			SandboxConfig: sConfigs[test.sandbox],
+		})
+		if test.expectError {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, requestedTarget, test.nsOpts.TargetId)
+
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expectedPid, string(c.HostConfig.PidMode))
+		assert.Equal(t, test.expectedNet, string(c.HostConfig.NetworkMode))
+		assert.Equal(t, test.expectedIpc, string(c.HostConfig.IpcMode))
+		_, err = ds.RemoveContainer(
+			getTestCTX(),
+			&runtimeapi.RemoveContainerRequest{ContainerId: createResp.ContainerId},
+		)
+		require.NoError(t, err)
+	}
+}
+
+// TestCreateContainerPrivileged tests the combinations of privileged sandboxes
+// and containers.
+func TestCreateContainerPrivileged(t *testing.T) {
+	for desc, test := range map[string]struct {
+		sandboxPrivileged   bool
+		containerPrivileged bool
+		expectError         bool
+	}{
+		"privileged container in privileged sandbox": {
+			sandboxPrivileged:   true,
+			containerPrivileged: true,
+		},
+		"unprivileged container in privileged sandbox": {
+			sandboxPrivileged: true,
+		},
+		"unprivileged container in unprivileged sandbox": {},
+		"privileged container in unprivileged sandbox": {
+			containerPrivileged: true,
+			expectError:         true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.DefaultDropCapabilities = []string{"NET_RAW"}
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		sConfig.Linux = &runtimeapi.LinuxPodSandboxConfig{
+			SecurityContext: &runtimeapi.LinuxSandboxSecurityContext{
+				Privileged: test.sandboxPrivileged,
+			},
+		}
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		sandbox, err := fDocker.InspectContainer(runSandboxResp.PodSandboxId)
+		require.NoError(t, err)
+		assert.Equal(t, test.sandboxPrivileged, sandbox.HostConfig.Privileged)
+
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Linux = &runtimeapi.LinuxContainerConfig{
+			SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+				Privileged: test.containerPrivileged,
+			},
+		}
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectError {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.containerPrivileged, c.HostConfig.Privileged)
+		if test.containerPrivileged {
+			assert.Equal(t, []string{"ALL"}, []string(c.HostConfig.CapAdd))
+			assert.Empty(t, c.HostConfig.CapDrop)
+			assert.Equal(t, []string{allDevicesCgroupRule}, c.HostConfig.DeviceCgroupRules)
+		} else {
+			assert.Equal(t, []string{"NET_RAW"}, []string(c.HostConfig.CapDrop))
+			assert.Empty(t, c.HostConfig.DeviceCgroupRules)
+		}
+	}
+}
+
+// TestStartContainerStartupDelay tests that the start of a container is delayed
+// by the startup delay annotation of its pod only when enabled.
+func TestStartContainerStartupDelay(t *testing.T) {
+	delay := 100 * time.Millisecond
+	for desc, test := range map[string]struct {
+		enabled       bool
+		annotation    string
+		expectDelayed bool
+	}{
+		"annotation honored when enabled": {
+			enabled:       true,
+			annotation:    delay.String(),
+			expectDelayed: true,
+		},
+		"annotation ignored when disabled": {
+			annotation: time.Hour.String(),
+		},
+		"invalid annotation ignored": {
+			enabled:    true,
+			annotation: "soon",
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.EnableStartupDelayAnnotation = test.enabled
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		sConfig.Annotations = map[string]string{startupDelayAnnotationKey: test.annotation}
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(getTestCTX(), 10*time.Second)
+		start := time.Now()
+		_, err = ds.StartContainer(ctx, &runtimeapi.StartContainerRequest{
+			ContainerId: createResp.ContainerId,
+		})
+		elapsed := time.Since(start)
+		cancel()
+		require.NoError(t, err)
+		assert.Contains(t, fDocker.Started, createResp.ContainerId)
+		if test.expectDelayed {
+			assert.GreaterOrEqual(t, elapsed, delay)
+		} else {
+			assert.Less(t, elapsed, delay)
+		}
+	}
+}
+
+// TestStartContainerTransientError tests that the start of a container is
+// retried when docker fails with a transient error, and that its log symlink
+// is created once.
+func TestStartContainerTransientError(t *testing.T) {
+	busyError := fmt.Errorf("Error response from daemon: device or resource busy")
+	imageError := fmt.Errorf("Error response from daemon: No such image: iamimage")
+	// The container is inspected once after the last start, for its log
+	// symlink, its hugepage limits and the correlation id of its logs.
+	for desc, test := range map[string]struct {
+		retries     int
+		startError  error
+		expectError bool
+		expectCalls []string
+	}{
+		"transient error succeeds on retry": {
+			retries:     2,
+			startError:  busyError,
+			expectCalls: []string{"start", "start", "inspect_container"},
+		},
+		"transient error without retries": {
+			startError:  busyError,
+			expectError: true,
+			expectCalls: []string{"start", "inspect_container"},
+		},
+		"permanent error is not retried": {
+			retries:     2,
+			startError:  imageError,
+			expectError: true,
+			expectCalls: []string{"start", "inspect_container"},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.ContainerStartRetries = test.retries
+		ds.runtimeSettings.ContainerStartRetryBackoff = time.Millisecond
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		sConfig.LogDirectory = "/pod/1"
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.LogPath = "0"
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		c.LogPath = "/docker/container/log"
+
+		symlinks := 0
+		ds.os.(*containertest.FakeOS).SymlinkFn = func(oldname, newname string) error {
+			symlinks++
+			return nil
+		}
+		fDocker.ClearCalls()
+		fDocker.InjectError("start", test.startError)
+		_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{
+			ContainerId: createResp.ContainerId,
+		})
+		if test.expectError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, fDocker.AssertCalls(test.expectCalls))
+		assert.Equal(t, 1, symlinks)
+	}
+}
+
+// TestCreateContainerValidateCommand tests that containers without anything to
+// run are rejected when the command is validated against the image.
+func TestCreateContainerValidateCommand(t *testing.T) {
+	for desc, test := range map[string]struct {
+		command     []string
+		imageCmd    []string
+		expectError bool
+	}{
+		"no command and no image cmd": {
+			expectError: true,
+		},
+		"image cmd": {
+			imageCmd: []string{"/bin/server"},
+		},
+		"container command": {
+			command: []string{"/bin/sh"},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.ValidateCommandAgainstImage = true
+		imageName := "iamimage"
+		fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+			ID:     imageName,
+			Config: &dockercontainer.Config{Cmd: test.imageCmd},
+		}})
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+
+		config := makeContainerConfig(sConfig, "app", imageName, 0, nil, nil)
+		config.Command = test.command
+		_, err = ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectError {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "no command specified")
+			assert.Empty(t, fDocker.Created[1:])
+		} else {
+			assert.NoError(t, err)
+			assert.Len(t, fDocker.Created, 2)
+		}
+	}
+}
+
+// TestCreateContainerImagePlatform tests that containers of an image built for
+// another platform than the node's are warned about, or rejected in strict mode.
+func TestCreateContainerImagePlatform(t *testing.T) {
+	defer func(os, arch string) { nodeOS, nodeArch = os, arch }(nodeOS, nodeArch)
+	nodeOS, nodeArch = "linux", "amd64"
+	defer logrus.SetOutput(logrus.StandardLogger().Out)
+
+	for desc, test := range map[string]struct {
+		os, arch      string
+		strict        bool
+		expectWarning bool
+		expectError   bool
+	}{
+		"matching platform": {
+			os:   "linux",
+			arch: "amd64",
+		},
+		"matching platform under its kernel name": {
+			os:   "linux",
+			arch: "x86_64",
+		},
+		"unknown platform": {},
+		"arm64 image on amd64": {
+			os:            "linux",
+			arch:          "arm64",
+			expectWarning: true,
+		},
+		"arm64 image on amd64 in strict mode": {
+			os:          "linux",
+			arch:        "arm64",
+			strict:      true,
+			expectError: true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.StrictImagePlatform = test.strict
+		imageName := "iamimage"
+		fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+			ID:           imageName,
+			Os:           test.os,
+			Architecture: test.arch,
+		}})
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+
+		var logs bytes.Buffer
+		logrus.SetOutput(&logs)
+		_, err = ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        makeContainerConfig(sConfig, "app", imageName, 0, nil, nil),
+			SandboxConfig: sConfig,
+		})
+		mismatch := "image platform linux/arm64 does not match the node platform linux/amd64"
+		if test.expectError {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), mismatch)
+			assert.Empty(t, fDocker.Created[1:])
+			continue
+		}
+		require.NoError(t, err)
+		assert.Len(t, fDocker.Created, 2)
+		if test.expectWarning {
+			assert.Contains(t, logs.String(), "level=warning")
+			assert.Contains(t, logs.String(), mismatch)
+		} else {
+			assert.NotContains(t, logs.String(), "level=warning")
+		}
+	}
+}
+
+// TestCreateContainerEphemeralStorageLimit tests that the ephemeral storage
+// limit of containers is enforced by the graph drivers supporting it.
+func TestCreateContainerEphemeralStorageLimit(t *testing.T) {
+	xfs := [][2]string{{"Backing Filesystem", "xfs"}}
+	for desc, test := range map[string]struct {
+		driver          string
+		driverStatus    [][2]string
+		limit           string
+		expectError     string
+		expectedStorage map[string]string
+	}{
+		"no limit": {
+			driver: "vfs",
+		},
+		"overlay2 on xfs": {
+			driver:          "overlay2",
+			driverStatus:    xfs,
+			limit:           "1Gi",
+			expectedStorage: map[string]string{"size": "1073741824"},
+		},
+		"btrfs": {
+			driver:          "btrfs",
+			limit:           "500M",
+			expectedStorage: map[string]string{"size": "500000000"},
+		},
+		"overlay2 on extfs": {
+			driver:       "overlay2",
+			driverStatus: [][2]string{{"Backing Filesystem", "extfs"}},
+			limit:        "1Gi",
+			expectError:  `storage driver "overlay2" does not support storage quotas`,
+		},
+		"unsupported driver": {
+			driver:      "vfs",
+			limit:       "1Gi",
+			expectError: `storage driver "vfs" does not support storage quotas`,
+		},
+		"invalid limit": {
+			driver:       "overlay2",
+			driverStatus: xfs,
+			limit:        "lots",
+			expectError:  `invalid limit "lots"`,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		fDocker.Information.Driver = test.driver
+		fDocker.Information.DriverStatus = test.driverStatus
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		if test.limit != "" {
+			sConfig.Annotations = map[string]string{ephemeralStorageLimitAnnotationKey: test.limit}
+		}
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil),
+			SandboxConfig: sConfig,
+		})
+		if test.expectError != "" {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectError)
+			assert.Empty(t, fDocker.Created[1:])
+			continue
+		}
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expectedStorage, c.HostConfig.StorageOpt)
+	}
+}
+
+// TestCreateContainerTmpfsMounts tests that the mounts without host path are
+// backed by a tmpfs instead of being bind mounted.
+func TestCreateContainerTmpfsMounts(t *testing.T) {
+	for desc, test := range map[string]struct {
+		mounts         []*runtimeapi.Mount
+		size           string
+		expectError    string
+		expectedTmpfs  map[string]string
+		expectedMounts []string
+	}{
+		"bind mounts only": {
+			mounts:         []*runtimeapi.Mount{{HostPath: "/host/data", ContainerPath: "/data"}},
+			expectedMounts: []string{"/data"},
+		},
+		"tmpfs mount": {
+			mounts: []*runtimeapi.Mount{
+				{HostPath: "/host/data", ContainerPath: "/data"},
+				{ContainerPath: "/scratch"},
+			},
+			expectedTmpfs:  map[string]string{"/scratch": "rw"},
+			expectedMounts: []string{"/data"},
+		},
+		"read-only tmpfs mount with size": {
+			mounts: []*runtimeapi.Mount{
+				{ContainerPath: "/scratch"},
+				{ContainerPath: "/cache", Readonly: true},
+			},
+			size: "64Mi",
+			expectedTmpfs: map[string]string{
+				"/scratch": "rw,size=67108864",
+				"/cache":   "ro,size=67108864",
+			},
+		},
+		"invalid size": {
+			mounts:      []*runtimeapi.Mount{{ContainerPath: "/scratch"}},
+			size:        "-1Mi",
+			expectError: `invalid size "-1Mi": must be positive`,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		if test.size != "" {
+			sConfig.Annotations = map[string]string{tmpfsSizeAnnotationKey: test.size}
+		}
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Mounts = test.mounts
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectError != "" {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectError)
+			continue
+		}
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expectedTmpfs, c.HostConfig.Tmpfs)
+		var targets []string
+		for _, m := range c.HostConfig.Mounts {
+			targets = append(targets, m.Target)
+		}
+		assert.Equal(t, test.expectedMounts, targets)
+	}
+}
+
+// TestCreateContainerReadonlyRootfsWritableMounts tests that the mounts a
+// container explicitly requests as writable stay writable when its root
+// filesystem is read-only.
+func TestCreateContainerRuntimeAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		"run.oci.keep_original_groups": "1",
+		"io.kubernetes.cri.rdt-class":  "gold",
+		"example.com/secret":           "value",
+	}
+	for desc, test := range map[string]struct {
+		allowed  []string
+		expected map[string]string
+	}{
+		"no allowlist": {},
+		"exact key": {
+			allowed:  []string{"io.kubernetes.cri.rdt-class"},
+			expected: map[string]string{"io.kubernetes.cri.rdt-class": "gold"},
+		},
+		"prefix": {
+			allowed: []string{"run.oci.*", "io.kubernetes.cri.rdt-class"},
+			expected: map[string]string{
+				"run.oci.keep_original_groups": "1",
+				"io.kubernetes.cri.rdt-class":  "gold",
+			},
+		},
+		"no match": {
+			allowed: []string{"example.com/other", "run.oci"},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.AllowedRuntimeAnnotations = test.allowed
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, annotations)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, c.HostConfig.Annotations)
+	}
+}
+
+func TestCreateContainerDefaultMountPropagation(t *testing.T) {
+	for desc, test := range map[string]struct {
+		defaultPropagation string
+		propagation        runtimeapi.MountPropagation
+		expected           dockermount.Propagation
+	}{
+		"private default": {
+			defaultPropagation: "private",
+		},
+		"default applied to an unspecified propagation": {
+			defaultPropagation: "rshared",
+			expected:           dockermount.PropagationRShared,
+		},
+		"explicit propagation overrides the default": {
+			defaultPropagation: "rshared",
+			propagation:        runtimeapi.MountPropagation_PROPAGATION_HOST_TO_CONTAINER,
+			expected:           dockermount.PropagationRSlave,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		var err error
+		ds.defaultMountPropagation, err = parseMountPropagation(test.defaultPropagation)
+		require.NoError(t, err)
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Mounts = []*runtimeapi.Mount{{
+			HostPath:      "/var/lib/kubelet/plugins",
+			ContainerPath: "/plugins",
+			Propagation:   test.propagation,
+		}}
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		require.Len(t, c.HostConfig.Mounts, 1)
+		assert.Equal(t, test.expected, c.HostConfig.Mounts[0].BindOptions.Propagation)
+	}
+}
+
+func TestParseMountPropagation(t *testing.T) {
+	_, err := parseMountPropagation("shared")
+	assert.EqualError(t, err, `invalid default mount propagation "shared": must be private, rslave or rshared`)
+}
+
+func TestCreateContainerReadonlyRootfsWritableMounts(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+	config.Linux = &runtimeapi.LinuxContainerConfig{
+		SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+			ReadonlyRootfs: true,
+		},
+	}
+	config.Mounts = []*runtimeapi.Mount{
+		{HostPath: "/host/data", ContainerPath: "/data"},
+		{HostPath: "/host/config", ContainerPath: "/config", Readonly: true},
+		{ContainerPath: "/scratch"},
+	}
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+
+	c, err := fDocker.InspectContainer(createResp.ContainerId)
+	require.NoError(t, err)
+	assert.True(t, c.HostConfig.ReadonlyRootfs)
+	readOnly := map[string]bool{}
+	for _, m := range c.HostConfig.Mounts {
+		readOnly[m.Target] = m.ReadOnly
+	}
+	assert.Equal(t, map[string]bool{"/data": false, "/config": true}, readOnly)
+	assert.Equal(t, map[string]string{"/scratch": "rw"}, c.HostConfig.Tmpfs)
+}
+
+// TestCreateContainerDefaultEnv tests that the default environment variables
+// are prepended to the environment of containers, unless they define the key.
+func TestCreateContainerDefaultEnv(t *testing.T) {
+	proxyEnv := []string{
+		"HTTP_PROXY=http://proxy:3128",
+		"HTTPS_PROXY=http://proxy:3128",
+		"NO_PROXY=localhost,.svc",
+	}
+	for desc, test := range map[string]struct {
+		defaultEnv  []string
+		envs        []*runtimeapi.KeyValue
+		expectedEnv []string
+	}{
+		"no default env": {
+			envs:        []*runtimeapi.KeyValue{{Key: "FOO", Value: "bar"}},
+			expectedEnv: []string{"FOO=bar"},
+		},
+		"default env injected before the container env": {
+			defaultEnv: proxyEnv,
+			envs:       []*runtimeapi.KeyValue{{Key: "FOO", Value: "bar"}},
+			expectedEnv: []string{
+				"HTTP_PROXY=http://proxy:3128",
+				"HTTPS_PROXY=http://proxy:3128",
+				"NO_PROXY=localhost,.svc",
+				"FOO=bar",
+			},
+		},
+		"container env overrides the default env": {
+			defaultEnv: proxyEnv,
+			envs: []*runtimeapi.KeyValue{
+				{Key: "NO_PROXY", Value: "*"},
+				{Key: "FOO", Value: "bar"},
+			},
+			expectedEnv: []string{
+				"HTTP_PROXY=http://proxy:3128",
+				"HTTPS_PROXY=http://proxy:3128",
+				"NO_PROXY=*",
+				"FOO=bar",
+			},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.DefaultEnv = test.defaultEnv
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+
+		// The environment is the same for every container created.
+		for i := uint32(0); i < 3; i++ {
+			config := makeContainerConfig(sConfig, "app", "iamimage", i, nil, nil)
+			config.Envs = test.envs
+			createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+				PodSandboxId:  runSandboxResp.PodSandboxId,
+				Config:        config,
+				SandboxConfig: sConfig,
+			})
+			require.NoError(t, err)
+			c, err := fDocker.InspectContainer(createResp.ContainerId)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedEnv, c.Config.Env)
+		}
+	}
+}
+
+func TestValidateDefaultEnv(t *testing.T) {
+	assert.NoError(t, validateDefaultEnv(nil))
+	assert.NoError(t, validateDefaultEnv([]string{"FOO=bar", "EMPTY=", "LIST=a,b=c"}))
+	assert.EqualError(
+		t,
+		validateDefaultEnv([]string{"FOO"}),
+		`invalid default environment variable "FOO": must be KEY=VALUE`,
+	)
+	assert.EqualError(
+		t,
+		validateDefaultEnv([]string{"=bar"}),
+		`invalid default environment variable "=bar": must be KEY=VALUE`,
+	)
+	assert.EqualError(
+		t,
+		validateDefaultEnv([]string{"FOO=bar", "FOO=baz"}),
+		`default environment variable "FOO" is set more than once`,
+	)
+}
+
+// TestContainerStatusAcrossRestart tests the timestamps reported for a
+// container which is restarted.
+func TestContainerStatusAcrossRestart(t *testing.T) {
+	ds, _, fClock := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	id := createResp.ContainerId
+	var zeroTime time.Time
+
+	status := func() (*runtimeapi.ContainerStatus, verboseContainerInfo) {
+		resp, err := ds.ContainerStatus(
+			getTestCTX(),
+			&runtimeapi.ContainerStatusRequest{ContainerId: id, Verbose: true},
+		)
+		require.NoError(t, err)
+		var info verboseContainerInfo
+		require.NoError(t, json.Unmarshal([]byte(resp.Info["info"]), &info))
+		return resp.Status, info
+	}
+
+	firstStart := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	fClock.SetTime(firstStart)
+	_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+	require.NoError(t, err)
+	s, info := status()
+	assert.Equal(t, runtimeapi.ContainerState_CONTAINER_RUNNING, s.State)
+	assert.Equal(t, firstStart.UnixNano(), s.StartedAt)
+	assert.Equal(t, zeroTime.UnixNano(), s.FinishedAt)
+	assert.Nil(t, info.PreviousRun)
+
+	firstFinish := firstStart.Add(time.Minute)
+	fClock.SetTime(firstFinish)
+	_, err = ds.StopContainer(getTestCTX(), &runtimeapi.StopContainerRequest{ContainerId: id})
+	require.NoError(t, err)
+	s, _ = status()
+	assert.Equal(t, runtimeapi.ContainerState_CONTAINER_EXITED, s.State)
+	assert.Equal(t, firstStart.UnixNano(), s.StartedAt)
+	assert.Equal(t, firstFinish.UnixNano(), s.FinishedAt)
+
+	// Docker still reports the finish time of the first run after the restart.
+	secondStart := firstFinish.Add(time.Minute)
+	fClock.SetTime(secondStart)
+	_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+	require.NoError(t, err)
+	s, info = status()
+	assert.Equal(t, runtimeapi.ContainerState_CONTAINER_RUNNING, s.State)
+	assert.Equal(t, secondStart.UnixNano(), s.StartedAt)
+	assert.Equal(t, zeroTime.UnixNano(), s.FinishedAt)
+	require.NotNil(t, info.PreviousRun)
+	assert.True(t, firstStart.Equal(info.PreviousRun.StartedAt))
+	assert.True(t, firstFinish.Equal(info.PreviousRun.FinishedAt))
+}
+
+// TestContainerStatusExitCode tests that the exit code of exited containers is
+// reported as is, and that a termination by a signal sets the reason.
+func TestContainerStatusExitCode(t *testing.T) {
+	for desc, test := range map[string]struct {
+		exitCode int
+		reason   string
+		message  string
+	}{
+		"normal exit": {
+			exitCode: 0,
+			reason:   "Completed",
+		},
+		"non-zero exit": {
+			exitCode: 3,
+			reason:   "Error",
+		},
+		"killed by SIGKILL": {
+			exitCode: 137,
+			reason:   "Signaled",
+			message:  "Terminated by signal SIGKILL",
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, fClock := newTestDockerService()
+		fClock.SetTime(time.Now())
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "init", "iamimage", 0, nil, nil)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		id := createResp.ContainerId
+		_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+		_, err = ds.StopContainer(getTestCTX(), &runtimeapi.StopContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+		fDocker.Lock()
+		fDocker.ContainerMap[id].State.ExitCode = test.exitCode
+		fDocker.Unlock()
+
+		resp, err := ds.ContainerStatus(
+			getTestCTX(),
+			&runtimeapi.ContainerStatusRequest{ContainerId: id},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, runtimeapi.ContainerState_CONTAINER_EXITED, resp.Status.State)
+		assert.Equal(t, int32(test.exitCode), resp.Status.ExitCode)
+		assert.Equal(t, test.reason, resp.Status.Reason)
+		assert.Equal(t, test.message, resp.Status.Message)
+	}
+}
+
+// TestContainerExternalAnnotations tests that the annotations too large for
+// labels round-trip through a side file, and that smaller ones stay in labels.
+func TestContainerExternalAnnotations(t *testing.T) {
+	largeValue := strings.Repeat("x", 4096)
+	annotations := map[string]string{
+		"small":         "value",
+		"large":         largeValue,
+		"another.large": largeValue + "y",
+	}
+	for desc, test := range map[string]struct {
+		maxBytes          int
+		expectedExternals []string
+	}{
+		"no limit": {},
+		"under the limit": {
+			maxBytes: 8192,
+		},
+		"over the limit": {
+			maxBytes:          1024,
+			expectedExternals: []string{"another.large", "large"},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.annotationsDir = t.TempDir()
+		ds.runtimeSettings.MaxAnnotationLabelBytes = test.maxBytes
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, annotations)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		id := createResp.ContainerId
+
+		c, err := fDocker.InspectContainer(id)
+		require.NoError(t, err)
+		for k := range annotations {
+			_, inLabels := c.Config.Labels[annotationPrefix+k]
+			assert.Equal(t, !slices.Contains(test.expectedExternals, k), inLabels, k)
+		}
+		_, err = os.Stat(ds.externalAnnotationsPath(id))
+		assert.Equal(t, len(test.expectedExternals) > 0, err == nil)
+
+		resp, err := ds.ContainerStatus(
+			getTestCTX(),
+			&runtimeapi.ContainerStatusRequest{ContainerId: id},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, annotations, resp.Status.Annotations)
+		listResp, err := ds.ListContainers(getTestCTX(), &runtimeapi.ListContainersRequest{})
+		require.NoError(t, err)
+		require.Len(t, listResp.Containers, 1)
+		assert.Equal(t, annotations, listResp.Containers[0].Annotations)
+
+		_, err = ds.RemoveContainer(getTestCTX(), &runtimeapi.RemoveContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+		_, err = os.Stat(ds.externalAnnotationsPath(id))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+// TestStopContainerTimeout tests the precedence of the grace periods recorded
+// at creation for the stops docker makes on its own: the one of the pod
+// annotation, else the one of the image, else the default one. The stops of
+// the kubelet use the requested grace period, 0 killing the container.
+func TestStopContainerTimeout(t *testing.T) {
+	annotationTimeout, imageTimeout, defaultTimeout := 30, 20, 10
+	for desc, test := range map[string]struct {
+		requestTimeout int64
+		annotation     string
+		imageTimeout   *int
+		defaultTimeout time.Duration
+		expectError    bool
+		expected       *int
+	}{
+		"pod annotation": {
+			annotation:     "30s",
+			imageTimeout:   &imageTimeout,
+			defaultTimeout: 10 * time.Second,
+			expected:       &annotationTimeout,
+		},
+		"image default": {
+			requestTimeout: 5,
+			imageTimeout:   &imageTimeout,
+			defaultTimeout: 10 * time.Second,
+			expected:       &imageTimeout,
+		},
+		"global default": {
+			defaultTimeout: 10 * time.Second,
+			expected:       &defaultTimeout,
+		},
+		"none": {
+			requestTimeout: 5,
+		},
+		"invalid annotation": {
+			annotation:  "soon",
+			expectError: true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.DefaultStopTimeout = test.defaultTimeout
+		fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+			ID:       "iamimage",
+			RepoTags: []string{"iamimage"},
+			Config:   &dockercontainer.Config{StopTimeout: test.imageTimeout},
+		}})
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		if test.annotation != "" {
+			sConfig.Annotations = map[string]string{stopTimeoutAnnotationKey: test.annotation}
+		}
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectError {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		id := createResp.ContainerId
+		c, err := fDocker.InspectContainer(id)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, c.Config.StopTimeout)
+		_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+
+		_, err = ds.StopContainer(
+			getTestCTX(),
+			&runtimeapi.StopContainerRequest{ContainerId: id, Timeout: test.requestTimeout},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, time.Duration(test.requestTimeout)*time.Second, fDocker.StopTimeouts[id])
+	}
+}
+
+// TestStopContainerForceRemovesStuckContainer tests that a container still
+// running after its stop failed is killed, and force removed only when it
+// survives the kill, and that the stop errors of stopped containers are
+// returned.
+func TestStopContainerForceRemovesStuckContainer(t *testing.T) {
+	hooks := logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	defer logrus.StandardLogger().ReplaceHooks(hooks)
+
+	for desc, test := range map[string]struct {
+		removeErr     error
+		stopped       bool
+		stuck         bool
+		expectErr     bool
+		expectKilled  bool
+		expectRemoved bool
+	}{
+		"container surviving its stop is killed": {
+			expectKilled: true,
+		},
+		"stuck container": {
+			stuck:         true,
+			expectRemoved: true,
+		},
+		"stuck container failing to be removed": {
+			stuck:     true,
+			removeErr: fmt.Errorf("device or resource busy"),
+			expectErr: true,
+		},
+		"stop error of a stopped container": {
+			stopped:   true,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		recorder := &entryRecorder{msg: "Force removed the stuck container"}
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		logrus.AddHook(recorder)
+		ds, fDocker, _ := newTestDockerService()
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil),
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		id := createResp.ContainerId
+		_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+		if test.stopped {
+			require.NoError(t, fDocker.StopContainer(id, 0))
+		}
+
+		// The container survives its stop, and its kill too when stuck.
+		fDocker.InjectError("stop", fmt.Errorf("tried to kill container, but did not receive an exit event"))
+		fDocker.StuckContainers = map[string]bool{id: test.stuck}
+		if test.removeErr != nil {
+			fDocker.InjectError("remove", test.removeErr)
+		}
+		_, err = ds.StopContainer(getTestCTX(), &runtimeapi.StopContainerRequest{ContainerId: id, Timeout: 1})
+		if test.expectErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		info, inspectErr := fDocker.InspectContainer(id)
+		if test.expectKilled {
+			require.NoError(t, inspectErr)
+			assert.False(t, info.State.Running)
+			assert.Equal(t, time.Duration(0), fDocker.StopTimeouts[id])
+		}
+		if test.expectRemoved {
+			assert.Error(t, inspectErr)
+			assert.Len(t, recorder.entries, 1)
+		} else {
+			assert.NoError(t, inspectErr)
+			assert.Empty(t, recorder.entries)
+		}
+	}
+}
+
+// TestCreateContainerInit tests that the pod annotation choosing whether
+// docker runs an init process in containers overrides the default.
+func TestCreateContainerInit(t *testing.T) {
+	enabled, disabled := true, false
+	for desc, test := range map[string]struct {
+		defaultInit bool
+		annotation  string
+		expectError bool
+		expected    *bool
+	}{
+		"unset":                       {},
+		"default":                     {defaultInit: true, expected: &enabled},
+		"annotation":                  {annotation: "true", expected: &enabled},
+		"annotation over the default": {defaultInit: true, annotation: "false", expected: &disabled},
+		"invalid annotation":          {annotation: "sometimes", expectError: true},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.DefaultInit = test.defaultInit
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		if test.annotation != "" {
+			sConfig.Annotations = map[string]string{initAnnotationKey: test.annotation}
+		}
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectError {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, c.HostConfig.Init)
+	}
+}
+
+// TestCreateContainerAllowedHostPaths tests that the host paths of the mounts
+// must be below the allowed host paths, if any.
+func TestCreateContainerAllowedHostPaths(t *testing.T) {
+	root := t.TempDir()
+	allowedDir := filepath.Join(root, "allowed")
+	otherDir := filepath.Join(root, "other")
+	for _, dir := range []string{allowedDir, otherDir, allowedDir + "-sibling"} {
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+	require.NoError(t, os.Symlink(otherDir, filepath.Join(allowedDir, "escape")))
+
+	for desc, test := range map[string]struct {
+		allowedHostPaths []string
+		hostPath         string
+		expectErr        bool
+	}{
+		"empty allowlist": {
+			hostPath: otherDir,
+		},
+		"allowed path": {
+			allowedHostPaths: []string{allowedDir},
+			hostPath:         filepath.Join(allowedDir, "data"),
+		},
+		"allowed path itself": {
+			allowedHostPaths: []string{otherDir, allowedDir + "/"},
+			hostPath:         allowedDir,
+		},
+		"disallowed path": {
+			allowedHostPaths: []string{allowedDir},
+			hostPath:         otherDir,
+			expectErr:        true,
+		},
+		"path sharing a prefix": {
+			allowedHostPaths: []string{allowedDir},
+			hostPath:         allowedDir + "-sibling",
+			expectErr:        true,
+		},
+		"path leaving the allowed path": {
+			allowedHostPaths: []string{allowedDir},
+			hostPath:         filepath.Join(allowedDir, "..", "other"),
+			expectErr:        true,
+		},
+		"symlink leaving the allowed path": {
+			allowedHostPaths: []string{allowedDir},
+			hostPath:         filepath.Join(allowedDir, "escape"),
+			expectErr:        true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.AllowedHostPaths = test.allowedHostPaths
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Mounts = []*runtimeapi.Mount{{HostPath: test.hostPath, ContainerPath: "/data"}}
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectErr {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "is not below the allowed host paths")
+			continue
+		}
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		require.Len(t, c.HostConfig.Mounts, 1)
+		assert.Equal(t, test.hostPath, c.HostConfig.Mounts[0].Source)
+	}
+}
+
+// TestCreateContainerRestartPolicy tests that docker doesn't restart the
+// sandboxes and containers unless configured otherwise.
+func TestCreateContainerRestartPolicy(t *testing.T) {
+	for desc, test := range map[string]struct {
+		policy   string
+		expected dockercontainer.RestartPolicy
+	}{
+		"default": {
+			expected: dockercontainer.RestartPolicy{Name: dockercontainer.RestartPolicyDisabled},
+		},
+		"no": {
+			policy:   "no",
+			expected: dockercontainer.RestartPolicy{Name: dockercontainer.RestartPolicyDisabled},
+		},
+		"unless-stopped": {
+			policy:   "unless-stopped",
+			expected: dockercontainer.RestartPolicy{Name: dockercontainer.RestartPolicyUnlessStopped},
+		},
+		"on-failure with max retries": {
+			policy: "on-failure:3",
+			expected: dockercontainer.RestartPolicy{
+				Name:              dockercontainer.RestartPolicyOnFailure,
+				MaximumRetryCount: 3,
+			},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.ContainerRestartPolicy = test.policy
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+
+		sandbox, err := fDocker.InspectContainer(runSandboxResp.PodSandboxId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, sandbox.HostConfig.RestartPolicy)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, c.HostConfig.RestartPolicy)
+	}
+
+	for _, policy := range []string{"sometimes", "always:3", "on-failure:-1"} {
+		_, err := parseRestartPolicy(policy)
+		assert.Error(t, err, policy)
+	}
+}
+
+// TestContainerStatusSeccompDenials tests that the seccomp denials of a
+// container are reported in its verbose status.
+func TestContainerStatusSeccompDenials(t *testing.T) {
+	ds, _, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	id := createResp.ContainerId
+
+	ds.seccompDenialCache.containerIDForPid = func(pid int) (string, bool) {
+		return id, pid == 1234
+	}
+	for _, record := range []string{
+		// Denied syscall of the container.
+		`type=SECCOMP msg=audit(1700000000.250:42): auid=4294967295 uid=0 gid=0 ses=4294967295 ` +
+			`subj=unconfined pid=1234 comm="mkdir" exe="/bin/mkdir" sig=0 arch=c000003e ` +
+			`syscall=83 compat=0 ip=0x7f3a8b2c1d0e code=0x50001`,
+		// Syscall only logged.
+		`type=SECCOMP msg=audit(1700000001.000:43): pid=1234 comm="ls" exe="/bin/ls" ` +
+			`arch=c000003e syscall=4 code=0x7ffc0000`,
+		// Process of another container.
+		`type=SECCOMP msg=audit(1700000002.000:44): pid=5678 comm="mount" exe="/bin/mount" ` +
+			`arch=c000003e syscall=165 code=0x0`,
+		`type=SYSCALL msg=audit(1700000003.000:45): arch=c000003e syscall=59 pid=1234`,
+	} {
+		ds.seccompDenialCache.handleAuditRecord(record)
+	}
+
+	resp, err := ds.ContainerStatus(
+		getTestCTX(),
+		&runtimeapi.ContainerStatusRequest{ContainerId: id, Verbose: true},
+	)
+	require.NoError(t, err)
+	var info verboseContainerInfo
+	require.NoError(t, json.Unmarshal([]byte(resp.Info["info"]), &info))
+	require.Len(t, info.SeccompDenials, 1)
+	assert.Equal(t, seccompDenial{
+		Time:    time.Unix(1700000000, 250*int64(time.Millisecond)).UTC(),
+		Pid:     1234,
+		Comm:    "mkdir",
+		Exe:     "/bin/mkdir",
+		Arch:    "c000003e",
+		Syscall: 83,
+	}, info.SeccompDenials[0])
+}
+
+// TestContainerStatusMountPropagation tests that the status of a container
+// reports the propagation requested for its mounts, rather than the rslave
+// propagation docker defaults to below its root.
+func TestContainerStatusMountPropagation(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+	config.Mounts = []*runtimeapi.Mount{
+		{HostPath: "/var/lib/docker/private", ContainerPath: "/private"},
+		{
+			HostPath:      "/var/lib/docker/slave",
+			ContainerPath: "/slave",
+			Propagation:   runtimeapi.MountPropagation_PROPAGATION_HOST_TO_CONTAINER,
+		},
+	}
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	c, err := fDocker.InspectContainer(createResp.ContainerId)
+	require.NoError(t, err)
+	c.Mounts = []dockertypes.MountPoint{
+		{
+			Type:        dockermount.TypeBind,
+			Source:      "/var/lib/docker/private",
+			Destination: "/private",
+			Propagation: dockermount.PropagationRSlave,
+		},
+		{
+			Type:        dockermount.TypeBind,
+			Source:      "/var/lib/docker/slave",
+			Destination: "/slave",
+			Propagation: dockermount.PropagationRSlave,
+		},
+	}
+
+	resp, err := ds.ContainerStatus(
+		getTestCTX(),
+		&runtimeapi.ContainerStatusRequest{ContainerId: createResp.ContainerId},
+	)
+	require.NoError(t, err)
+	mounts := resp.Status.Mounts
+	require.Len(t, mounts, 2)
+	assert.Equal(t, runtimeapi.MountPropagation_PROPAGATION_PRIVATE, mounts[0].Propagation)
+	assert.Equal(t, runtimeapi.MountPropagation_PROPAGATION_HOST_TO_CONTAINER, mounts[1].Propagation)
+}
+
+// TestPruneSeccompDenials tests that the seccomp denials of the containers
+// which no longer exist are forgotten.
+func TestPruneSeccompDenials(t *testing.T) {
+	ds, _, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil),
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	id := createResp.ContainerId
+
+	ds.seccompDenialCache.add(id, seccompDenial{Pid: 1234, Syscall: 83})
+	ds.seccompDenialCache.add("gone", seccompDenial{Pid: 5678, Syscall: 165})
+	ds.pruneSeccompDenials()
+	assert.Len(t, ds.seccompDenialCache.get(id), 1)
+	assert.Empty(t, ds.seccompDenialCache.get("gone"))
+}
+
+// TestContainerStatusUser tests that the verbose container status reports the
+// user set by the security context, or else by the image.
+func TestContainerStatusUser(t *testing.T) {
+	for desc, test := range map[string]struct {
+		imageUser    string
+		runAsUser    *runtimeapi.Int64Value
+		runAsGroup   *runtimeapi.Int64Value
+		expectedUser containerUser
+	}{
+		"image user": {
+			imageUser:    "app:staff",
+			expectedUser: containerUser{User: "app", Group: "staff"},
+		},
+		"run as user overrides the image user": {
+			imageUser:    "app:staff",
+			runAsUser:    &runtimeapi.Int64Value{Value: 1000},
+			runAsGroup:   &runtimeapi.Int64Value{Value: 2000},
+			expectedUser: containerUser{User: "1000", Group: "2000"},
+		},
+		"run as user without image user": {
+			runAsUser:    &runtimeapi.Int64Value{Value: 1000},
+			expectedUser: containerUser{User: "1000"},
+		},
+		"no user": {
+			expectedUser: containerUser{User: "0"},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+			ID:     "iamimage",
+			Config: &dockercontainer.Config{User: test.imageUser},
+		}})
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Linux = &runtimeapi.LinuxContainerConfig{
+			SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+				RunAsUser:  test.runAsUser,
+				RunAsGroup: test.runAsGroup,
+			},
+		}
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+
+		resp, err := ds.ContainerStatus(
+			getTestCTX(),
+			&runtimeapi.ContainerStatusRequest{ContainerId: createResp.ContainerId, Verbose: true},
+		)
+		require.NoError(t, err)
+		var info verboseContainerInfo
+		require.NoError(t, json.Unmarshal([]byte(resp.Info["info"]), &info))
+		require.NotNil(t, info.User)
+		assert.Equal(t, test.expectedUser, *info.User)
+	}
+}
+
+// TestContainerImageVolumeOverlap tests that no anonymous volume is created for
+// a VOLUME of the image overlapping a CRI mount.
+func TestContainerImageVolumeOverlap(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	imageName := "iamimage"
+	fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+		ID: imageName,
+		Config: &dockercontainer.Config{
+			Volumes: map[string]struct{}{
+				"/var/lib/app/data":    {},
+				"/var/lib/app/missing": {},
+				"/etc/app":             {},
+				"/cache":               {},
+			},
+		},
+	}})
+	hostApp := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(hostApp, "data"), 0o755))
+
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+
+	config := makeContainerConfig(sConfig, "app", imageName, 0, nil, nil)
+	config.Mounts = []*runtimeapi.Mount{
+		{HostPath: hostApp, ContainerPath: "/var/lib/app", Readonly: true},
+		{HostPath: "/host/config", ContainerPath: "/etc/app/"},
+	}
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+
+	c, err := fDocker.InspectContainer(createResp.ContainerId)
+	require.NoError(t, err)
+	// Only the volumes not overlapping any mount, or with no matching directory
+	// in the mount, are created.
+	var destinations []string
+	for _, m := range c.Mounts {
+		destinations = append(destinations, m.Destination)
+	}
+	assert.ElementsMatch(t, []string{"/cache", "/var/lib/app/missing"}, destinations)
+
+	// The overlapped volume is bound to the matching directory of the mount.
+	require.Len(t, c.HostConfig.Mounts, 3)
+	dataMount := c.HostConfig.Mounts[2]
+	assert.Equal(t, filepath.Join(hostApp, "data"), dataMount.Source)
+	assert.Equal(t, "/var/lib/app/data", dataMount.Target)
+	assert.True(t, dataMount.ReadOnly)
+}
+
+// TestContainerCreationConflict tests the logic to work around docker container
+// creation naming conflict bug.
+func TestContainerCreationConflict(t *testing.T) {
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	config := makeContainerConfig(
+		sConfig,
+		"pause",
+		"iamimage",
+		0,
+		map[string]string{},
+		map[string]string{},
+	)
+	containerName := makeContainerName(sConfig, config)
+	conflictError := fmt.Errorf(
+		"Error response from daemon: Conflict. The name \"/%s\" is already in use by container %q. You have to remove (or rename) that container to be able to reuse that name",
+		containerName,
+		containerID,
+	)
+	noContainerError := fmt.Errorf("Error response from daemon: No such container: %s", containerID)
+	randomError := fmt.Errorf("random error")
+
+	// sandBox run called "list", "inspect_image", "pull", "create", "start", then
+	// the container creation called "inspect_image", "inspect_container".
+	sandBoxCalls := []string{
+		"list", "inspect_image", "pull", "create", "start", "inspect_image", "inspect_container",
+	}
+	for desc, test := range map[string]struct {
+		createError  error
+		removeError  error
+		expectError  error
+		expectCalls  []string
+		expectFields int
+	}{
+		"no create error": {
+			expectCalls:  append(sandBoxCalls, []string{"create"}...),
+			expectFields: 6,
+		},
+		"random create error": {
+			createError: randomError,
+			expectError: randomError,
+			expectCalls: append(sandBoxCalls, []string{"create"}...),
+		},
+		"conflict create error with successful remove": {
+			createError: conflictError,
+			expectError: conflictError,
+			expectCalls: append(sandBoxCalls, []string{"create", "remove"}...),
+		},
+		"conflict create error with random remove error": {
+			createError: conflictError,
+			removeError: randomError,
+			expectError: conflictError,
+			expectCalls: append(sandBoxCalls, []string{"create", "remove"}...),
+		},
+		"conflict create error with no such container remove error": {
+			createError:  conflictError,
+			removeError:  noContainerError,
+			expectCalls:  append(sandBoxCalls, []string{"create", "remove", "create"}...),
+			expectFields: 7,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		if err != nil {
+			require.EqualError(t, err, test.expectError.Error())
+			continue
+		}
+
+		if test.createError != nil {
+			fDocker.InjectError("create", test.createError)
+		}
+		if test.removeError != nil {
+			fDocker.InjectError("remove", test.removeError)
+		}
+
+		req := &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		}
+		createResp, err := ds.CreateContainer(getTestCTX(), req)
+		require.Equal(t, test.expectError, err)
+		assert.NoError(t, fDocker.AssertCalls(test.expectCalls))
+		if err == nil {
+			c, err := fDocker.InspectContainer(createResp.ContainerId)
+			assert.NoError(t, err)
+			assert.Len(t, strings.Split(c.Name, nameDelimiter), test.expectFields)
+		}
+	}
+}
+
+// TestUpdateContainerResourcesFreeze tests that containers are paused and
+// unpaused through the freeze annotation, and reported as such.
+func TestUpdateContainerResourcesFreeze(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	id := createResp.ContainerId
+	_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+	require.NoError(t, err)
+
+	freeze := func(value string) error {
+		_, err := ds.UpdateContainerResources(getTestCTX(), &runtimeapi.UpdateContainerResourcesRequest{
+			ContainerId: id,
+			Annotations: map[string]string{freezeAnnotationKey: value},
+		})
+		return err
+	}
+	status := func() *runtimeapi.ContainerStatus {
+		resp, err := ds.ContainerStatus(
+			getTestCTX(),
+			&runtimeapi.ContainerStatusRequest{ContainerId: id},
+		)
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	for _, test := range []struct {
+		value          string
+		expectedCalls  []string
+		expectedPaused bool
+	}{
+		{value: "true", expectedCalls: []string{"inspect_container", "pause"}, expectedPaused: true},
+		// Freezing a paused container is a no-op.
+		{value: "true", expectedCalls: []string{"inspect_container"}, expectedPaused: true},
+		{value: "false", expectedCalls: []string{"inspect_container", "unpause"}},
+		{value: "false", expectedCalls: []string{"inspect_container"}},
+	} {
+		fDocker.ClearCalls()
+		require.NoError(t, freeze(test.value))
+		assert.NoError(t, fDocker.AssertCalls(test.expectedCalls))
+
+		s := status()
+		assert.Equal(t, runtimeapi.ContainerState_CONTAINER_RUNNING, s.State)
+		if test.expectedPaused {
+			assert.Equal(t, "true", s.Annotations[pausedAnnotationKey])
+		} else {
+			assert.NotContains(t, s.Annotations, pausedAnnotationKey)
+		}
+	}
+
+	assert.Error(t, freeze("maybe"))
+}
+
+// TestCreateContainerAnnotationLabels tests that the mapped pod annotations are
+// copied to the docker labels of the containers, without overwriting their
+// labels nor the internal ones.
+func TestCreateContainerAnnotationLabels(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	ds.runtimeSettings.AnnotationToLabel = map[string]string{
+		"sidecar.istio.io/status":   "mesh.example.com/sidecar-status",
+		"example.com/team":          "team",
+		"example.com/missing":       "missing",
+		"example.com/sandbox":       sandboxIDLabelKey,
+		"example.com/container-tag": "tag",
+	}
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sConfig.Annotations = map[string]string{
+		"sidecar.istio.io/status":   `{"ready":true}`,
+		"example.com/team":          "payments",
+		"example.com/sandbox":       "hijacked",
+		"example.com/container-tag": "from-pod",
+	}
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	sandboxID := runSandboxResp.PodSandboxId
+	config := makeContainerConfig(sConfig, "app", "iamimage", 0, map[string]string{"tag": "from-container"}, nil)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxID,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+
+	container, err := fDocker.InspectContainer(createResp.ContainerId)
+	require.NoError(t, err)
+	labels := container.Config.Labels
+	assert.Equal(t, `{"ready":true}`, labels["mesh.example.com/sidecar-status"])
+	assert.Equal(t, "payments", labels["team"])
+	assert.NotContains(t, labels, "missing")
+	// The internal labels and the labels of the container are kept.
+	assert.Equal(t, sandboxID, labels[sandboxIDLabelKey])
+	assert.Equal(t, containerTypeLabelContainer, labels[containerTypeLabelKey])
+	assert.Equal(t, "from-container", labels["tag"])
+
+	// The copied labels are not reported back as CRI labels.
+	statusResp, err := ds.ContainerStatus(getTestCTX(), &runtimeapi.ContainerStatusRequest{
+		ContainerId: createResp.ContainerId,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, config.Labels, statusResp.Status.Labels)
+	listResp, err := ds.ListContainers(getTestCTX(), &runtimeapi.ListContainersRequest{})
+	require.NoError(t, err)
+	require.Len(t, listResp.Containers, 1)
+	assert.Equal(t, config.Labels, listResp.Containers[0].Labels)
+}
+
+// TestValidateAnnotationToLabel tests that the pod annotations can't be copied
+// to the reserved labels.
+func TestValidateAnnotationToLabel(t *testing.T) {
+	for desc, test := range map[string]struct {
+		mapping   map[string]string
+		expectErr bool
+	}{
+		"no mapping": {},
+		"labels": {
+			mapping: map[string]string{"example.com/team": "team", "example.com/tier": "tier"},
+		},
+		"empty label": {
+			mapping:   map[string]string{"example.com/team": ""},
+			expectErr: true,
+		},
+		"internal label": {
+			mapping:   map[string]string{"example.com/type": containerTypeLabelKey},
+			expectErr: true,
+		},
+		"log path label": {
+			mapping:   map[string]string{"example.com/log": containerLogPathLabelKey},
+			expectErr: true,
+		},
+		"label with a comma": {
+			mapping:   map[string]string{"example.com/team": "team,tier"},
+			expectErr: true,
+		},
+		"container annotation label": {
+			mapping:   map[string]string{"example.com/team": annotationPrefix + "team"},
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		err := validateAnnotationToLabel(test.mapping)
+		if test.expectErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+// TestCreateContainerCDIDevices tests that the CDI devices of a container, from
+// its config and its annotations, are resolved against the CDI specs, and that
+// unknown devices and devices with hooks fail the creation.
+func TestCreateContainerCDIDevices(t *testing.T) {
+	etcDir, runDir := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(etcDir, "vendor.json"), []byte(`{
+  "cdiVersion": "0.6.0",
+  "kind": "vendor.com/gpu",
+  "containerEdits": {"env": ["VENDOR_DRIVER=1"]},
+  "devices": [
+    {
+      "name": "gpu0",
+      "containerEdits": {
+        "env": ["VENDOR_VISIBLE_DEVICES=0"],
+        "deviceNodes": [{"path": "/dev/vendor0"}],
+        "mounts": [{"hostPath": "/opt/vendor/lib", "containerPath": "/usr/lib/vendor", "options": ["ro", "rbind", "rslave"]}]
+      }
+    },
+    {
+      "name": "gpu1",
+      "containerEdits": {"deviceNodes": [{"path": "/dev/vendor1", "permissions": "rw"}]}
+    }
+  ]
+}`), 0o644))
+	// The specs of the later directory take precedence.
+	require.NoError(t, os.WriteFile(filepath.Join(runDir, "vendor.yaml"), []byte(`cdiVersion: 0.6.0
+kind: vendor.com/gpu
+devices:
+- name: gpu1
+  containerEdits:
+    deviceNodes:
+    - path: /dev/vendor1
+      hostPath: /dev/dri/vendor1
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(runDir, "hooks.yaml"), []byte(`cdiVersion: 0.6.0
+kind: hooks.com/nic
+devices:
+- name: nic0
+  containerEdits:
+    hooks:
+    - hookName: createContainer
+      path: /usr/bin/nic-hook
+`), 0o644))
+
+	for desc, test := range map[string]struct {
+		cdiDevices      []*runtimeapi.CDIDevice
+		annotations     map[string]string
+		expectErr       bool
+		expectedDevices []dockercontainer.DeviceMapping
+		expectedMounts  []dockermount.Mount
+		expectedEnv     []string
+	}{
+		"no CDI devices": {},
+		"CDI devices of the config and the annotations": {
+			cdiDevices:  []*runtimeapi.CDIDevice{{Name: "vendor.com/gpu=gpu0"}},
+			annotations: map[string]string{cdiAnnotationPrefix + "vendor-device-plugin": "vendor.com/gpu=gpu1,vendor.com/gpu=gpu0"},
+			expectedDevices: []dockercontainer.DeviceMapping{
+				{PathOnHost: "/dev/vendor0", PathInContainer: "/dev/vendor0", CgroupPermissions: "rwm"},
+				{PathOnHost: "/dev/dri/vendor1", PathInContainer: "/dev/vendor1", CgroupPermissions: "rwm"},
+			},
+			expectedMounts: []dockermount.Mount{{
+				Type:        dockermount.TypeBind,
+				Source:      "/opt/vendor/lib",
+				Target:      "/usr/lib/vendor",
+				ReadOnly:    true,
+				BindOptions: &dockermount.BindOptions{Propagation: dockermount.PropagationRSlave},
+			}},
+			expectedEnv: []string{"VENDOR_DRIVER=1", "VENDOR_VISIBLE_DEVICES=0"},
+		},
+		"unknown CDI device": {
+			cdiDevices: []*runtimeapi.CDIDevice{{Name: "vendor.com/gpu=gpu9"}},
+			expectErr:  true,
+		},
+		"CDI device with hooks": {
+			cdiDevices: []*runtimeapi.CDIDevice{{Name: "hooks.com/nic=nic0"}},
+			expectErr:  true,
+		},
+		"invalid CDI device name": {
+			annotations: map[string]string{cdiAnnotationPrefix + "plugin": "gpu0"},
+			expectErr:   true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.CDISpecDirs = []string{etcDir, runDir, filepath.Join(runDir, "missing")}
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, test.annotations)
+		config.CDIDevices = test.cdiDevices
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+
+		container, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, test.expectedDevices, container.HostConfig.Devices)
+		for _, m := range test.expectedMounts {
+			assert.Contains(t, container.HostConfig.Mounts, m)
+		}
+		for _, env := range test.expectedEnv {
+			assert.Contains(t, container.Config.Env, env)
+		}
+	}
+}
+
+// TestRemoveContainerRetainsExitedContainers tests that the removals of the
+// most recent exited containers of a running sandbox by the kubelet are no-ops,
+// and that they are removed once the sandbox stops, with the sandbox, or when
+// their log was not deleted first by the kubelet.
+func TestRemoveContainerRetainsExitedContainers(t *testing.T) {
+	ds, fDocker, fClock := newTestDockerService()
+	ds.runtimeSettings.RetainExitedContainers = 3
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sConfig.LogDirectory = "/pod/1"
+	// The kubelet deletes the logs of the containers it removes.
+	logs := map[string]bool{}
+	ds.os.(*containertest.FakeOS).StatFn = func(path string) (os.FileInfo, error) {
+		if logs[path] {
+			return nil, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	sandboxID := runSandboxResp.PodSandboxId
+
+	var ids []string
+	for attempt := uint32(0); attempt < 5; attempt++ {
+		fClock.Step(time.Second)
+		config := makeContainerConfig(sConfig, "app", "iamimage", attempt, nil, nil)
+		config.LogPath = fmt.Sprintf("app/%d.log", attempt)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  sandboxID,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		id := createResp.ContainerId
+		_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	// The most recent container still runs.
+	for _, id := range ids[:4] {
+		_, err = ds.StopContainer(getTestCTX(), &runtimeapi.StopContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+	}
+	exists := func(id string) bool {
+		_, err := fDocker.InspectContainer(id)
+		return err == nil
+	}
+
+	// The oldest exited container is removed, the three most recent ones kept,
+	// but for the one whose log is still in place, not removed by the kubelet.
+	logs["/pod/1/app/3.log"] = true
+	for _, id := range ids[:4] {
+		_, err = ds.RemoveContainer(getTestCTX(), &runtimeapi.RemoveContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+	}
+	assert.False(t, exists(ids[0]))
+	assert.True(t, exists(ids[1]))
+	assert.True(t, exists(ids[2]))
+	assert.False(t, exists(ids[3]))
+
+	// Running containers are not retained.
+	_, err = ds.RemoveContainer(getTestCTX(), &runtimeapi.RemoveContainerRequest{ContainerId: ids[4]})
+	require.NoError(t, err)
+	assert.False(t, exists(ids[4]))
+
+	// Once the sandbox is stopped, nothing is retained.
+	_, err = ds.StopPodSandbox(getTestCTX(), &runtimeapi.StopPodSandboxRequest{PodSandboxId: sandboxID})
+	require.NoError(t, err)
+	_, err = ds.RemoveContainer(getTestCTX(), &runtimeapi.RemoveContainerRequest{ContainerId: ids[1]})
+	require.NoError(t, err)
+	assert.False(t, exists(ids[1]))
+	assert.True(t, exists(ids[2]))
+
+	// The sandbox removal removes the retained containers.
+	_, err = ds.RemovePodSandbox(getTestCTX(), &runtimeapi.RemovePodSandboxRequest{PodSandboxId: sandboxID})
+	require.NoError(t, err)
+	assert.False(t, exists(ids[2]))
+}
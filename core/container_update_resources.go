@@ -19,6 +19,8 @@ package core
 import (
 	"context"
 	"fmt"
+	"strconv"
+
 	"github.com/docker/docker/api/types/container"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
@@ -27,22 +29,66 @@ func (ds *dockerService) UpdateContainerResources(
 	_ context.Context,
 	r *v1.UpdateContainerResourcesRequest,
 ) (*v1.UpdateContainerResourcesResponse, error) {
-	resources := r.Linux
-	updateConfig := container.UpdateConfig{
-		Resources: container.Resources{
-			CPUPeriod:  resources.CpuPeriod,
-			CPUQuota:   resources.CpuQuota,
-			CPUShares:  resources.CpuShares,
-			Memory:     resources.MemoryLimitInBytes,
-			MemorySwap: resources.MemoryLimitInBytes,
-			CpusetCpus: resources.CpusetCpus,
-			CpusetMems: resources.CpusetMems,
-		},
+	if resources := r.Linux; resources != nil {
+		cpuQuota, cpuPeriod, err := cpuQuotaAndPeriod(resources.CpuQuota, resources.CpuPeriod)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU limits for container %q: %v", r.ContainerId, err)
+		}
+		reservation, err := memoryReservation(resources.Unified, resources.MemoryLimitInBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory resources for container %q: %v", r.ContainerId, err)
+		}
+		updateConfig := container.UpdateConfig{
+			Resources: container.Resources{
+				CPUPeriod:         cpuPeriod,
+				CPUQuota:          cpuQuota,
+				CPUShares:         resources.CpuShares,
+				Memory:            resources.MemoryLimitInBytes,
+				MemorySwap:        resources.MemoryLimitInBytes,
+				MemoryReservation: reservation,
+				CpusetCpus:        resources.CpusetCpus,
+				CpusetMems:        resources.CpusetMems,
+			},
+		}
+
+		err = ds.client.UpdateContainerResources(r.ContainerId, updateConfig)
+		ds.containerInspectCache.invalidate(r.ContainerId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update container %q: %v", r.ContainerId, err)
+		}
+		if err := ds.updateHugepageLimits(r.ContainerId, resources.HugepageLimits); err != nil {
+			return nil, fmt.Errorf("failed to update the hugepage limits of container %q: %v", r.ContainerId, err)
+		}
 	}
 
-	err := ds.client.UpdateContainerResources(r.ContainerId, updateConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update container %q: %v", r.ContainerId, err)
+	if value, ok := r.GetAnnotations()[freezeAnnotationKey]; ok {
+		freeze, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %v", freezeAnnotationKey, value, err)
+		}
+		if err := ds.freezeContainer(r.ContainerId, freeze); err != nil {
+			return nil, err
+		}
 	}
 	return &v1.UpdateContainerResourcesResponse{}, nil
 }
+
+// freezeContainer pauses or unpauses the container, unless it already is.
+func (ds *dockerService) freezeContainer(containerID string, freeze bool) error {
+	r, err := ds.client.InspectContainer(containerID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case freeze && !r.State.Paused:
+		if err := ds.client.PauseContainer(containerID); err != nil {
+			return fmt.Errorf("failed to pause container %q: %v", containerID, err)
+		}
+	case !freeze && r.State.Paused:
+		if err := ds.client.UnpauseContainer(containerID); err != nil {
+			return fmt.Errorf("failed to unpause container %q: %v", containerID, err)
+		}
+	}
+	ds.containerInspectCache.invalidate(containerID)
+	return nil
+}
@@ -19,12 +19,14 @@ package core
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
+	dockermount "github.com/docker/docker/api/types/mount"
 
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
@@ -75,6 +77,42 @@ func imageInspectToRuntimeAPIImage(image *dockertypes.ImageInspect, pinned bool)
 type verboseImageInfo struct {
 	Labels    map[string]string `json:"labels,omitempty"`
 	ImageSpec imagespec.Image   `json:"imageSpec"`
+	// Size is the size of the image, including the layers it shares with
+	// other images.
+	Size int64 `json:"size"`
+	// LayerCount is the number of layers of the image.
+	LayerCount int `json:"layerCount"`
+	// Layers are the layers of the image, the base one first.
+	Layers []imageLayer `json:"layers,omitempty"`
+}
+
+// imageLayer is a layer of an image, with the size it adds to it.
+type imageLayer struct {
+	// Digest is the diff ID of the layer, unknown if the layers of the
+	// history don't match the ones of the image.
+	Digest string `json:"digest,omitempty"`
+	Size   int64  `json:"size"`
+}
+
+// toImageLayers returns the layers of the image, from its history entries
+// creating a non-empty layer, the base one first. The digests are only set if
+// these entries match the layers of the image.
+func toImageLayers(
+	rootfs dockertypes.RootFS,
+	history []dockerimagetypes.HistoryResponseItem,
+) []imageLayer {
+	var layers []imageLayer
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Size > 0 {
+			layers = append(layers, imageLayer{Size: history[i].Size})
+		}
+	}
+	if len(layers) == len(rootfs.Layers) {
+		for i := range layers {
+			layers[i].Digest = rootfs.Layers[i]
+		}
+	}
+	return layers
 }
 
 func imageInspectToRuntimeAPIImageInfo(image *dockertypes.ImageInspect, history []dockerimagetypes.HistoryResponseItem) (map[string]string, error) {
@@ -98,8 +136,11 @@ func imageInspectToRuntimeAPIImageInfo(image *dockertypes.ImageInspect, history
 	}
 
 	imi := &verboseImageInfo{
-		Labels:    image.Config.Labels,
-		ImageSpec: imageSpec,
+		Labels:     image.Config.Labels,
+		ImageSpec:  imageSpec,
+		Size:       image.Size,
+		LayerCount: len(image.RootFS.Layers),
+		Layers:     toImageLayers(image.RootFS, history),
 	}
 
 	m, err := json.Marshal(imi)
@@ -113,16 +154,60 @@ func imageInspectToRuntimeAPIImageInfo(image *dockertypes.ImageInspect, history
 }
 
 type verboseContainerInfo struct {
-	SandboxID string `json:"sandboxID"`
-	Pid       int    `json:"pid"`
+	SandboxID      string          `json:"sandboxID"`
+	Pid            int             `json:"pid"`
+	SeccompDenials []seccompDenial `json:"seccompDenials,omitempty"`
+	PreviousRun    *containerRun   `json:"previousRun,omitempty"`
+	// User is the user the container runs as.
+	User *containerUser `json:"user,omitempty"`
+	// ZombieProcesses is the number of zombie processes in the container,
+	// when they are counted.
+	ZombieProcesses *int `json:"zombieProcesses,omitempty"`
+}
+
+// containerUser is the user a container runs as, as set by its security
+// context or else by its image.
+type containerUser struct {
+	// User is the name or the UID of the user.
+	User string `json:"user"`
+	// Group is the name or the GID of the group, if set.
+	Group string `json:"group,omitempty"`
 }
 
-func containerInspectToRuntimeAPIContainerInfo(container *dockertypes.ContainerJSON) (map[string]string, error) {
+// resolveContainerUser returns the user the container runs as. The user set by
+// the security context overrides the one of the image, and docker runs the
+// container as root when neither sets one.
+func resolveContainerUser(
+	container *dockertypes.ContainerJSON,
+	image *dockertypes.ImageInspect,
+) *containerUser {
+	user := container.Config.User
+	if user == "" && image != nil && image.Config != nil {
+		user = image.Config.User
+	}
+	if user == "" {
+		return &containerUser{User: "0"}
+	}
+	name, group, _ := strings.Cut(user, ":")
+	return &containerUser{User: name, Group: group}
+}
+
+func containerInspectToRuntimeAPIContainerInfo(
+	container *dockertypes.ContainerJSON,
+	image *dockertypes.ImageInspect,
+	seccompDenials []seccompDenial,
+	previousRun *containerRun,
+	zombieProcesses *int,
+) (map[string]string, error) {
 	info := make(map[string]string)
 
 	cti := &verboseContainerInfo{
-		SandboxID: container.Config.Labels[sandboxIDLabelKey],
-		Pid:       container.State.Pid,
+		SandboxID:       container.Config.Labels[sandboxIDLabelKey],
+		Pid:             container.State.Pid,
+		SeccompDenials:  seccompDenials,
+		PreviousRun:     previousRun,
+		User:            resolveContainerUser(container, image),
+		ZombieProcesses: zombieProcesses,
 	}
 
 	m, err := json.Marshal(cti)
@@ -193,12 +278,47 @@ func toPullableImageID(id string, image *dockertypes.ImageInspect) string {
 	return imageID
 }
 
+// toRuntimeAPIMountPropagation converts the propagation of a docker bind mount
+// back to the CRI propagation: rshared is bidirectional, rslave is host to
+// container, and anything else, including no propagation, is private.
+func toRuntimeAPIMountPropagation(propagation dockermount.Propagation) runtimeapi.MountPropagation {
+	switch propagation {
+	case dockermount.PropagationRShared:
+		return runtimeapi.MountPropagation_PROPAGATION_BIDIRECTIONAL
+	case dockermount.PropagationRSlave:
+		return runtimeapi.MountPropagation_PROPAGATION_HOST_TO_CONTAINER
+	}
+	return runtimeapi.MountPropagation_PROPAGATION_PRIVATE
+}
+
+// requestedMountPropagations returns the propagations requested for the bind
+// mounts of a container at its creation, by destination. They are preferred to
+// the propagations reported by docker, which are rslave for the mounts below
+// the docker root left to the default.
+func requestedMountPropagations(hc *dockercontainer.HostConfig) map[string]dockermount.Propagation {
+	if hc == nil {
+		return nil
+	}
+	propagations := make(map[string]dockermount.Propagation)
+	for _, m := range hc.Mounts {
+		if m.Type != dockermount.TypeBind {
+			continue
+		}
+		var propagation dockermount.Propagation
+		if m.BindOptions != nil {
+			propagation = m.BindOptions.Propagation
+		}
+		propagations[filepath.Clean(m.Target)] = propagation
+	}
+	return propagations
+}
+
 func toRuntimeAPIContainer(c *dockertypes.Container) (*runtimeapi.Container, error) {
 	state := toRuntimeAPIContainerState(c.Status)
 	if len(c.Names) == 0 {
 		return nil, fmt.Errorf("unexpected empty container name: %+v", c)
 	}
-	metadata, err := parseContainerName(c.Names[0])
+	metadata, err := parseContainerName(fullName(c.Names[0], c.Labels))
 	if err != nil {
 		return nil, err
 	}
@@ -219,18 +339,20 @@ func toRuntimeAPIContainer(c *dockertypes.Container) (*runtimeapi.Container, err
 	}, nil
 }
 
-func toDockerContainerStatus(state runtimeapi.ContainerState) string {
+// toDockerContainerStatuses returns the docker statuses of the containers in
+// the given state, to let docker filter the containers it lists. It returns
+// nil for states docker can't filter on.
+func toDockerContainerStatuses(state runtimeapi.ContainerState) []string {
 	switch state {
 	case runtimeapi.ContainerState_CONTAINER_CREATED:
-		return "created"
+		return []string{"created"}
 	case runtimeapi.ContainerState_CONTAINER_RUNNING:
-		return "running"
+		// Paused containers are reported as running.
+		return []string{"running", "paused"}
 	case runtimeapi.ContainerState_CONTAINER_EXITED:
-		return "exited"
-	case runtimeapi.ContainerState_CONTAINER_UNKNOWN:
-		fallthrough
+		return []string{"exited"}
 	default:
-		return "unknown"
+		return nil
 	}
 }
 
@@ -249,6 +371,50 @@ func toRuntimeAPIContainerState(state string) runtimeapi.ContainerState {
 	}
 }
 
+// dockerStateToRuntimeAPIContainerState maps the State.Status string of an
+// inspected docker container to the CRI container state.
+func dockerStateToRuntimeAPIContainerState(status string) runtimeapi.ContainerState {
+	switch status {
+	case "created":
+		return runtimeapi.ContainerState_CONTAINER_CREATED
+	case "running", "restarting":
+		return runtimeapi.ContainerState_CONTAINER_RUNNING
+	case "paused":
+		// Note: the CRI has no paused state. The processes of a paused
+		// container are still alive, so it is reported as running.
+		return runtimeapi.ContainerState_CONTAINER_RUNNING
+	case "exited", "dead", "removing":
+		return runtimeapi.ContainerState_CONTAINER_EXITED
+	default:
+		return runtimeapi.ContainerState_CONTAINER_UNKNOWN
+	}
+}
+
+// inspectToRuntimeAPIContainerState returns the CRI state of an inspected docker
+// container. The State.Status string reported by docker is authoritative; the
+// state is only inferred from the other fields if docker did not report it.
+func inspectToRuntimeAPIContainerState(
+	state *dockertypes.ContainerState,
+	finishedAt time.Time,
+) runtimeapi.ContainerState {
+	if state.Status != "" {
+		s := dockerStateToRuntimeAPIContainerState(state.Status)
+		if s == runtimeapi.ContainerState_CONTAINER_CREATED && state.ExitCode != 0 {
+			// The container has failed to start.
+			return runtimeapi.ContainerState_CONTAINER_EXITED
+		}
+		return s
+	}
+	switch {
+	case state.Running:
+		return runtimeapi.ContainerState_CONTAINER_RUNNING
+	case !finishedAt.IsZero() || state.ExitCode != 0:
+		return runtimeapi.ContainerState_CONTAINER_EXITED
+	default:
+		return runtimeapi.ContainerState_CONTAINER_CREATED
+	}
+}
+
 func toRuntimeAPISandboxState(state string) runtimeapi.PodSandboxState {
 	// Parse the state string in dockertypes.Container. This could break when
 	// we upgrade docker.
@@ -265,7 +431,7 @@ func containerToRuntimeAPISandbox(c *dockertypes.Container) (*runtimeapi.PodSand
 	if len(c.Names) == 0 {
 		return nil, fmt.Errorf("unexpected empty sandbox name: %+v", c)
 	}
-	metadata, err := parseSandboxName(c.Names[0])
+	metadata, err := parseSandboxName(fullName(c.Names[0], c.Labels))
 	if err != nil {
 		return nil, err
 	}
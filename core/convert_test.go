@@ -18,6 +18,7 @@ package core
 
 import (
 	"testing"
+	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	"github.com/stretchr/testify/assert"
@@ -42,6 +43,82 @@ func TestConvertDockerStatusToRuntimeAPIState(t *testing.T) {
 	}
 }
 
+func TestConvertDockerStateToRuntimeAPIState(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected runtimeapi.ContainerState
+	}{
+		{input: "created", expected: runtimeapi.ContainerState_CONTAINER_CREATED},
+		{input: "running", expected: runtimeapi.ContainerState_CONTAINER_RUNNING},
+		{input: "restarting", expected: runtimeapi.ContainerState_CONTAINER_RUNNING},
+		{input: "paused", expected: runtimeapi.ContainerState_CONTAINER_RUNNING},
+		{input: "removing", expected: runtimeapi.ContainerState_CONTAINER_EXITED},
+		{input: "exited", expected: runtimeapi.ContainerState_CONTAINER_EXITED},
+		{input: "dead", expected: runtimeapi.ContainerState_CONTAINER_EXITED},
+		{input: "Up 5 hours", expected: runtimeapi.ContainerState_CONTAINER_UNKNOWN},
+		{input: "", expected: runtimeapi.ContainerState_CONTAINER_UNKNOWN},
+	}
+
+	for _, test := range testCases {
+		actual := dockerStateToRuntimeAPIContainerState(test.input)
+		assert.Equal(t, test.expected, actual, "docker state %q", test.input)
+	}
+}
+
+func TestInspectToRuntimeAPIContainerState(t *testing.T) {
+	finishedAt := time.Now()
+	testCases := []struct {
+		name       string
+		state      *dockertypes.ContainerState
+		finishedAt time.Time
+		expected   runtimeapi.ContainerState
+	}{
+		{
+			name:     "status reported as running",
+			state:    &dockertypes.ContainerState{Status: "running"},
+			expected: runtimeapi.ContainerState_CONTAINER_RUNNING,
+		},
+		{
+			// The status is authoritative over the partial fields.
+			name:       "status reported as created with stale finish time",
+			state:      &dockertypes.ContainerState{Status: "created"},
+			finishedAt: finishedAt,
+			expected:   runtimeapi.ContainerState_CONTAINER_CREATED,
+		},
+		{
+			name:     "status reported as created after failed start",
+			state:    &dockertypes.ContainerState{Status: "created", ExitCode: 128},
+			expected: runtimeapi.ContainerState_CONTAINER_EXITED,
+		},
+		{
+			name:     "status reported as paused",
+			state:    &dockertypes.ContainerState{Status: "paused", Running: true, Paused: true},
+			expected: runtimeapi.ContainerState_CONTAINER_RUNNING,
+		},
+		{
+			name:     "no status, running",
+			state:    &dockertypes.ContainerState{Running: true},
+			expected: runtimeapi.ContainerState_CONTAINER_RUNNING,
+		},
+		{
+			name:       "no status, finished",
+			state:      &dockertypes.ContainerState{},
+			finishedAt: finishedAt,
+			expected:   runtimeapi.ContainerState_CONTAINER_EXITED,
+		},
+		{
+			name:     "no status, not started",
+			state:    &dockertypes.ContainerState{},
+			expected: runtimeapi.ContainerState_CONTAINER_CREATED,
+		},
+	}
+
+	for _, test := range testCases {
+		actual := inspectToRuntimeAPIContainerState(test.state, test.finishedAt)
+		assert.Equal(t, test.expected, actual, test.name)
+	}
+}
+
 func TestConvertToPullableImageID(t *testing.T) {
 	testCases := []struct {
 		id       string
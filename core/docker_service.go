@@ -29,6 +29,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Mirantis/cri-dockerd/cmd/version"
 	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/containermanager"
 	"github.com/Mirantis/cri-dockerd/libdocker"
@@ -41,8 +42,10 @@ import (
 	"github.com/Mirantis/cri-dockerd/utils"
 	"github.com/blang/semver"
 	dockertypes "github.com/docker/docker/api/types"
+	dockermount "github.com/docker/docker/api/types/mount"
 	dockersystem "github.com/docker/docker/api/types/system"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 
 	v1 "k8s.io/api/core/v1"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
@@ -67,6 +70,53 @@ const (
 	containerTypeLabelContainer = "container"
 	containerLogPathLabelKey    = "io.kubernetes.container.logpath"
 	sandboxIDLabelKey           = "io.kubernetes.sandbox.id"
+	// Internal docker label carrying the correlation id generated for each
+	// sandbox and inherited by its containers.
+	correlationIDLabelKey = "io.kubernetes.sandbox.correlation-id"
+	// Internal docker label carrying the hugepage limits of a container, set
+	// on its cgroup when it is started.
+	hugepageLimitsLabelKey = "io.kubernetes.container.hugepage-limits"
+	// Internal docker label listing the annotations of a container kept in a
+	// side file, as they exceed the label size limit.
+	externalAnnotationsLabelKey = "io.kubernetes.container.external-annotations"
+	// Internal docker label carrying the full name of a sandbox or a
+	// container whose docker name was shortened.
+	fullNameLabelKey = "io.kubernetes.docker.full-name"
+	// Internal docker label listing, comma separated, the labels copied from
+	// the pod annotations, which are not CRI labels of the container.
+	annotationLabelsLabelKey = "io.kubernetes.container.annotation-labels"
+	// Internal docker label carrying the startup delay of a container,
+	// waited for when it is started.
+	startupDelayLabelKey = "io.kubernetes.container.startup-delay"
+
+	// Pod annotation delaying the start of the containers of the pod by the
+	// given duration, when enabled.
+	startupDelayAnnotationKey = "cri-dockerd.mirantis.com/startup-delay"
+	// Pod annotation setting, as a duration, the grace period docker gives
+	// the containers of the pod when it stops them on its own.
+	stopTimeoutAnnotationKey = "cri-dockerd.mirantis.com/stop-timeout"
+	// Pod annotation limiting the size of the writable layer of the
+	// containers of the pod, as a resource quantity.
+	ephemeralStorageLimitAnnotationKey = "cri-dockerd.mirantis.com/ephemeral-storage-limit"
+	// Pod annotation setting the size, as a resource quantity, of the tmpfs
+	// backing the mounts without host path of the containers of the pod.
+	tmpfsSizeAnnotationKey = "cri-dockerd.mirantis.com/tmpfs-size"
+	// Image or sandbox annotation selecting the platform, as os/arch[/variant],
+	// of the image pulled.
+	imagePlatformAnnotationKey = "cri-dockerd.mirantis.com/image-platform"
+	// UpdateContainerResources annotation pausing ("true") or unpausing
+	// ("false") the container.
+	freezeAnnotationKey = "cri-dockerd.mirantis.com/freeze"
+	// Container status annotation set to "true" while the container is paused,
+	// as the CRI reports paused containers as running.
+	pausedAnnotationKey = "cri-dockerd.mirantis.com/paused"
+	// Container stats annotation reporting, in bytes, the peak memory usage
+	// of the container.
+	peakMemoryAnnotationKey = "cri-dockerd.mirantis.com/peak-memory-bytes"
+	// Sandbox annotation running ("true") or not ("false") an init process as
+	// PID 1 of the containers of the pod, reaping zombies and forwarding
+	// signals.
+	initAnnotationKey = "cri-dockerd.mirantis.com/init"
 
 	systemInfoCacheMinTTL = time.Minute
 
@@ -89,6 +139,8 @@ type CRIService interface {
 
 type serviceCommon interface {
 	Start() error
+	// Stop stops the background tasks of the service.
+	Stop()
 	http.Handler
 
 	// GetContainerLogs gets logs for a specific container.
@@ -120,7 +172,17 @@ type DockerService interface {
 	serviceCommon
 }
 
-var internalLabelKeys = []string{containerTypeLabelKey, containerLogPathLabelKey, sandboxIDLabelKey}
+var internalLabelKeys = []string{
+	containerTypeLabelKey,
+	containerLogPathLabelKey,
+	sandboxIDLabelKey,
+	correlationIDLabelKey,
+	hugepageLimitsLabelKey,
+	externalAnnotationsLabelKey,
+	fullNameLabelKey,
+	annotationLabelsLabelKey,
+	startupDelayLabelKey,
+}
 
 // NewDockerService creates a new `DockerService`
 func NewDockerService(
@@ -131,6 +193,7 @@ func NewDockerService(
 	cgroupsName string,
 	kubeCgroupDriver string,
 	criDockerdRootDir string,
+	runtimeSettings *config.RuntimeSettings,
 ) (DockerService, error) {
 
 	client := config.NewDockerClientFromConfig(clientConfig)
@@ -151,12 +214,57 @@ func NewDockerService(
 		streamingRuntime: &streaming.StreamingRuntime{
 			Client:      client,
 			ExecHandler: &NativeExecHandler{},
+
+			ExecOutputFlushInterval: runtimeSettings.ExecOutputFlushInterval,
 		},
 		containerManager:      containermanager.NewContainerManager(cgroupsName, client),
 		checkpointManager:     checkpointManager,
 		networkReady:          make(map[string]bool),
+		stoppedContainers:     make(map[string]map[string]bool),
 		containerCleanupInfos: make(map[string]*containerCleanupInfo),
 		containerStatsCache:   newContainerStatsCache(),
+		seccompDenialCache:    newSeccompDenialCache(),
+		containerHistoryCache: newContainerHistoryCache(),
+		runtimeSettings:       *runtimeSettings,
+		annotationsDir:        filepath.Join(criDockerdRootDir, externalAnnotationsDir),
+		stopCh:                make(chan struct{}),
+	}
+
+	if err := validateProcMountTypes(runtimeSettings.AllowedProcMountTypes); err != nil {
+		return nil, err
+	}
+	if err := validateDefaultEnv(runtimeSettings.DefaultEnv); err != nil {
+		return nil, err
+	}
+	ds.defaultMountPropagation, err = parseMountPropagation(runtimeSettings.DefaultMountPropagation)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseRestartPolicy(runtimeSettings.ContainerRestartPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateSandboxSecurityProfiles(
+		runtimeSettings.SandboxSeccompProfile,
+		runtimeSettings.SandboxApparmorProfile,
+	); err != nil {
+		return nil, err
+	}
+	if err := validateAnnotationToLabel(runtimeSettings.AnnotationToLabel); err != nil {
+		return nil, err
+	}
+	if err := validateImagePullRetries(
+		runtimeSettings.ImagePullRetries,
+		runtimeSettings.ImagePullRetryBackoff,
+	); err != nil {
+		return nil, err
+	}
+	if runtimeSettings.SandboxLifecycleLogLevel != "" {
+		if _, err := parseSandboxLifecycleLogLevel(runtimeSettings.SandboxLifecycleLogLevel); err != nil {
+			return nil, err
+		}
+	}
+	if runtimeSettings.MaxConcurrentSandboxCreates > 0 {
+		ds.sandboxCreateSem = make(chan struct{}, runtimeSettings.MaxConcurrentSandboxCreates)
 	}
 
 	// check docker version compatibility.
@@ -187,6 +295,8 @@ func NewDockerService(
 		pluginSettings.PluginConfDir,
 		pluginSettings.PluginCacheDir,
 		pluginSettings.PluginBinDirs,
+		pluginSettings.CNIOperationTimeout,
+		pluginSettings.ManageLoopback,
 	)
 	cniPlugins = append(
 		cniPlugins,
@@ -249,6 +359,9 @@ func NewDockerService(
 	metrics.Register()
 
 	go ds.startStatsCollection()
+	go ds.startSeccompDenialCollection()
+	go ds.startDanglingImagePrune()
+	go ds.startContainerEventWatch()
 
 	return ds, nil
 }
@@ -267,6 +380,10 @@ type dockerService struct {
 	// Map of podSandboxID :: network-is-ready
 	networkReady     map[string]bool
 	networkReadyLock sync.Mutex
+	// Map of podSandboxID :: IDs of the containers stopped in the sandbox,
+	// for the logs of the sandbox stop.
+	stoppedContainers     map[string]map[string]bool
+	stoppedContainersLock sync.Mutex
 
 	containerManager containermanager.ContainerManager
 	// cgroup driver used by Docker runtime.
@@ -281,6 +398,16 @@ type dockerService struct {
 
 	containerStatsCache *containerStatsCache
 
+	// seccompDenialCache keeps the recent seccomp denials of containers.
+	seccompDenialCache *seccompDenialCache
+	// stopCh is closed when the service is stopped, to end its background
+	// tasks.
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	// containerHistoryCache keeps the timestamps of the recent runs of containers.
+	containerHistoryCache *containerHistoryCache
+
 	// containerCleanupInfos maps container IDs to the `containerCleanupInfo` structs
 	// needed to clean up after containers have been removed.
 	// (see `applyPlatformSpecificDockerConfig` and `performPlatformSpecificContainerCleanup`
@@ -288,6 +415,35 @@ type dockerService struct {
 	containerCleanupInfos map[string]*containerCleanupInfo
 	cleanupInfosLock      sync.RWMutex
 
+	// runtimeSettings holds the options applied to new sandboxes and containers.
+	runtimeSettings config.RuntimeSettings
+	// defaultMountPropagation is the parsed default mount propagation of the
+	// runtime settings.
+	defaultMountPropagation dockermount.Propagation
+
+	// annotationsDir holds the annotations of containers too large for labels.
+	annotationsDir string
+
+	// containerStatusGroup coalesces concurrent ContainerStatus calls.
+	containerStatusGroup singleflight.Group
+
+	// containerInspectCache keeps the inspections of containers for their
+	// status until a docker event invalidates them.
+	containerInspectCache containerInspectCache
+
+	// imageLocks keeps images being pulled from being removed.
+	imageLocks imageLocks
+
+	// sandboxLocks serializes the concurrent stops of the same sandbox.
+	sandboxLocks sandboxLocks
+
+	// cdiSpecs keeps the parsed CDI spec files.
+	cdiSpecs cdiSpecCache
+
+	// sandboxCreateSem limits the number of sandboxes created concurrently,
+	// nil if there is no limit.
+	sandboxCreateSem chan struct{}
+
 	// runtimeInfoLock sync.RWMutex
 }
 
@@ -404,6 +560,11 @@ func (ds *dockerService) Start() error {
 	return ds.containerManager.Start()
 }
 
+// Stop stops the background tasks of dockerService.
+func (ds *dockerService) Stop() {
+	ds.stopOnce.Do(func() { close(ds.stopCh) })
+}
+
 // Status returns the status of the runtime.
 func (ds *dockerService) Status(
 	_ context.Context,
@@ -443,8 +604,19 @@ func (ds *dockerService) Status(
 		if err != nil {
 			return nil, err
 		}
+		// The CRI version response has no room for the version of the shim
+		// itself, as its runtime is docker: it is reported here.
+		versionByt, err := json.Marshal(map[string]string{
+			"name":      version.PlatformName,
+			"version":   version.TagVersion(),
+			"gitCommit": version.GitCommit,
+		})
+		if err != nil {
+			return nil, err
+		}
 		resp.Info = make(map[string]string)
 		resp.Info["config"] = string(configByt)
+		resp.Info["version"] = string(versionByt)
 	}
 	return resp, nil
 }
@@ -500,20 +672,50 @@ func (ds *dockerService) checkVersionCompatibility() error {
 		return err
 	}
 
-	minAPIVersion, err := semver.Parse(libdocker.MinimumDockerAPIVersion)
+	minAPIVersion, err := ds.minDockerAPIVersion()
 	if err != nil {
 		return err
 	}
 
 	// Verify the docker version.
-	result := apiVersion.Compare(minAPIVersion)
+	result := apiVersion.Compare(*minAPIVersion)
 	if result < 0 {
-		return fmt.Errorf("docker API version is older than %s", libdocker.MinimumDockerAPIVersion)
+		return fmt.Errorf(
+			"docker API version %s is older than the minimum %s",
+			apiVersion,
+			minAPIVersion,
+		)
 	}
 
+	logrus.Infof("Using docker API version %s", apiVersion)
 	return nil
 }
 
+// minDockerAPIVersion returns the minimum docker API version, which is the
+// configured one if any. Docker API versions (e.g. 1.42) are accepted as is.
+func (ds *dockerService) minDockerAPIVersion() (*semver.Version, error) {
+	supported, err := semver.Parse(libdocker.MinimumDockerAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+	configured := ds.runtimeSettings.MinDockerAPIVersion
+	if configured == "" {
+		return &supported, nil
+	}
+	minAPIVersion, err := semver.ParseTolerant(configured)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum docker API version %q: %v", configured, err)
+	}
+	if minAPIVersion.LT(supported) {
+		return nil, fmt.Errorf(
+			"invalid minimum docker API version %q: cri-dockerd requires at least %s",
+			configured,
+			libdocker.MinimumDockerAPIVersion,
+		)
+	}
+	return &minAPIVersion, nil
+}
+
 // initCleanup is responsible for cleaning up any crufts left by previous
 // runs. If there are any errors, it simply logs them.
 func (ds *dockerService) initCleanup() {
@@ -111,13 +111,17 @@ func newTestDockerService() (*dockerService, *libdocker.FakeDockerClient, *clock
 	pm := network.NewPluginManager(&network.NoopNetworkPlugin{})
 	ckm := newMockCheckpointManager()
 	return &dockerService{
-		client:              c,
-		os:                  &containertest.FakeOS{},
-		network:             pm,
-		checkpointManager:   ckm,
-		networkReady:        make(map[string]bool),
-		dockerRootDir:       "/docker/root/dir",
-		containerStatsCache: newContainerStatsCache(),
+		client:                c,
+		os:                    &containertest.FakeOS{},
+		network:               pm,
+		checkpointManager:     ckm,
+		networkReady:          make(map[string]bool),
+		stoppedContainers:     make(map[string]map[string]bool),
+		dockerRootDir:         "/docker/root/dir",
+		containerStatsCache:   newContainerStatsCache(),
+		seccompDenialCache:    newSeccompDenialCache(),
+		containerHistoryCache: newContainerHistoryCache(),
+		stopCh:                make(chan struct{}),
 	}, c, fakeClock
 }
 
@@ -203,6 +207,86 @@ func TestVersion(t *testing.T) {
 	assert.Equal(t, expectedAPIVersion, apiVersion)
 }
 
+// TestVersionResponse tests that the version response reports docker as the
+// runtime, with the version of the docker daemon, and that the version of the
+// shim is reported in the verbose status.
+func TestVersionResponse(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	fDocker.WithVersion("24.0.7", "1.43")
+
+	resp, err := ds.Version(getTestCTX(), &runtimeapi.VersionRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, &runtimeapi.VersionResponse{
+		Version:           "0.1.0",
+		RuntimeName:       "docker",
+		RuntimeVersion:    "24.0.7",
+		RuntimeApiVersion: "v1",
+	}, resp)
+
+	alphaResp, err := ds.AlphaVersion(getTestCTX(), &runtimeapi.VersionRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "docker", alphaResp.RuntimeName)
+	assert.Equal(t, "24.0.7", alphaResp.RuntimeVersion)
+	assert.Equal(t, "v1alpha2", alphaResp.RuntimeApiVersion)
+
+	statusResp, err := ds.Status(getTestCTX(), &runtimeapi.StatusRequest{Verbose: true})
+	require.NoError(t, err)
+	assert.JSONEq(
+		t,
+		`{"name": "cri-dockerd", "version": "dev", "gitCommit": "HEAD"}`,
+		statusResp.Info["version"],
+	)
+}
+
+// TestCheckVersionCompatibility tests that docker daemons older than the
+// minimum API version are rejected.
+func TestCheckVersionCompatibility(t *testing.T) {
+	for desc, test := range map[string]struct {
+		apiVersion    string
+		minAPIVersion string
+		expectError   string
+	}{
+		"supported version": {
+			apiVersion: "1.42",
+		},
+		"version below the supported minimum": {
+			apiVersion:  "1.41",
+			expectError: "docker API version 1.41.0 is older than the minimum 1.42.0",
+		},
+		"version above the configured minimum": {
+			apiVersion:    "1.45",
+			minAPIVersion: "1.44",
+		},
+		"version below the configured minimum": {
+			apiVersion:    "1.43",
+			minAPIVersion: "1.44",
+			expectError:   "docker API version 1.43.0 is older than the minimum 1.44.0",
+		},
+		"configured minimum below the supported one": {
+			apiVersion:    "1.43",
+			minAPIVersion: "1.40",
+			expectError:   `invalid minimum docker API version "1.40"`,
+		},
+		"invalid configured minimum": {
+			apiVersion:    "1.43",
+			minAPIVersion: "latest",
+			expectError:   `invalid minimum docker API version "latest"`,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		fDocker.WithVersion("25.0.0", test.apiVersion)
+		ds.runtimeSettings.MinDockerAPIVersion = test.minAPIVersion
+		err := ds.checkVersionCompatibility()
+		if test.expectError != "" {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectError)
+			continue
+		}
+		assert.NoError(t, err)
+	}
+}
+
 func TestAPIVersionWithCache(t *testing.T) {
 	ds, _, _ := newTestDockerServiceWithVersionCache()
 
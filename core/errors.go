@@ -0,0 +1,90 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+// errorCodeMessages are the docker error messages mapped to a gRPC code, for
+// the errors which lost their type when they were wrapped. NotFound and
+// FailedPrecondition are only given to typed errors: the kubelet takes a
+// NotFound from the stops and removals as a success, so a loose match on e.g.
+// a CNI teardown failure would leak the pod network.
+var errorCodeMessages = []struct {
+	code     codes.Code
+	messages []string
+}{
+	{codes.DeadlineExceeded, []string{"context deadline exceeded", "operation timeout"}},
+	{codes.ResourceExhausted, []string{"toomanyrequests", "too many requests", "rate limit"}},
+}
+
+// ToGRPCError translates an error returned by a CRI method into a gRPC status
+// error, so that the kubelet can tell missing objects and timeouts apart from
+// other failures. Errors which already carry a gRPC status are returned as is,
+// and so are the errors with no better code than Unknown.
+func ToGRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if code := errorCode(err); code != codes.Unknown {
+		return status.Error(code, err.Error())
+	}
+	return err
+}
+
+// errorCode returns the gRPC code matching a docker error.
+func errorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errdefs.IsDeadline(err):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled) || errdefs.IsCancelled(err):
+		return codes.Canceled
+	case libdocker.IsContainerNotFoundError(err) ||
+		libdocker.IsImageNotFoundError(err) ||
+		errdefs.IsNotFound(err):
+		return codes.NotFound
+	case errdefs.IsConflict(err):
+		return codes.FailedPrecondition
+	}
+	var jerr *jsonmessage.JSONError
+	if errors.As(err, &jerr) && jerr.Code == http.StatusTooManyRequests {
+		return codes.ResourceExhausted
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range errorCodeMessages {
+		for _, message := range m.messages {
+			if strings.Contains(msg, message) {
+				return m.code
+			}
+		}
+	}
+	return codes.Unknown
+}
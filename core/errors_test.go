@@ -0,0 +1,111 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+func TestToGRPCError(t *testing.T) {
+	for desc, test := range map[string]struct {
+		err          error
+		expectedCode codes.Code
+	}{
+		"no error": {
+			expectedCode: codes.OK,
+		},
+		"missing container": {
+			err:          fmt.Errorf("Error response from daemon: No such container: 4a2b8c"),
+			expectedCode: codes.NotFound,
+		},
+		"missing image": {
+			err:          libdocker.ImageNotFoundError{ID: "busybox"},
+			expectedCode: codes.NotFound,
+		},
+		"typed not found": {
+			err:          errdefs.NotFound(fmt.Errorf("network foo")),
+			expectedCode: codes.NotFound,
+		},
+		"wrapped timeout": {
+			err:          fmt.Errorf("failed to inspect container: %w", context.DeadlineExceeded),
+			expectedCode: codes.DeadlineExceeded,
+		},
+		"timeout message": {
+			err:          fmt.Errorf("operation timeout: context deadline exceeded"),
+			expectedCode: codes.DeadlineExceeded,
+		},
+		"cancelled": {
+			err:          context.Canceled,
+			expectedCode: codes.Canceled,
+		},
+		"rate limited pull": {
+			err:          &jsonmessage.JSONError{Code: 429, Message: "pull limit reached"},
+			expectedCode: codes.ResourceExhausted,
+		},
+		"rate limit message": {
+			err: fmt.Errorf(
+				"Error response from daemon: toomanyrequests: You have reached your pull rate limit",
+			),
+			expectedCode: codes.ResourceExhausted,
+		},
+		"name conflict": {
+			err: errdefs.Conflict(fmt.Errorf(
+				`Conflict. The container name "/foo" is already in use by container "4a2b8c"`,
+			)),
+			expectedCode: codes.FailedPrecondition,
+		},
+		"untyped conflict": {
+			err:          fmt.Errorf("conflict: unable to delete 4a2b8c"),
+			expectedCode: codes.Unknown,
+		},
+		"untyped not found": {
+			err: fmt.Errorf(
+				"failed to teardown pod network: plugin type=\"bridge\" not found",
+			),
+			expectedCode: codes.Unknown,
+		},
+		"container not running": {
+			err:          errdefs.Conflict(fmt.Errorf("container 4a2b8c is not running")),
+			expectedCode: codes.FailedPrecondition,
+		},
+		"existing status": {
+			err:          status.Error(codes.Unavailable, "no such container"),
+			expectedCode: codes.Unavailable,
+		},
+		"other error": {
+			err:          fmt.Errorf("unexpected EOF"),
+			expectedCode: codes.Unknown,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		err := ToGRPCError(test.err)
+		assert.Equal(t, test.expectedCode, status.Code(err))
+		if test.err != nil {
+			assert.Contains(t, err.Error(), test.err.Error())
+		}
+	}
+}
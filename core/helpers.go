@@ -21,11 +21,18 @@ import (
 	"fmt"
 	"github.com/Mirantis/cri-dockerd/config"
 	"io"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
 
+	dockertypes "github.com/docker/docker/api/types"
 	dockerfilters "github.com/docker/docker/api/types/filters"
+	dockermount "github.com/docker/docker/api/types/mount"
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -33,11 +40,38 @@ const (
 	securityOptSeparator = '='
 )
 
+// The range of the CFS period accepted by the kernel, and the period used when
+// only a quota is set, in microseconds.
+const (
+	minCPUPeriod     = 1000
+	maxCPUPeriod     = 1000000
+	defaultCPUPeriod = 100000
+)
+
+// memoryRequestUnifiedKey is the unified cgroup setting the kubelet sets to
+// the memory request of the containers, with the MemoryQoS feature.
+const memoryRequestUnifiedKey = "memory.min"
+
+// minMemoryReservation is the smallest memory reservation accepted by docker.
+const minMemoryReservation = 6 << 20
+
 var (
 	conflictRE = regexp.MustCompile(
 		`Conflict. (?:.)+ is already in use by container \"?([0-9a-z]+)\"?`,
 	)
 
+	// transientCreateRE matches docker errors caused by lock contention in the
+	// daemon, for which retrying the creation of a container is worthwhile.
+	transientCreateRE = regexp.MustCompile(
+		`(?i)(resource temporarily unavailable|database is locked|lock timeout)`,
+	)
+
+	// transientStartRE matches docker errors caused by resources briefly held
+	// on the host, for which retrying the start of a container is worthwhile.
+	transientStartRE = regexp.MustCompile(
+		`(?i)(device or resource busy|resource temporarily unavailable|text file busy)`,
+	)
+
 	// this is hacky, but extremely common.
 	// if a container starts but the executable file is not found, runc gives a message that matches
 	startRE = regexp.MustCompile(`\\\\\\\"(.*)\\\\\\\": executable file not found`)
@@ -46,6 +80,75 @@ var (
 	errMaximumWrite = errors.New("maximum write")
 )
 
+// cpuQuotaAndPeriod checks that the CFS quota and period of a container are
+// accepted by the kernel, so that docker doesn't reject them with an obscure
+// error, and returns them with the default period if only the quota is set.
+// Zero values are unset.
+func cpuQuotaAndPeriod(quota, period int64) (int64, int64, error) {
+	if quota < 0 {
+		return 0, 0, fmt.Errorf("CPU quota %d must not be negative", quota)
+	}
+	if period != 0 && (period < minCPUPeriod || period > maxCPUPeriod) {
+		return 0, 0, fmt.Errorf(
+			"CPU period %d must be between %d and %d microseconds",
+			period,
+			minCPUPeriod,
+			maxCPUPeriod,
+		)
+	}
+	if quota != 0 && period == 0 {
+		period = defaultCPUPeriod
+	}
+	return quota, period, nil
+}
+
+// memoryReservation returns the memory soft limit of a container: its memory
+// request, when the kubelet passes it in the unified cgroup settings. It must
+// not exceed the memory limit, if any. Zero is unset, and so are the requests
+// below the minimum of docker. Docker sets the reservation to memory.low under
+// cgroup v2, so unlike memory.min the request is not a hard guarantee.
+func memoryReservation(unified map[string]string, limit int64) (int64, error) {
+	value, ok := unified[memoryRequestUnifiedKey]
+	if !ok {
+		return 0, nil
+	}
+	reservation, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || reservation < 0 {
+		return 0, fmt.Errorf("invalid memory request %q", value)
+	}
+	if limit > 0 && reservation > limit {
+		return 0, fmt.Errorf(
+			"memory request %d must not exceed the memory limit %d",
+			reservation,
+			limit,
+		)
+	}
+	if reservation < minMemoryReservation {
+		return 0, nil
+	}
+	return reservation, nil
+}
+
+// splitSysctls splits the sysctls of a pod into the network ones, which can
+// only be set where the network namespace lives, in the sandbox, and the
+// others, which are set on each container of the pod.
+func splitSysctls(sysctls map[string]string) (network, others map[string]string) {
+	for k, v := range sysctls {
+		if strings.HasPrefix(k, "net.") {
+			if network == nil {
+				network = make(map[string]string)
+			}
+			network[k] = v
+			continue
+		}
+		if others == nil {
+			others = make(map[string]string)
+		}
+		others[k] = v
+	}
+	return network, others
+}
+
 // makeLabels converts annotations to labels and merge them with the given
 // labels. This is necessary because docker does not support annotations;
 // we *fake* annotations using labels. Note that docker labels are not
@@ -62,21 +165,32 @@ func makeLabels(labels, annotations map[string]string) map[string]string {
 	return merged
 }
 
+// isInternalLabelKey tells whether the docker label is used internally by the
+// shim.
+func isInternalLabelKey(key string) bool {
+	for _, internalKey := range internalLabelKeys {
+		if key == internalKey {
+			return true
+		}
+	}
+	return false
+}
+
 // extractLabels converts raw docker labels to the CRI labels and annotations.
-// It also filters out internal labels used by this shim.
+// It also filters out internal labels used by this shim, and the labels copied
+// from the pod annotations.
 func extractLabels(input map[string]string) (map[string]string, map[string]string) {
 	labels := make(map[string]string)
 	annotations := make(map[string]string)
+	copied := make(map[string]bool)
+	if keys := input[annotationLabelsLabelKey]; keys != "" {
+		for _, k := range strings.Split(keys, ",") {
+			copied[k] = true
+		}
+	}
 	for k, v := range input {
 		// Check if the key is used internally by the shim.
-		internal := false
-		for _, internalKey := range internalLabelKeys {
-			if k == internalKey {
-				internal = true
-				break
-			}
-		}
-		if internal {
+		if isInternalLabelKey(k) || copied[k] {
 			continue
 		}
 
@@ -97,6 +211,42 @@ func extractLabels(input map[string]string) (map[string]string, map[string]strin
 	return labels, annotations
 }
 
+// containerLogger returns a log entry carrying the sandbox correlation id and
+// the container id as structured fields.
+func containerLogger(correlationID, containerID string) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"correlationID": correlationID,
+		"containerID":   containerID,
+	})
+}
+
+// getCorrelationID returns the correlation id of the sandbox the inspected
+// container belongs to. It returns an empty string if the container wasn't
+// inspected.
+func getCorrelationID(info *dockertypes.ContainerJSON) string {
+	if info == nil || info.Config == nil {
+		return ""
+	}
+	return info.Config.Labels[correlationIDLabelKey]
+}
+
+// resolveNamespaceTarget returns the docker id of the container whose
+// namespaces are joined by another container of the given sandbox.
+func (ds *dockerService) resolveNamespaceTarget(targetID, podSandboxID string) (string, error) {
+	info, err := ds.client.InspectContainer(targetID)
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect target container %q: %v", targetID, err)
+	}
+	if info.Config == nil || info.Config.Labels[sandboxIDLabelKey] != podSandboxID {
+		return "", fmt.Errorf(
+			"target container %q does not belong to sandbox %q",
+			targetID,
+			podSandboxID,
+		)
+	}
+	return info.ID, nil
+}
+
 // dockerFilter wraps around dockerfilters.Args and provides methods to modify
 // the filter easily.
 type dockerFilter struct {
@@ -154,6 +304,59 @@ func SharedLimitWriter(w io.Writer, limit *int64) io.Writer {
 	}
 }
 
+// makeImageVolumeMounts returns the bind mounts needed to keep docker from
+// creating an anonymous volume for a VOLUME of the image which lies below one
+// of the given mounts, as such a volume would shadow the mounted content.
+// Docker does not create anonymous volumes at destinations already mounted, so
+// each returned mount binds the matching subdirectory of the parent mount. A
+// volume is left to docker when that subdirectory does not exist, as docker
+// refuses to bind a missing source.
+func makeImageVolumeMounts(
+	imageVolumes map[string]struct{},
+	mounts []dockermount.Mount,
+) []dockermount.Mount {
+	var result []dockermount.Mount
+	for volume := range imageVolumes {
+		volume = filepath.Clean(volume)
+		var parent *dockermount.Mount
+		var subPath string
+		for i := range mounts {
+			target := filepath.Clean(mounts[i].Target)
+			if target == volume {
+				// Already covered by the mount itself.
+				parent = nil
+				break
+			}
+			rel, err := filepath.Rel(target, volume)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			// Prefer the innermost mount.
+			if parent == nil || len(target) > len(filepath.Clean(parent.Target)) {
+				parent = &mounts[i]
+				subPath = rel
+			}
+		}
+		if parent == nil || parent.Type != dockermount.TypeBind {
+			continue
+		}
+		bind := *parent
+		bind.Source = filepath.Join(parent.Source, subPath)
+		if _, err := os.Stat(bind.Source); err != nil {
+			logrus.Debugf("Not binding the image volume %s: %v", volume, err)
+			continue
+		}
+		bind.Target = volume
+		if parent.BindOptions != nil {
+			bindOptions := *parent.BindOptions
+			bind.BindOptions = &bindOptions
+		}
+		result = append(result, bind)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Target < result[j].Target })
+	return result
+}
+
 // fmtDockerOpts formats the docker security options using the given separator.
 func FmtDockerOpts(opts []DockerOpt, sep rune) []string {
 	fmtOpts := make([]string, len(opts))
@@ -20,41 +20,170 @@ limitations under the License.
 package core
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/blang/semver"
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockermount "github.com/docker/docker/api/types/mount"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
+// nsGetNSType is the NS_GET_NSTYPE ioctl request, returning the type of the
+// namespace a file refers to.
+const nsGetNSType = 0xb703
+
+// The range of the OOM score adjustment accepted by the kernel.
+const (
+	minOOMScoreAdj = -1000
+	maxOOMScoreAdj = 1000
+)
+
+// The CPU shares of the Kubernetes formula, from the milli CPUs requested.
+const (
+	minCPUShares    = 2
+	maxCPUShares    = 262144
+	cpuSharesPerCPU = 1024
+	milliCPUToCPU   = 1000
+)
+
+// localtimePath is the timezone file of containers.
+const localtimePath = "/etc/localtime"
+
+// hostLocaltimePath is the timezone file of the node.
+var hostLocaltimePath = localtimePath
+
 // DefaultMemorySwap always returns 0 for no memory swap in a sandbox
 func DefaultMemorySwap() int64 {
 	return 0
 }
 
+// clampOOMScoreAdj brings the OOM score adjustment within the range accepted
+// by the kernel.
+func clampOOMScoreAdj(oomScoreAdj int64) int {
+	if oomScoreAdj < minOOMScoreAdj {
+		return minOOMScoreAdj
+	}
+	if oomScoreAdj > maxOOMScoreAdj {
+		return maxOOMScoreAdj
+	}
+	return int(oomScoreAdj)
+}
+
+// milliCPUToShares converts the milli CPUs requested to CPU shares, as the
+// kubelet does: 1024 shares per CPU, within the range of the kernel.
+func milliCPUToShares(milliCPU int64) int64 {
+	shares := milliCPU * cpuSharesPerCPU / milliCPUToCPU
+	if shares < minCPUShares {
+		return minCPUShares
+	}
+	if shares > maxCPUShares {
+		return maxCPUShares
+	}
+	return shares
+}
+
+// containerCPUShares returns the CPU shares of a container. Without shares,
+// the CPU request is derived as the kubelet would from the limit given by the
+// CFS quota. Without either, 0 leaves the docker default.
+func containerCPUShares(shares, quota, period int64) int64 {
+	if shares != 0 {
+		return shares
+	}
+	if quota > 0 && period > 0 {
+		return milliCPUToShares(quota * milliCPUToCPU / period)
+	}
+	return 0
+}
+
 func (ds *dockerService) updateCreateConfig(
 	createConfig *dockerbackend.ContainerCreateConfig,
 	config *runtimeapi.ContainerConfig,
 	sandboxConfig *runtimeapi.PodSandboxConfig,
+	imageInspect *dockertypes.ImageInspect,
 	podSandboxID string, securityOptSep rune, apiVersion *semver.Version) error {
+	// Privileged containers are only allowed in privileged sandboxes.
+	if config.GetLinux().GetSecurityContext().GetPrivileged() &&
+		!sandboxConfig.GetLinux().GetSecurityContext().GetPrivileged() {
+		return fmt.Errorf(
+			"privileged container %q is not allowed in a non-privileged sandbox",
+			config.Metadata.Name,
+		)
+	}
 	// Apply Linux-specific options if applicable.
 	if lc := config.GetLinux(); lc != nil {
+		// The target of the namespaces may be given as a name or a short id,
+		// make it the docker id of the container, on a copy of the config so
+		// that the request is left untouched.
+		if nsOpts := lc.GetSecurityContext().GetNamespaceOptions(); nsOpts.GetTargetId() != "" {
+			targetID, err := ds.resolveNamespaceTarget(nsOpts.GetTargetId(), podSandboxID)
+			if err != nil {
+				return fmt.Errorf(
+					"failed to resolve namespace target for container %q: %v",
+					config.Metadata.Name,
+					err,
+				)
+			}
+			resolvedNsOpts := *nsOpts
+			resolvedNsOpts.TargetId = targetID
+			resolvedSc := *lc.SecurityContext
+			resolvedSc.NamespaceOptions = &resolvedNsOpts
+			resolvedLc := *lc
+			resolvedLc.SecurityContext = &resolvedSc
+			lc = &resolvedLc
+		}
 		rOpts := lc.GetResources()
 		if rOpts != nil {
+			cpuQuota, cpuPeriod, err := cpuQuotaAndPeriod(rOpts.CpuQuota, rOpts.CpuPeriod)
+			if err != nil {
+				return fmt.Errorf(
+					"invalid CPU limits for container %q: %v",
+					config.Metadata.Name,
+					err,
+				)
+			}
+			reservation, err := memoryReservation(rOpts.Unified, rOpts.MemoryLimitInBytes)
+			if err != nil {
+				return fmt.Errorf(
+					"invalid memory resources for container %q: %v",
+					config.Metadata.Name,
+					err,
+				)
+			}
 			createConfig.HostConfig.Resources = dockercontainer.Resources{
 				// Memory and MemorySwap are set to the same value, this prevents containers from using any swap.
-				Memory:     rOpts.MemoryLimitInBytes,
-				MemorySwap: rOpts.MemoryLimitInBytes,
-				CPUShares:  rOpts.CpuShares,
-				CPUQuota:   rOpts.CpuQuota,
-				CPUPeriod:  rOpts.CpuPeriod,
-				CpusetCpus: rOpts.CpusetCpus,
-				CpusetMems: rOpts.CpusetMems,
+				Memory:            rOpts.MemoryLimitInBytes,
+				MemorySwap:        rOpts.MemoryLimitInBytes,
+				MemoryReservation: reservation,
+				CPUShares:         containerCPUShares(rOpts.CpuShares, cpuQuota, cpuPeriod),
+				CPUQuota:          cpuQuota,
+				CPUPeriod:         cpuPeriod,
+				CpusetCpus:        rOpts.CpusetCpus,
+				CpusetMems:        rOpts.CpusetMems,
+			}
+			createConfig.HostConfig.OomScoreAdj = clampOOMScoreAdj(rOpts.OomScoreAdj)
+			hugepages, err := hugepageLimits(rOpts.HugepageLimits)
+			if err != nil {
+				return fmt.Errorf(
+					"invalid hugepage limits for container %q: %v",
+					config.Metadata.Name,
+					err,
+				)
+			}
+			if len(hugepages) > 0 {
+				value, err := json.Marshal(hugepages)
+				if err != nil {
+					return err
+				}
+				createConfig.Config.Labels[hugepageLimitsLabelKey] = string(value)
 			}
-			createConfig.HostConfig.OomScoreAdj = int(rOpts.OomScoreAdj)
 		}
 		// Note: ShmSize is handled in kube_docker_client.go
 
@@ -66,6 +195,21 @@ func (ds *dockerService) updateCreateConfig(
 				err,
 			)
 		}
+		modifyProcMount(lc.SecurityContext, createConfig.HostConfig, ds.runtimeSettings.AllowedProcMountTypes)
+	}
+
+	// Merge the default capabilities with the ones requested by the container,
+	// privileged containers have them all.
+	if !createConfig.HostConfig.Privileged {
+		createConfig.HostConfig.CapAdd, createConfig.HostConfig.CapDrop = mergeCapabilities(
+			ds.runtimeSettings.DefaultAddCapabilities,
+			ds.runtimeSettings.DefaultDropCapabilities,
+			config.GetLinux().GetSecurityContext().GetCapabilities(),
+		)
+	}
+
+	if ds.runtimeSettings.MountHostTimezone {
+		applyHostTimezone(createConfig, config, imageInspect)
 	}
 
 	// Apply cgroupsParent derived from the sandbox config.
@@ -80,11 +224,59 @@ func (ds *dockerService) updateCreateConfig(
 			)
 		}
 		createConfig.HostConfig.CgroupParent = cgroupParent
+
+		// The network sysctls of the pod are set on the sandbox.
+		_, createConfig.HostConfig.Sysctls = splitSysctls(lc.Sysctls)
 	}
 
 	return nil
 }
 
+// applyHostTimezone mounts the timezone file of the node read-only in the
+// container and points TZ at it, unless the container already mounts a
+// timezone file, or it or its image sets TZ, which wins.
+func applyHostTimezone(
+	createConfig *dockerbackend.ContainerCreateConfig,
+	config *runtimeapi.ContainerConfig,
+	imageInspect *dockertypes.ImageInspect,
+) {
+	for _, m := range config.GetMounts() {
+		if m.ContainerPath == localtimePath {
+			return
+		}
+	}
+	if setsTimezone(createConfig.Config.Env) {
+		return
+	}
+	if imageInspect != nil && imageInspect.Config != nil && setsTimezone(imageInspect.Config.Env) {
+		return
+	}
+	if _, err := os.Stat(hostLocaltimePath); err != nil {
+		logrus.Debugf("Not mounting the host timezone: %v", err)
+		return
+	}
+
+	createConfig.HostConfig.Mounts = append(createConfig.HostConfig.Mounts, dockermount.Mount{
+		Type:     dockermount.TypeBind,
+		Source:   hostLocaltimePath,
+		Target:   localtimePath,
+		ReadOnly: true,
+	})
+	// The zone name of the node may be missing from the zoneinfo of the
+	// image, so TZ names the mounted file itself.
+	createConfig.Config.Env = append(createConfig.Config.Env, "TZ=:"+localtimePath)
+}
+
+// setsTimezone returns whether the KEY=VALUE environment variables set TZ.
+func setsTimezone(env []string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, "TZ=") {
+			return true
+		}
+	}
+	return false
+}
+
 func (ds *dockerService) determinePodIPBySandboxID(uid string) []string {
 	return nil
 }
@@ -97,6 +289,49 @@ func getNetworkNamespace(c *dockertypes.ContainerJSON) (string, error) {
 	return fmt.Sprintf(dockerNetNSFmt, c.State.Pid), nil
 }
 
+// checkNetworkNamespace checks that the given path can be opened, and refers to
+// a network namespace. Kernels older than 4.11 cannot tell the type of a
+// namespace, in which case the path is only checked to be a namespace file.
+func checkNetworkNamespace(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	nsType, err := unix.IoctlRetInt(int(f.Fd()), nsGetNSType)
+	if (errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EINVAL)) && isNamespaceFile(f) {
+		// NS_GET_NSTYPE is not supported, the type cannot be verified.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s is not a namespace: %v", path, err)
+	}
+	if nsType != unix.CLONE_NEWNET {
+		return fmt.Errorf("%s is not a network namespace", path)
+	}
+	return nil
+}
+
+// isNamespaceFile tells whether the file lies on the filesystem of the
+// namespaces, or on procfs for the kernels predating it.
+func isNamespaceFile(f *os.File) bool {
+	var fs unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &fs); err != nil {
+		return false
+	}
+	return fs.Type == unix.NSFS_MAGIC || fs.Type == unix.PROC_SUPER_MAGIC
+}
+
+// checkSandboxNetworkNamespace checks that the network namespace of a running
+// sandbox is still valid.
+func checkSandboxNetworkNamespace(sandbox *dockertypes.ContainerJSON) error {
+	netnsPath, err := getNetworkNamespace(sandbox)
+	if err != nil {
+		return err
+	}
+	return checkNetworkNamespace(netnsPath)
+}
+
 type containerCleanupInfo struct{}
 
 // applyPlatformSpecificDockerConfig applies platform-specific configurations to a dockerbackend.ContainerCreateConfig struct.
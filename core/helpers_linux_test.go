@@ -20,12 +20,17 @@ limitations under the License.
 package core
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockermount "github.com/docker/docker/api/types/mount"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	"github.com/stretchr/testify/assert"
@@ -124,3 +129,447 @@ func TestLoadSeccompLocalhostProfiles(t *testing.T) {
 		}
 	}
 }
+
+// TestSandboxSecurityProfiles tests that the pause container runs with the
+// configured seccomp and apparmor profiles, or unconfined when opted out.
+func TestSandboxSecurityProfiles(t *testing.T) {
+	profilePath := filepath.Join(t.TempDir(), "pause.json")
+	require.NoError(t, os.WriteFile(profilePath, []byte(`{"defaultAction": "SCMP_ACT_ERRNO"}`), 0o644))
+
+	for desc, test := range map[string]struct {
+		seccomp      string
+		apparmor     string
+		expectedOpts []string
+	}{
+		"default": {
+			expectedOpts: []string{"no-new-privileges"},
+		},
+		"runtime default": {
+			seccomp:      "runtime/default",
+			apparmor:     "runtime/default",
+			expectedOpts: []string{"no-new-privileges"},
+		},
+		"unconfined": {
+			seccomp:      "unconfined",
+			apparmor:     "unconfined",
+			expectedOpts: []string{"no-new-privileges", "seccomp=unconfined", "apparmor=unconfined"},
+		},
+		"localhost profiles": {
+			seccomp:  "localhost/" + profilePath,
+			apparmor: "localhost/pause-profile",
+			expectedOpts: []string{
+				"no-new-privileges",
+				`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`,
+				"apparmor=pause-profile",
+			},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.SandboxSeccompProfile = test.seccomp
+		ds.runtimeSettings.SandboxApparmorProfile = test.apparmor
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: makeSandboxConfig("foo", "bar", "1", 0),
+		})
+		require.NoError(t, err)
+		sandbox, err := fDocker.InspectContainer(runSandboxResp.PodSandboxId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expectedOpts, sandbox.HostConfig.SecurityOpt)
+	}
+
+	assert.Error(t, validateSandboxSecurityProfiles("docker/default", ""))
+	assert.Error(t, validateSandboxSecurityProfiles("", "pause-profile"))
+	assert.NoError(t, validateSandboxSecurityProfiles("localhost/"+profilePath, "localhost/pause-profile"))
+}
+
+// TestCreateContainerOOMScoreAdj tests that the OOM score adjustment of
+// containers is applied, within the range accepted by the kernel.
+func TestCreateContainerOOMScoreAdj(t *testing.T) {
+	for desc, test := range map[string]struct {
+		oomScoreAdj int64
+		expected    int
+	}{
+		"guaranteed":         {oomScoreAdj: -997, expected: -997},
+		"burstable":          {oomScoreAdj: 500, expected: 500},
+		"best effort":        {oomScoreAdj: 1000, expected: 1000},
+		"unset":              {oomScoreAdj: 0, expected: 0},
+		"below the minimum":  {oomScoreAdj: -2000, expected: -1000},
+		"above the maximum":  {oomScoreAdj: 1001, expected: 1000},
+		"overflowing an int": {oomScoreAdj: 1 << 40, expected: 1000},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Linux = &runtimeapi.LinuxContainerConfig{
+			Resources: &runtimeapi.LinuxContainerResources{OomScoreAdj: test.oomScoreAdj},
+		}
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, c.HostConfig.OomScoreAdj)
+	}
+}
+
+// TestCreateContainerCPUQuotaAndPeriod tests that the CPU limits of containers
+// get the default period, and that invalid ones are rejected before docker
+// creates the container.
+func TestCreateContainerCPUQuotaAndPeriod(t *testing.T) {
+	for desc, test := range map[string]struct {
+		quota, period int64
+		expectPeriod  int64
+		expectError   bool
+	}{
+		"valid":                  {quota: 50000, period: 50000, expectPeriod: 50000},
+		"default period derived": {quota: 50000, expectPeriod: defaultCPUPeriod},
+		"invalid period":         {quota: 50000, period: 100, expectError: true},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Linux = &runtimeapi.LinuxContainerConfig{
+			Resources: &runtimeapi.LinuxContainerResources{
+				CpuQuota:  test.quota,
+				CpuPeriod: test.period,
+			},
+		}
+		created := len(fDocker.Created)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectError {
+			assert.Error(t, err)
+			assert.Len(t, fDocker.Created, created)
+			continue
+		}
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.quota, c.HostConfig.CPUQuota)
+		assert.Equal(t, test.expectPeriod, c.HostConfig.CPUPeriod)
+	}
+}
+
+// TestMilliCPUToShares tests that the CPU shares follow the formula of the
+// kubelet: 1024 shares per CPU, at least 2 and at most 262144.
+func TestMilliCPUToShares(t *testing.T) {
+	for milliCPU, expected := range map[int64]int64{
+		0:       2,
+		1:       2,
+		5:       5,
+		100:     102,
+		250:     256,
+		500:     512,
+		1000:    1024,
+		1500:    1536,
+		4000:    4096,
+		256000:  262144,
+		1000000: 262144,
+	} {
+		assert.Equal(t, expected, milliCPUToShares(milliCPU), "milliCPU %d", milliCPU)
+	}
+}
+
+// TestCreateContainerCPUShares tests that the CPU shares of a container are
+// the requested ones, or else derived from its CPU limit, or else the docker
+// default.
+func TestCreateContainerCPUShares(t *testing.T) {
+	for desc, test := range map[string]struct {
+		shares, quota, period int64
+		expectShares          int64
+	}{
+		"requested shares":           {shares: 512, quota: 200000, period: 100000, expectShares: 512},
+		"shares from the limit":      {quota: 150000, period: 100000, expectShares: 1536},
+		"shares from a small limit":  {quota: 25000, expectShares: 256},
+		"shares from a tiny limit":   {quota: 1000, period: 1000000, expectShares: 2},
+		"no request nor limit":       {expectShares: 0},
+		"minimum shares of the pods": {shares: 2, expectShares: 2},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Linux = &runtimeapi.LinuxContainerConfig{
+			Resources: &runtimeapi.LinuxContainerResources{
+				CpuShares: test.shares,
+				CpuQuota:  test.quota,
+				CpuPeriod: test.period,
+			},
+		}
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.expectShares, c.HostConfig.CPUShares)
+	}
+}
+
+// TestCreateContainerMemoryReservation tests that the memory soft limit of a
+// container is its memory request, distinct from its memory limit, that it
+// can't exceed the limit, and that requests below the docker minimum are unset.
+func TestCreateContainerMemoryReservation(t *testing.T) {
+	for desc, test := range map[string]struct {
+		limit             int64
+		unified           map[string]string
+		expectReservation int64
+		expectError       bool
+	}{
+		"request and limit": {
+			limit:             256 << 20,
+			unified:           map[string]string{"memory.min": "134217728"},
+			expectReservation: 128 << 20,
+		},
+		"request without limit": {
+			unified:           map[string]string{"memory.min": "134217728"},
+			expectReservation: 128 << 20,
+		},
+		"request equal to the limit": {
+			limit:             128 << 20,
+			unified:           map[string]string{"memory.min": "134217728"},
+			expectReservation: 128 << 20,
+		},
+		"limit without request": {
+			limit: 256 << 20,
+		},
+		"request below the docker minimum": {
+			limit:   256 << 20,
+			unified: map[string]string{"memory.min": "1048576"},
+		},
+		"request exceeding the limit": {
+			limit:       64 << 20,
+			unified:     map[string]string{"memory.min": "134217728"},
+			expectError: true,
+		},
+		"invalid request": {
+			unified:     map[string]string{"memory.min": "max"},
+			expectError: true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		resources := &runtimeapi.LinuxContainerResources{
+			MemoryLimitInBytes: test.limit,
+			Unified:            test.unified,
+		}
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Linux = &runtimeapi.LinuxContainerConfig{Resources: resources}
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		if test.expectError {
+			assert.Error(t, err)
+			_, err = ds.UpdateContainerResources(getTestCTX(), &runtimeapi.UpdateContainerResourcesRequest{
+				ContainerId: "app",
+				Linux:       resources,
+			})
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+		assert.Equal(t, test.limit, c.HostConfig.Memory)
+		assert.Equal(t, test.expectReservation, c.HostConfig.MemoryReservation)
+	}
+}
+
+// TestCreateContainerHostTimezone tests that the timezone of the node is
+// mounted in containers when enabled, unless the pod or the image sets its
+// own.
+func TestCreateContainerHostTimezone(t *testing.T) {
+	localtime := filepath.Join(t.TempDir(), "localtime")
+	require.NoError(t, os.WriteFile(localtime, []byte("TZif"), 0o644))
+	defer func(path string) { hostLocaltimePath = path }(hostLocaltimePath)
+	hostLocaltimePath = localtime
+
+	for desc, test := range map[string]struct {
+		enabled     bool
+		envs        []*runtimeapi.KeyValue
+		imageEnv    []string
+		mounts      []*runtimeapi.Mount
+		expectMount bool
+		expectTZ    string
+	}{
+		"disabled": {},
+		"enabled": {
+			enabled:     true,
+			expectMount: true,
+			expectTZ:    ":/etc/localtime",
+		},
+		"pod sets TZ": {
+			enabled:  true,
+			envs:     []*runtimeapi.KeyValue{{Key: "TZ", Value: "Asia/Tokyo"}},
+			expectTZ: "Asia/Tokyo",
+		},
+		"image sets TZ": {
+			enabled:  true,
+			imageEnv: []string{"PATH=/bin", "TZ=UTC"},
+		},
+		"pod mounts localtime": {
+			enabled: true,
+			mounts: []*runtimeapi.Mount{
+				{HostPath: "/usr/share/zoneinfo/UTC", ContainerPath: "/etc/localtime"},
+			},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.MountHostTimezone = test.enabled
+		fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+			ID:       "iamimage",
+			RepoTags: []string{"iamimage"},
+			Config:   &dockercontainer.Config{Env: test.imageEnv},
+		}})
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		config.Envs = test.envs
+		config.Mounts = test.mounts
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		c, err := fDocker.InspectContainer(createResp.ContainerId)
+		require.NoError(t, err)
+
+		var mount *dockermount.Mount
+		for i := range c.HostConfig.Mounts {
+			if c.HostConfig.Mounts[i].Source == localtime {
+				mount = &c.HostConfig.Mounts[i]
+			}
+		}
+		if test.expectMount {
+			require.NotNil(t, mount)
+			assert.Equal(t, "/etc/localtime", mount.Target)
+			assert.True(t, mount.ReadOnly)
+		} else {
+			assert.Nil(t, mount)
+		}
+		var tz string
+		for _, env := range c.Config.Env {
+			if value, ok := strings.CutPrefix(env, "TZ="); ok {
+				tz = value
+			}
+		}
+		assert.Equal(t, test.expectTZ, tz)
+	}
+}
+
+// TestPodSysctls tests that the network sysctls of a pod are set on the
+// sandbox, which holds the network namespace, and the others on its containers.
+func TestPodSysctls(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sConfig.Linux = &runtimeapi.LinuxPodSandboxConfig{
+		Sysctls: map[string]string{
+			"net.ipv4.ip_forward":    "1",
+			"kernel.shm_rmid_forced": "1",
+		},
+	}
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+
+	sandbox, err := fDocker.InspectContainer(runSandboxResp.PodSandboxId)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"net.ipv4.ip_forward": "1"}, sandbox.HostConfig.Sysctls)
+	c, err := fDocker.InspectContainer(createResp.ContainerId)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"kernel.shm_rmid_forced": "1"}, c.HostConfig.Sysctls)
+}
+
+func TestCheckNetworkNamespace(t *testing.T) {
+	assert.NoError(t, checkNetworkNamespace("/proc/self/ns/net"))
+	assert.EqualError(
+		t,
+		checkNetworkNamespace("/proc/self/ns/pid"),
+		"/proc/self/ns/pid is not a network namespace",
+	)
+	file := filepath.Join(t.TempDir(), "net")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	err := checkNetworkNamespace(file)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), file+" is not a namespace")
+	assert.True(t, os.IsNotExist(checkNetworkNamespace("/proc/0/ns/net")))
+}
+
+// TestPodSandboxStatusStaleNetworkNamespace tests that a running sandbox whose
+// network namespace is gone is reported as not ready, with the reason in its
+// verbose status.
+func TestPodSandboxStatusStaleNetworkNamespace(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: makeSandboxConfig("foo", "bar", "1", 0),
+	})
+	require.NoError(t, err)
+
+	statusResp, err := ds.PodSandboxStatus(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatusRequest{PodSandboxId: resp.PodSandboxId},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, statusResp.Status.State)
+
+	// Point the sandbox at the namespace of a process which doesn't exist.
+	sandbox, err := fDocker.InspectContainer(resp.PodSandboxId)
+	require.NoError(t, err)
+	sandbox.State.Pid = 1 << 30
+
+	statusResp, err = ds.PodSandboxStatus(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatusRequest{PodSandboxId: resp.PodSandboxId, Verbose: true},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_NOTREADY, statusResp.Status.State)
+	var reason string
+	require.NoError(t, json.Unmarshal([]byte(statusResp.Info["notReadyReason"]), &reason))
+	assert.Equal(
+		t,
+		"stale network namespace: open /proc/1073741824/ns/net: no such file or directory",
+		reason,
+	)
+}
@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
@@ -147,8 +149,12 @@ func TestEnsureSandboxImageExists(t *testing.T) {
 		injectImage  bool
 		imgNeedsAuth bool
 		injectErr    error
+		pullErr      error
+		tarball      bool
+		archived     bool
 		calls        []string
 		err          bool
+		errContains  string
 		configJSON   string
 	}{
 		"should not pull image when it already exists": {
@@ -174,6 +180,27 @@ func TestEnsureSandboxImageExists(t *testing.T) {
 			calls:        []string{"inspect_image", "pull"},
 			err:          true,
 		},
+		"should return an actionable error when the pull fails": {
+			injectErr:   libdocker.ImageNotFoundError{ID: "image_id"},
+			pullErr:     fmt.Errorf("no route to host"),
+			calls:       []string{"inspect_image", "pull"},
+			err:         true,
+			errContains: "preload it",
+		},
+		"should load the image from the tarball when the pull fails": {
+			injectErr: libdocker.ImageNotFoundError{ID: "image_id"},
+			pullErr:   fmt.Errorf("no route to host"),
+			tarball:   true,
+			archived:  true,
+			calls:     []string{"inspect_image", "pull", "load_image", "inspect_image"},
+		},
+		"should return error when the tarball does not hold the image": {
+			injectErr: libdocker.ImageNotFoundError{ID: "image_id"},
+			pullErr:   fmt.Errorf("no route to host"),
+			tarball:   true,
+			calls:     []string{"inspect_image", "pull", "load_image", "inspect_image"},
+			err:       true,
+		},
 	} {
 		t.Logf("TestCase: %q", desc)
 		_, fakeDocker, _ := newTestDockerService()
@@ -185,10 +212,22 @@ func TestEnsureSandboxImageExists(t *testing.T) {
 			}
 		}
 		fakeDocker.InjectError("inspect_image", test.injectErr)
+		fakeDocker.InjectError("pull", test.pullErr)
+		if test.archived {
+			fakeDocker.ArchivedImages = []dockerimage.Summary{{ID: sandboxImage}}
+		}
+		var tarball string
+		if test.tarball {
+			tarball = filepath.Join(t.TempDir(), "pause.tar")
+			require.NoError(t, os.WriteFile(tarball, []byte("archive"), 0o644))
+		}
 
-		err := ensureSandboxImageExists(fakeDocker, sandboxImage)
+		err := ensureSandboxImageExists(fakeDocker, sandboxImage, tarball)
 		assert.NoError(t, fakeDocker.AssertCalls(test.calls))
 		assert.Equal(t, test.err, err != nil)
+		if test.errContains != "" {
+			assert.ErrorContains(t, err, test.errContains)
+		}
 	}
 }
 
@@ -343,6 +382,67 @@ func TestGenerateMountBindings(t *testing.T) {
 	assert.Equal(t, expectedResult, result)
 }
 
+// TestMountPropagation tests the mapping of the CRI propagation of mounts to
+// the docker bind propagation, and back.
+func TestMountPropagation(t *testing.T) {
+	for desc, test := range map[string]struct {
+		propagation         runtimeapi.MountPropagation
+		readonly            bool
+		expectedPropagation dockermount.Propagation
+	}{
+		"private": {
+			propagation:         runtimeapi.MountPropagation_PROPAGATION_PRIVATE,
+			expectedPropagation: "",
+		},
+		"rslave": {
+			propagation:         runtimeapi.MountPropagation_PROPAGATION_HOST_TO_CONTAINER,
+			expectedPropagation: dockermount.PropagationRSlave,
+		},
+		"rshared": {
+			propagation:         runtimeapi.MountPropagation_PROPAGATION_BIDIRECTIONAL,
+			expectedPropagation: dockermount.PropagationRShared,
+		},
+		"read-only private": {
+			propagation:         runtimeapi.MountPropagation_PROPAGATION_PRIVATE,
+			readonly:            true,
+			expectedPropagation: "",
+		},
+		"read-only rslave": {
+			propagation:         runtimeapi.MountPropagation_PROPAGATION_HOST_TO_CONTAINER,
+			readonly:            true,
+			expectedPropagation: dockermount.PropagationRSlave,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		result := libdocker.GenerateMountBindings([]*runtimeapi.Mount{{
+			HostPath:      "/var/lib/kubelet/pods/uid/volumes/secret",
+			ContainerPath: "/etc/secret",
+			Readonly:      test.readonly,
+			Propagation:   test.propagation,
+		}}, "")
+		require.Len(t, result, 1)
+		bind := result[0]
+		assert.Equal(t, dockermount.TypeBind, bind.Type)
+		assert.Equal(t, test.readonly, bind.ReadOnly)
+		require.NotNil(t, bind.BindOptions)
+		assert.Equal(t, test.readonly, bind.BindOptions.ReadOnlyNonRecursive)
+		assert.Equal(t, test.expectedPropagation, bind.BindOptions.Propagation)
+
+		assert.Equal(t, test.propagation, toRuntimeAPIMountPropagation(bind.BindOptions.Propagation))
+	}
+	// Docker reports the propagation it defaulted to for private mounts.
+	assert.Equal(
+		t,
+		runtimeapi.MountPropagation_PROPAGATION_PRIVATE,
+		toRuntimeAPIMountPropagation(dockermount.PropagationRPrivate),
+	)
+	assert.Equal(
+		t,
+		runtimeapi.MountPropagation_PROPAGATION_HOST_TO_CONTAINER,
+		toRuntimeAPIMountPropagation(dockermount.PropagationRSlave),
+	)
+}
+
 func TestLimitedWriter(t *testing.T) {
 	max := func(x, y int64) int64 {
 		if x > y {
@@ -443,3 +543,34 @@ func TestLimitedWriter(t *testing.T) {
 		}
 	}
 }
+
+// TestCPUQuotaAndPeriod tests the validation of the CFS quota and period of
+// containers.
+func TestCPUQuotaAndPeriod(t *testing.T) {
+	for desc, test := range map[string]struct {
+		quota, period             int64
+		expectQuota, expectPeriod int64
+		expectError               bool
+	}{
+		"unset":                  {},
+		"valid":                  {quota: 50000, period: 100000, expectQuota: 50000, expectPeriod: 100000},
+		"minimum period":         {quota: 500, period: 1000, expectQuota: 500, expectPeriod: 1000},
+		"maximum period":         {quota: 2000000, period: 1000000, expectQuota: 2000000, expectPeriod: 1000000},
+		"period without quota":   {period: 50000, expectPeriod: 50000},
+		"default period derived": {quota: 25000, expectQuota: 25000, expectPeriod: defaultCPUPeriod},
+		"negative quota":         {quota: -1, period: 100000, expectError: true},
+		"period below the range": {quota: 500, period: 999, expectError: true},
+		"period above the range": {quota: 50000, period: 1000001, expectError: true},
+		"negative period":        {quota: 50000, period: -100000, expectError: true},
+	} {
+		t.Logf("TestCase: %s", desc)
+		quota, period, err := cpuQuotaAndPeriod(test.quota, test.period)
+		if test.expectError {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expectQuota, quota)
+		assert.Equal(t, test.expectPeriod, period)
+	}
+}
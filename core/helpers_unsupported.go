@@ -42,15 +42,16 @@ func (ds *dockerService) getSecurityOpts(
 	return nil, nil
 }
 
-func (ds *dockerService) getSandBoxSecurityOpts(separator rune) []string {
+func (ds *dockerService) getSandBoxSecurityOpts(separator rune) ([]string, error) {
 	logrus.Info("getSandBoxSecurityOpts is unsupported in this build")
-	return nil
+	return nil, nil
 }
 
 func (ds *dockerService) updateCreateConfig(
 	createConfig *dockerbackend.ContainerCreateConfig,
 	config *runtimeapi.ContainerConfig,
 	sandboxConfig *runtimeapi.PodSandboxConfig,
+	imageInspect *dockertypes.ImageInspect,
 	podSandboxID string, securityOptSep rune, apiVersion *semver.Version) error {
 	logrus.Info("updateCreateConfig is unsupported in this build")
 	return nil
@@ -65,6 +66,10 @@ func getNetworkNamespace(c *dockertypes.ContainerJSON) (string, error) {
 	return "", fmt.Errorf("unsupported platform")
 }
 
+func checkSandboxNetworkNamespace(sandbox *dockertypes.ContainerJSON) error {
+	return nil
+}
+
 type containerCleanupInfo struct{}
 
 // applyPlatformSpecificDockerConfig applies platform-specific configurations to a dockerbackend.ContainerCreateConfig struct.
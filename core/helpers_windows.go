@@ -47,6 +47,7 @@ func (ds *dockerService) updateCreateConfig(
 	createConfig *dockerbackend.ContainerCreateConfig,
 	config *runtimeapi.ContainerConfig,
 	sandboxConfig *runtimeapi.PodSandboxConfig,
+	imageInspect *dockertypes.ImageInspect,
 	podSandboxID string, securityOptSep rune, apiVersion *semver.Version) error {
 	if networkMode := os.Getenv("CONTAINER_NETWORK"); networkMode != "" {
 		createConfig.HostConfig.NetworkMode = dockercontainer.NetworkMode(networkMode)
@@ -145,6 +146,12 @@ func getNetworkNamespace(c *dockertypes.ContainerJSON) (string, error) {
 	return string(c.HostConfig.NetworkMode), nil
 }
 
+// checkSandboxNetworkNamespace is a no-op on Windows, where the network
+// namespace of sandboxes has no path.
+func checkSandboxNetworkNamespace(sandbox *dockertypes.ContainerJSON) error {
+	return nil
+}
+
 type containerCleanupInfo struct {
 	gMSARegistryValueName string
 }
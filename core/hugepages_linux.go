@@ -0,0 +1,242 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/api/resource"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+var (
+	// hugepagesDir has an entry per huge page size supported by the node.
+	hugepagesDir = "/sys/kernel/mm/hugepages"
+	// cgroupRoot is where the cgroup filesystems are mounted.
+	cgroupRoot = "/sys/fs/cgroup"
+	// procRoot is where the proc filesystem is mounted.
+	procRoot = "/proc"
+
+	// errHugetlbUnavailable is returned when the hugetlb controller cannot be
+	// used for a container.
+	errHugetlbUnavailable = errors.New("hugetlb cgroup controller is not available")
+)
+
+// Docker has no setting for the hugetlb controller, so the hugepage limits of
+// a container are kept in a label when it is created, and written to its
+// cgroup once it is started.
+
+// hugepageLimits translates the CRI hugepage limits into hugetlb cgroup limits
+// keyed by page size, named as in the cgroup files (e.g. 2MB). The page sizes
+// must be supported by the node. Zero limits are skipped.
+func hugepageLimits(limits []*runtimeapi.HugepageLimit) (map[string]uint64, error) {
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	supported, err := supportedHugepageSizes()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]uint64, len(limits))
+	for _, limit := range limits {
+		size, err := parseHugepageSize(limit.PageSize)
+		if err != nil {
+			return nil, err
+		}
+		if !supported[size] {
+			return nil, fmt.Errorf("huge page size %q is not supported by the node", limit.PageSize)
+		}
+		if limit.Limit == 0 {
+			continue
+		}
+		result[hugepageSizeName(size)] = limit.Limit
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// supportedHugepageSizes returns the huge page sizes in bytes supported by the
+// node, none if huge pages are not enabled.
+func supportedHugepageSizes() (map[uint64]bool, error) {
+	entries, err := os.ReadDir(hugepagesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the huge page sizes: %v", err)
+	}
+	sizes := map[uint64]bool{}
+	for _, entry := range entries {
+		kb, ok := strings.CutPrefix(entry.Name(), "hugepages-")
+		if !ok {
+			continue
+		}
+		size, err := strconv.ParseUint(strings.TrimSuffix(kb, "kB"), 10, 64)
+		if err != nil {
+			continue
+		}
+		sizes[size*1024] = true
+	}
+	return sizes, nil
+}
+
+// parseHugepageSize returns the size in bytes of a huge page, given as in the
+// cgroup files (e.g. 2MB, as sent by the kubelet) or as a resource quantity
+// (e.g. 2Mi).
+func parseHugepageSize(pageSize string) (uint64, error) {
+	for i, unit := range []string{"KB", "MB", "GB"} {
+		if value, ok := strings.CutSuffix(pageSize, unit); ok {
+			size, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || size == 0 {
+				return 0, fmt.Errorf("invalid huge page size %q", pageSize)
+			}
+			return size << (10 * (i + 1)), nil
+		}
+	}
+	quantity, err := resource.ParseQuantity(pageSize)
+	if err != nil || quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid huge page size %q", pageSize)
+	}
+	return uint64(quantity.Value()), nil
+}
+
+// hugepageSizeName returns the name of a huge page size in the cgroup files.
+func hugepageSizeName(size uint64) string {
+	switch {
+	case size >= 1<<30 && size%(1<<30) == 0:
+		return fmt.Sprintf("%dGB", size>>30)
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", size>>20)
+	default:
+		return fmt.Sprintf("%dKB", size>>10)
+	}
+}
+
+// applyHugepageLimits writes the hugepage limits recorded when the container
+// was created to its cgroup, given the inspection of the started container.
+// The limits are skipped when the container is not running, e.g. it already
+// exited, or when the hugetlb controller is not available to the container,
+// e.g. not delegated to its cgroup.
+func (ds *dockerService) applyHugepageLimits(r *dockertypes.ContainerJSON) error {
+	if r.Config == nil || r.State == nil || r.State.Pid == 0 {
+		return nil
+	}
+	value, ok := r.Config.Labels[hugepageLimitsLabelKey]
+	if !ok {
+		return nil
+	}
+	var limits map[string]uint64
+	if err := json.Unmarshal([]byte(value), &limits); err != nil {
+		return fmt.Errorf("invalid hugepage limits %q: %v", value, err)
+	}
+	err := writeHugepageLimits(r.State.Pid, limits)
+	if errors.Is(err, errHugetlbUnavailable) {
+		logrus.Warningf("Not applying the hugepage limits of container %s: %v", r.ID, err)
+		return nil
+	}
+	return err
+}
+
+// updateHugepageLimits writes new hugepage limits to the cgroup of a running
+// container.
+func (ds *dockerService) updateHugepageLimits(
+	containerID string,
+	hugepages []*runtimeapi.HugepageLimit,
+) error {
+	limits, err := hugepageLimits(hugepages)
+	if err != nil || len(limits) == 0 {
+		return err
+	}
+	r, err := ds.client.InspectContainer(containerID)
+	if err != nil {
+		return err
+	}
+	return writeHugepageLimits(r.State.Pid, limits)
+}
+
+// writeHugepageLimits writes the hugepage limits to the hugetlb cgroup of the
+// given process, under either cgroup v1 or v2. It fails with
+// errHugetlbUnavailable when the process has no hugetlb cgroup it can write.
+func writeHugepageLimits(pid int, limits map[string]uint64) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	if pid == 0 {
+		return fmt.Errorf("container is not running")
+	}
+	cgroups, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return fmt.Errorf("failed to read the cgroups of process %d: %v", pid, err)
+	}
+	var dir, fileFmt string
+	for _, line := range strings.Split(strings.TrimSpace(string(cgroups)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" && dir == "" {
+			dir, fileFmt = filepath.Join(cgroupRoot, fields[2]), "hugetlb.%s.max"
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if controller == "hugetlb" {
+				dir = filepath.Join(cgroupRoot, "hugetlb", fields[2])
+				fileFmt = "hugetlb.%s.limit_in_bytes"
+			}
+		}
+	}
+	if dir == "" {
+		return fmt.Errorf("%w: no hugetlb cgroup for process %d", errHugetlbUnavailable, pid)
+	}
+	for size, limit := range limits {
+		path := filepath.Join(dir, fmt.Sprintf(fileFmt, size))
+		if err := writeCgroupFile(path, strconv.FormatUint(limit, 10)); err != nil {
+			return fmt.Errorf("failed to set the %s hugepage limit: %w", size, err)
+		}
+	}
+	return nil
+}
+
+// writeCgroupFile writes the value to an existing cgroup file. Missing or
+// read-only files are reported as errHugetlbUnavailable.
+func writeCgroupFile(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if os.IsNotExist(err) || os.IsPermission(err) || errors.Is(err, unix.EROFS) {
+		return fmt.Errorf("%w: %v", errHugetlbUnavailable, err)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// fakeHugepageSizes makes the node support 2Mi and 1Gi huge pages.
+func fakeHugepageSizes(t *testing.T) {
+	dir := t.TempDir()
+	for _, size := range []string{"hugepages-2048kB", "hugepages-1048576kB"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, size), 0o755))
+	}
+	saved := hugepagesDir
+	hugepagesDir = dir
+	t.Cleanup(func() { hugepagesDir = saved })
+}
+
+func TestHugepageLimits(t *testing.T) {
+	fakeHugepageSizes(t)
+	for desc, test := range map[string]struct {
+		limits      []*runtimeapi.HugepageLimit
+		expected    map[string]uint64
+		expectError string
+	}{
+		"no limits": {},
+		"2Mi and 1Gi pages": {
+			limits: []*runtimeapi.HugepageLimit{
+				{PageSize: "2Mi", Limit: 64 << 20},
+				{PageSize: "1Gi", Limit: 2 << 30},
+			},
+			expected: map[string]uint64{"2MB": 64 << 20, "1GB": 2 << 30},
+		},
+		"cgroup page size names": {
+			limits: []*runtimeapi.HugepageLimit{
+				{PageSize: "2MB", Limit: 4 << 20},
+				{PageSize: "1GB", Limit: 0},
+			},
+			expected: map[string]uint64{"2MB": 4 << 20},
+		},
+		"zero limits": {
+			limits: []*runtimeapi.HugepageLimit{
+				{PageSize: "2Mi", Limit: 0},
+				{PageSize: "1Gi", Limit: 0},
+			},
+		},
+		"unsupported page size": {
+			limits:      []*runtimeapi.HugepageLimit{{PageSize: "64KB", Limit: 1 << 20}},
+			expectError: `huge page size "64KB" is not supported by the node`,
+		},
+		"invalid page size": {
+			limits:      []*runtimeapi.HugepageLimit{{PageSize: "huge", Limit: 1 << 20}},
+			expectError: `invalid huge page size "huge"`,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		limits, err := hugepageLimits(test.limits)
+		if test.expectError != "" {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectError)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, limits)
+	}
+}
+
+func TestCreateContainerHugepageLimits(t *testing.T) {
+	fakeHugepageSizes(t)
+	ds, fDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+
+	config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+	config.Linux = &runtimeapi.LinuxContainerConfig{
+		Resources: &runtimeapi.LinuxContainerResources{
+			HugepageLimits: []*runtimeapi.HugepageLimit{{PageSize: "2Mi", Limit: 64 << 20}},
+		},
+	}
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	c, err := fDocker.InspectContainer(createResp.ContainerId)
+	require.NoError(t, err)
+	assert.Equal(t, `{"2MB":67108864}`, c.Config.Labels[hugepageLimitsLabelKey])
+	// The limits of a container which is not running are not applied.
+	assert.NoError(t, ds.applyHugepageLimits(c))
+
+	config.Metadata.Name = "unsupported"
+	config.Linux.Resources.HugepageLimits = []*runtimeapi.HugepageLimit{
+		{PageSize: "16Gi", Limit: 16 << 30},
+	}
+	_, err = ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `huge page size "16Gi" is not supported by the node`)
+}
+
+func TestWriteHugepageLimits(t *testing.T) {
+	for desc, test := range map[string]struct {
+		cgroup       string
+		expectedFile string
+		noFile       bool
+	}{
+		"cgroup v1": {
+			cgroup:       "12:hugetlb:/kubepods/pod1/abc\n1:name=systemd:/kubepods/pod1/abc\n",
+			expectedFile: "hugetlb/kubepods/pod1/abc/hugetlb.2MB.limit_in_bytes",
+		},
+		"cgroup v2": {
+			cgroup:       "0::/kubepods/pod1/abc\n",
+			expectedFile: "kubepods/pod1/abc/hugetlb.2MB.max",
+		},
+		"controller not delegated": {
+			cgroup:       "0::/kubepods/pod1/abc\n",
+			expectedFile: "kubepods/pod1/abc/hugetlb.2MB.max",
+			noFile:       true,
+		},
+		"no hugetlb cgroup": {
+			cgroup: "1:name=systemd:/kubepods/pod1/abc\n",
+			noFile: true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		savedProc, savedCgroup := procRoot, cgroupRoot
+		procRoot, cgroupRoot = t.TempDir(), t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "42"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(procRoot, "42", "cgroup"), []byte(test.cgroup), 0o644))
+		file := filepath.Join(cgroupRoot, test.expectedFile)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+		if test.noFile {
+			err := writeHugepageLimits(42, map[string]uint64{"2MB": 64 << 20})
+			assert.ErrorIs(t, err, errHugetlbUnavailable)
+			procRoot, cgroupRoot = savedProc, savedCgroup
+			continue
+		}
+		require.NoError(t, os.WriteFile(file, []byte("max"), 0o644))
+
+		require.NoError(t, writeHugepageLimits(42, map[string]uint64{"2MB": 64 << 20}))
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, "67108864", string(content))
+		procRoot, cgroupRoot = savedProc, savedCgroup
+	}
+}
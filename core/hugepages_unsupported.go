@@ -0,0 +1,38 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	dockertypes "github.com/docker/docker/api/types"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// applyHugepageLimits is a no-op, hugepage limits are only supported on Linux.
+func (ds *dockerService) applyHugepageLimits(*dockertypes.ContainerJSON) error {
+	return nil
+}
+
+// updateHugepageLimits is a no-op, hugepage limits are only supported on Linux.
+func (ds *dockerService) updateHugepageLimits(
+	containerID string,
+	hugepages []*runtimeapi.HugepageLimit,
+) error {
+	return nil
+}
@@ -18,14 +18,19 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockerfilters "github.com/docker/docker/api/types/filters"
 	dockerregistry "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/jsonmessage"
 
 	"github.com/sirupsen/logrus"
@@ -96,18 +101,12 @@ func (ds *dockerService) ImageStatus(
 ) (*runtimeapi.ImageStatusResponse, error) {
 	image := r.GetImage()
 
-	imageInspect, err := ds.client.InspectImageByRef(image.Image)
+	imageInspect, err := ds.inspectImage(image.Image)
 	if err != nil {
-		if !libdocker.IsImageNotFoundError(err) {
-			return nil, err
-		}
-		imageInspect, err = ds.client.InspectImageByID(image.Image)
-		if err != nil {
-			if libdocker.IsImageNotFoundError(err) {
-				return &runtimeapi.ImageStatusResponse{}, nil
-			}
-			return nil, err
+		if libdocker.IsImageNotFoundError(err) {
+			return &runtimeapi.ImageStatusResponse{}, nil
 		}
+		return nil, err
 	}
 
 	pinned := isPinned(ds.sandboxImage(), imageInspect.RepoTags)
@@ -133,7 +132,7 @@ func (ds *dockerService) ImageStatus(
 
 // PullImage pulls an image with authentication config.
 func (ds *dockerService) PullImage(
-	_ context.Context,
+	ctx context.Context,
 	r *runtimeapi.PullImageRequest,
 ) (*runtimeapi.PullImageResponse, error) {
 	image := r.GetImage()
@@ -147,15 +146,18 @@ func (ds *dockerService) PullImage(
 		authConfig.IdentityToken = auth.IdentityToken
 		authConfig.RegistryToken = auth.RegistryToken
 	}
-	err := ds.client.PullImage(image.Image,
-		authConfig,
-		dockertypes.ImagePullOptions{},
-	)
+	platform, err := imagePlatform(r)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the image from being removed until its reference is resolved.
+	defer ds.imageLocks.lockPull(image.Image)()
+	err = ds.pullImageWithRetry(ctx, image.Image, authConfig, platform)
 	if err != nil {
 		return nil, filterHTTPError(err, image.Image)
 	}
 
-	imageRef, err := getImageRef(ds.client, image.Image)
+	imageRef, err := ds.getImageRef(image.Image)
 	if err != nil {
 		return nil, err
 	}
@@ -163,6 +165,155 @@ func (ds *dockerService) PullImage(
 	return &runtimeapi.PullImageResponse{ImageRef: imageRef}, nil
 }
 
+// inspectImage inspects the image known by the given reference, tag, digest
+// or ID. Docker resolves the tags in any form, but not the digest references
+// in every form, e.g. with a tag too, so these are matched against the repo
+// digests of the local images once normalized.
+func (ds *dockerService) inspectImage(image string) (*dockertypes.ImageInspect, error) {
+	imageInspect, err := ds.client.InspectImageByRef(image)
+	if err == nil || !libdocker.IsImageNotFoundError(err) {
+		return imageInspect, err
+	}
+	imageInspect, err = ds.client.InspectImageByID(image)
+	if err == nil || !libdocker.IsImageNotFoundError(err) {
+		return imageInspect, err
+	}
+	if !isDigestImageRef(image) {
+		return nil, err
+	}
+
+	ref := normalizeImageRef(image)
+	images, err := ds.client.ListImages(dockertypes.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range images {
+		for _, r := range i.RepoDigests {
+			if normalizeImageRef(r) == ref {
+				return ds.client.InspectImageByID(i.ID)
+			}
+		}
+	}
+	return nil, libdocker.ImageNotFoundError{ID: image}
+}
+
+// imagePlatform returns the platform of the image to pull, as requested by the
+// platform annotation of the image or else of the sandbox, or an empty string
+// for the platform of the node.
+func imagePlatform(r *runtimeapi.PullImageRequest) (string, error) {
+	platform, ok := r.GetImage().GetAnnotations()[imagePlatformAnnotationKey]
+	if !ok {
+		platform, ok = r.GetSandboxConfig().GetAnnotations()[imagePlatformAnnotationKey]
+	}
+	if !ok {
+		return "", nil
+	}
+	return parseImagePlatform(platform)
+}
+
+// platformComponentRE matches the components of a platform.
+var platformComponentRE = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// parseImagePlatform validates a platform in the os/arch[/variant] form, and
+// returns it with its architecture normalized.
+func parseImagePlatform(platform string) (string, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", fmt.Errorf("invalid image platform %q: must be os/arch[/variant]", platform)
+	}
+	for _, part := range parts {
+		if !platformComponentRE.MatchString(part) {
+			return "", fmt.Errorf("invalid image platform %q: must be os/arch[/variant]", platform)
+		}
+	}
+	parts[1] = normalizeArch(parts[1])
+	return strings.Join(parts, "/"), nil
+}
+
+// validateImagePullRetries checks the image pull retry settings: the backoff
+// must be positive when the pulls are retried.
+func validateImagePullRetries(retries int, backoff time.Duration) error {
+	if retries > 0 && backoff <= 0 {
+		return fmt.Errorf("invalid image pull retry backoff %v: must be positive", backoff)
+	}
+	return nil
+}
+
+// pullImageWithRetry pulls the image, retrying with an exponential backoff and
+// jitter as long as the pull fails with a transient error.
+func (ds *dockerService) pullImageWithRetry(
+	ctx context.Context,
+	image string,
+	authConfig dockerregistry.AuthConfig,
+	platform string,
+) error {
+	backoff := ds.runtimeSettings.ImagePullRetryBackoff
+	for retry := 0; ; retry++ {
+		err := ds.client.PullImage(image, authConfig, dockertypes.ImagePullOptions{Platform: platform})
+		if err == nil || retry >= ds.runtimeSettings.ImagePullRetries ||
+			!isTransientPullError(err) {
+			return err
+		}
+		// Add up to 50% of jitter so that concurrent pulls do not retry in sync.
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		logrus.Infof("Transient error pulling image %s, retrying in %v: %v", image, delay, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("retries aborted: %v, last error: %v", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientPullError returns whether an image pull failed with an error
+// which may go away on retry: timeouts, registry server errors and rate
+// limiting. Authentication failures and missing images are not transient.
+func isTransientPullError(err error) bool {
+	if errors.Is(err, context.Canceled) || libdocker.IsImageNotFoundError(err) ||
+		errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err) || errdefs.IsNotFound(err) {
+		return false
+	}
+	var jerr *jsonmessage.JSONError
+	if errors.As(err, &jerr) && jerr.Code != 0 {
+		return jerr.Code == http.StatusRequestTimeout ||
+			jerr.Code == http.StatusTooManyRequests ||
+			jerr.Code >= http.StatusInternalServerError
+	}
+	if errdefs.IsUnavailable(err) || errdefs.IsDeadline(err) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range []string{
+		"unauthorized",
+		"authentication required",
+		"denied",
+		"manifest unknown",
+		"not found",
+	} {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+	for _, transient := range []string{
+		"toomanyrequests",
+		"too many requests",
+		"timeout",
+		"timed out",
+		"connection reset",
+		"connection refused",
+		"service unavailable",
+		"bad gateway",
+		"internal server error",
+	} {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
 // RemoveImage removes the image.
 func (ds *dockerService) RemoveImage(
 	_ context.Context,
@@ -171,10 +322,7 @@ func (ds *dockerService) RemoveImage(
 	image := r.GetImage()
 	// If the image has multiple tags, we need to remove all the tags
 	// of kubelet, but we should still clarify this in CRI.
-	imageInspect, err := ds.client.InspectImageByID(image.Image)
-
-	// dockerclient.InspectImageByID doesn't work with digest and repoTags,
-	// it is safe to continue removing it since there is another check below.
+	imageInspect, err := ds.inspectImage(image.Image)
 	if err != nil && !libdocker.IsImageNotFoundError(err) {
 		return nil, err
 	}
@@ -192,6 +340,14 @@ func (ds *dockerService) RemoveImage(
 	images = append(images, imageInspect.RepoDigests...)
 	images = append(images, image.Image)
 
+	unlock, pulling := ds.imageLocks.lockRemoval(images)
+	if unlock == nil {
+		// The image garbage collection will get another chance to remove it.
+		logrus.Infof("Skipping the removal of image %s, %s is being pulled", image.Image, pulling)
+		return &runtimeapi.RemoveImageResponse{}, nil
+	}
+	defer unlock()
+
 	for _, image := range images {
 		if _, err := ds.client.RemoveImage(image, dockertypes.ImageRemoveOptions{PruneChildren: true}); err != nil &&
 			!libdocker.IsImageNotFoundError(err) {
@@ -203,8 +359,8 @@ func (ds *dockerService) RemoveImage(
 }
 
 // getImageRef returns the image digest if exists, or else returns the image ID.
-func getImageRef(client libdocker.DockerClientInterface, image string) (string, error) {
-	img, err := client.InspectImageByRef(image)
+func (ds *dockerService) getImageRef(image string) (string, error) {
+	img, err := ds.inspectImage(image)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,134 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sync"
+
+	dockerref "github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// imageLocks serializes the pulls and the removals of the same images, so that
+// the image garbage collection does not remove an image being pulled. The zero
+// value is ready to use.
+type imageLocks struct {
+	sync.Mutex
+	// pulls counts the in-flight pulls by image reference.
+	pulls map[string]int
+	// removals holds the in-flight removals by image reference, each channel
+	// being closed once its removal completes.
+	removals map[string]chan struct{}
+}
+
+// lockPull waits for any removal of the image to complete, then records its
+// pull until the returned function is called.
+func (l *imageLocks) lockPull(image string) func() {
+	key := normalizeImageRef(image)
+	l.Lock()
+	for {
+		done, ok := l.removals[key]
+		if !ok {
+			break
+		}
+		l.Unlock()
+		<-done
+		l.Lock()
+	}
+	if l.pulls == nil {
+		l.pulls = make(map[string]int)
+	}
+	l.pulls[key]++
+	l.Unlock()
+
+	return func() {
+		l.Lock()
+		defer l.Unlock()
+		l.pulls[key]--
+		if l.pulls[key] == 0 {
+			delete(l.pulls, key)
+		}
+	}
+}
+
+// lockRemoval records the removal of the image known by the given references
+// until the returned function is called. If one of the references is being
+// pulled, nothing is recorded and that reference is returned instead.
+func (l *imageLocks) lockRemoval(images []string) (func(), string) {
+	keys := make([]string, len(images))
+	for i, image := range images {
+		keys[i] = normalizeImageRef(image)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	for i, key := range keys {
+		if l.pulls[key] > 0 {
+			return nil, images[i]
+		}
+	}
+	if l.removals == nil {
+		l.removals = make(map[string]chan struct{})
+	}
+	done := make(chan struct{})
+	var locked []string
+	for _, key := range keys {
+		if _, ok := l.removals[key]; !ok {
+			l.removals[key] = done
+			locked = append(locked, key)
+		}
+	}
+
+	return func() {
+		l.Lock()
+		defer l.Unlock()
+		for _, key := range locked {
+			delete(l.removals, key)
+		}
+		close(done)
+	}, ""
+}
+
+// normalizeImageRef returns the fully qualified form of an image reference,
+// with the default tag if it has neither tag nor digest, and without its tag if
+// it has a digest, so that the different forms of a reference match. Image IDs
+// are returned as is.
+func normalizeImageRef(image string) string {
+	if _, err := digest.Parse(image); err == nil {
+		return image
+	}
+	named, err := dockerref.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	if digested, ok := named.(dockerref.Digested); ok {
+		if canonical, err := dockerref.WithDigest(dockerref.TrimNamed(named), digested.Digest()); err == nil {
+			return canonical.String()
+		}
+	}
+	return dockerref.TagNameOnly(named).String()
+}
+
+// isDigestImageRef returns whether the image reference has a digest.
+func isDigestImageRef(image string) bool {
+	named, err := dockerref.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	_, ok := named.(dockerref.Digested)
+	return ok
+}
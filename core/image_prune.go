@@ -0,0 +1,100 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	dockerimage "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"github.com/sirupsen/logrus"
+)
+
+// startDanglingImagePrune periodically prunes the dangling images, such as the
+// intermediate images left by builds on the node, which the image garbage
+// collection of the kubelet does not know about. It returns right away if the
+// prune interval is not positive.
+func (ds *dockerService) startDanglingImagePrune() {
+	interval := ds.runtimeSettings.PruneDanglingImagesInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := ds.pruneDanglingImages(); err != nil {
+			logrus.Errorf("Failed to prune dangling images: %v", err)
+		}
+	}
+}
+
+// pruneDanglingImages removes the dangling images, those with neither a tag
+// nor a digest, which no container uses. Docker refuses to remove the images
+// of containers, so these are skipped. The sandbox image is never removed,
+// whatever the references it was pulled or loaded with.
+func (ds *dockerService) pruneDanglingImages() error {
+	images, err := ds.client.ListImages(dockertypes.ImageListOptions{})
+	if err != nil {
+		return err
+	}
+	sandboxImageID := ""
+	if sandboxImage, err := ds.client.InspectImageByRef(ds.sandboxImage()); err == nil {
+		sandboxImageID = sandboxImage.ID
+	}
+
+	var dangling []dockerimage.Summary
+	for _, image := range images {
+		if image.ID != sandboxImageID && isDanglingImage(image) {
+			dangling = append(dangling, image)
+		}
+	}
+	pruned := 0
+	var reclaimed int64
+	for _, image := range dangling {
+		_, err := ds.client.RemoveImage(image.ID, dockertypes.ImageRemoveOptions{PruneChildren: true})
+		if err != nil {
+			if errdefs.IsConflict(err) || errdefs.IsNotFound(err) {
+				logrus.Debugf("Not pruning dangling image %s: %v", image.ID, err)
+				continue
+			}
+			return err
+		}
+		pruned++
+		reclaimed += image.Size
+	}
+	if pruned > 0 {
+		logrus.Infof("Pruned %d dangling images, reclaiming %d bytes", pruned, reclaimed)
+	}
+	return nil
+}
+
+// isDanglingImage returns whether the image has neither a tag nor a digest.
+// Older docker API versions report these as <none>:<none> and <none>@<none>.
+func isDanglingImage(image dockerimage.Summary) bool {
+	for _, tag := range image.RepoTags {
+		if tag != "<none>:<none>" {
+			return false
+		}
+	}
+	for _, digest := range image.RepoDigests {
+		if digest != "<none>@<none>" {
+			return false
+		}
+	}
+	return true
+}
@@ -17,11 +17,19 @@ limitations under the License.
 package core
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerimage "github.com/docker/docker/api/types/image"
+	dockerregistry "github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -168,3 +176,516 @@ func TestPullWithJSONError(t *testing.T) {
 		assert.Contains(t, err.Error(), test.expectedError)
 	}
 }
+
+func TestPullImageRetry(t *testing.T) {
+	image := &runtimeapi.ImageSpec{Image: "ubuntu"}
+	tests := map[string]struct {
+		err           error
+		expectedCalls []string
+		expectError   bool
+	}{
+		"rate limited then success": {
+			err:           &jsonmessage.JSONError{Code: 429, Message: "toomanyrequests: rate limit exceeded"},
+			expectedCalls: []string{"pull", "pull", "inspect_image"},
+		},
+		"unauthorized is not retried": {
+			err:           &jsonmessage.JSONError{Code: 401, Message: "unauthorized: authentication required"},
+			expectedCalls: []string{"pull"},
+			expectError:   true,
+		},
+		"manifest not found is not retried": {
+			err:           fmt.Errorf("manifest for ubuntu:nope not found: manifest unknown"),
+			expectedCalls: []string{"pull"},
+			expectError:   true,
+		},
+	}
+	for key, test := range tests {
+		ds, fakeDocker, _ := newTestDockerService()
+		ds.runtimeSettings.ImagePullRetries = 3
+		ds.runtimeSettings.ImagePullRetryBackoff = time.Millisecond
+		fakeDocker.InjectError("pull", test.err)
+		_, err := ds.PullImage(
+			getTestCTX(),
+			&runtimeapi.PullImageRequest{Image: image, Auth: &runtimeapi.AuthConfig{}},
+		)
+		if test.expectError {
+			assert.Error(t, err, fmt.Sprintf("TestCase [%s]", key))
+		} else {
+			assert.NoError(t, err, fmt.Sprintf("TestCase [%s]", key))
+		}
+		assert.NoError(t, fakeDocker.AssertCalls(test.expectedCalls), fmt.Sprintf("TestCase [%s]", key))
+	}
+}
+
+func TestValidateImagePullRetries(t *testing.T) {
+	assert.NoError(t, validateImagePullRetries(3, time.Second))
+	assert.NoError(t, validateImagePullRetries(0, 0))
+	assert.EqualError(
+		t,
+		validateImagePullRetries(3, 0),
+		"invalid image pull retry backoff 0s: must be positive",
+	)
+	assert.Error(t, validateImagePullRetries(3, -time.Second))
+}
+
+func TestPullImageRetryCanceled(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	ds.runtimeSettings.ImagePullRetries = 3
+	ds.runtimeSettings.ImagePullRetryBackoff = time.Hour
+	fakeDocker.InjectError("pull", &jsonmessage.JSONError{Code: 503, Message: "service unavailable"})
+
+	ctx, cancel := context.WithCancel(getTestCTX())
+	cancel()
+	_, err := ds.PullImage(
+		ctx,
+		&runtimeapi.PullImageRequest{Image: &runtimeapi.ImageSpec{Image: "ubuntu"}},
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+	assert.NoError(t, fakeDocker.AssertCalls([]string{"pull"}))
+}
+
+// TestImageStatusVerbose tests that the verbose status of an image holds its
+// runtime config, and that the non-verbose status holds no info.
+func TestImageStatusVerbose(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+		ID:           "sha256:1234",
+		RepoTags:     []string{"app:1.0"},
+		Created:      "2024-01-02T03:04:05Z",
+		Os:           "linux",
+		Architecture: "amd64",
+		Config: &dockercontainer.Config{
+			Entrypoint:   []string{"/bin/app"},
+			Cmd:          []string{"--serve"},
+			Env:          []string{"PATH=/bin", "MODE=prod"},
+			WorkingDir:   "/srv",
+			User:         "app",
+			ExposedPorts: nat.PortSet{"8080/tcp": {}},
+		},
+	}})
+
+	resp, err := ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: "sha256:1234"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Image)
+	assert.Empty(t, resp.Info)
+
+	resp, err = ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+		Image:   &runtimeapi.ImageSpec{Image: "sha256:1234"},
+		Verbose: true,
+	})
+	require.NoError(t, err)
+	var info verboseImageInfo
+	require.NoError(t, json.Unmarshal([]byte(resp.Info["info"]), &info))
+	config := info.ImageSpec.Config
+	assert.Equal(t, []string{"/bin/app"}, config.Entrypoint)
+	assert.Equal(t, []string{"--serve"}, config.Cmd)
+	assert.Equal(t, []string{"PATH=/bin", "MODE=prod"}, config.Env)
+	assert.Equal(t, "/srv", config.WorkingDir)
+	assert.Equal(t, "app", config.User)
+	assert.Equal(t, map[string]struct{}{"8080/tcp": {}}, config.ExposedPorts)
+}
+
+// TestImageSize tests that the images report their size including the layers
+// they share, and that the verbose status holds the sizes of their layers.
+func TestImageSize(t *testing.T) {
+	const (
+		baseLayer = "sha256:aaaa"
+		appLayer  = "sha256:bbbb"
+	)
+	ds, fDocker, _ := newTestDockerService()
+	fDocker.InjectImages([]dockerimage.Summary{
+		{ID: "sha256:base", RepoTags: []string{"base:1.0"}, Size: 100},
+		{ID: "sha256:app", RepoTags: []string{"app:1.0"}, Size: 150},
+	})
+	fDocker.Lock()
+	fDocker.ImageInspects["sha256:app"] = &dockertypes.ImageInspect{
+		ID:       "sha256:app",
+		RepoTags: []string{"app:1.0"},
+		Created:  "2024-01-02T03:04:05Z",
+		Size:     150,
+		Config:   &dockercontainer.Config{},
+		RootFS:   dockertypes.RootFS{Type: "layers", Layers: []string{baseLayer, appLayer}},
+	}
+	fDocker.Unlock()
+	// The history lists the most recent entry first.
+	fDocker.InjectImageHistory(map[string][]dockerimage.HistoryResponseItem{
+		"sha256:app": {
+			{CreatedBy: "COPY app /bin/app", Size: 50},
+			{CreatedBy: "ENV MODE=prod", Size: 0},
+			{CreatedBy: "ADD rootfs.tar /", Size: 100},
+		},
+	})
+
+	listResp, err := ds.ListImages(getTestCTX(), &runtimeapi.ListImagesRequest{})
+	require.NoError(t, err)
+	sizes := make(map[string]uint64)
+	for _, image := range listResp.Images {
+		sizes[image.Id] = image.Size_
+	}
+	// The base layer counts in the size of both images.
+	assert.Equal(t, map[string]uint64{"sha256:base": 100, "sha256:app": 150}, sizes)
+
+	resp, err := ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+		Image:   &runtimeapi.ImageSpec{Image: "sha256:app"},
+		Verbose: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(150), resp.Image.Size_)
+	var info verboseImageInfo
+	require.NoError(t, json.Unmarshal([]byte(resp.Info["info"]), &info))
+	assert.Equal(t, int64(150), info.Size)
+	assert.Equal(t, 2, info.LayerCount)
+	assert.Equal(t, []imageLayer{
+		{Digest: baseLayer, Size: 100},
+		{Digest: appLayer, Size: 50},
+	}, info.Layers)
+
+	// The digests are left out when the history doesn't match the layers.
+	fDocker.InjectImageHistory(map[string][]dockerimage.HistoryResponseItem{
+		"sha256:app": {{CreatedBy: "ADD rootfs.tar /", Size: 150}},
+	})
+	resp, err = ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+		Image:   &runtimeapi.ImageSpec{Image: "sha256:app"},
+		Verbose: true,
+	})
+	require.NoError(t, err)
+	info = verboseImageInfo{}
+	require.NoError(t, json.Unmarshal([]byte(resp.Info["info"]), &info))
+	assert.Equal(t, 2, info.LayerCount)
+	assert.Equal(t, []imageLayer{{Size: 150}}, info.Layers)
+}
+
+// TestImageStatusUser tests that the status of an image holds the UID or the
+// name of the user of its config, and neither when it runs as root by default.
+func TestImageStatusUser(t *testing.T) {
+	for desc, test := range map[string]struct {
+		user             string
+		expectedUID      *runtimeapi.Int64Value
+		expectedUsername string
+	}{
+		"numeric user": {
+			user:        "1000",
+			expectedUID: &runtimeapi.Int64Value{Value: 1000},
+		},
+		"numeric user and group": {
+			user:        "1000:2000",
+			expectedUID: &runtimeapi.Int64Value{Value: 1000},
+		},
+		"root user": {
+			user:        "0",
+			expectedUID: &runtimeapi.Int64Value{Value: 0},
+		},
+		"named user": {
+			user:             "app",
+			expectedUsername: "app",
+		},
+		"named user and group": {
+			user:             "app:staff",
+			expectedUsername: "app",
+		},
+		"empty user": {},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+			ID:     "sha256:1234",
+			Config: &dockercontainer.Config{User: test.user},
+		}})
+
+		resp, err := ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+			Image: &runtimeapi.ImageSpec{Image: "sha256:1234"},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp.Image)
+		assert.Equal(t, test.expectedUID, resp.Image.Uid)
+		assert.Equal(t, test.expectedUsername, resp.Image.Username)
+	}
+}
+
+// TestImageReferenceForms tests that the different forms of a reference find
+// the same image, and that only the digest references missed by docker are
+// looked up in the image list.
+func TestImageReferenceForms(t *testing.T) {
+	const (
+		imageID     = "sha256:5b0f3b9d3b1d5c0d0b63a3c2b3c8f1f0e2d7c6f5b4a39281706f5e4d3c2b1a09"
+		imageDigest = "sha256:9ae97d36d26566ff84e8893c64a6dc4fe8ca6d1144bf5b87b2b85a32def253c7"
+	)
+	for _, ref := range []string{
+		"busybox",
+		"busybox:latest",
+		"library/busybox",
+		"docker.io/library/busybox:latest",
+		"busybox@" + imageDigest,
+		"docker.io/library/busybox:latest@" + imageDigest,
+		imageID,
+	} {
+		t.Logf("TestCase: %s", ref)
+		ds, fDocker, _ := newTestDockerService()
+		fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+			ID:          imageID,
+			RepoTags:    []string{"busybox:latest"},
+			RepoDigests: []string{"busybox@" + imageDigest},
+			Config:      &dockercontainer.Config{},
+		}})
+
+		statusResp, err := ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+			Image: &runtimeapi.ImageSpec{Image: ref},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, statusResp.Image)
+		assert.Equal(t, imageID, statusResp.Image.Id)
+
+		imageRef, err := ds.getImageRef(ref)
+		require.NoError(t, err)
+		assert.Equal(t, "busybox@"+imageDigest, imageRef)
+	}
+
+	ds, fDocker, _ := newTestDockerService()
+	fDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+		ID:       imageID,
+		RepoTags: []string{"busybox:latest"},
+		Config:   &dockercontainer.Config{},
+	}})
+	fDocker.ClearCalls()
+	statusResp, err := ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: "busybox:1.36"},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, statusResp.Image)
+	assert.NoError(t, fDocker.AssertCalls([]string{"inspect_image", "inspect_image"}))
+
+	// The image is found, and removed, by a short name too.
+	fDocker.ClearCalls()
+	_, err = ds.RemoveImage(getTestCTX(), &runtimeapi.RemoveImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "busybox"},
+	})
+	require.NoError(t, err)
+	removeOpts := dockertypes.ImageRemoveOptions{PruneChildren: true}
+	assert.NoError(t, fDocker.AssertCallDetails(
+		libdocker.NewCalledDetail("inspect_image", nil),
+		libdocker.NewCalledDetail("remove_image", []interface{}{"busybox:latest", removeOpts}),
+		libdocker.NewCalledDetail("remove_image", []interface{}{"busybox", removeOpts}),
+	))
+}
+
+// TestPullImagePlatform tests that the platform annotation selects the platform
+// of the pulled image, and that malformed platforms are rejected.
+func TestPullImagePlatform(t *testing.T) {
+	for desc, test := range map[string]struct {
+		imageAnnotations   map[string]string
+		sandboxAnnotations map[string]string
+		expectedPlatform   string
+		expectedErr        string
+	}{
+		"no platform": {},
+		"image platform": {
+			imageAnnotations: map[string]string{imagePlatformAnnotationKey: "linux/arm64"},
+			expectedPlatform: "linux/arm64",
+		},
+		"sandbox platform": {
+			sandboxAnnotations: map[string]string{imagePlatformAnnotationKey: "linux/arm/v7"},
+			expectedPlatform:   "linux/arm/v7",
+		},
+		"image platform overrides the sandbox one": {
+			imageAnnotations:   map[string]string{imagePlatformAnnotationKey: "linux/amd64"},
+			sandboxAnnotations: map[string]string{imagePlatformAnnotationKey: "linux/arm64"},
+			expectedPlatform:   "linux/amd64",
+		},
+		"normalized architecture": {
+			imageAnnotations: map[string]string{imagePlatformAnnotationKey: "linux/x86_64"},
+			expectedPlatform: "linux/amd64",
+		},
+		"missing architecture": {
+			imageAnnotations: map[string]string{imagePlatformAnnotationKey: "linux"},
+			expectedErr:      `invalid image platform "linux": must be os/arch[/variant]`,
+		},
+		"empty architecture": {
+			imageAnnotations: map[string]string{imagePlatformAnnotationKey: "linux/"},
+			expectedErr:      `invalid image platform "linux/": must be os/arch[/variant]`,
+		},
+		"too many components": {
+			imageAnnotations: map[string]string{imagePlatformAnnotationKey: "linux/arm/v7/extra"},
+			expectedErr:      `invalid image platform "linux/arm/v7/extra": must be os/arch[/variant]`,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		client := &pullRecordingClient{DockerClientInterface: fDocker}
+		ds.client = client
+		_, err := ds.PullImage(getTestCTX(), &runtimeapi.PullImageRequest{
+			Image: &runtimeapi.ImageSpec{
+				Image:       "busybox",
+				Annotations: test.imageAnnotations,
+			},
+			SandboxConfig: &runtimeapi.PodSandboxConfig{Annotations: test.sandboxAnnotations},
+		})
+		if test.expectedErr != "" {
+			assert.EqualError(t, err, test.expectedErr)
+			assert.Empty(t, client.platforms)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, []string{test.expectedPlatform}, client.platforms)
+	}
+}
+
+// pullRecordingClient records the platforms of the image pulls.
+type pullRecordingClient struct {
+	libdocker.DockerClientInterface
+	platforms []string
+}
+
+func (c *pullRecordingClient) PullImage(
+	image string,
+	auth dockerregistry.AuthConfig,
+	opts dockertypes.ImagePullOptions,
+) error {
+	c.platforms = append(c.platforms, opts.Platform)
+	return c.DockerClientInterface.PullImage(image, auth, opts)
+}
+
+// TestPullAndRemoveImageSerialized tests that the pulls and the removals of the
+// same image do not interleave.
+func TestPullAndRemoveImageSerialized(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	fDocker.InjectImages([]dockerimage.Summary{{ID: "1111", RepoTags: []string{"busybox:latest"}}})
+	client := newBlockingImageClient(fDocker)
+	ds.client = client
+	pull := func() <-chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := ds.PullImage(getTestCTX(), &runtimeapi.PullImageRequest{
+				Image: &runtimeapi.ImageSpec{Image: "busybox"},
+			})
+			errCh <- err
+		}()
+		return errCh
+	}
+	remove := func() <-chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := ds.RemoveImage(getTestCTX(), &runtimeapi.RemoveImageRequest{
+				Image: &runtimeapi.ImageSpec{Image: "1111"},
+			})
+			errCh <- err
+		}()
+		return errCh
+	}
+
+	// The removal of an image being pulled is skipped.
+	pullErr := pull()
+	<-client.started
+	require.NoError(t, <-remove())
+	client.release <- struct{}{}
+	require.NoError(t, <-pullErr)
+	assert.Equal(t, []string{"pull"}, client.getEvents())
+
+	// The pull of an image being removed waits for the removal to complete.
+	client.events = nil
+	removeErr := remove()
+	<-client.started
+	pullErr = pull()
+	// Give the pull the opportunity to interleave.
+	time.Sleep(50 * time.Millisecond)
+	client.release <- struct{}{}
+	require.NoError(t, <-removeErr)
+	<-client.started
+	client.release <- struct{}{}
+	require.NoError(t, <-pullErr)
+	assert.Equal(t, []string{"remove", "pull"}, client.getEvents())
+}
+
+// blockingImageClient blocks the image pulls, and the removals of the image
+// 1111, until released, and records them once released.
+type blockingImageClient struct {
+	libdocker.DockerClientInterface
+	started chan struct{}
+	release chan struct{}
+
+	mu     sync.Mutex
+	events []string
+}
+
+func newBlockingImageClient(client libdocker.DockerClientInterface) *blockingImageClient {
+	return &blockingImageClient{
+		DockerClientInterface: client,
+		started:               make(chan struct{}),
+		release:               make(chan struct{}),
+	}
+}
+
+func (c *blockingImageClient) record(event string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, event)
+}
+
+func (c *blockingImageClient) getEvents() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.events...)
+}
+
+func (c *blockingImageClient) PullImage(
+	image string,
+	auth dockerregistry.AuthConfig,
+	opts dockertypes.ImagePullOptions,
+) error {
+	c.started <- struct{}{}
+	<-c.release
+	c.record("pull")
+	return c.DockerClientInterface.PullImage(image, auth, opts)
+}
+
+func (c *blockingImageClient) RemoveImage(
+	image string,
+	opts dockertypes.ImageRemoveOptions,
+) ([]dockerimage.DeleteResponse, error) {
+	if image == "1111" {
+		c.started <- struct{}{}
+		<-c.release
+		c.record("remove")
+	}
+	return c.DockerClientInterface.RemoveImage(image, opts)
+}
+
+// TestPruneDanglingImages tests that only the images with neither a tag nor
+// a digest which no container uses are pruned, and never the sandbox image.
+func TestPruneDanglingImages(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	ds.podSandboxImage = "sha256:sandbox"
+	fDocker.InjectImages([]dockerimage.Summary{
+		{
+			ID:          "sha256:dangling",
+			RepoTags:    []string{"<none>:<none>"},
+			RepoDigests: []string{"<none>@<none>"},
+			Size:        100,
+		},
+		{ID: "sha256:untagged", Size: 50},
+		{ID: "sha256:digest", RepoDigests: []string{"busybox@sha256:digest"}, Size: 20},
+		{ID: "sha256:used", RepoTags: []string{"<none>:<none>"}, Size: 10},
+		{ID: "sha256:tagged", RepoTags: []string{"busybox:latest"}, Size: 1},
+		{ID: "sha256:sandbox", Size: 1},
+	})
+	fDocker.SetFakeContainers([]*libdocker.FakeContainer{{
+		ID:     "container",
+		Config: &dockercontainer.Config{Image: "sha256:used"},
+	}})
+
+	require.NoError(t, ds.pruneDanglingImages())
+	var remaining []string
+	for _, image := range fDocker.Images {
+		remaining = append(remaining, image.ID)
+	}
+	assert.Equal(
+		t,
+		[]string{"sha256:digest", "sha256:used", "sha256:tagged", "sha256:sandbox"},
+		remaining,
+	)
+
+	fDocker.InjectError("list_images", fmt.Errorf("list failed"))
+	assert.Error(t, ds.pruneDanglingImages())
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/Mirantis/cri-dockerd/config"
 
 	"github.com/armon/circbuf"
+	dockertypes "github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
 
 	"github.com/sirupsen/logrus"
@@ -160,10 +161,22 @@ func (ds *dockerService) getContainerLogPath(containerID string) (string, string
 
 // createContainerLogSymlink creates the symlink for docker container log.
 func (ds *dockerService) createContainerLogSymlink(containerID string) error {
-	path, realPath, err := ds.getContainerLogPath(containerID)
+	info, err := ds.client.InspectContainer(containerID)
 	if err != nil {
-		return fmt.Errorf("failed to get container %q log path: %v", containerID, err)
+		return fmt.Errorf(
+			"failed to get container %q log path: failed to inspect container %q: %v",
+			containerID,
+			containerID,
+			err,
+		)
 	}
+	return ds.linkContainerLog(info)
+}
+
+// linkContainerLog creates the symlink for the log of an inspected container.
+func (ds *dockerService) linkContainerLog(info *dockertypes.ContainerJSON) error {
+	containerID := info.ID
+	path, realPath := info.Config.Labels[containerLogPathLabelKey], info.LogPath
 
 	if path == "" {
 		logrus.Debugf("Container log path for Container ID %s isn't specified, will not create symlink", containerID)
@@ -172,11 +185,18 @@ func (ds *dockerService) createContainerLogSymlink(containerID string) error {
 
 	if realPath != "" {
 		// Only create the symlink when container log path is specified and log file exists.
-		// Delete possibly existing file first
-		if err = ds.os.Remove(path); err == nil {
-			logrus.Debugf("Deleted previously existing symlink file: %s", path)
+		// Delete the symlink possibly left by a previous attempt first.
+		if err := ds.removeStaleLogSymlink(path); err != nil {
+			return fmt.Errorf("failed to create container %q log symlink: %v", containerID, err)
+		}
+		err := ds.os.Symlink(realPath, path)
+		if os.IsExist(err) {
+			// The symlink was created again in the meantime, replace it once more.
+			if err = ds.removeStaleLogSymlink(path); err == nil {
+				err = ds.os.Symlink(realPath, path)
+			}
 		}
-		if err = ds.os.Symlink(realPath, path); err != nil {
+		if err != nil {
 			return fmt.Errorf(
 				"failed to create symbolic link %q to the container log file %q for container %q: %v",
 				path,
@@ -202,6 +222,20 @@ func (ds *dockerService) createContainerLogSymlink(containerID string) error {
 	return nil
 }
 
+// removeStaleLogSymlink removes the file found at the path of a container log
+// symlink, if any.
+func (ds *dockerService) removeStaleLogSymlink(path string) error {
+	err := ds.os.Remove(path)
+	if err == nil {
+		logrus.Debugf("Deleted previously existing symlink file: %s", path)
+		return nil
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return fmt.Errorf("failed to remove stale log symlink %q: %v", path, err)
+}
+
 // removeContainerLogSymlink removes the symlink for docker container log.
 func (ds *dockerService) removeContainerLogSymlink(containerID string) error {
 	path, _, err := ds.getContainerLogPath(containerID)
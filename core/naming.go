@@ -17,6 +17,8 @@ limitations under the License.
 package core
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"strconv"
@@ -53,9 +55,21 @@ const (
 	DockerImageIDPrefix = "docker://"
 	// DockerPullableImageIDPrefix is the prefix of pullable image id in container status.
 	DockerPullableImageIDPrefix = "docker-pullable://"
+	// maxNameLength is the maximum length of the docker names of the
+	// sandboxes and containers, including the random suffix of the names
+	// which conflict.
+	maxNameLength = 255
+	// randomSuffixLength is the length of the suffix added by randomizeName.
+	randomSuffixLength = 9
+	// nameHashLength is the length of the hash suffix of the shortened names.
+	nameHashLength = 16
 )
 
 func makeSandboxName(s *runtimeapi.PodSandboxConfig) string {
+	return shortenName(makeSandboxFullName(s))
+}
+
+func makeSandboxFullName(s *runtimeapi.PodSandboxConfig) string {
 	return strings.Join([]string{
 		kubePrefix,                            // 0
 		sandboxContainerName,                  // 1
@@ -67,6 +81,10 @@ func makeSandboxName(s *runtimeapi.PodSandboxConfig) string {
 }
 
 func makeContainerName(s *runtimeapi.PodSandboxConfig, c *runtimeapi.ContainerConfig) string {
+	return shortenName(makeContainerFullName(s, c))
+}
+
+func makeContainerFullName(s *runtimeapi.PodSandboxConfig, c *runtimeapi.ContainerConfig) string {
 	return strings.Join([]string{
 		kubePrefix,                            // 0
 		c.Metadata.Name,                       // 1:
@@ -77,6 +95,41 @@ func makeContainerName(s *runtimeapi.PodSandboxConfig, c *runtimeapi.ContainerCo
 	}, nameDelimiter)
 }
 
+// shortenName returns the name unchanged if it fits in the docker name
+// length limit. Otherwise it keeps the start of the name, still readable, and
+// replaces the rest by a hash of the whole name, so that the shortened name is
+// both deterministic and unique. The metadata of the shortened names can't be
+// parsed from them: it is parsed from their full name label instead.
+func shortenName(name string) string {
+	if len(name)+randomSuffixLength <= maxNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:nameHashLength]
+	prefix := name[:maxNameLength-randomSuffixLength-len(nameDelimiter)-nameHashLength]
+	return strings.Join([]string{
+		strings.TrimRight(prefix, nameDelimiter),
+		hash,
+	}, nameDelimiter)
+}
+
+// setFullNameLabel records the full name in the labels when the docker name
+// was shortened.
+func setFullNameLabel(labels map[string]string, name, fullName string) {
+	if name != fullName {
+		labels[fullNameLabelKey] = fullName
+	}
+}
+
+// fullName returns the name to parse the metadata from: the full name label,
+// if the docker name was shortened, or else the docker name.
+func fullName(name string, labels map[string]string) string {
+	if fullName, ok := labels[fullNameLabelKey]; ok {
+		return fullName
+	}
+	return name
+}
+
 // randomizeName randomizes the container name. This should only be used when we hit the
 // docker container name conflict bug.
 func randomizeName(name string) string {
@@ -17,6 +17,7 @@ limitations under the License.
 package core
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -104,3 +105,61 @@ func TestParseRandomizedNames(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, config.Metadata, actualMetadata)
 }
+
+func TestShortenedNames(t *testing.T) {
+	long := strings.Repeat("a", 253)
+	sConfig := makeSandboxConfig(long, "bar", "iamuid", 3)
+	otherSConfig := makeSandboxConfig(long+"b", "bar", "iamuid", 3)
+	config := &runtimeapi.ContainerConfig{
+		Metadata: &runtimeapi.ContainerMetadata{
+			Name:    "pause",
+			Attempt: 5,
+		},
+	}
+
+	for desc, test := range map[string]struct {
+		name      string
+		otherName string
+		fullName  string
+	}{
+		"sandbox": {
+			name:      makeSandboxName(sConfig),
+			otherName: makeSandboxName(otherSConfig),
+			fullName:  makeSandboxFullName(sConfig),
+		},
+		"container": {
+			name:      makeContainerName(sConfig, config),
+			otherName: makeContainerName(otherSConfig, config),
+			fullName:  makeContainerFullName(sConfig, config),
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		assert.LessOrEqual(t, len(randomizeName(test.name)), maxNameLength)
+		assert.Regexp(t, "^k8s_[a-zA-Z0-9_.-]+$", test.name)
+		assert.Equal(t, shortenName(test.fullName), test.name, "shortened names must be deterministic")
+		assert.NotEqual(t, test.otherName, test.name, "shortened names must be unique")
+
+		labels := map[string]string{}
+		setFullNameLabel(labels, test.name, test.fullName)
+		assert.Equal(t, test.fullName, fullName("/"+test.name, labels))
+	}
+
+	labels := map[string]string{}
+	setFullNameLabel(labels, makeSandboxName(sConfig), makeSandboxFullName(sConfig))
+	sMetadata, err := parseSandboxName(fullName(randomizeName(makeSandboxName(sConfig)), labels))
+	assert.NoError(t, err)
+	assert.Equal(t, sConfig.Metadata, sMetadata)
+
+	labels = map[string]string{}
+	setFullNameLabel(labels, makeContainerName(sConfig, config), makeContainerFullName(sConfig, config))
+	metadata, err := parseContainerName(fullName(makeContainerName(sConfig, config), labels))
+	assert.NoError(t, err)
+	assert.Equal(t, config.Metadata, metadata)
+
+	// Names within the limit are not shortened, nor labeled.
+	labels = map[string]string{}
+	name := makeSandboxName(makeSandboxConfig("foo", "bar", "iamuid", 3))
+	setFullNameLabel(labels, name, name)
+	assert.Empty(t, labels)
+	assert.Equal(t, name, fullName(name, labels))
+}
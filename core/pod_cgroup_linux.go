@@ -0,0 +1,163 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// podCgroupStats are the CPU and memory usage of a pod cgroup.
+type podCgroupStats struct {
+	cpuUsageNanoSeconds uint64
+	memoryUsageBytes    uint64
+	memoryWorkingSet    uint64
+}
+
+// readPodCgroupStats reads the CPU and memory usage of the pod cgroup, given
+// the cgroup parent of its sandbox, under either cgroup v1 or v2. The returned
+// error satisfies os.IsNotExist if the cgroup is not found.
+func readPodCgroupStats(cgroupParent string) (*podCgroupStats, error) {
+	if cgroupParent == "" {
+		return nil, os.ErrNotExist
+	}
+	relPath := podCgroupPath(cgroupParent)
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return readPodCgroupV2Stats(filepath.Join(cgroupRoot, relPath))
+	}
+	return readPodCgroupV1Stats(
+		filepath.Join(cgroupRoot, "cpuacct", relPath),
+		filepath.Join(cgroupRoot, "memory", relPath),
+	)
+}
+
+// podCgroupPath returns the path of the cgroup parent relative to the cgroup
+// root. The systemd slice names are expanded to the path of the slice, e.g.
+// kubepods-burstable-pod1.slice is under kubepods.slice/kubepods-burstable.slice.
+func podCgroupPath(cgroupParent string) string {
+	if !strings.HasSuffix(cgroupParent, ".slice") || strings.Contains(cgroupParent, "/") {
+		return cgroupParent
+	}
+	name := strings.TrimSuffix(cgroupParent, ".slice")
+	var dirs []string
+	prefix := ""
+	for _, component := range strings.Split(name, "-") {
+		prefix += component
+		dirs = append(dirs, prefix+".slice")
+		prefix += "-"
+	}
+	return filepath.Join(dirs...)
+}
+
+// readPodCgroupV2Stats reads the usage of the cgroup v2 directory.
+func readPodCgroupV2Stats(dir string) (*podCgroupStats, error) {
+	cpuStat, err := readCgroupKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	usageUsec, ok := cpuStat["usage_usec"]
+	if !ok {
+		return nil, fmt.Errorf("no usage_usec in the cpu stats of cgroup %q", dir)
+	}
+	memoryUsage, err := readCgroupValue(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+	memoryStat, err := readCgroupKeyValues(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	return &podCgroupStats{
+		cpuUsageNanoSeconds: usageUsec * 1000,
+		memoryUsageBytes:    memoryUsage,
+		memoryWorkingSet:    workingSet(memoryUsage, memoryStat["inactive_file"]),
+	}, nil
+}
+
+// readPodCgroupV1Stats reads the usage of the cgroup v1 cpuacct and memory
+// directories.
+func readPodCgroupV1Stats(cpuDir, memoryDir string) (*podCgroupStats, error) {
+	cpuUsage, err := readCgroupValue(filepath.Join(cpuDir, "cpuacct.usage"))
+	if err != nil {
+		return nil, err
+	}
+	memoryUsage, err := readCgroupValue(filepath.Join(memoryDir, "memory.usage_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	memoryStat, err := readCgroupKeyValues(filepath.Join(memoryDir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	return &podCgroupStats{
+		cpuUsageNanoSeconds: cpuUsage,
+		memoryUsageBytes:    memoryUsage,
+		memoryWorkingSet:    workingSet(memoryUsage, memoryStat["total_inactive_file"]),
+	}, nil
+}
+
+// workingSet returns the memory usage without the inactive file cache, which
+// the kernel can reclaim, as the kubelet does.
+func workingSet(usage, inactiveFile uint64) uint64 {
+	if inactiveFile > usage {
+		return 0
+	}
+	return usage - inactiveFile
+}
+
+// readCgroupValue reads a cgroup file holding a single value.
+func readCgroupValue(path string) (uint64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse cgroup file %q: %v", path, err)
+	}
+	return value, nil
+}
+
+// readCgroupKeyValues reads a cgroup file holding a "key value" pair per line.
+func readCgroupKeyValues(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cgroup file %q: %v", path, err)
+		}
+		values[fields[0]] = value
+	}
+	return values, scanner.Err()
+}
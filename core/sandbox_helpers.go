@@ -25,6 +25,7 @@ import (
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/utils"
 	"github.com/Mirantis/cri-dockerd/utils/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/kubernetes/pkg/credentialprovider"
 
 	"github.com/Mirantis/cri-dockerd/config"
@@ -96,9 +97,36 @@ func (ds *dockerService) clearNetworkReady(podSandboxID string) {
 	delete(ds.networkReady, podSandboxID)
 }
 
+// setContainerStopped records the stop of a container of the sandbox.
+func (ds *dockerService) setContainerStopped(podSandboxID, containerID string) {
+	if podSandboxID == "" {
+		return
+	}
+	ds.stoppedContainersLock.Lock()
+	defer ds.stoppedContainersLock.Unlock()
+	if ds.stoppedContainers[podSandboxID] == nil {
+		ds.stoppedContainers[podSandboxID] = make(map[string]bool)
+	}
+	ds.stoppedContainers[podSandboxID][containerID] = true
+}
+
+// getStoppedContainers returns the number of containers of the sandbox which
+// were stopped.
+func (ds *dockerService) getStoppedContainers(podSandboxID string) int {
+	ds.stoppedContainersLock.Lock()
+	defer ds.stoppedContainersLock.Unlock()
+	return len(ds.stoppedContainers[podSandboxID])
+}
+
+func (ds *dockerService) clearStoppedContainers(podSandboxID string) {
+	ds.stoppedContainersLock.Lock()
+	defer ds.stoppedContainersLock.Unlock()
+	delete(ds.stoppedContainers, podSandboxID)
+}
+
 // getIPsFromPlugin interrogates the network plugin for sandbox IPs.
 func (ds *dockerService) getIPsFromPlugin(sandbox *dockertypes.ContainerJSON) ([]string, error) {
-	metadata, err := parseSandboxName(sandbox.Name)
+	metadata, err := parseSandboxName(fullName(sandbox.Name, sandbox.Config.Labels))
 	if err != nil {
 		return nil, err
 	}
@@ -178,7 +206,7 @@ func (ds *dockerService) getPodSandboxDetails(
 		return nil, nil, err
 	}
 
-	metadata, err := parseSandboxName(resp.Name)
+	metadata, err := parseSandboxName(fullName(resp.Name, resp.Config.Labels))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -202,8 +230,8 @@ func (ds *dockerService) applySandboxLinuxOptions(
 		return err
 	}
 
-	// Set sysctls.
-	hc.Sysctls = lc.Sysctls
+	// Set the network sysctls, the others are set on the containers.
+	hc.Sysctls, _ = splitSysctls(lc.Sysctls)
 	return nil
 }
 
@@ -224,6 +252,14 @@ func (ds *dockerService) applySandboxResources(
 			return err
 		}
 		hc.CgroupParent = cgroupParent
+
+		// The kubelet already sizes the pod cgroup with the pod overhead of the
+		// runtime class. Only the CPU weight of the overhead is given to the
+		// sandbox: capping the CPU or the memory of the pause container would
+		// not reserve anything for the runtime, and could only starve it.
+		if overhead := lc.GetOverhead(); overhead != nil {
+			hc.CPUShares += overhead.CpuShares
+		}
 	}
 	return nil
 }
@@ -239,9 +275,18 @@ func (ds *dockerService) makeSandboxDockerConfig(
 	labels[containerTypeLabelKey] = containerTypeLabelSandbox
 	// Apply a container name label for infra container. This is used in summary v1.
 	labels[config.KubernetesContainerNameLabel] = sandboxContainerName
+	// Generate the correlation id shared by the sandbox and its containers.
+	labels[correlationIDLabelKey] = string(uuid.NewUUID())
+	// Record the full name of the sandbox if its docker name is shortened.
+	setFullNameLabel(labels, makeSandboxName(c), makeSandboxFullName(c))
 
+	restartPolicy, err := parseRestartPolicy(ds.runtimeSettings.ContainerRestartPolicy)
+	if err != nil {
+		return nil, err
+	}
 	hc := &dockercontainer.HostConfig{
-		IpcMode: dockercontainer.IpcMode("shareable"),
+		IpcMode:       dockercontainer.IpcMode("shareable"),
+		RestartPolicy: restartPolicy,
 	}
 	createConfig := &dockerbackend.ContainerCreateConfig{
 		Name: makeSandboxName(c),
@@ -271,7 +316,10 @@ func (ds *dockerService) makeSandboxDockerConfig(
 	}
 
 	// Set security options.
-	securityOpts := ds.getSandBoxSecurityOpts(securityOptSeparator)
+	securityOpts, err := ds.getSandBoxSecurityOpts(securityOptSeparator)
+	if err != nil {
+		return nil, err
+	}
 	hc.SecurityOpt = append(hc.SecurityOpt, securityOpts...)
 
 	return createConfig, nil
@@ -339,6 +387,53 @@ func toCheckpointProtocol(protocol runtimeapi.Protocol) config.Protocol {
 	return protocolTCP
 }
 
+// fromCheckpointProtocol returns the protocol of a checkpointed port mapping,
+// defaulting to TCP for the mappings which don't have one.
+func fromCheckpointProtocol(protocol *config.Protocol) config.Protocol {
+	if protocol == nil {
+		return config.ProtocolTCP
+	}
+	switch config.Protocol(strings.ToLower(string(*protocol))) {
+	case protocolTCP, "":
+		return config.ProtocolTCP
+	case protocolUDP:
+		return config.ProtocolUDP
+	case protocolSCTP:
+		return config.ProtocolSCTP
+	}
+	logrus.Infof("Unknown protocol, defaulting to TCP: %v", *protocol)
+	return config.ProtocolTCP
+}
+
+// maxDNSNameservers is the number of nameservers the resolver uses, the same
+// limit as the one of the kubelet.
+const maxDNSNameservers = 3
+
+// limitDNSServers returns the nameservers of the pod the resolver uses. The
+// ones beyond the limit are dropped with a warning, or fail the sandbox
+// creation with strict DNS limits.
+func (ds *dockerService) limitDNSServers(podName string, servers []string) ([]string, error) {
+	if len(servers) <= maxDNSNameservers {
+		return servers, nil
+	}
+	dropped := servers[maxDNSNameservers:]
+	if ds.runtimeSettings.StrictDNSLimits {
+		return nil, fmt.Errorf(
+			"pod %q has %d nameservers, more than the limit of %d",
+			podName,
+			len(servers),
+			maxDNSNameservers,
+		)
+	}
+	logrus.Warnf(
+		"Pod %q has more than %d nameservers, dropping %s",
+		podName,
+		maxDNSNameservers,
+		strings.Join(dropped, ", "),
+	)
+	return servers[:maxDNSNameservers], nil
+}
+
 // rewriteResolvFile rewrites resolv.conf file generated by docker.
 func rewriteResolvFile(
 	resolvFilePath string,
@@ -422,8 +517,12 @@ func recoverFromCreationConflictIfNeeded(
 	return client.CreateContainer(createConfig)
 }
 
-// ensureSandboxImageExists pulls the sandbox image when it's not present.
-func ensureSandboxImageExists(client libdocker.DockerClientInterface, image string) error {
+// ensureSandboxImageExists pulls the sandbox image when it's not present. If
+// the pull fails, the image is loaded from the tarball, when one is set.
+func ensureSandboxImageExists(
+	client libdocker.DockerClientInterface,
+	image, tarball string,
+) error {
 	_, err := client.InspectImageByRef(image)
 	if err == nil {
 		return nil
@@ -432,6 +531,39 @@ func ensureSandboxImageExists(client libdocker.DockerClientInterface, image stri
 		return fmt.Errorf("failed to inspect sandbox image %q: %v", image, err)
 	}
 
+	pullErr := pullSandboxImage(client, image)
+	if pullErr == nil {
+		return nil
+	}
+	if tarball == "" {
+		return fmt.Errorf(
+			"sandbox image %q is not present on the node and could not be pulled: %v; "+
+				"preload it with docker load or set --pod-infra-container-image-tarball",
+			image,
+			pullErr,
+		)
+	}
+
+	logrus.Infof(
+		"Failed to pull the sandbox image %q, loading it from %s: %v",
+		image,
+		tarball,
+		pullErr,
+	)
+	if err := loadSandboxImage(client, image, tarball); err != nil {
+		return fmt.Errorf(
+			"sandbox image %q is not present on the node, could not be pulled (%v) nor loaded: %v",
+			image,
+			pullErr,
+			err,
+		)
+	}
+	return nil
+}
+
+// pullSandboxImage pulls the sandbox image, with the credentials of the
+// keyring if there are any.
+func pullSandboxImage(client libdocker.DockerClientInterface, image string) error {
 	repoToPull, _, _, err := utils.ParseImageName(image)
 	if err != nil {
 		return err
@@ -464,3 +596,67 @@ func ensureSandboxImageExists(client libdocker.DockerClientInterface, image stri
 
 	return errors.NewAggregate(pullErrs)
 }
+
+// loadSandboxImage loads the sandbox image from the docker image archive and
+// checks the archive did hold it.
+func loadSandboxImage(client libdocker.DockerClientInterface, image, tarball string) error {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := client.LoadImage(f); err != nil {
+		return fmt.Errorf("failed to load %s: %v", tarball, err)
+	}
+	if _, err := client.InspectImageByRef(image); err != nil {
+		return fmt.Errorf("%s does not hold the image: %v", tarball, err)
+	}
+	return nil
+}
+
+// parseSandboxLifecycleLogLevel parses the level at which the stop and the
+// removal of sandboxes are logged. Levels above error, which would make logrus
+// exit or panic, are rejected.
+func parseSandboxLifecycleLogLevel(level string) (logrus.Level, error) {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return l, fmt.Errorf("invalid sandbox lifecycle log level: %v", err)
+	}
+	if l < logrus.ErrorLevel {
+		return l, fmt.Errorf("invalid sandbox lifecycle log level %q: must be error or below", level)
+	}
+	return l, nil
+}
+
+// logSandboxLifecycle logs the stop or the removal of a sandbox, at the
+// configured level. Only the identity of the pod is logged, never its
+// annotations, which may hold secrets. The number of containers of the
+// sandbox the operation went through is only computed if the level is enabled.
+func (ds *dockerService) logSandboxLifecycle(
+	msg, podSandboxID string,
+	metadata *runtimeapi.PodSandboxMetadata,
+	containersKey string,
+	containers func() int,
+	start time.Time,
+	err error,
+) {
+	level, parseErr := parseSandboxLifecycleLogLevel(ds.runtimeSettings.SandboxLifecycleLogLevel)
+	if parseErr != nil {
+		level = logrus.InfoLevel
+	}
+	if !logrus.IsLevelEnabled(level) {
+		return
+	}
+	fields := logrus.Fields{
+		"podSandboxID": podSandboxID,
+		"podName":      metadata.GetName(),
+		"podNamespace": metadata.GetNamespace(),
+		"podUID":       metadata.GetUid(),
+		containersKey:  containers(),
+		"elapsed":      time.Since(start).String(),
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	logrus.WithFields(fields).Log(level, msg)
+}
@@ -17,13 +17,25 @@ limitations under the License.
 package core
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	dockertypes "github.com/docker/docker/api/types"
+	dockerbackend "github.com/docker/docker/api/types/backend"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -207,6 +219,78 @@ func TestSandboxHasLeastPrivilegesConfig(t *testing.T) {
 	)
 }
 
+// TestSandboxPodOverhead tests that the CPU weight of the pod overhead is given
+// to the sandbox, whose CPU and memory are not capped.
+func TestSandboxPodOverhead(t *testing.T) {
+	for desc, test := range map[string]struct {
+		overhead *runtimeapi.LinuxContainerResources
+		expected dockercontainer.Resources
+	}{
+		"no overhead": {
+			expected: dockercontainer.Resources{
+				CPUShares:  defaultSandboxCPUshares,
+				MemorySwap: DefaultMemorySwap(),
+			},
+		},
+		"overhead": {
+			overhead: &runtimeapi.LinuxContainerResources{
+				CpuShares:          256,
+				CpuQuota:           25000,
+				MemoryLimitInBytes: 128 << 20,
+			},
+			expected: dockercontainer.Resources{
+				CPUShares:  defaultSandboxCPUshares + 256,
+				MemorySwap: DefaultMemorySwap(),
+			},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, _, _ := newTestDockerService()
+		sandboxConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		sandboxConfig.Linux = &runtimeapi.LinuxPodSandboxConfig{Overhead: test.overhead}
+		createConfig, err := ds.makeSandboxDockerConfig(sandboxConfig, defaultSandboxImage)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, createConfig.HostConfig.Resources)
+	}
+}
+
+// TestRunPodSandboxPortMappingProtocol tests that port mappings without a
+// protocol default to TCP, and that SCTP mappings are kept.
+func TestRunPodSandboxPortMappingProtocol(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	sandboxConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sandboxConfig.PortMappings = []*runtimeapi.PortMapping{
+		{ContainerPort: 80, HostPort: 8080},
+		{ContainerPort: 90, HostPort: 9090, Protocol: runtimeapi.Protocol_SCTP},
+	}
+	resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sandboxConfig,
+	})
+	require.NoError(t, err)
+
+	sandbox, err := fDocker.InspectContainer(resp.PodSandboxId)
+	require.NoError(t, err)
+	assert.Contains(t, sandbox.HostConfig.PortBindings, nat.Port("80/tcp"))
+	assert.Contains(t, sandbox.HostConfig.PortBindings, nat.Port("90/sctp"))
+
+	portMappings, err := ds.GetPodPortMappings(resp.PodSandboxId)
+	require.NoError(t, err)
+	require.Len(t, portMappings, 2)
+	assert.Equal(t, config.ProtocolTCP, portMappings[0].Protocol)
+	assert.Equal(t, config.ProtocolSCTP, portMappings[1].Protocol)
+
+	// Checkpoints may have port mappings without a protocol.
+	hostPort, containerPort := int32(8080), int32(80)
+	checkpoint := NewPodSandboxCheckpoint("bar", "legacy", &CheckpointData{
+		PortMappings: []*config.PortMapping{{HostPort: &hostPort, ContainerPort: &containerPort}},
+	})
+	require.NoError(t, ds.checkpointManager.CreateCheckpoint("legacy", checkpoint))
+	portMappings, err = ds.GetPodPortMappings("legacy")
+	require.NoError(t, err)
+	require.Len(t, portMappings, 1)
+	assert.Equal(t, config.ProtocolTCP, portMappings[0].Protocol)
+}
+
 // TestSandboxStatusAfterRestart tests that retrieving sandbox status returns
 // an IP address even if RunPodSandbox() was not yet called for this pod, as
 // would happen on kubelet restart
@@ -292,6 +376,112 @@ func TestNetworkPluginInvocation(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// TestStopPodSandboxConcurrently checks that distinct sandboxes can be stopped
+// concurrently, and that the concurrent stops of the same sandbox tear its
+// network down once.
+func TestStopPodSandboxConcurrently(t *testing.T) {
+	ds, _, _ := newTestDockerService()
+	mockPlugin := newTestNetworkPlugin(t)
+	ds.network = network.NewPluginManager(mockPlugin)
+	defer mockPlugin.Finish()
+	mockPlugin.EXPECT().Name().Return("mockNetworkPlugin").AnyTimes()
+
+	const sandboxes, stopsPerSandbox = 20, 3
+	var ids []string
+	for i := 0; i < sandboxes; i++ {
+		name := fmt.Sprintf("foo%d", i)
+		ns := fmt.Sprintf("bar%d", i)
+		c := makeSandboxConfig(name, ns, fmt.Sprintf("%d", i), 0)
+		cID := config.ContainerID{
+			Type: runtimeName,
+			ID:   libdocker.GetFakeContainerID(fmt.Sprintf("/%v", makeSandboxName(c))),
+		}
+		setup := mockPlugin.EXPECT().SetUpPod(ns, name, cID)
+		// Slow teardowns widen the window of concurrent stops of the sandbox.
+		mockPlugin.EXPECT().TearDownPod(ns, name, cID).After(setup).Times(1).DoAndReturn(
+			func(string, string, config.ContainerID) error {
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			},
+		)
+		_, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: c})
+		require.NoError(t, err)
+		ids = append(ids, cID.ID)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, sandboxes*stopsPerSandbox)
+	for _, id := range ids {
+		for i := 0; i < stopsPerSandbox; i++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				_, err := ds.StopPodSandbox(
+					getTestCTX(),
+					&runtimeapi.StopPodSandboxRequest{PodSandboxId: id},
+				)
+				errs <- err
+			}(id)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	for _, id := range ids {
+		ready, ok := ds.getNetworkReady(id)
+		assert.True(t, ok)
+		assert.False(t, ready)
+	}
+	assert.Empty(t, ds.sandboxLocks.locks)
+}
+
+// TestPodSandboxStatusVerbose checks that the verbose status of a sandbox holds
+// its inspection, network status and cgroup parent, and that the non-verbose
+// status doesn't.
+func TestPodSandboxStatusVerbose(t *testing.T) {
+	ds, _, _ := newTestDockerService()
+	mockPlugin := newTestNetworkPlugin(t)
+	ds.network = network.NewPluginManager(mockPlugin)
+	defer mockPlugin.Finish()
+
+	c := makeSandboxConfig("foo", "bar", "1", 0)
+	c.Linux = &runtimeapi.LinuxPodSandboxConfig{CgroupParent: "/kubepods/pod1"}
+	mockPlugin.EXPECT().Name().Return("mockNetworkPlugin").AnyTimes()
+	mockPlugin.EXPECT().SetUpPod(gomock.Any(), gomock.Any(), gomock.Any())
+	mockPlugin.EXPECT().GetPodNetworkStatus("bar", "foo", gomock.Any()).Return(
+		&network.PodNetworkStatus{IP: net.ParseIP("10.0.0.2")}, nil,
+	).AnyTimes()
+	resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: c})
+	require.NoError(t, err)
+
+	statusResp, err := ds.PodSandboxStatus(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatusRequest{PodSandboxId: resp.PodSandboxId},
+	)
+	require.NoError(t, err)
+	assert.Empty(t, statusResp.Info)
+
+	statusResp, err = ds.PodSandboxStatus(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatusRequest{PodSandboxId: resp.PodSandboxId, Verbose: true},
+	)
+	require.NoError(t, err)
+	require.Len(t, statusResp.Info, 3)
+
+	var inspect dockertypes.ContainerJSON
+	require.NoError(t, json.Unmarshal([]byte(statusResp.Info["inspect"]), &inspect))
+	assert.Equal(t, resp.PodSandboxId, inspect.ID)
+	var networkStatus network.PodNetworkStatus
+	require.NoError(t, json.Unmarshal([]byte(statusResp.Info["networkStatus"]), &networkStatus))
+	assert.Equal(t, "10.0.0.2", networkStatus.IP.String())
+	var cgroupParent string
+	require.NoError(t, json.Unmarshal([]byte(statusResp.Info["cgroupParent"]), &cgroupParent))
+	assert.Equal(t, inspect.HostConfig.CgroupParent, cgroupParent)
+	assert.NotEmpty(t, cgroupParent)
+}
+
 // TestHostNetworkPluginInvocation checks that *no* SetUp/TearDown calls happen
 // for host network sandboxes.
 func TestHostNetworkPluginInvocation(t *testing.T) {
@@ -389,6 +579,46 @@ func TestSetUpPodFailure(t *testing.T) {
 	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_NOTREADY, sandbox.State)
 }
 
+// TestRunPodSandboxRetry tests that running the sandbox of the same pod
+// attempt again reuses the ready sandbox, and recreates the exited one.
+func TestRunPodSandboxRetry(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	labels := map[string]string{config.KubernetesPodUIDLabel: "1"}
+	c := makeSandboxConfigWithLabelsAndAnnotations("foo", "bar", "1", 0, labels, nil)
+	run := func() string {
+		resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: c})
+		require.NoError(t, err)
+		return resp.PodSandboxId
+	}
+	listSandboxes := func() []*runtimeapi.PodSandbox {
+		resp, err := ds.ListPodSandbox(getTestCTX(), &runtimeapi.ListPodSandboxRequest{})
+		require.NoError(t, err)
+		return resp.Items
+	}
+
+	id := run()
+	assert.Equal(t, id, run())
+	sandboxes := listSandboxes()
+	require.Len(t, sandboxes, 1)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, sandboxes[0].State)
+	assert.Equal(t, []string{id}, fDocker.Created)
+
+	// An exited sandbox is removed and created again.
+	_, err := ds.StopPodSandbox(getTestCTX(), &runtimeapi.StopPodSandboxRequest{PodSandboxId: id})
+	require.NoError(t, err)
+	id = run()
+	assert.Equal(t, []string{id}, fDocker.Removed)
+	sandboxes = listSandboxes()
+	require.Len(t, sandboxes, 1)
+	assert.Equal(t, id, sandboxes[0].Id)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, sandboxes[0].State)
+
+	// Another attempt of the pod gets its own sandbox.
+	c = makeSandboxConfigWithLabelsAndAnnotations("foo", "bar", "1", 1, labels, nil)
+	assert.NotEqual(t, id, run())
+	assert.Len(t, listSandboxes(), 2)
+}
+
 // TestRuntimeHandler checks that the sandbox with RuntimeHandler
 func TestRuntimeHandler(t *testing.T) {
 	ds, _, _ := newTestDockerService()
@@ -453,3 +683,274 @@ func TestRuntimeHandler(t *testing.T) {
 	}
 
 }
+
+// concurrencyTrackingClient records the peak number of concurrent container
+// creations.
+type concurrencyTrackingClient struct {
+	*libdocker.FakeDockerClient
+	current, peak int32
+}
+
+func (c *concurrencyTrackingClient) CreateContainer(
+	opts dockerbackend.ContainerCreateConfig,
+) (*dockercontainer.CreateResponse, error) {
+	current := atomic.AddInt32(&c.current, 1)
+	defer atomic.AddInt32(&c.current, -1)
+	for {
+		peak := atomic.LoadInt32(&c.peak)
+		if current <= peak || atomic.CompareAndSwapInt32(&c.peak, peak, current) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return c.FakeDockerClient.CreateContainer(opts)
+}
+
+func TestRunPodSandboxConcurrencyLimit(t *testing.T) {
+	const limit = 2
+	ds, fDocker, _ := newTestDockerService()
+	client := &concurrencyTrackingClient{FakeDockerClient: fDocker}
+	ds.client = client
+	ds.sandboxCreateSem = make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+				Config: makeSandboxConfig(fmt.Sprintf("foo%d", i), "bar", fmt.Sprintf("%d", i), 0),
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, atomic.LoadInt32(&client.peak), int32(limit))
+
+	// Requests over the limit give up at their deadline.
+	for i := 0; i < limit; i++ {
+		ds.sandboxCreateSem <- struct{}{}
+	}
+	ctx, cancel := context.WithTimeout(getTestCTX(), 10*time.Millisecond)
+	defer cancel()
+	_, err := ds.RunPodSandbox(ctx, &runtimeapi.RunPodSandboxRequest{
+		Config: makeSandboxConfig("late", "bar", "late", 0),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+}
+
+// entryRecorder records the log entries with the given message.
+type entryRecorder struct {
+	msg     string
+	entries []*logrus.Entry
+}
+
+func (r *entryRecorder) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (r *entryRecorder) Fire(entry *logrus.Entry) error {
+	if entry.Message == r.msg {
+		r.entries = append(r.entries, entry)
+	}
+	return nil
+}
+
+// TestSandboxLifecycleLogging tests that the stop and the removal of a sandbox
+// are logged with the identity of the pod, at the configured level, without
+// its annotations.
+func TestSandboxLifecycleLogging(t *testing.T) {
+	hooks := logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	defer logrus.StandardLogger().ReplaceHooks(hooks)
+
+	for desc, test := range map[string]struct {
+		level         string
+		expectedLevel logrus.Level
+	}{
+		"default level": {
+			expectedLevel: logrus.InfoLevel,
+		},
+		"configured level": {
+			level:         "warning",
+			expectedLevel: logrus.WarnLevel,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, _, _ := newTestDockerService()
+		ds.runtimeSettings.SandboxLifecycleLogLevel = test.level
+		sConfig := makeSandboxConfigWithLabelsAndAnnotations(
+			"foo", "bar", "1", 0,
+			nil,
+			map[string]string{"token": "s3cr3t"},
+		)
+		runResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: sConfig})
+		require.NoError(t, err)
+		id := runResp.PodSandboxId
+		var sidecarID string
+		for _, name := range []string{"app", "sidecar"} {
+			createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+				PodSandboxId:  id,
+				Config:        makeContainerConfig(sConfig, name, "iamimage", 0, nil, nil),
+				SandboxConfig: sConfig,
+			})
+			require.NoError(t, err)
+			_, err = ds.StartContainer(
+				getTestCTX(),
+				&runtimeapi.StartContainerRequest{ContainerId: createResp.ContainerId},
+			)
+			require.NoError(t, err)
+			sidecarID = createResp.ContainerId
+			if name == "app" {
+				_, err = ds.StopContainer(
+					getTestCTX(),
+					&runtimeapi.StopContainerRequest{ContainerId: createResp.ContainerId},
+				)
+				require.NoError(t, err)
+			}
+		}
+
+		stopped := &entryRecorder{msg: "Stopped sandbox"}
+		removed := &entryRecorder{msg: "Removed sandbox"}
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		logrus.AddHook(stopped)
+		logrus.AddHook(removed)
+		_, stopErr := ds.StopPodSandbox(getTestCTX(), &runtimeapi.StopPodSandboxRequest{PodSandboxId: id})
+		// Only the app container was stopped, the sidecar still runs.
+		require.Len(t, stopped.entries, 1)
+		assert.Equal(t, 1, stopped.entries[0].Data["stoppedContainers"])
+		_, err = ds.StopContainer(getTestCTX(), &runtimeapi.StopContainerRequest{ContainerId: sidecarID})
+		require.NoError(t, err)
+		_, removeErr := ds.RemovePodSandbox(getTestCTX(), &runtimeapi.RemovePodSandboxRequest{PodSandboxId: id})
+		require.NoError(t, stopErr)
+		require.NoError(t, removeErr)
+
+		require.Len(t, removed.entries, 1)
+		for _, entry := range []*logrus.Entry{stopped.entries[0], removed.entries[0]} {
+			assert.Equal(t, test.expectedLevel, entry.Level)
+			assert.Equal(t, id, entry.Data["podSandboxID"])
+			assert.Equal(t, "foo", entry.Data["podName"])
+			assert.Equal(t, "bar", entry.Data["podNamespace"])
+			assert.Equal(t, "1", entry.Data["podUID"])
+			assert.Contains(t, entry.Data, "elapsed")
+			assert.NotContains(t, entry.Data, "error")
+			for _, value := range entry.Data {
+				assert.NotContains(t, fmt.Sprint(value), "s3cr3t")
+			}
+		}
+		assert.Equal(t, 2, removed.entries[0].Data["removedContainers"])
+	}
+}
+
+func TestParseSandboxLifecycleLogLevel(t *testing.T) {
+	level, err := parseSandboxLifecycleLogLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, logrus.DebugLevel, level)
+	_, err = parseSandboxLifecycleLogLevel("loud")
+	assert.Error(t, err)
+	_, err = parseSandboxLifecycleLogLevel("panic")
+	assert.EqualError(t, err, `invalid sandbox lifecycle log level "panic": must be error or below`)
+}
+
+// TestRewriteResolvFile checks that the resolv.conf of a sandbox holds exactly
+// the DNS settings of its pod, and that the host resolv.conf copied by docker
+// is kept when there are none.
+func TestRewriteResolvFile(t *testing.T) {
+	hostResolvConf := "nameserver 192.168.0.1\nsearch host.local\noptions ndots:1\n"
+	for desc, test := range map[string]struct {
+		servers  []string
+		searches []string
+		options  []string
+		expected string
+	}{
+		"full dns config": {
+			servers:  []string{"1.1.1.1", "8.8.8.8"},
+			searches: []string{"ns.svc.cluster.local", "example.com"},
+			options:  []string{"ndots:5", "edns0"},
+			expected: "nameserver 1.1.1.1\nnameserver 8.8.8.8\n" +
+				"search ns.svc.cluster.local example.com\noptions ndots:5 edns0\n",
+		},
+		"servers only": {
+			servers:  []string{"1.1.1.1"},
+			expected: "nameserver 1.1.1.1\n",
+		},
+		"empty dns config": {
+			expected: hostResolvConf,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		path := filepath.Join(t.TempDir(), "resolv.conf")
+		require.NoError(t, os.WriteFile(path, []byte(hostResolvConf), 0644))
+
+		require.NoError(t, rewriteResolvFile(path, test.servers, test.searches, test.options))
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, string(content))
+	}
+}
+
+// TestRunPodSandboxDNSLimits tests that the nameservers of a pod beyond the
+// limit are dropped with a warning, or fail the sandbox creation with strict
+// DNS limits.
+func TestRunPodSandboxDNSLimits(t *testing.T) {
+	hooks := logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	defer logrus.StandardLogger().ReplaceHooks(hooks)
+
+	servers := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}
+	for desc, test := range map[string]struct {
+		servers         []string
+		strict          bool
+		expectErr       bool
+		expectedServers []string
+		expectWarning   bool
+	}{
+		"servers within the limit": {
+			servers:         servers[:3],
+			expectedServers: servers[:3],
+		},
+		"servers beyond the limit are dropped": {
+			servers:         servers,
+			expectedServers: servers[:3],
+			expectWarning:   true,
+		},
+		"servers within the strict limit": {
+			servers:         servers[:3],
+			strict:          true,
+			expectedServers: servers[:3],
+		},
+		"servers beyond the strict limit fail": {
+			servers:   servers,
+			strict:    true,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.StrictDNSLimits = test.strict
+		recorder := &entryRecorder{msg: `Pod "foo" has more than 3 nameservers, dropping 10.0.0.4, 10.0.0.5`}
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		logrus.AddHook(recorder)
+
+		dnsServers, err := ds.limitDNSServers("foo", test.servers)
+		config := makeSandboxConfig("foo", "bar", "1", 0)
+		config.DnsConfig = &runtimeapi.DNSConfig{Servers: test.servers}
+		_, runErr := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: config})
+		if test.expectErr {
+			assert.EqualError(t, err, `pod "foo" has 5 nameservers, more than the limit of 3`)
+			assert.Error(t, runErr)
+			// The sandbox is not created.
+			assert.Empty(t, fDocker.ContainerMap)
+			continue
+		}
+		require.NoError(t, err)
+		require.NoError(t, runErr)
+		assert.Equal(t, test.expectedServers, dnsServers)
+		if test.expectWarning {
+			// Once for each call.
+			require.Len(t, recorder.entries, 2)
+			assert.Equal(t, logrus.WarnLevel, recorder.entries[0].Level)
+		} else {
+			assert.Empty(t, recorder.entries)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sync"
+)
+
+// sandboxLocks serializes the operations on the same sandbox, while letting
+// the operations on distinct sandboxes run in parallel. The zero value is
+// ready to use.
+type sandboxLocks struct {
+	sync.Mutex
+	locks map[string]*sandboxLock
+}
+
+type sandboxLock struct {
+	sync.Mutex
+	// refcount counts the holders and waiters of the lock, which is removed
+	// from the map once it reaches zero.
+	refcount uint
+}
+
+// lock waits for the in-flight operations on the sandbox to complete, then
+// locks the sandbox until the returned function is called.
+func (l *sandboxLocks) lock(podSandboxID string) func() {
+	l.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sandboxLock)
+	}
+	sl, ok := l.locks[podSandboxID]
+	if !ok {
+		sl = &sandboxLock{}
+		l.locks[podSandboxID] = sl
+	}
+	sl.refcount++
+	l.Unlock()
+
+	sl.Lock()
+	return func() {
+		sl.Unlock()
+		l.Lock()
+		defer l.Unlock()
+		sl.refcount--
+		if sl.refcount == 0 {
+			delete(l.locks, podSandboxID)
+		}
+	}
+}
@@ -140,7 +140,7 @@ func (ds *dockerService) GetPodPortMappings(podSandboxID string) ([]*hostport.Po
 		portMappings = append(portMappings, &hostport.PortMapping{
 			HostPort:      *pm.HostPort,
 			ContainerPort: *pm.ContainerPort,
-			Protocol:      *pm.Protocol,
+			Protocol:      fromCheckpointProtocol(pm.Protocol),
 			HostIP:        pm.HostIP,
 		})
 	}
@@ -18,6 +18,7 @@ package core
 
 import (
 	"context"
+	"time"
 
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/utils/errors"
@@ -32,9 +33,13 @@ func (ds *dockerService) RemovePodSandbox(
 	ctx context.Context,
 	r *v1.RemovePodSandboxRequest,
 ) (*v1.RemovePodSandboxResponse, error) {
+	start := time.Now()
 	podSandboxID := r.PodSandboxId
 	var errs []error
 
+	// The identity of the pod is lost once its sandbox is removed.
+	_, metadata, _ := ds.getPodSandboxDetails(podSandboxID)
+
 	opts := dockercontainer.ListOptions{All: true}
 
 	opts.Filters = filters.NewArgs()
@@ -47,9 +52,12 @@ func (ds *dockerService) RemovePodSandbox(
 	}
 
 	// Remove all containers in the sandbox.
+	removed := 0
 	for i := range containers {
-		if _, err := ds.RemoveContainer(ctx, &v1.RemoveContainerRequest{ContainerId: containers[i].ID}); err != nil &&
-			!libdocker.IsContainerNotFoundError(err) {
+		err := ds.removeContainer(containers[i].ID)
+		if err == nil {
+			removed++
+		} else if !libdocker.IsContainerNotFoundError(err) {
 			errs = append(errs, err)
 		}
 	}
@@ -63,6 +71,7 @@ func (ds *dockerService) RemovePodSandbox(
 		// Only clear network ready when the sandbox has actually been
 		// removed from docker or doesn't exist
 		ds.clearNetworkReady(podSandboxID)
+		ds.clearStoppedContainers(podSandboxID)
 	} else {
 		errs = append(errs, err)
 	}
@@ -71,8 +80,18 @@ func (ds *dockerService) RemovePodSandbox(
 	if err := ds.checkpointManager.RemoveCheckpoint(podSandboxID); err != nil {
 		errs = append(errs, err)
 	}
-	if len(errs) == 0 {
+	err = errors.NewAggregate(errs)
+	ds.logSandboxLifecycle(
+		"Removed sandbox",
+		podSandboxID,
+		metadata,
+		"removedContainers",
+		func() int { return removed },
+		start,
+		err,
+	)
+	if err == nil {
 		return &v1.RemovePodSandboxResponse{}, nil
 	}
-	return nil, errors.NewAggregate(errs)
+	return nil, err
 }
@@ -23,6 +23,9 @@ import (
 
 	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/utils/errors"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
@@ -37,6 +40,36 @@ func (ds *dockerService) RunPodSandbox(
 ) (*v1.RunPodSandboxResponse, error) {
 	containerConfig := r.GetConfig()
 
+	// Limit the number of sandboxes created at once, as the network plugin may
+	// not cope well with many concurrent setups.
+	if ds.sandboxCreateSem != nil {
+		select {
+		case ds.sandboxCreateSem <- struct{}{}:
+			defer func() { <-ds.sandboxCreateSem }()
+		case <-ctx.Done():
+			return nil, fmt.Errorf(
+				"timed out waiting to create a sandbox for pod %q: %v",
+				containerConfig.GetMetadata().GetName(),
+				ctx.Err(),
+			)
+		}
+	}
+
+	// Kubelet retries RunPodSandbox when its response is lost, reuse the
+	// sandbox created by the previous call for the same attempt of the pod.
+	if id, ok := ds.reuseExistingSandbox(containerConfig.GetMetadata()); ok {
+		logrus.Infof("Reusing sandbox %s for pod %q", id, containerConfig.Metadata.Name)
+		return &v1.RunPodSandboxResponse{PodSandboxId: id}, nil
+	}
+
+	dnsServers, err := ds.limitDNSServers(
+		containerConfig.GetMetadata().GetName(),
+		containerConfig.GetDnsConfig().GetServers(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	// Step 1: Pull the image for the sandbox.
 	image := defaultSandboxImage
 	podSandboxImage := ds.podSandboxImage
@@ -47,7 +80,7 @@ func (ds *dockerService) RunPodSandbox(
 	// NOTE: To use a custom sandbox image in a private repository, users need to configure the nodes with credentials properly.
 	// see: http://kubernetes.io/docs/user-guide/images/#configuring-nodes-to-authenticate-to-a-private-repository
 	// Only pull sandbox image when it's not present - v1.PullIfNotPresent.
-	if err := ensureSandboxImageExists(ds.client, image); err != nil {
+	if err := ensureSandboxImageExists(ds.client, image, ds.runtimeSettings.SandboxImageTarball); err != nil {
 		return nil, err
 	}
 
@@ -82,6 +115,8 @@ func (ds *dockerService) RunPodSandbox(
 		)
 	}
 	resp := &v1.RunPodSandboxResponse{PodSandboxId: createResp.ID}
+	containerLogger(createConfig.Config.Labels[correlationIDLabelKey], createResp.ID).
+		Infof("Created sandbox for pod %q", containerConfig.Metadata.Name)
 
 	ds.setNetworkReady(createResp.ID, false)
 	defer func(e *error) {
@@ -125,7 +160,7 @@ func (ds *dockerService) RunPodSandbox(
 			)
 		}
 
-		if err := rewriteResolvFile(containerInfo.ResolvConfPath, dnsConfig.Servers, dnsConfig.Searches, dnsConfig.Options); err != nil {
+		if err := rewriteResolvFile(containerInfo.ResolvConfPath, dnsServers, dnsConfig.Searches, dnsConfig.Options); err != nil {
 			return nil, fmt.Errorf(
 				"rewrite resolv.conf failed for pod %q: %v",
 				containerConfig.Metadata.Name,
@@ -210,3 +245,49 @@ func (ds *dockerService) RunPodSandbox(
 
 	return resp, nil
 }
+
+// reuseExistingSandbox returns the ID of a ready sandbox of the same pod UID
+// and attempt as the given metadata, if any. Only the sandboxes labeled with
+// the pod UID by the kubelet are considered. The sandboxes of that attempt
+// which are not ready, having failed or exited, are removed so that the
+// sandbox can be recreated.
+func (ds *dockerService) reuseExistingSandbox(metadata *v1.PodSandboxMetadata) (string, bool) {
+	opts := dockercontainer.ListOptions{All: true, Filters: filters.NewArgs()}
+	f := NewDockerFilter(&opts.Filters)
+	f.AddLabel(containerTypeLabelKey, containerTypeLabelSandbox)
+	f.AddLabel(config.KubernetesPodUIDLabel, metadata.GetUid())
+	containers, err := ds.client.ListContainers(opts)
+	if err != nil {
+		logrus.Debugf("Unable to list the sandboxes of pod %q: %v", metadata.GetName(), err)
+		return "", false
+	}
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		m, err := parseSandboxName(fullName(c.Names[0], c.Labels))
+		if err != nil || m.Uid != metadata.GetUid() || m.Attempt != metadata.GetAttempt() {
+			continue
+		}
+		if toRuntimeAPISandboxState(c.Status) == v1.PodSandboxState_SANDBOX_READY {
+			if ready, ok := ds.getNetworkReady(c.ID); ready || !ok {
+				return c.ID, true
+			}
+		}
+		logrus.Infof("Removing sandbox %s of pod %q which is not ready", c.ID, metadata.GetName())
+		if _, err := ds.StopPodSandbox(
+			context.Background(),
+			&v1.StopPodSandboxRequest{PodSandboxId: c.ID},
+		); err != nil {
+			logrus.Errorf("Failed to stop sandbox %s: %v", c.ID, err)
+			continue
+		}
+		if _, err := ds.RemovePodSandbox(
+			context.Background(),
+			&v1.RemovePodSandboxRequest{PodSandboxId: c.ID},
+		); err != nil {
+			logrus.Errorf("Failed to remove sandbox %s: %v", c.ID, err)
+		}
+	}
+	return "", false
+}
@@ -18,9 +18,15 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/network"
 )
 
 // PodSandboxStatus returns the status of the PodSandbox.
@@ -44,8 +50,18 @@ func (ds *dockerService) PodSandboxStatus(
 
 	// Translate container to sandbox state.
 	state := v1.PodSandboxState_SANDBOX_NOTREADY
+	var notReadyReason string
 	if r.State.Running {
 		state = v1.PodSandboxState_SANDBOX_READY
+		// After an unclean shutdown, the network namespace of the sandbox may be
+		// gone, and reusing it would break the networking of the pod.
+		if networkNamespaceMode(r) != v1.NamespaceMode_NODE {
+			if err := checkSandboxNetworkNamespace(r); err != nil {
+				state = v1.PodSandboxState_SANDBOX_NOTREADY
+				notReadyReason = fmt.Sprintf("stale network namespace: %v", err)
+				logrus.Warningf("Sandbox %s is not ready: %s", podSandboxID, notReadyReason)
+			}
+		}
 	}
 
 	var ips []string
@@ -92,5 +108,50 @@ func (ds *dockerService) PodSandboxStatus(
 		})
 	}
 	status.Network.AdditionalIps = additionalPodIPs
-	return &v1.PodSandboxStatusResponse{Status: status}, nil
+	res := &v1.PodSandboxStatusResponse{Status: status}
+	if req.GetVerbose() {
+		info, err := ds.podSandboxVerboseInfo(r, metadata, notReadyReason)
+		if err != nil {
+			return nil, err
+		}
+		res.Info = info
+	}
+	return res, nil
+}
+
+// podSandboxVerboseInfo returns the information of a sandbox meant for
+// debugging, as JSON values: the inspection of its pause container, its network
+// status as resolved by the network plugin and its cgroup parent, as well as
+// the reason why a running sandbox is not ready, if any.
+func (ds *dockerService) podSandboxVerboseInfo(
+	sandbox *dockertypes.ContainerJSON,
+	metadata *v1.PodSandboxMetadata,
+	notReadyReason string,
+) (map[string]string, error) {
+	var networkStatus *network.PodNetworkStatus
+	if networkNamespaceMode(sandbox) != v1.NamespaceMode_NODE {
+		var err error
+		cID := config.BuildContainerID(runtimeName, sandbox.ID)
+		networkStatus, err = ds.network.GetPodNetworkStatus(metadata.Namespace, metadata.Name, cID)
+		if err != nil {
+			logrus.Debugf("Unable to get the network status of sandbox %s: %v", sandbox.ID, err)
+		}
+	}
+	values := map[string]interface{}{
+		"inspect":       sandbox,
+		"networkStatus": networkStatus,
+		"cgroupParent":  sandbox.HostConfig.CgroupParent,
+	}
+	if notReadyReason != "" {
+		values["notReadyReason"] = notReadyReason
+	}
+	info := make(map[string]string, len(values))
+	for key, value := range values {
+		m, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal the %s of sandbox %s: %v", key, sandbox.ID, err)
+		}
+		info[key] = string(m)
+	}
+	return info, nil
 }
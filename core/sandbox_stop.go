@@ -19,6 +19,8 @@ package core
 import (
 	"context"
 	"fmt"
+	"time"
+
 	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/store"
@@ -35,17 +37,24 @@ func (ds *dockerService) StopPodSandbox(
 	ctx context.Context,
 	r *v1.StopPodSandboxRequest,
 ) (*v1.StopPodSandboxResponse, error) {
-	var namespace, name string
+	start := time.Now()
+	var namespace, name, uid string
 	var hostNetwork bool
 
 	podSandboxID := r.PodSandboxId
 	resp := &v1.StopPodSandboxResponse{}
 
+	// Distinct sandboxes are stopped in parallel, but the concurrent stops of
+	// the same sandbox must not tear its network down more than once.
+	unlock := ds.sandboxLocks.lock(podSandboxID)
+	defer unlock()
+
 	// Try to retrieve minimal sandbox information from docker daemon or sandbox checkpoint.
 	inspectResult, metadata, statusErr := ds.getPodSandboxDetails(podSandboxID)
 	if statusErr == nil {
 		namespace = metadata.Namespace
 		name = metadata.Name
+		uid = metadata.Uid
 		hostNetwork = (networkNamespaceMode(inspectResult) == v1.NamespaceMode_NODE)
 	} else {
 		checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
@@ -106,9 +115,19 @@ func (ds *dockerService) StopPodSandbox(
 		}
 	}
 
-	if len(errList) == 0 {
+	err := errors.NewAggregate(errList)
+	ds.logSandboxLifecycle(
+		"Stopped sandbox",
+		podSandboxID,
+		&v1.PodSandboxMetadata{Name: name, Namespace: namespace, Uid: uid},
+		"stoppedContainers",
+		func() int { return ds.getStoppedContainers(podSandboxID) },
+		start,
+		err,
+	)
+	if err == nil {
 		return resp, nil
 	}
 
-	return nil, errors.NewAggregate(errList)
+	return nil, err
 }
@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
+)
+
+// maxSeccompDenials is the number of recent denials kept per container.
+const maxSeccompDenials = 10
+
+const (
+	// seccompAuditType is the numeric audit record type of seccomp events, as
+	// found in kernel messages.
+	seccompAuditType = "1326"
+	// seccompRetLog and seccompRetAllow are the actions of seccomp events for
+	// syscalls which were logged, but not denied.
+	seccompRetLog   = 0x7ffc0000
+	seccompRetAllow = 0x7fff0000
+)
+
+var (
+	auditTimestampRE = regexp.MustCompile(`audit\((\d+)\.(\d+):\d+\)`)
+	auditFieldRE     = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+)
+
+// seccompDenial is a syscall of a container denied by its seccomp profile.
+type seccompDenial struct {
+	Time    time.Time `json:"time"`
+	Pid     int       `json:"pid"`
+	Comm    string    `json:"comm,omitempty"`
+	Exe     string    `json:"exe,omitempty"`
+	Arch    string    `json:"arch,omitempty"`
+	Syscall int       `json:"syscall"`
+}
+
+// seccompDenialCache keeps the recent seccomp denials of each container.
+type seccompDenialCache struct {
+	sync.RWMutex
+	denials map[string][]seccompDenial
+	// containerIDForPid resolves the container a process belongs to.
+	containerIDForPid func(pid int) (string, bool)
+}
+
+func newSeccompDenialCache() *seccompDenialCache {
+	return &seccompDenialCache{
+		denials:           make(map[string][]seccompDenial),
+		containerIDForPid: containerIDForPid,
+	}
+}
+
+func (c *seccompDenialCache) add(containerID string, denial seccompDenial) {
+	c.Lock()
+	defer c.Unlock()
+	denials := append(c.denials[containerID], denial)
+	if len(denials) > maxSeccompDenials {
+		denials = denials[len(denials)-maxSeccompDenials:]
+	}
+	c.denials[containerID] = denials
+}
+
+func (c *seccompDenialCache) get(containerID string) []seccompDenial {
+	c.RLock()
+	defer c.RUnlock()
+	return append([]seccompDenial(nil), c.denials[containerID]...)
+}
+
+func (c *seccompDenialCache) remove(containerID string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.denials, containerID)
+}
+
+// prune forgets the denials of the containers which are not in the given set,
+// e.g. removed behind the back of cri-dockerd.
+func (c *seccompDenialCache) prune(live map[string]bool) {
+	c.Lock()
+	defer c.Unlock()
+	for containerID := range c.denials {
+		if !live[containerID] {
+			delete(c.denials, containerID)
+		}
+	}
+}
+
+// pruneSeccompDenials forgets the denials of the containers which no longer
+// exist.
+func (ds *dockerService) pruneSeccompDenials() {
+	containers, err := ds.client.ListContainers(dockercontainer.ListOptions{All: true})
+	if err != nil {
+		logrus.Debugf("Unable to list the containers to prune the seccomp denials: %v", err)
+		return
+	}
+	live := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		live[c.ID] = true
+	}
+	ds.seccompDenialCache.prune(live)
+}
+
+// handleAuditRecord records the denial reported by the given audit record, if
+// it is a seccomp event of a container process.
+func (c *seccompDenialCache) handleAuditRecord(record string) {
+	denial, ok := parseSeccompAuditRecord(record)
+	if !ok {
+		return
+	}
+	containerID, ok := c.containerIDForPid(denial.Pid)
+	if !ok {
+		return
+	}
+	logrus.Debugf(
+		"Seccomp denied syscall %d of process %d (%s) in container %s",
+		denial.Syscall,
+		denial.Pid,
+		denial.Comm,
+		containerID,
+	)
+	c.add(containerID, denial)
+}
+
+// parseSeccompAuditRecord parses a seccomp audit record, either read from the
+// audit log or from the kernel messages. It returns false if the record is not
+// the denial of a syscall.
+func parseSeccompAuditRecord(record string) (seccompDenial, bool) {
+	if !strings.Contains(record, "type=SECCOMP") &&
+		!strings.Contains(record, "type="+seccompAuditType) {
+		return seccompDenial{}, false
+	}
+	fields := make(map[string]string)
+	for _, match := range auditFieldRE.FindAllStringSubmatch(record, -1) {
+		fields[match[1]] = strings.Trim(match[2], `"`)
+	}
+	if code, err := strconv.ParseUint(fields["code"], 0, 32); err == nil {
+		if action := code & 0xffff0000; action == seccompRetLog || action == seccompRetAllow {
+			return seccompDenial{}, false
+		}
+	}
+	pid, err := strconv.Atoi(fields["pid"])
+	if err != nil {
+		return seccompDenial{}, false
+	}
+	syscall, err := strconv.Atoi(fields["syscall"])
+	if err != nil {
+		return seccompDenial{}, false
+	}
+	denial := seccompDenial{
+		Pid:     pid,
+		Comm:    fields["comm"],
+		Exe:     fields["exe"],
+		Arch:    fields["arch"],
+		Syscall: syscall,
+	}
+	if match := auditTimestampRE.FindStringSubmatch(record); match != nil {
+		sec, _ := strconv.ParseInt(match[1], 10, 64)
+		msec, _ := strconv.ParseInt(match[2], 10, 64)
+		denial.Time = time.Unix(sec, msec*int64(time.Millisecond)).UTC()
+	}
+	return denial, true
+}
@@ -0,0 +1,130 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// auditLogPath is where auditd writes the audit records, seccomp
+	// denials included.
+	auditLogPath = "/var/log/audit/audit.log"
+	// auditLogPollInterval is how often the audit log is checked for new records.
+	auditLogPollInterval = time.Second
+	// seccompDenialPruneInterval is how often the denials of the containers
+	// which no longer exist are forgotten.
+	seccompDenialPruneInterval = 10 * time.Minute
+)
+
+var cgroupContainerIDRE = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// containerIDForPid returns the id of the docker container the given process
+// runs in, based on its cgroup.
+func containerIDForPid(pid int) (string, bool) {
+	cgroups, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", false
+	}
+	ids := cgroupContainerIDRE.FindAllString(string(cgroups), -1)
+	if len(ids) == 0 {
+		return "", false
+	}
+	return ids[len(ids)-1], true
+}
+
+// startSeccompDenialCollection follows the audit log and records the seccomp
+// denials of containers, until the service is stopped. It returns right away
+// if auditd is not in use.
+func (ds *dockerService) startSeccompDenialCollection() {
+	f, err := os.Open(auditLogPath)
+	if err != nil {
+		logrus.Debugf("Seccomp denials are not collected, unable to open audit log: %v", err)
+		return
+	}
+	defer func() { f.Close() }()
+	// Only new records are of interest.
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		logrus.Errorf("Unable to seek to the end of the audit log: %v", err)
+		return
+	}
+	pruneTicker := time.NewTicker(seccompDenialPruneInterval)
+	defer pruneTicker.Stop()
+	reader := bufio.NewReader(f)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		if err == nil {
+			ds.seccompDenialCache.handleAuditRecord(partial + line)
+			partial = ""
+			continue
+		}
+		partial += line
+		if err != io.EOF {
+			logrus.Errorf("Error reading the audit log: %v", err)
+		}
+		select {
+		case <-ds.stopCh:
+			return
+		case <-pruneTicker.C:
+			ds.pruneSeccompDenials()
+		case <-time.After(auditLogPollInterval):
+		}
+		// Reopen the audit log once it is rotated.
+		if rotated, err := fileRotated(f); err == nil && rotated {
+			newFile, err := os.Open(auditLogPath)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f = newFile
+			reader.Reset(f)
+			partial = ""
+		}
+	}
+}
+
+// fileRotated returns whether the path of the audit log no longer refers to
+// the given file, or if the file was truncated.
+func fileRotated(f *os.File) (bool, error) {
+	current, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	latest, err := os.Stat(auditLogPath)
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(current, latest) {
+		return true, nil
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	return latest.Size() < offset, nil
+}
@@ -29,12 +29,17 @@ import (
 	"github.com/Mirantis/cri-dockerd/config"
 
 	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
 
+	v1 "k8s.io/api/core/v1"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	knetwork "github.com/Mirantis/cri-dockerd/network"
 )
 
+// allDevicesCgroupRule allows access to all host devices.
+const allDevicesCgroupRule = "a *:* rwm"
+
 // applySandboxSecurityContext updates docker sandbox options according to security context.
 func applySandboxSecurityContext(
 	lc *runtimeapi.LinuxPodSandboxConfig,
@@ -145,6 +150,13 @@ func modifyHostConfig(
 		hostConfig.CapAdd = sc.GetCapabilities().AddCapabilities
 		hostConfig.CapDrop = sc.GetCapabilities().DropCapabilities
 	}
+	if sc.Privileged {
+		// Docker grants all capabilities and host devices to privileged
+		// containers, spell it out in the config.
+		hostConfig.CapAdd = []string{"ALL"}
+		hostConfig.CapDrop = nil
+		hostConfig.DeviceCgroupRules = []string{allDevicesCgroupRule}
+	}
 	if sc.SelinuxOptions != nil {
 		hostConfig.SecurityOpt = addSELinuxOptions(
 			hostConfig.SecurityOpt,
@@ -160,8 +172,10 @@ func modifyHostConfig(
 	}
 	hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, apparmorSecurityOpts...)
 
-	if sc.NoNewPrivs {
-		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges")
+	// Privileged containers may escalate privileges anyway, the flag is
+	// ignored for them.
+	if sc.NoNewPrivs && !sc.Privileged {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges:true")
 	}
 
 	if !hostConfig.Privileged {
@@ -172,6 +186,96 @@ func modifyHostConfig(
 	return nil
 }
 
+// validateProcMountTypes verifies that only known proc mount types are allowed.
+func validateProcMountTypes(procMountTypes []string) error {
+	for _, t := range procMountTypes {
+		switch v1.ProcMountType(t) {
+		case v1.DefaultProcMount, v1.UnmaskedProcMount:
+		default:
+			return fmt.Errorf("unknown proc mount type %q", t)
+		}
+	}
+	return nil
+}
+
+// getProcMountType returns the proc mount type requested by the security context.
+// The CRI has no field for it: the kubelet always sends the masked and read-only
+// paths for the Default type, and leaves both empty for the Unmasked type.
+func getProcMountType(sc *runtimeapi.LinuxContainerSecurityContext) v1.ProcMountType {
+	if sc == nil || sc.Privileged || len(sc.MaskedPaths) != 0 || len(sc.ReadonlyPaths) != 0 {
+		return v1.DefaultProcMount
+	}
+	return v1.UnmaskedProcMount
+}
+
+// modifyProcMount removes docker's /proc masking when the container requests
+// an unmasked proc mount and the Unmasked type is allowed. Otherwise docker
+// keeps applying its default set of masked and read-only paths.
+func modifyProcMount(
+	sc *runtimeapi.LinuxContainerSecurityContext,
+	hostConfig *dockercontainer.HostConfig,
+	allowedProcMountTypes []string,
+) {
+	if getProcMountType(sc) != v1.UnmaskedProcMount {
+		return
+	}
+	for _, t := range allowedProcMountTypes {
+		if v1.ProcMountType(t) == v1.UnmaskedProcMount {
+			// Empty (rather than nil) lists override docker's default paths.
+			hostConfig.MaskedPaths = []string{}
+			hostConfig.ReadonlyPaths = []string{}
+			return
+		}
+	}
+	logrus.Debugf("Unmasked proc mount is not allowed, using the default proc mount")
+}
+
+// mergeCapabilities merges the default capabilities with the ones requested by
+// the container. Capabilities requested by the container take precedence over
+// the defaults: a dropped capability is never added by default and vice versa.
+// Both returned lists keep the order of their inputs, defaults first, and are
+// de-duplicated.
+func mergeCapabilities(
+	defaultAdd, defaultDrop []string,
+	caps *runtimeapi.Capability,
+) (capAdd, capDrop []string) {
+	requestedAdd := caps.GetAddCapabilities()
+	requestedDrop := caps.GetDropCapabilities()
+
+	capAdd = appendCapabilities(capAdd, defaultAdd, requestedDrop)
+	capAdd = appendCapabilities(capAdd, requestedAdd, nil)
+	capDrop = appendCapabilities(capDrop, defaultDrop, requestedAdd)
+	capDrop = appendCapabilities(capDrop, requestedDrop, nil)
+	return capAdd, capDrop
+}
+
+// appendCapabilities appends the capabilities not already present in dst nor
+// listed in exclude to dst. Capabilities are compared regardless of case and
+// of the "CAP_" prefix, and "ALL" in exclude excludes every capability.
+func appendCapabilities(dst, caps, exclude []string) []string {
+	for _, c := range caps {
+		if containsCapability(exclude, "ALL") ||
+			containsCapability(exclude, c) ||
+			containsCapability(dst, c) {
+			continue
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+func containsCapability(caps []string, c string) bool {
+	normalize := func(c string) string {
+		return strings.TrimPrefix(strings.ToUpper(c), "CAP_")
+	}
+	for _, v := range caps {
+		if normalize(v) == normalize(c) {
+			return true
+		}
+	}
+	return false
+}
+
 // modifySandboxNamespaceOptions apply namespace options for sandbox
 func modifySandboxNamespaceOptions(
 	nsOpts *runtimeapi.NamespaceOption,
@@ -233,7 +337,7 @@ func modifyHostOptionsForSandbox(
 	}
 }
 
-// modifyHostOptionsForContainer applies NetworkMode/UTSMode to container's dockercontainer.HostConfig.
+// modifyHostOptionsForContainer applies NetworkMode/IpcMode/UTSMode to container's dockercontainer.HostConfig.
 func modifyHostOptionsForContainer(
 	nsOpts *runtimeapi.NamespaceOption,
 	podSandboxID string,
@@ -241,9 +345,28 @@ func modifyHostOptionsForContainer(
 ) {
 	sandboxNSMode := fmt.Sprintf("container:%v", podSandboxID)
 	hc.NetworkMode = dockercontainer.NetworkMode(sandboxNSMode)
-	hc.IpcMode = dockercontainer.IpcMode(sandboxNSMode)
 	hc.UTSMode = ""
 
+	// Containers share the IPC namespace, and /dev/shm, of their pod unless
+	// they ask for the one of the node or for their own.
+	switch nsOpts.GetIpc() {
+	case runtimeapi.NamespaceMode_NODE:
+		hc.IpcMode = namespaceModeHost
+	case runtimeapi.NamespaceMode_CONTAINER:
+		hc.IpcMode = dockercontainer.IpcMode("private")
+	default:
+		hc.IpcMode = dockercontainer.IpcMode(sandboxNSMode)
+	}
+
+	// Debug containers may join the namespaces of another container.
+	targetNSMode := fmt.Sprintf("container:%v", nsOpts.GetTargetId())
+	if nsOpts.GetNetwork() == runtimeapi.NamespaceMode_TARGET {
+		hc.NetworkMode = dockercontainer.NetworkMode(targetNSMode)
+	}
+	if nsOpts.GetIpc() == runtimeapi.NamespaceMode_TARGET {
+		hc.IpcMode = dockercontainer.IpcMode(targetNSMode)
+	}
+
 	if nsOpts.GetNetwork() == runtimeapi.NamespaceMode_NODE {
 		hc.UTSMode = namespaceModeHost
 	}
@@ -299,6 +422,46 @@ func getSeccompSecurityOpts(seccompProfile *runtimeapi.SecurityProfile, separato
 	return FmtDockerOpts(seccompOpts, separator), nil
 }
 
+// parseSandboxSeccompProfile returns the seccomp profile of a sandbox setting:
+// runtime/default, the default, unconfined or localhost/<path>.
+func parseSandboxSeccompProfile(profile string) (*runtimeapi.SecurityProfile, error) {
+	switch {
+	case profile == "" || profile == config.SeccompProfileRuntimeDefault:
+		return &runtimeapi.SecurityProfile{ProfileType: runtimeapi.SecurityProfile_RuntimeDefault}, nil
+	case profile == config.SeccompProfileNameUnconfined:
+		return &runtimeapi.SecurityProfile{ProfileType: runtimeapi.SecurityProfile_Unconfined}, nil
+	case strings.HasPrefix(profile, config.SeccompLocalhostProfileNamePrefix):
+		return &runtimeapi.SecurityProfile{
+			ProfileType:  runtimeapi.SecurityProfile_Localhost,
+			LocalhostRef: strings.TrimPrefix(profile, config.SeccompLocalhostProfileNamePrefix),
+		}, nil
+	}
+	return nil, fmt.Errorf(
+		"invalid sandbox seccomp profile %q: must be runtime/default, unconfined or localhost/<path>",
+		profile,
+	)
+}
+
+// validateSandboxSecurityProfiles checks the seccomp and apparmor profiles of
+// the sandboxes.
+func validateSandboxSecurityProfiles(seccomp, apparmor string) error {
+	if _, err := parseSandboxSeccompProfile(seccomp); err != nil {
+		return err
+	}
+	switch {
+	case apparmor == "", apparmor == config.AppArmorBetaProfileRuntimeDefault,
+		apparmor == config.AppArmorBetaProfileNameUnconfined:
+	case strings.HasPrefix(apparmor, config.AppArmorBetaProfileNamePrefix) &&
+		apparmor != config.AppArmorBetaProfileNamePrefix:
+	default:
+		return fmt.Errorf(
+			"invalid sandbox apparmor profile %q: must be runtime/default, unconfined or localhost/<name>",
+			apparmor,
+		)
+	}
+	return nil
+}
+
 // getApparmorSecurityOpts gets apparmor options from container config.
 func getApparmorSecurityOpts(
 	sc *runtimeapi.LinuxContainerSecurityContext,
@@ -34,8 +34,23 @@ func (ds *dockerService) getSecurityOpts(seccomp *runtimeapi.SecurityProfile, se
 	return seccompSecurityOpts, nil
 }
 
-func (ds *dockerService) getSandBoxSecurityOpts(separator rune) []string {
-	// run sandbox with no-new-privileges and using runtime/default
-	// sending no "seccomp=" means docker will use default profile
-	return []string{"no-new-privileges"}
+func (ds *dockerService) getSandBoxSecurityOpts(separator rune) ([]string, error) {
+	// run sandbox with no-new-privileges and the configured seccomp and
+	// apparmor profiles, runtime/default unless set otherwise: sending no
+	// "seccomp=" or "apparmor=" means docker will use its default profiles.
+	opts := []string{"no-new-privileges"}
+	seccomp, err := parseSandboxSeccompProfile(ds.runtimeSettings.SandboxSeccompProfile)
+	if err != nil {
+		return nil, err
+	}
+	seccompOpts, err := getSeccompSecurityOpts(seccomp, separator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate seccomp security options for sandbox: %v", err)
+	}
+	opts = append(opts, seccompOpts...)
+	apparmorOpts, err := getAppArmorOpts(ds.runtimeSettings.SandboxApparmorProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate apparmor security options for sandbox: %v", err)
+	}
+	return append(opts, FmtDockerOpts(apparmorOpts, separator)...), nil
 }
@@ -141,6 +141,8 @@ func TestModifyHostConfig(t *testing.T) {
 	setPrivSC.ReadonlyPaths = []string{"/hello/world/readonly"}
 	setPrivHC := &dockercontainer.HostConfig{
 		Privileged: true,
+		CapAdd:     []string{"ALL"},
+		Resources:  dockercontainer.Resources{DeviceCgroupRules: []string{allDevicesCgroupRule}},
 	}
 
 	unsetPrivSC := &runtimeapi.LinuxContainerSecurityContext{}
@@ -166,6 +168,10 @@ func TestModifyHostConfig(t *testing.T) {
 		},
 	}
 
+	setNoNewPrivsHC := &dockercontainer.HostConfig{
+		SecurityOpt: []string{"no-new-privileges:true"},
+	}
+
 	cases := []struct {
 		name     string
 		sc       *runtimeapi.LinuxContainerSecurityContext
@@ -205,6 +211,21 @@ func TestModifyHostConfig(t *testing.T) {
 			},
 			expected: setSELinuxHC,
 		},
+		{
+			name: "container.SecurityContext.NoNewPrivs",
+			sc: &runtimeapi.LinuxContainerSecurityContext{
+				NoNewPrivs: true,
+			},
+			expected: setNoNewPrivsHC,
+		},
+		{
+			name: "container.SecurityContext.NoNewPrivs ignored when privileged",
+			sc: &runtimeapi.LinuxContainerSecurityContext{
+				Privileged: true,
+				NoNewPrivs: true,
+			},
+			expected: setPrivHC,
+		},
 	}
 
 	for _, tc := range cases {
@@ -244,6 +265,151 @@ func TestModifyHostConfigWithGroups(t *testing.T) {
 	}
 }
 
+func TestModifyProcMount(t *testing.T) {
+	maskedSC := &runtimeapi.LinuxContainerSecurityContext{
+		MaskedPaths:   []string{"/proc/kcore"},
+		ReadonlyPaths: []string{"/proc/sys"},
+	}
+	unmaskedHC := &dockercontainer.HostConfig{
+		MaskedPaths:   []string{},
+		ReadonlyPaths: []string{},
+	}
+
+	cases := []struct {
+		name     string
+		sc       *runtimeapi.LinuxContainerSecurityContext
+		allowed  []string
+		expected *dockercontainer.HostConfig
+	}{
+		{
+			name:     "unmasked requested and allowed",
+			sc:       &runtimeapi.LinuxContainerSecurityContext{},
+			allowed:  []string{"Default", "Unmasked"},
+			expected: unmaskedHC,
+		},
+		{
+			name:     "unmasked requested but not allowed",
+			sc:       &runtimeapi.LinuxContainerSecurityContext{},
+			allowed:  []string{"Default"},
+			expected: &dockercontainer.HostConfig{},
+		},
+		{
+			name:     "default requested",
+			sc:       maskedSC,
+			allowed:  []string{"Default", "Unmasked"},
+			expected: &dockercontainer.HostConfig{},
+		},
+		{
+			name:     "privileged",
+			sc:       &runtimeapi.LinuxContainerSecurityContext{Privileged: true},
+			allowed:  []string{"Unmasked"},
+			expected: &dockercontainer.HostConfig{},
+		},
+		{
+			name:     "nil security context",
+			sc:       nil,
+			allowed:  []string{"Unmasked"},
+			expected: &dockercontainer.HostConfig{},
+		},
+	}
+
+	for _, tc := range cases {
+		dockerCfg := &dockercontainer.HostConfig{}
+		modifyProcMount(tc.sc, dockerCfg, tc.allowed)
+		assert.Equal(t, tc.expected, dockerCfg, "[Test case %q]", tc.name)
+	}
+
+	assert.NoError(t, validateProcMountTypes([]string{"Default", "Unmasked"}))
+	assert.Error(t, validateProcMountTypes([]string{"Masked"}))
+}
+
+func TestMergeCapabilities(t *testing.T) {
+	cases := []struct {
+		name            string
+		defaultAdd      []string
+		defaultDrop     []string
+		caps            *runtimeapi.Capability
+		expectedCapAdd  []string
+		expectedCapDrop []string
+	}{
+		{
+			name: "no defaults and no capabilities",
+		},
+		{
+			name:            "defaults only",
+			defaultAdd:      []string{"NET_ADMIN"},
+			defaultDrop:     []string{"NET_RAW", "MKNOD"},
+			expectedCapAdd:  []string{"NET_ADMIN"},
+			expectedCapDrop: []string{"NET_RAW", "MKNOD"},
+		},
+		{
+			name: "capabilities only",
+			caps: &runtimeapi.Capability{
+				AddCapabilities:  []string{"SYS_TIME"},
+				DropCapabilities: []string{"CHOWN"},
+			},
+			expectedCapAdd:  []string{"SYS_TIME"},
+			expectedCapDrop: []string{"CHOWN"},
+		},
+		{
+			name:        "defaults merged with capabilities",
+			defaultAdd:  []string{"NET_ADMIN"},
+			defaultDrop: []string{"NET_RAW"},
+			caps: &runtimeapi.Capability{
+				AddCapabilities:  []string{"SYS_TIME"},
+				DropCapabilities: []string{"CHOWN"},
+			},
+			expectedCapAdd:  []string{"NET_ADMIN", "SYS_TIME"},
+			expectedCapDrop: []string{"NET_RAW", "CHOWN"},
+		},
+		{
+			name:        "duplicates are removed",
+			defaultAdd:  []string{"NET_ADMIN", "NET_ADMIN"},
+			defaultDrop: []string{"NET_RAW"},
+			caps: &runtimeapi.Capability{
+				AddCapabilities:  []string{"CAP_NET_ADMIN", "SYS_TIME"},
+				DropCapabilities: []string{"net_raw"},
+			},
+			expectedCapAdd:  []string{"NET_ADMIN", "SYS_TIME"},
+			expectedCapDrop: []string{"NET_RAW"},
+		},
+		{
+			name:       "dropped capability overrides default add",
+			defaultAdd: []string{"NET_ADMIN", "SYS_TIME"},
+			caps: &runtimeapi.Capability{
+				DropCapabilities: []string{"NET_ADMIN"},
+			},
+			expectedCapAdd:  []string{"SYS_TIME"},
+			expectedCapDrop: []string{"NET_ADMIN"},
+		},
+		{
+			name:        "added capability overrides default drop",
+			defaultDrop: []string{"NET_RAW", "MKNOD"},
+			caps: &runtimeapi.Capability{
+				AddCapabilities: []string{"NET_RAW"},
+			},
+			expectedCapAdd:  []string{"NET_RAW"},
+			expectedCapDrop: []string{"MKNOD"},
+		},
+		{
+			name:       "dropping all capabilities overrides default adds",
+			defaultAdd: []string{"NET_ADMIN"},
+			caps: &runtimeapi.Capability{
+				AddCapabilities:  []string{"CHOWN"},
+				DropCapabilities: []string{"ALL"},
+			},
+			expectedCapAdd:  []string{"CHOWN"},
+			expectedCapDrop: []string{"ALL"},
+		},
+	}
+
+	for _, tc := range cases {
+		capAdd, capDrop := mergeCapabilities(tc.defaultAdd, tc.defaultDrop, tc.caps)
+		assert.Equal(t, tc.expectedCapAdd, capAdd, "[Test case %q]", tc.name)
+		assert.Equal(t, tc.expectedCapDrop, capDrop, "[Test case %q]", tc.name)
+	}
+}
+
 func TestModifyHostConfigAndNamespaceOptionsForContainer(t *testing.T) {
 	sandboxID := "sandbox"
 	sandboxNSMode := fmt.Sprintf("container:%v", sandboxID)
@@ -251,6 +417,8 @@ func TestModifyHostConfigAndNamespaceOptionsForContainer(t *testing.T) {
 	setPrivSC.Privileged = true
 	setPrivHC := &dockercontainer.HostConfig{
 		Privileged:  true,
+		CapAdd:      []string{"ALL"},
+		Resources:   dockercontainer.Resources{DeviceCgroupRules: []string{allDevicesCgroupRule}},
 		IpcMode:     dockercontainer.IpcMode(sandboxNSMode),
 		NetworkMode: dockercontainer.NetworkMode(sandboxNSMode),
 		PidMode:     dockercontainer.PidMode(sandboxNSMode),
@@ -397,12 +565,34 @@ func TestModifyContainerNamespaceOptions(t *testing.T) {
 			nsOpt: &runtimeapi.NamespaceOption{
 				Ipc: runtimeapi.NamespaceMode_NODE,
 			},
+			expected: &dockercontainer.HostConfig{
+				NetworkMode: dockercontainer.NetworkMode(sandboxNSMode),
+				IpcMode:     namespaceModeHost,
+				PidMode:     dockercontainer.PidMode(sandboxNSMode),
+			},
+		},
+		{
+			name: "Pod IPC NamespaceOption",
+			nsOpt: &runtimeapi.NamespaceOption{
+				Ipc: runtimeapi.NamespaceMode_POD,
+			},
 			expected: &dockercontainer.HostConfig{
 				NetworkMode: dockercontainer.NetworkMode(sandboxNSMode),
 				IpcMode:     dockercontainer.IpcMode(sandboxNSMode),
 				PidMode:     dockercontainer.PidMode(sandboxNSMode),
 			},
 		},
+		{
+			name: "Container IPC NamespaceOption",
+			nsOpt: &runtimeapi.NamespaceOption{
+				Ipc: runtimeapi.NamespaceMode_CONTAINER,
+			},
+			expected: &dockercontainer.HostConfig{
+				NetworkMode: dockercontainer.NetworkMode(sandboxNSMode),
+				IpcMode:     dockercontainer.IpcMode("private"),
+				PidMode:     dockercontainer.PidMode(sandboxNSMode),
+			},
+		},
 		{
 			name: "Host PID NamespaceOption",
 			nsOpt: &runtimeapi.NamespaceOption{
@@ -437,6 +627,30 @@ func TestModifyContainerNamespaceOptions(t *testing.T) {
 				PidMode:     dockercontainer.PidMode("container:some-container"),
 			},
 		},
+		{
+			name: "Target Network NamespaceOption",
+			nsOpt: &runtimeapi.NamespaceOption{
+				Network:  runtimeapi.NamespaceMode_TARGET,
+				TargetId: "some-container",
+			},
+			expected: &dockercontainer.HostConfig{
+				NetworkMode: dockercontainer.NetworkMode("container:some-container"),
+				IpcMode:     dockercontainer.IpcMode(sandboxNSMode),
+				PidMode:     dockercontainer.PidMode(sandboxNSMode),
+			},
+		},
+		{
+			name: "Target IPC NamespaceOption",
+			nsOpt: &runtimeapi.NamespaceOption{
+				Ipc:      runtimeapi.NamespaceMode_TARGET,
+				TargetId: "some-container",
+			},
+			expected: &dockercontainer.HostConfig{
+				NetworkMode: dockercontainer.NetworkMode(sandboxNSMode),
+				IpcMode:     dockercontainer.IpcMode("container:some-container"),
+				PidMode:     dockercontainer.PidMode(sandboxNSMode),
+			},
+		},
 	}
 	for _, tc := range cases {
 		dockerCfg := &dockercontainer.HostConfig{}
@@ -477,8 +691,8 @@ func inputSELinuxOptions() *runtimeapi.SELinuxOption {
 func fullValidHostConfig() *dockercontainer.HostConfig {
 	return &dockercontainer.HostConfig{
 		Privileged: true,
-		CapAdd:     []string{"addCapA", "addCapB"},
-		CapDrop:    []string{"dropCapA", "dropCapB"},
+		CapAdd:     []string{"ALL"},
+		Resources:  dockercontainer.Resources{DeviceCgroupRules: []string{allDevicesCgroupRule}},
 		SecurityOpt: []string{
 			fmt.Sprintf("%s:%s", selinuxLabelUser('='), "user"),
 			fmt.Sprintf("%s:%s", selinuxLabelRole('='), "role"),
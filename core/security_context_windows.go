@@ -35,10 +35,10 @@ func (ds *dockerService) getSecurityOpts(
 	return nil, nil
 }
 
-func (ds *dockerService) getSandBoxSecurityOpts(separator rune) []string {
+func (ds *dockerService) getSandBoxSecurityOpts(separator rune) ([]string, error) {
 	// Currently, Windows container does not support privileged mode, so no no-new-privileges flag can be returned directly like Linux
 	// If the future Windows container has new support for privileged mode, we can adjust it here
-	return nil
+	return nil, nil
 }
 
 // applyWindowsContainerSecurityContext updates docker container options according to security context.
@@ -44,6 +44,8 @@ type containerStatsCache struct {
 	sync.RWMutex
 	stats map[string]*cstats
 	clist chan []*runtimeapi.Container
+	// peakMemory is the high-water mark of the memory usage of containers.
+	peakMemory map[string]uint64
 }
 
 func newCstats(cid string, ds *dockerService) *cstats {
@@ -56,8 +58,9 @@ func newCstats(cid string, ds *dockerService) *cstats {
 
 func newContainerStatsCache() *containerStatsCache {
 	return &containerStatsCache{
-		stats: make(map[string]*cstats),
-		clist: make(chan []*runtimeapi.Container, 1),
+		stats:      make(map[string]*cstats),
+		clist:      make(chan []*runtimeapi.Container, 1),
+		peakMemory: make(map[string]uint64),
 	}
 }
 
@@ -124,6 +127,29 @@ func (c *containerStatsCache) getStats(containerID string) *cstats {
 	return c.stats[containerID]
 }
 
+// observeMemory records the memory usage of a container and returns its peak.
+// Docker reports the peak usage with cgroup v1 only, with cgroup v2 it is the
+// highest usage observed.
+func (c *containerStatsCache) observeMemory(containerID string, usage, maxUsage uint64) uint64 {
+	c.Lock()
+	defer c.Unlock()
+	peak := c.peakMemory[containerID]
+	if maxUsage > peak {
+		peak = maxUsage
+	}
+	if usage > peak {
+		peak = usage
+	}
+	c.peakMemory[containerID] = peak
+	return peak
+}
+
+func (c *containerStatsCache) removePeakMemory(containerID string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.peakMemory, containerID)
+}
+
 func (ds *dockerService) startStatsCollection() {
 	c := ds.containerStatsCache
 	for clist := range c.clist {
@@ -253,3 +279,91 @@ func (ds *dockerService) ListContainerStats(
 
 	return &runtimeapi.ListContainerStatsResponse{Stats: results}, nil
 }
+
+// PodSandboxStats returns the stats of the sandbox and of its containers.
+func (ds *dockerService) PodSandboxStats(
+	ctx context.Context,
+	r *runtimeapi.PodSandboxStatsRequest,
+) (*runtimeapi.PodSandboxStatsResponse, error) {
+	listResp, err := ds.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{
+		Filter: &runtimeapi.PodSandboxFilter{Id: r.PodSandboxId},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(listResp.Items) != 1 {
+		return nil, fmt.Errorf("sandbox with id %s not found", r.PodSandboxId)
+	}
+	stats, err := ds.getPodSandboxStats(ctx, listResp.Items[0])
+	if err != nil {
+		return nil, err
+	}
+	return &runtimeapi.PodSandboxStatsResponse{Stats: stats}, nil
+}
+
+// ListPodSandboxStats returns the stats of the ready sandboxes matching the
+// filter, and of their containers.
+func (ds *dockerService) ListPodSandboxStats(
+	ctx context.Context,
+	r *runtimeapi.ListPodSandboxStatsRequest,
+) (*runtimeapi.ListPodSandboxStatsResponse, error) {
+	filter := &runtimeapi.PodSandboxFilter{
+		State: &runtimeapi.PodSandboxStateValue{State: runtimeapi.PodSandboxState_SANDBOX_READY},
+	}
+	if statsFilter := r.GetFilter(); statsFilter != nil {
+		filter.Id = statsFilter.Id
+		filter.LabelSelector = statsFilter.LabelSelector
+	}
+	listResp, err := ds.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*runtimeapi.PodSandboxStats, 0, len(listResp.Items))
+	for _, sandbox := range listResp.Items {
+		stats, err := ds.getPodSandboxStats(ctx, sandbox)
+		if err != nil {
+			logrus.Errorf("Error collecting stats for sandbox %s: %v", sandbox.Id, err)
+			continue
+		}
+		results = append(results, stats)
+	}
+	return &runtimeapi.ListPodSandboxStatsResponse{Stats: results}, nil
+}
+
+// getPodSandboxStats returns the stats of the sandbox and of its containers.
+func (ds *dockerService) getPodSandboxStats(
+	ctx context.Context,
+	sandbox *runtimeapi.PodSandbox,
+) (*runtimeapi.PodSandboxStats, error) {
+	// The containers are not passed on to the stats cache, which expects all
+	// of them to tell the removed ones.
+	containersResp, err := ds.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{PodSandboxId: sandbox.Id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	containerStats := make([]*runtimeapi.ContainerStats, 0, len(containersResp.Containers))
+	for _, c := range containersResp.Containers {
+		cs, err := ds.getContainerStats(c)
+		if err != nil {
+			logrus.Errorf("error collecting stats for container '%s': %v", c.Metadata.Name, err)
+			continue
+		}
+		if cs != nil {
+			containerStats = append(containerStats, cs)
+		}
+	}
+	stats := &runtimeapi.PodSandboxStats{
+		Attributes: &runtimeapi.PodSandboxAttributes{
+			Id:          sandbox.Id,
+			Metadata:    sandbox.Metadata,
+			Labels:      sandbox.Labels,
+			Annotations: sandbox.Annotations,
+		},
+	}
+	if err := ds.addPodSandboxPlatformStats(stats, containerStats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
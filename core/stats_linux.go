@@ -20,9 +20,16 @@ limitations under the License.
 package core
 
 import (
+	"os"
+	"sort"
+	"strconv"
 	"time"
 
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/network"
 )
 
 func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*runtimeapi.ContainerStats, error) {
@@ -34,12 +41,24 @@ func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*ru
 
 	dockerStats := statsJSON.Stats
 	timestamp := time.Now().UnixNano()
+	peakMemory := ds.containerStatsCache.observeMemory(
+		containerID,
+		dockerStats.MemoryStats.Usage,
+		dockerStats.MemoryStats.MaxUsage,
+	)
+	// The CRI has no field for the peak memory usage, it is reported as an
+	// annotation of the stats.
+	annotations := make(map[string]string, len(container.Annotations)+1)
+	for k, v := range container.Annotations {
+		annotations[k] = v
+	}
+	annotations[peakMemoryAnnotationKey] = strconv.FormatUint(peakMemory, 10)
 	containerStats := &runtimeapi.ContainerStats{
 		Attributes: &runtimeapi.ContainerAttributes{
 			Id:          containerID,
 			Metadata:    container.Metadata,
 			Labels:      container.Labels,
-			Annotations: container.Annotations,
+			Annotations: annotations,
 		},
 		Cpu: &runtimeapi.CpuUsage{
 			Timestamp: timestamp,
@@ -65,3 +84,102 @@ func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*ru
 	}
 	return containerStats, nil
 }
+
+// addPodSandboxPlatformStats adds the network usage of the sandbox, whose
+// network namespace its containers share, the CPU and memory usage of the pod
+// and the stats of its containers.
+func (ds *dockerService) addPodSandboxPlatformStats(
+	stats *runtimeapi.PodSandboxStats,
+	containerStats []*runtimeapi.ContainerStats,
+) error {
+	sandboxID := stats.Attributes.Id
+	statsJSON, err := ds.client.GetContainerStats(sandboxID)
+	if err != nil {
+		return err
+	}
+	r, err := ds.client.InspectContainer(sandboxID)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().UnixNano()
+	stats.Linux = &runtimeapi.LinuxPodSandboxStats{
+		Network:    networkUsage(statsJSON.Networks, timestamp),
+		Containers: containerStats,
+	}
+
+	// The pod cgroup accounts for all the processes of the pod, which the sum
+	// of the container stats misses, e.g. the sandbox ones.
+	podStats, err := readPodCgroupStats(r.HostConfig.CgroupParent)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorf("Failed to read the pod cgroup stats of sandbox %s: %v", sandboxID, err)
+		}
+		stats.Linux.Cpu, stats.Linux.Memory = sumContainerUsage(containerStats, timestamp)
+		return nil
+	}
+	stats.Linux.Cpu = &runtimeapi.CpuUsage{
+		Timestamp:            timestamp,
+		UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: podStats.cpuUsageNanoSeconds},
+	}
+	stats.Linux.Memory = &runtimeapi.MemoryUsage{
+		Timestamp:       timestamp,
+		WorkingSetBytes: &runtimeapi.UInt64Value{Value: podStats.memoryWorkingSet},
+		UsageBytes:      &runtimeapi.UInt64Value{Value: podStats.memoryUsageBytes},
+	}
+	return nil
+}
+
+// sumContainerUsage returns the CPU and memory usage of the pod as the sum of
+// the ones of its containers.
+func sumContainerUsage(
+	containerStats []*runtimeapi.ContainerStats,
+	timestamp int64,
+) (*runtimeapi.CpuUsage, *runtimeapi.MemoryUsage) {
+	var cpu, memory uint64
+	for _, cs := range containerStats {
+		cpu += cs.GetCpu().GetUsageCoreNanoSeconds().GetValue()
+		memory += cs.GetMemory().GetWorkingSetBytes().GetValue()
+	}
+	cpuUsage := &runtimeapi.CpuUsage{
+		Timestamp:            timestamp,
+		UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: cpu},
+	}
+	memoryUsage := &runtimeapi.MemoryUsage{
+		Timestamp:       timestamp,
+		WorkingSetBytes: &runtimeapi.UInt64Value{Value: memory},
+	}
+	return cpuUsage, memoryUsage
+}
+
+// networkUsage converts the docker stats of the network interfaces, sorted by
+// name. The default interface is eth0, or else the first one.
+func networkUsage(networks map[string]dockertypes.NetworkStats, timestamp int64) *runtimeapi.NetworkUsage {
+	if len(networks) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	usage := &runtimeapi.NetworkUsage{Timestamp: timestamp}
+	for _, name := range names {
+		n := networks[name]
+		iface := &runtimeapi.NetworkInterfaceUsage{
+			Name:     name,
+			RxBytes:  &runtimeapi.UInt64Value{Value: n.RxBytes},
+			RxErrors: &runtimeapi.UInt64Value{Value: n.RxErrors},
+			TxBytes:  &runtimeapi.UInt64Value{Value: n.TxBytes},
+			TxErrors: &runtimeapi.UInt64Value{Value: n.TxErrors},
+		}
+		usage.Interfaces = append(usage.Interfaces, iface)
+		if name == network.DefaultInterfaceName {
+			usage.DefaultInterface = iface
+		}
+	}
+	if usage.DefaultInterface == nil {
+		usage.DefaultInterface = usage.Interfaces[0]
+	}
+	return usage
+}
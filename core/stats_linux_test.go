@@ -0,0 +1,261 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// TestPodSandboxStatsNetwork tests that the sandbox stats report the network
+// counters of the sandbox interfaces, shared by its containers.
+func TestPodSandboxStatsNetwork(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sandboxResp, err := ds.RunPodSandbox(
+		getTestCTX(),
+		&runtimeapi.RunPodSandboxRequest{Config: sConfig},
+	)
+	require.NoError(t, err)
+	sandboxID := sandboxResp.PodSandboxId
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxID,
+		Config:        makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil),
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+
+	sandboxStats := &dockertypes.StatsJSON{Networks: map[string]dockertypes.NetworkStats{
+		"net1": {RxBytes: 10, RxErrors: 1, TxBytes: 20, TxErrors: 2},
+		"eth0": {RxBytes: 1000, RxErrors: 3, TxBytes: 2000, TxErrors: 4},
+	}}
+	fakeDocker.InjectContainerStats(map[string]*dockertypes.StatsJSON{
+		sandboxID:              sandboxStats,
+		createResp.ContainerId: {},
+	})
+
+	resp, err := ds.PodSandboxStats(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatsRequest{PodSandboxId: sandboxID},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, sandboxID, resp.Stats.Attributes.Id)
+	assert.Equal(t, sConfig.Metadata, resp.Stats.Attributes.Metadata)
+	require.NotNil(t, resp.Stats.Linux)
+	network := resp.Stats.Linux.Network
+	require.NotNil(t, network)
+	eth0 := &runtimeapi.NetworkInterfaceUsage{
+		Name:     "eth0",
+		RxBytes:  &runtimeapi.UInt64Value{Value: 1000},
+		RxErrors: &runtimeapi.UInt64Value{Value: 3},
+		TxBytes:  &runtimeapi.UInt64Value{Value: 2000},
+		TxErrors: &runtimeapi.UInt64Value{Value: 4},
+	}
+	assert.Equal(t, eth0, network.DefaultInterface)
+	assert.Equal(t, []*runtimeapi.NetworkInterfaceUsage{eth0, {
+		Name:     "net1",
+		RxBytes:  &runtimeapi.UInt64Value{Value: 10},
+		RxErrors: &runtimeapi.UInt64Value{Value: 1},
+		TxBytes:  &runtimeapi.UInt64Value{Value: 20},
+		TxErrors: &runtimeapi.UInt64Value{Value: 2},
+	}}, network.Interfaces)
+	require.Len(t, resp.Stats.Linux.Containers, 1)
+	assert.Equal(t, createResp.ContainerId, resp.Stats.Linux.Containers[0].Attributes.Id)
+
+	listResp, err := ds.ListPodSandboxStats(getTestCTX(), &runtimeapi.ListPodSandboxStatsRequest{})
+	require.NoError(t, err)
+	require.Len(t, listResp.Stats, 1)
+	assert.Equal(t, network.Interfaces, listResp.Stats[0].Linux.Network.Interfaces)
+
+	// Without network stats, e.g. for host network sandboxes, none are reported.
+	fakeDocker.InjectContainerStats(map[string]*dockertypes.StatsJSON{sandboxID: {}})
+	resp, err = ds.PodSandboxStats(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatsRequest{PodSandboxId: sandboxID},
+	)
+	require.NoError(t, err)
+	assert.Nil(t, resp.Stats.Linux.Network)
+}
+
+// TestPodSandboxStatsCgroup tests that the sandbox stats report the CPU and
+// memory usage of the pod cgroup, or else the sum of the container ones.
+func TestPodSandboxStatsCgroup(t *testing.T) {
+	for desc, test := range map[string]struct {
+		cgroupParent       string
+		files              map[string]string
+		expectedCPU        uint64
+		expectedWorkingSet uint64
+	}{
+		"cgroup v2": {
+			cgroupParent: "/kubepods/burstable/pod1",
+			files: map[string]string{
+				"cgroup.controllers":                     "cpu memory",
+				"kubepods/burstable/pod1/cpu.stat":       "usage_usec 300\nuser_usec 200\nsystem_usec 100\n",
+				"kubepods/burstable/pod1/memory.current": "5000\n",
+				"kubepods/burstable/pod1/memory.stat":    "anon 3000\ninactive_file 1000\n",
+			},
+			expectedCPU:        300000,
+			expectedWorkingSet: 4000,
+		},
+		"cgroup v2 with a systemd slice": {
+			cgroupParent: "kubepods-burstable-pod1.slice",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1.slice/cpu.stat":       "usage_usec 7\n",
+				"kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1.slice/memory.current": "2000\n",
+				"kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1.slice/memory.stat":    "inactive_file 0\n",
+			},
+			expectedCPU:        7000,
+			expectedWorkingSet: 2000,
+		},
+		"cgroup v1": {
+			cgroupParent: "/kubepods/pod1",
+			files: map[string]string{
+				"cpuacct/kubepods/pod1/cpuacct.usage":        "123456\n",
+				"memory/kubepods/pod1/memory.usage_in_bytes": "9000\n",
+				"memory/kubepods/pod1/memory.stat":           "cache 2000\ntotal_inactive_file 1500\n",
+			},
+			expectedCPU:        123456,
+			expectedWorkingSet: 7500,
+		},
+		"missing cgroup falls back to the sum of the containers": {
+			cgroupParent:       "/kubepods/pod1",
+			files:              map[string]string{"cgroup.controllers": "cpu memory"},
+			expectedCPU:        30,
+			expectedWorkingSet: 300,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		savedCgroup := cgroupRoot
+		cgroupRoot = t.TempDir()
+		for name, content := range test.files {
+			path := filepath.Join(cgroupRoot, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		}
+
+		ds, fakeDocker, _ := newTestDockerService()
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		sandboxResp, err := ds.RunPodSandbox(
+			getTestCTX(),
+			&runtimeapi.RunPodSandboxRequest{Config: sConfig},
+		)
+		require.NoError(t, err)
+		sandboxID := sandboxResp.PodSandboxId
+		fakeDocker.Lock()
+		fakeDocker.ContainerMap[sandboxID].HostConfig.CgroupParent = test.cgroupParent
+		fakeDocker.Unlock()
+
+		containerIDs := make([]string, 0, 2)
+		for _, name := range []string{"app1", "app2"} {
+			createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+				PodSandboxId:  sandboxID,
+				Config:        makeContainerConfig(sConfig, name, "iamimage", 0, nil, nil),
+				SandboxConfig: sConfig,
+			})
+			require.NoError(t, err)
+			containerIDs = append(containerIDs, createResp.ContainerId)
+		}
+		containerStats := func(cpu, memory uint64) *dockertypes.StatsJSON {
+			s := &dockertypes.StatsJSON{}
+			s.CPUStats.CPUUsage.TotalUsage = cpu
+			s.MemoryStats.Usage = memory
+			return s
+		}
+		fakeDocker.InjectContainerStats(map[string]*dockertypes.StatsJSON{
+			sandboxID:       {},
+			containerIDs[0]: containerStats(10, 100),
+			containerIDs[1]: containerStats(20, 200),
+		})
+
+		resp, err := ds.PodSandboxStats(
+			getTestCTX(),
+			&runtimeapi.PodSandboxStatsRequest{PodSandboxId: sandboxID},
+		)
+		cgroupRoot = savedCgroup
+		require.NoError(t, err)
+		require.NotNil(t, resp.Stats.Linux)
+		assert.Equal(t, test.expectedCPU, resp.Stats.Linux.Cpu.UsageCoreNanoSeconds.Value)
+		assert.Equal(t, test.expectedWorkingSet, resp.Stats.Linux.Memory.WorkingSetBytes.Value)
+		assert.Len(t, resp.Stats.Linux.Containers, 2)
+	}
+}
+
+// TestContainerStatsPeakMemory tests that the container stats report the peak
+// memory usage of the container, as reported by docker or else as observed.
+func TestContainerStatsPeakMemory(t *testing.T) {
+	for desc, test := range map[string]struct {
+		memoryStats  []dockertypes.MemoryStats
+		expectedPeak string
+	}{
+		"peak reported by docker with cgroup v1": {
+			memoryStats: []dockertypes.MemoryStats{
+				{Usage: 100, MaxUsage: 300},
+				{Usage: 50, MaxUsage: 300},
+			},
+			expectedPeak: "300",
+		},
+		"peak derived from the usage with cgroup v2": {
+			memoryStats: []dockertypes.MemoryStats{
+				{Usage: 100},
+				{Usage: 200},
+				{Usage: 50},
+			},
+			expectedPeak: "200",
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fakeDocker, _ := newTestDockerService()
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		sandboxResp, err := ds.RunPodSandbox(
+			getTestCTX(),
+			&runtimeapi.RunPodSandboxRequest{Config: sConfig},
+		)
+		require.NoError(t, err)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  sandboxResp.PodSandboxId,
+			Config:        makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil),
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		id := createResp.ContainerId
+
+		var peak string
+		for _, memoryStats := range test.memoryStats {
+			stats := &dockertypes.StatsJSON{}
+			stats.MemoryStats = memoryStats
+			fakeDocker.InjectContainerStats(map[string]*dockertypes.StatsJSON{id: stats})
+			resp, err := ds.ContainerStats(
+				getTestCTX(),
+				&runtimeapi.ContainerStatsRequest{ContainerId: id},
+			)
+			require.NoError(t, err)
+			assert.Equal(t, memoryStats.Usage, resp.Stats.Memory.WorkingSetBytes.Value)
+			peak = resp.Stats.Attributes.Annotations[peakMemoryAnnotationKey]
+		}
+		assert.Equal(t, test.expectedPeak, peak)
+	}
+}
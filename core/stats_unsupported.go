@@ -28,3 +28,10 @@ import (
 func (ds *dockerService) getContainerStats(c *runtimeapi.Container) (*runtimeapi.ContainerStats, error) {
 	return nil, fmt.Errorf("not implemented")
 }
+
+func (ds *dockerService) addPodSandboxPlatformStats(
+	stats *runtimeapi.PodSandboxStats,
+	containerStats []*runtimeapi.ContainerStats,
+) error {
+	return fmt.Errorf("not implemented")
+}
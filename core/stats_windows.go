@@ -20,6 +20,7 @@ limitations under the License.
 package core
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -95,3 +96,10 @@ func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*ru
 	}
 	return containerStats, nil
 }
+
+func (ds *dockerService) addPodSandboxPlatformStats(
+	stats *runtimeapi.PodSandboxStats,
+	containerStats []*runtimeapi.ContainerStats,
+) error {
+	return fmt.Errorf("not implemented")
+}
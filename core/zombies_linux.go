@@ -0,0 +1,59 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// countZombieProcesses returns the number of zombie processes in the pid
+// namespace of the given process, read from the proc filesystem mounted in
+// its root filesystem.
+func countZombieProcesses(pid int) (int, error) {
+	procDir := filepath.Join(procRoot, strconv.Itoa(pid), "root", "proc")
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return 0, err
+	}
+	zombies := 0
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "stat"))
+		if err != nil {
+			// The process exited in the meantime.
+			continue
+		}
+		// The state follows the command name, which may hold spaces and
+		// parentheses itself.
+		end := strings.LastIndexByte(string(stat), ')')
+		if end < 0 {
+			continue
+		}
+		if fields := strings.Fields(string(stat[end+1:])); len(fields) > 0 && fields[0] == "Z" {
+			zombies++
+		}
+	}
+	return zombies, nil
+}
@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// TestContainerStatusZombieProcesses tests that the verbose container status
+// reports the zombie processes found in the proc filesystem of the container,
+// when enabled.
+func TestContainerStatusZombieProcesses(t *testing.T) {
+	saved := procRoot
+	procRoot = t.TempDir()
+	t.Cleanup(func() { procRoot = saved })
+	containerProc := filepath.Join(procRoot, "4242", "root", "proc")
+	for pid, stat := range map[string]string{
+		"1":  "1 (sh) S 0 1 1 0 -1 4194560",
+		"7":  "7 (sleep) Z 1 7 1 0 -1 4227084",
+		"8":  "8 (my (odd) app) Z 1 8 1 0 -1 4227084",
+		"9":  "9 (app) R 1 9 1 0 -1 4194304",
+		"10": "10 (Z) S 1 10 1 0 -1 4194304",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(containerProc, pid), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(containerProc, pid, "stat"), []byte(stat), 0o644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(containerProc, "sys"), 0o755))
+
+	for desc, test := range map[string]struct {
+		enabled  bool
+		expected *int
+	}{
+		"disabled": {},
+		"enabled": {
+			enabled:  true,
+			expected: func() *int { n := 2; return &n }(),
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		ds, fDocker, _ := newTestDockerService()
+		ds.runtimeSettings.ReportZombieProcesses = test.enabled
+		sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+		runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+			Config: sConfig,
+		})
+		require.NoError(t, err)
+		config := makeContainerConfig(sConfig, "app", "iamimage", 0, nil, nil)
+		createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+			PodSandboxId:  runSandboxResp.PodSandboxId,
+			Config:        config,
+			SandboxConfig: sConfig,
+		})
+		require.NoError(t, err)
+		id := createResp.ContainerId
+		_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+		fDocker.Lock()
+		fDocker.ContainerMap[id].State.Pid = 4242
+		fDocker.Unlock()
+
+		resp, err := ds.ContainerStatus(
+			getTestCTX(),
+			&runtimeapi.ContainerStatusRequest{ContainerId: id, Verbose: true},
+		)
+		require.NoError(t, err)
+		var info verboseContainerInfo
+		require.NoError(t, json.Unmarshal([]byte(resp.Info["info"]), &info))
+		assert.Equal(t, test.expected, info.ZombieProcesses)
+	}
+}
@@ -17,12 +17,14 @@ limitations under the License.
 package libdocker
 
 import (
+	"io"
 	"os"
 	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	dockersystem "github.com/docker/docker/api/types/system"
@@ -56,11 +58,14 @@ type DockerClientInterface interface {
 	StartContainer(id string) error
 	StopContainer(id string, timeout time.Duration) error
 	UpdateContainerResources(id string, updateConfig dockercontainer.UpdateConfig) error
+	PauseContainer(id string) error
+	UnpauseContainer(id string) error
 	RemoveContainer(id string, opts dockercontainer.RemoveOptions) error
 	InspectImageByRef(imageRef string) (*dockertypes.ImageInspect, error)
 	InspectImageByID(imageID string) (*dockertypes.ImageInspect, error)
 	ListImages(opts dockertypes.ImageListOptions) ([]dockerimagetypes.Summary, error)
 	PullImage(image string, auth dockerregistry.AuthConfig, opts dockertypes.ImagePullOptions) error
+	LoadImage(input io.Reader) error
 	RemoveImage(
 		image string,
 		opts dockertypes.ImageRemoveOptions,
@@ -76,6 +81,7 @@ type DockerClientInterface interface {
 	ResizeContainerTTY(id string, height, width uint) error
 	ResizeExecTTY(id string, height, width uint) error
 	GetContainerStats(id string) (*dockertypes.StatsJSON, error)
+	Events(opts dockertypes.EventsOptions) (<-chan dockerevents.Message, <-chan error)
 }
 
 // Get a *dockerapi.Client, either using the endpoint passed in, or using
@@ -96,10 +102,12 @@ func getDockerClient(dockerEndpoint string) (*dockerapi.Client, error) {
 // will be returned. The program exits if error occurs. The requestTimeout
 // is the timeout for docker requests. If timeout is exceeded, the request
 // will be cancelled and throw out an error. If requestTimeout is 0, a default
-// value will be applied.
+// value will be applied. The logs of containers are read through a buffer of
+// logReadBufferSize bytes, or DefaultLogReadBufferSize if 0.
 func ConnectToDockerOrDie(
 	dockerEndpoint string,
 	requestTimeout, imagePullProgressDeadline time.Duration,
+	logReadBufferSize int,
 ) DockerClientInterface {
 	client, err := getDockerClient(dockerEndpoint)
 	if err != nil {
@@ -108,5 +116,10 @@ func ConnectToDockerOrDie(
 
 	}
 	logrus.Infof("Start docker client with request timeout %s", requestTimeout)
-	return newKubeDockerClient(client, requestTimeout, imagePullProgressDeadline)
+	return newKubeDockerClient(
+		client,
+		requestTimeout,
+		imagePullProgressDeadline,
+		logReadBufferSize,
+	)
 }
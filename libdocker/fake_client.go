@@ -20,20 +20,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	dockerref "github.com/docker/distribution/reference"
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
+	dockermount "github.com/docker/docker/api/types/mount"
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	dockersystem "github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/errdefs"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/utils/clock"
@@ -70,8 +76,15 @@ type FakeDockerClient struct {
 	Started []string
 	Stopped []string
 	Removed []string
+	// StopTimeouts contains, by container ID, the timeout of its last stop.
+	StopTimeouts map[string]time.Duration
+	// StuckContainers contains the IDs of the containers surviving their
+	// stops, as if stuck in an uninterruptible state.
+	StuckContainers map[string]bool
 	// Images pulled by ref (name or ID).
 	ImagesPulled []string
+	// ArchivedImages are the images of the archives given to LoadImage.
+	ArchivedImages []dockerimagetypes.Summary
 
 	VersionInfo       dockertypes.Version
 	Information       dockersystem.Info
@@ -80,6 +93,10 @@ type FakeDockerClient struct {
 	EnableSleep       bool
 	ImageHistoryMap   map[string][]dockerimagetypes.HistoryResponseItem
 	ContainerStatsMap map[string]*dockertypes.StatsJSON
+	// events and eventErrs hold the events and the stream errors injected
+	// until they are streamed.
+	events    chan dockerevents.Message
+	eventErrs chan error
 }
 
 const (
@@ -239,12 +256,19 @@ func convertFakeContainer(f *FakeContainer) *dockertypes.ContainerJSON {
 		f.HostConfig = &dockercontainer.HostConfig{}
 	}
 	fakeRWSize := int64(40)
+	status := "created"
+	if f.Running {
+		status = "running"
+	} else if !f.FinishedAt.IsZero() || f.ExitCode != 0 {
+		status = "exited"
+	}
 	return &dockertypes.ContainerJSON{
 		ContainerJSONBase: &dockertypes.ContainerJSONBase{
 			ID:    f.ID,
 			Name:  f.Name,
 			Image: f.Config.Image,
 			State: &dockertypes.ContainerState{
+				Status:     status,
 				Running:    f.Running,
 				ExitCode:   f.ExitCode,
 				Pid:        f.Pid,
@@ -349,9 +373,9 @@ func (f *FakeDockerClient) ListContainers(
 		}
 		containerList = filtered
 	}
-	// Filters containers with status, only support 1 status.
+	// Filters containers with status, any of the statuses matches.
 	statusFilters := options.Filters.Get("status")
-	if len(statusFilters) == 1 {
+	if len(statusFilters) != 0 {
 		var filtered []dockertypes.Container
 		for _, container := range containerList {
 			for _, statusFilter := range statusFilters {
@@ -447,6 +471,21 @@ func (f *FakeDockerClient) InspectImageByRef(name string) (*dockertypes.ImageIns
 	if result, ok := f.ImageInspects[name]; ok {
 		return result, nil
 	}
+	// As docker, resolve the tags in any form, e.g. without the registry or
+	// the default tag.
+	if named, err := dockerref.ParseNormalizedNamed(name); err == nil {
+		if _, ok := named.(dockerref.Digested); !ok {
+			tag := dockerref.TagNameOnly(named).String()
+			for _, result := range f.ImageInspects {
+				for _, repoTag := range result.RepoTags {
+					named, err := dockerref.ParseNormalizedNamed(repoTag)
+					if err == nil && dockerref.TagNameOnly(named).String() == tag {
+						return result, nil
+					}
+				}
+			}
+		}
+	}
 	return nil, ImageNotFoundError{name}
 }
 
@@ -517,6 +556,25 @@ func (f *FakeDockerClient) CreateContainer(
 	}, f.RunningContainerList...)
 	f.ContainerMap[id] = convertFakeContainer(&FakeContainer{
 		ID: id, Name: name, Config: c.Config, HostConfig: c.HostConfig, CreatedAt: timestamp})
+	// Like docker, create an anonymous volume for each unmounted VOLUME of the image.
+	if image, ok := f.ImageInspects[c.Config.Image]; ok && image.Config != nil {
+		mounted := map[string]bool{}
+		if c.HostConfig != nil {
+			for _, m := range c.HostConfig.Mounts {
+				mounted[filepath.Clean(m.Target)] = true
+			}
+		}
+		for volume := range image.Config.Volumes {
+			if mounted[filepath.Clean(volume)] {
+				continue
+			}
+			f.ContainerMap[id].Mounts = append(f.ContainerMap[id].Mounts, dockertypes.MountPoint{
+				Type:        dockermount.TypeVolume,
+				Destination: volume,
+				RW:          true,
+			})
+		}
+	}
 
 	f.normalSleep(100, 25, 25)
 
@@ -554,6 +612,7 @@ func (f *FakeDockerClient) StartContainer(id string) error {
 	if !ok {
 		container = convertFakeContainer(&FakeContainer{ID: id, Name: id, CreatedAt: timestamp})
 	}
+	container.State.Status = "running"
 	container.State.Running = true
 	container.State.Pid = os.Getpid()
 	container.State.StartedAt = dockerTimestampToString(timestamp)
@@ -579,7 +638,14 @@ func (f *FakeDockerClient) StopContainer(id string, timeout time.Duration) error
 	if err := f.popError("stop"); err != nil {
 		return err
 	}
+	if f.StuckContainers[id] {
+		return fmt.Errorf("tried to kill container, but did not receive an exit event")
+	}
 	f.appendContainerTrace("Stopped", id)
+	if f.StopTimeouts == nil {
+		f.StopTimeouts = make(map[string]time.Duration)
+	}
+	f.StopTimeouts[id] = timeout
 	// Container status should be Updated before container moved to ExitedContainerList
 	f.updateContainerStatus(id, StatusExitedPrefix)
 	var newList []dockertypes.Container
@@ -605,6 +671,7 @@ func (f *FakeDockerClient) StopContainer(id string, timeout time.Duration) error
 		})
 	} else {
 		container.State.FinishedAt = dockerTimestampToString(f.Clock.Now())
+		container.State.Status = "exited"
 		container.State.Running = false
 	}
 	f.ContainerMap[id] = container
@@ -635,8 +702,9 @@ func (f *FakeDockerClient) RemoveContainer(
 
 	}
 	for i := range f.RunningContainerList {
-		// allow removal of running containers which are not running
-		if f.RunningContainerList[i].ID == id && !f.ContainerMap[id].State.Running {
+		// allow removal of running containers which are not running, or
+		// forced
+		if f.RunningContainerList[i].ID == id && (opts.Force || !f.ContainerMap[id].State.Running) {
 			delete(f.ContainerMap, id)
 			f.RunningContainerList = append(
 				f.RunningContainerList[:i],
@@ -656,6 +724,47 @@ func (f *FakeDockerClient) UpdateContainerResources(
 	return nil
 }
 
+func (f *FakeDockerClient) PauseContainer(id string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled(CalledDetail{name: "pause", arguments: []interface{}{id}})
+	if err := f.popError("pause"); err != nil {
+		return err
+	}
+	container, ok := f.ContainerMap[id]
+	if !ok {
+		return fmt.Errorf("container %q not found", id)
+	}
+	if !container.State.Running {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	if container.State.Paused {
+		return fmt.Errorf("container %s is already paused", id)
+	}
+	container.State.Paused = true
+	container.State.Status = "paused"
+	return nil
+}
+
+func (f *FakeDockerClient) UnpauseContainer(id string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled(CalledDetail{name: "unpause", arguments: []interface{}{id}})
+	if err := f.popError("unpause"); err != nil {
+		return err
+	}
+	container, ok := f.ContainerMap[id]
+	if !ok {
+		return fmt.Errorf("container %q not found", id)
+	}
+	if !container.State.Paused {
+		return fmt.Errorf("container %s is not paused", id)
+	}
+	container.State.Paused = false
+	container.State.Status = "running"
+	return nil
+}
+
 // Logs is a test-spy implementation of DockerClientInterface.Logs.
 // It adds an entry "logs" to the internal method call record.
 func (f *FakeDockerClient) Logs(
@@ -742,8 +851,12 @@ func (f *FakeDockerClient) AttachToContainer(
 	sopts StreamOptions,
 ) error {
 	f.Lock()
-	defer f.Unlock()
 	f.appendCalled(CalledDetail{name: "attach"})
+	f.Unlock()
+	// Like docker, the input is streamed to the container until it ends.
+	if sopts.InputStream != nil {
+		io.Copy(io.Discard, sopts.InputStream)
+	}
 	return nil
 }
 
@@ -769,6 +882,15 @@ func (f *FakeDockerClient) RemoveImage(
 	defer f.Unlock()
 	f.appendCalled(CalledDetail{name: "remove_image", arguments: []interface{}{image, opts}})
 	err := f.popError("remove_image")
+	if err == nil && !opts.Force {
+		// Like docker, refuse to remove the images containers use.
+		for _, c := range f.ContainerMap {
+			if c.Image == image {
+				err = errdefs.Conflict(fmt.Errorf("image %q is used by container %s", image, c.ID))
+				break
+			}
+		}
+	}
 	if err == nil {
 		for i := range f.Images {
 			if f.Images[i].ID == image {
@@ -780,6 +902,26 @@ func (f *FakeDockerClient) RemoveImage(
 	return []dockerimagetypes.DeleteResponse{{Deleted: image}}, err
 }
 
+// LoadImage is a test-spy implementation of DockerClientInterface.LoadImage.
+// It adds an entry "load_image" to the internal method call record, and makes
+// the archived images available.
+func (f *FakeDockerClient) LoadImage(input io.Reader) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled(CalledDetail{name: "load_image"})
+	if err := f.popError("load_image"); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, input); err != nil {
+		return err
+	}
+	f.Images = append(f.Images, f.ArchivedImages...)
+	for _, i := range f.ArchivedImages {
+		f.ImageInspects[i.ID] = createImageInspectFromImage(i)
+	}
+	return nil
+}
+
 func (f *FakeDockerClient) InjectImages(images []dockerimagetypes.Summary) {
 	f.Lock()
 	defer f.Unlock()
@@ -829,14 +971,14 @@ func (f *FakeDockerClient) updateContainerStatus(id, status string) {
 func (f *FakeDockerClient) ResizeExecTTY(id string, height, width uint) error {
 	f.Lock()
 	defer f.Unlock()
-	f.appendCalled(CalledDetail{name: "resize_exec"})
+	f.appendCalled(CalledDetail{name: "resize_exec", arguments: []interface{}{id, height, width}})
 	return nil
 }
 
 func (f *FakeDockerClient) ResizeContainerTTY(id string, height, width uint) error {
 	f.Lock()
 	defer f.Unlock()
-	f.appendCalled(CalledDetail{name: "resize_container"})
+	f.appendCalled(CalledDetail{name: "resize_container", arguments: []interface{}{id, height, width}})
 	return nil
 }
 
@@ -864,8 +1006,9 @@ func createImageInspectFromImage(image dockerimagetypes.Summary) *dockertypes.Im
 
 func createImageFromImageInspect(inspect dockertypes.ImageInspect) *dockerimagetypes.Summary {
 	return &dockerimagetypes.Summary{
-		ID:       inspect.ID,
-		RepoTags: inspect.RepoTags,
+		ID:          inspect.ID,
+		RepoTags:    inspect.RepoTags,
+		RepoDigests: inspect.RepoDigests,
 		// Image size is required to be non-zero for CRI integration.
 		VirtualSize: fakeImageSize,
 		Size:        fakeImageSize,
@@ -927,3 +1070,43 @@ func (f *FakeDockerClient) GetContainerStats(id string) (*dockertypes.StatsJSON,
 	}
 	return stats, nil
 }
+
+// Events is a test-spy implementation of DockerClientInterface.Events.
+// It adds an entry "events" to the internal method call record, and streams
+// the injected events, ignoring the options.
+func (f *FakeDockerClient) Events(
+	opts dockertypes.EventsOptions,
+) (<-chan dockerevents.Message, <-chan error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled(CalledDetail{name: "events"})
+	f.initEventChannels()
+	return f.events, f.eventErrs
+}
+
+// InjectEvent streams the event to the callers of Events.
+func (f *FakeDockerClient) InjectEvent(event dockerevents.Message) {
+	f.Lock()
+	f.initEventChannels()
+	events := f.events
+	f.Unlock()
+	events <- event
+}
+
+// InjectEventError ends the event stream of a caller of Events with the error.
+func (f *FakeDockerClient) InjectEventError(err error) {
+	f.Lock()
+	f.initEventChannels()
+	errs := f.eventErrs
+	f.Unlock()
+	errs <- err
+}
+
+// initEventChannels creates the channels of the injected events and errors,
+// f being locked.
+func (f *FakeDockerClient) initEventChannels() {
+	if f.events == nil {
+		f.events = make(chan dockerevents.Message, 16)
+		f.eventErrs = make(chan error, 1)
+	}
+}
@@ -17,14 +17,17 @@ limitations under the License.
 package libdocker
 
 import (
+	"io"
 	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	dockersystem "github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/errdefs"
 
 	"github.com/Mirantis/cri-dockerd/metrics"
 )
@@ -60,9 +63,32 @@ func recordError(operation string, err error) {
 		}
 		// Docker operation timeout error is also a docker error, so we don't add else here.
 		metrics.DockerOperationsErrors.WithLabelValues(operation).Inc()
+		metrics.DockerAPIErrors.WithLabelValues(errorType(err)).Inc()
 	}
 }
 
+// errorType classifies a docker error for the metrics.
+func errorType(err error) string {
+	if _, ok := err.(operationTimeout); ok {
+		return "timeout"
+	}
+	switch {
+	case errdefs.IsNotFound(err), IsContainerNotFoundError(err), IsImageNotFoundError(err):
+		return "not_found"
+	case errdefs.IsConflict(err):
+		return "conflict"
+	case errdefs.IsInvalidParameter(err):
+		return "invalid_parameter"
+	case errdefs.IsUnauthorized(err), errdefs.IsForbidden(err):
+		return "unauthorized"
+	case errdefs.IsUnavailable(err):
+		return "unavailable"
+	case errdefs.IsSystem(err):
+		return "system"
+	}
+	return "unknown"
+}
+
 func (in instrumentedInterface) ListContainers(
 	options dockercontainer.ListOptions,
 ) ([]dockertypes.Container, error) {
@@ -147,6 +173,24 @@ func (in instrumentedInterface) UpdateContainerResources(
 	return err
 }
 
+func (in instrumentedInterface) PauseContainer(id string) error {
+	const operation = "pause_container"
+	defer recordOperation(operation, time.Now())
+
+	err := in.client.PauseContainer(id)
+	recordError(operation, err)
+	return err
+}
+
+func (in instrumentedInterface) UnpauseContainer(id string) error {
+	const operation = "unpause_container"
+	defer recordOperation(operation, time.Now())
+
+	err := in.client.UnpauseContainer(id)
+	recordError(operation, err)
+	return err
+}
+
 func (in instrumentedInterface) InspectImageByRef(image string) (*dockertypes.ImageInspect, error) {
 	const operation = "inspect_image"
 	defer recordOperation(operation, time.Now())
@@ -188,6 +232,15 @@ func (in instrumentedInterface) PullImage(
 	return err
 }
 
+func (in instrumentedInterface) LoadImage(input io.Reader) error {
+	const operation = "load_image"
+	defer recordOperation(operation, time.Now())
+
+	err := in.client.LoadImage(input)
+	recordError(operation, err)
+	return err
+}
+
 func (in instrumentedInterface) RemoveImage(
 	image string,
 	opts dockertypes.ImageRemoveOptions,
@@ -315,3 +368,12 @@ func (in instrumentedInterface) GetContainerStats(id string) (*dockertypes.Stats
 	recordError(operation, err)
 	return out, err
 }
+
+func (in instrumentedInterface) Events(
+	opts dockertypes.EventsOptions,
+) (<-chan dockerevents.Message, <-chan error) {
+	const operation = "events"
+	defer recordOperation(operation, time.Now())
+
+	return in.client.Events(opts)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libdocker
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/Mirantis/cri-dockerd/metrics"
+)
+
+func TestDockerAPIErrorsMetric(t *testing.T) {
+	metrics.Register()
+	for desc, test := range map[string]struct {
+		err          error
+		expectedType string
+	}{
+		"timeout": {
+			err:          operationTimeout{err: fmt.Errorf("context deadline exceeded")},
+			expectedType: "timeout",
+		},
+		"container not found": {
+			err:          fmt.Errorf("Error response from daemon: No such container: 96e914f31579"),
+			expectedType: "not_found",
+		},
+		"typed not found": {
+			err:          errdefs.NotFound(fmt.Errorf("no such volume")),
+			expectedType: "not_found",
+		},
+		"conflict": {
+			err:          errdefs.Conflict(fmt.Errorf("name already in use")),
+			expectedType: "conflict",
+		},
+		"unauthorized": {
+			err:          errdefs.Unauthorized(fmt.Errorf("authentication required")),
+			expectedType: "unauthorized",
+		},
+		"unknown": {
+			err:          fmt.Errorf("something went wrong"),
+			expectedType: "unknown",
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		fakeClient := NewFakeDockerClient()
+		fakeClient.InjectError("inspect_container", test.err)
+		client := NewInstrumentedInterface(fakeClient)
+		counter := metrics.DockerAPIErrors.WithLabelValues(test.expectedType)
+		before, err := testutil.GetCounterMetricValue(counter)
+		require.NoError(t, err)
+
+		_, err = client.InspectContainer("foo")
+		assert.Equal(t, test.err, err)
+		after, err := testutil.GetCounterMetricValue(counter)
+		require.NoError(t, err)
+		assert.Equal(t, before+1, after)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package libdocker
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/base64"
@@ -33,6 +34,7 @@ import (
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	dockersystem "github.com/docker/docker/api/types/system"
@@ -48,10 +50,14 @@ type kubeDockerClient struct {
 	// timeout is the timeout of short running docker operations.
 	timeout time.Duration
 	// If no pulling progress is made before imagePullProgressDeadline, the image pulling will be cancelled.
+	// The same goes for the image loads.
 	// Docker reports image progress for every 512kB block, so normally there shouldn't be too long interval
 	// between progress updates.
 	imagePullProgressDeadline time.Duration
-	client                    *dockerapi.Client
+	// logReadBufferSize is the size of the buffer the container logs are
+	// read through.
+	logReadBufferSize int
+	client            *dockerapi.Client
 }
 
 // Make sure that kubeDockerClient implemented the DockerClientInterface.
@@ -74,28 +80,49 @@ const (
 
 	// defaultImagePullingProgressReportInterval is the default interval of image pulling progress reporting.
 	defaultImagePullingProgressReportInterval = 10 * time.Second
+
+	// DefaultLogReadBufferSize is the default size of the buffer the container
+	// logs are read through.
+	DefaultLogReadBufferSize = 64 * 1024
+	// minLogReadBufferSize is the smallest buffer the container logs are read
+	// through, smaller sizes being raised to it.
+	minLogReadBufferSize = 4 * 1024
 )
 
 // newKubeDockerClient creates an kubeDockerClient from an existing docker client. If requestTimeout is 0,
-// defaultTimeout will be applied.
+// defaultTimeout will be applied, and if logReadBufferSize is 0, DefaultLogReadBufferSize.
 func newKubeDockerClient(
 	dockerClient *dockerapi.Client,
 	requestTimeout, imagePullProgressDeadline time.Duration,
+	logReadBufferSize int,
 ) DockerClientInterface {
 	if requestTimeout == 0 {
 		requestTimeout = defaultTimeout
 	}
+	if logReadBufferSize == 0 {
+		logReadBufferSize = DefaultLogReadBufferSize
+	}
+	if logReadBufferSize < minLogReadBufferSize {
+		logrus.Warningf(
+			"Container log read buffer size %d is too small, using %d instead",
+			logReadBufferSize,
+			minLogReadBufferSize,
+		)
+		logReadBufferSize = minLogReadBufferSize
+	}
 
 	k := &kubeDockerClient{
 		client:                    dockerClient,
 		timeout:                   requestTimeout,
 		imagePullProgressDeadline: imagePullProgressDeadline,
+		logReadBufferSize:         logReadBufferSize,
 	}
 
 	// Notice that this assumes that docker is running before kubelet is started.
 	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
 	defer cancel()
 	dockerClient.NegotiateAPIVersion(ctx)
+	logrus.Infof("Negotiated docker API version %s", dockerClient.ClientVersion())
 
 	return k
 }
@@ -220,6 +247,26 @@ func (d *kubeDockerClient) UpdateContainerResources(
 	return err
 }
 
+func (d *kubeDockerClient) PauseContainer(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	err := d.client.ContainerPause(ctx, id)
+	if ctxErr := contextError(ctx); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func (d *kubeDockerClient) UnpauseContainer(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	err := d.client.ContainerUnpause(ctx, id)
+	if ctxErr := contextError(ctx); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
 func (d *kubeDockerClient) inspectImageRaw(ref string) (*dockertypes.ImageInspect, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
 	defer cancel()
@@ -434,6 +481,46 @@ func (d *kubeDockerClient) PullImage(
 	return nil
 }
 
+// LoadImage loads the images of the tar archive read from input, as saved by
+// docker save. As for pulls, the load is cancelled once it makes no progress
+// for imagePullProgressDeadline.
+func (d *kubeDockerClient) LoadImage(input io.Reader) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deadline := time.AfterFunc(d.imagePullProgressDeadline, cancel)
+	defer deadline.Stop()
+	loadErr := func(err error) error {
+		if ctx.Err() != nil {
+			return fmt.Errorf(
+				"image load made no progress for %s: %v",
+				d.imagePullProgressDeadline,
+				err,
+			)
+		}
+		return err
+	}
+	resp, err := d.client.ImageLoad(ctx, input, false)
+	if err != nil {
+		return loadErr(err)
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg dockermessage.JSONMessage
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return loadErr(err)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		deadline.Reset(d.imagePullProgressDeadline)
+	}
+}
+
 func (d *kubeDockerClient) RemoveImage(
 	image string,
 	opts dockertypes.ImageRemoveOptions,
@@ -469,7 +556,7 @@ func (d *kubeDockerClient) Logs(
 		sopts.RawTerminal,
 		sopts.OutputStream,
 		sopts.ErrorStream,
-		resp,
+		bufio.NewReaderSize(resp, d.logReadBufferSize),
 	)
 }
 
@@ -613,6 +700,14 @@ func (d *kubeDockerClient) ResizeContainerTTY(id string, height, width uint) err
 	})
 }
 
+// Events streams the docker events matching the options, until an error,
+// which ends the stream, is sent.
+func (d *kubeDockerClient) Events(
+	opts dockertypes.EventsOptions,
+) (<-chan dockerevents.Message, <-chan error) {
+	return d.client.Events(context.Background(), opts)
+}
+
 // GetContainerStats is currently only used for Windows container stats
 func (d *kubeDockerClient) GetContainerStats(id string) (*dockertypes.StatsJSON, error) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -635,7 +730,8 @@ func (d *kubeDockerClient) GetContainerStats(id string) (*dockertypes.StatsJSON,
 }
 
 // redirectResponseToOutputStream redirect the response stream to stdout and stderr. When tty is true, all stream will
-// only be redirected to stdout.
+// only be redirected to stdout. Otherwise each frame of the multiplexed stream is written, in order, to the writer
+// matching the stream recorded for it by docker, so interleaved stdout and stderr entries keep their attribution.
 func (d *kubeDockerClient) redirectResponseToOutputStream(
 	tty bool,
 	outputStream, errorStream io.Writer,
@@ -17,10 +17,20 @@ limitations under the License.
 package libdocker
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	dockerapi "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsContainerNotFoundError(t *testing.T) {
@@ -33,3 +43,135 @@ func TestIsContainerNotFoundError(t *testing.T) {
 	assert.True(t, IsContainerNotFoundError(containerNotFoundError))
 	assert.False(t, IsContainerNotFoundError(otherError))
 }
+
+// streamRecorder records the lines written to it along with the stream they
+// were written to, in the order they were received across all streams.
+type streamRecorder struct {
+	stream  string
+	entries *[]string
+}
+
+func (r streamRecorder) Write(p []byte) (int, error) {
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line != "" {
+			*r.entries = append(*r.entries, r.stream+" "+line)
+		}
+	}
+	return len(p), nil
+}
+
+func TestRedirectResponseToOutputStreamInterleaved(t *testing.T) {
+	var buf bytes.Buffer
+	stdoutFrames := stdcopy.NewStdWriter(&buf, stdcopy.Stdout)
+	stderrFrames := stdcopy.NewStdWriter(&buf, stdcopy.Stderr)
+	for _, entry := range []struct {
+		w    io.Writer
+		line string
+	}{
+		{stdoutFrames, "out 1\n"},
+		{stderrFrames, "err 1\n"},
+		{stderrFrames, "err 2\n"},
+		{stdoutFrames, "out 2\n"},
+		{stderrFrames, "err 3\n"},
+		{stdoutFrames, "out 3\n"},
+	} {
+		_, err := entry.w.Write([]byte(entry.line))
+		require.NoError(t, err)
+	}
+
+	for desc, test := range map[string]struct {
+		tty      bool
+		input    []byte
+		expected []string
+	}{
+		"multiplexed stream keeps the stream of each entry": {
+			input: buf.Bytes(),
+			expected: []string{
+				"stdout out 1\n",
+				"stderr err 1\n",
+				"stderr err 2\n",
+				"stdout out 2\n",
+				"stderr err 3\n",
+				"stdout out 3\n",
+			},
+		},
+		"tty output is only written to stdout": {
+			tty:      true,
+			input:    []byte("out 1\nerr 1\n"),
+			expected: []string{"stdout out 1\n", "stdout err 1\n"},
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		var entries []string
+		d := &kubeDockerClient{}
+		err := d.redirectResponseToOutputStream(
+			test.tty,
+			streamRecorder{stream: "stdout", entries: &entries},
+			streamRecorder{stream: "stderr", entries: &entries},
+			bytes.NewReader(test.input),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, entries)
+	}
+}
+
+func TestNewKubeDockerClientLogReadBufferSize(t *testing.T) {
+	for desc, test := range map[string]struct {
+		size     int
+		expected int
+	}{
+		"default":            {size: 0, expected: DefaultLogReadBufferSize},
+		"configured":         {size: 1024 * 1024, expected: 1024 * 1024},
+		"below the floor":    {size: 512, expected: minLogReadBufferSize},
+		"negative":           {size: -1, expected: minLogReadBufferSize},
+		"equal to the floor": {size: minLogReadBufferSize, expected: minLogReadBufferSize},
+	} {
+		t.Logf("TestCase: %s", desc)
+		// The API version negotiation fails without a daemon, which is fine.
+		client, err := dockerapi.NewClientWithOpts(
+			dockerapi.WithHost("unix://" + filepath.Join(t.TempDir(), "docker.sock")),
+		)
+		require.NoError(t, err)
+		d := newKubeDockerClient(client, time.Second, 0, test.size).(*kubeDockerClient)
+		assert.Equal(t, test.expected, d.logReadBufferSize)
+	}
+}
+
+// TestLoadImageProgressDeadline tests that an image load is cancelled once it
+// makes no progress for the image pull progress deadline.
+func TestLoadImageProgressDeadline(t *testing.T) {
+	for desc, test := range map[string]struct {
+		stall     bool
+		expectErr bool
+	}{
+		"load completes": {},
+		"load stalls":    {stall: true, expectErr: true},
+	} {
+		t.Logf("TestCase: %s", desc)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, `{"status":"Loading layer","id":"layer%d"}`+"\n", i)
+				w.(http.Flusher).Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+			if test.stall {
+				<-r.Context().Done()
+			}
+		}))
+		client, err := dockerapi.NewClientWithOpts(
+			dockerapi.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")),
+			dockerapi.WithVersion("1.41"),
+		)
+		require.NoError(t, err)
+		d := &kubeDockerClient{client: client, imagePullProgressDeadline: 200 * time.Millisecond}
+		err = d.LoadImage(strings.NewReader("archive"))
+		server.Close()
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+	}
+}
@@ -5,6 +5,7 @@
 package testing
 
 import (
+	io "io"
 	reflect "reflect"
 	time "time"
 
@@ -13,6 +14,7 @@ import (
 	backend "github.com/docker/docker/api/types/backend"
 	registry "github.com/docker/docker/api/types/registry"
 	container "github.com/docker/docker/api/types/container"
+	events "github.com/docker/docker/api/types/events"
 	image "github.com/docker/docker/api/types/image"
 	system "github.com/docker/docker/api/types/system"
 	gomock "github.com/golang/mock/gomock"
@@ -85,6 +87,21 @@ func (mr *MockDockerClientInterfaceMockRecorder) CreateExec(arg0, arg1 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateExec", reflect.TypeOf((*MockDockerClientInterface)(nil).CreateExec), arg0, arg1)
 }
 
+// Events mocks base method.
+func (m *MockDockerClientInterface) Events(opts types.EventsOptions) (<-chan events.Message, <-chan error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Events", opts)
+	ret0, _ := ret[0].(<-chan events.Message)
+	ret1, _ := ret[1].(<-chan error)
+	return ret0, ret1
+}
+
+// Events indicates an expected call of Events.
+func (mr *MockDockerClientInterfaceMockRecorder) Events(opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Events", reflect.TypeOf((*MockDockerClientInterface)(nil).Events), opts)
+}
+
 // GetContainerStats mocks base method.
 func (m *MockDockerClientInterface) GetContainerStats(id string) (*types.StatsJSON, error) {
 	m.ctrl.T.Helper()
@@ -249,6 +266,20 @@ func (mr *MockDockerClientInterfaceMockRecorder) Logs(arg0, arg1, arg2 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Logs", reflect.TypeOf((*MockDockerClientInterface)(nil).Logs), arg0, arg1, arg2)
 }
 
+// PauseContainer mocks base method.
+func (m *MockDockerClientInterface) PauseContainer(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PauseContainer", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PauseContainer indicates an expected call of PauseContainer.
+func (mr *MockDockerClientInterfaceMockRecorder) PauseContainer(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PauseContainer", reflect.TypeOf((*MockDockerClientInterface)(nil).PauseContainer), id)
+}
+
 // PullImage mocks base method.
 func (m *MockDockerClientInterface) PullImage(image string, auth registry.AuthConfig, opts types.ImagePullOptions) error {
 	m.ctrl.T.Helper()
@@ -277,6 +308,20 @@ func (mr *MockDockerClientInterfaceMockRecorder) RemoveContainer(id, opts interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveContainer", reflect.TypeOf((*MockDockerClientInterface)(nil).RemoveContainer), id, opts)
 }
 
+// LoadImage mocks base method.
+func (m *MockDockerClientInterface) LoadImage(input io.Reader) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadImage", input)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LoadImage indicates an expected call of LoadImage.
+func (mr *MockDockerClientInterfaceMockRecorder) LoadImage(input interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadImage", reflect.TypeOf((*MockDockerClientInterface)(nil).LoadImage), input)
+}
+
 // RemoveImage mocks base method.
 func (m *MockDockerClientInterface) RemoveImage(imageStr string, opts image.RemoveOptions) ([]image.DeleteResponse, error) {
 	m.ctrl.T.Helper()
@@ -362,6 +407,20 @@ func (mr *MockDockerClientInterfaceMockRecorder) StopContainer(id, timeout inter
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StopContainer", reflect.TypeOf((*MockDockerClientInterface)(nil).StopContainer), id, timeout)
 }
 
+// UnpauseContainer mocks base method.
+func (m *MockDockerClientInterface) UnpauseContainer(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnpauseContainer", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnpauseContainer indicates an expected call of UnpauseContainer.
+func (mr *MockDockerClientInterfaceMockRecorder) UnpauseContainer(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnpauseContainer", reflect.TypeOf((*MockDockerClientInterface)(nil).UnpauseContainer), id)
+}
+
 // UpdateContainerResources mocks base method.
 func (m *MockDockerClientInterface) UpdateContainerResources(id string, updateConfig container.UpdateConfig) error {
 	m.ctrl.T.Helper()
@@ -17,6 +17,8 @@ limitations under the License.
 package metrics
 
 import (
+	"net"
+	"net/http"
 	"sync"
 	"time"
 
@@ -34,8 +36,15 @@ const (
 	// DockerOperationsTimeoutKey is the key for the operation timeout metrics.
 	DockerOperationsTimeoutKey = "docker_operations_timeout_total"
 
+	// CRIOperationsLatencyKey is the key for the CRI operation latency metrics.
+	CRIOperationsLatencyKey = "cri_operations_duration_seconds"
+	// DockerAPIErrorsKey is the key for the docker API error metrics.
+	DockerAPIErrorsKey = "docker_api_errors_total"
+
 	// Keep the "kubelet" subsystem for backward compatibility.
 	kubeletSubsystem = "kubelet"
+	// criDockerdSubsystem is the subsystem of the metrics specific to cri-dockerd.
+	criDockerdSubsystem = "cri_dockerd"
 )
 
 var (
@@ -82,6 +91,28 @@ var (
 		},
 		[]string{"operation_type"},
 	)
+	// CRIOperationsLatency collects the latency of the CRI calls by operation
+	// and outcome.
+	CRIOperationsLatency = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      criDockerdSubsystem,
+			Name:           CRIOperationsLatencyKey,
+			Help:           "Latency in seconds of CRI operations. Broken down by operation and outcome.",
+			Buckets:        metrics.DefBuckets,
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation", "outcome"},
+	)
+	// DockerAPIErrors collects the errors returned by docker by error type.
+	DockerAPIErrors = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      criDockerdSubsystem,
+			Name:           DockerAPIErrorsKey,
+			Help:           "Cumulative number of Docker API errors by error type.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"error_type"},
+	)
 )
 
 var registerMetrics sync.Once
@@ -93,9 +124,19 @@ func Register() {
 		legacyregistry.MustRegister(DockerOperations)
 		legacyregistry.MustRegister(DockerOperationsErrors)
 		legacyregistry.MustRegister(DockerOperationsTimeout)
+		legacyregistry.MustRegister(CRIOperationsLatency)
+		legacyregistry.MustRegister(DockerAPIErrors)
 	})
 }
 
+// Serve serves the registered metrics on /metrics for the connections of the
+// given listener.
+func Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	return http.Serve(l, mux)
+}
+
 // SinceInSeconds gets the time since the specified start in seconds.
 func SinceInSeconds(start time.Time) float64 {
 	return time.Since(start).Seconds()
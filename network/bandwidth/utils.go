@@ -35,32 +35,57 @@ func validateBandwidthIsReasonable(rsrc *resource.Quantity) error {
 	return nil
 }
 
+const (
+	ingressBandwidthAnnotation = "kubernetes.io/ingress-bandwidth"
+	egressBandwidthAnnotation  = "kubernetes.io/egress-bandwidth"
+)
+
+// extractBandwidthResource parses and validates the bandwidth found in the given
+// pod annotation. It returns nil if the annotation is not set.
+func extractBandwidthResource(podAnnotations map[string]string, key string) (*resource.Quantity, error) {
+	str, found := podAnnotations[key]
+	if !found {
+		return nil, nil
+	}
+	value, err := resource.ParseQuantity(str)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateBandwidthIsReasonable(&value); err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
 // ExtractPodBandwidthResources extracts the ingress and egress from the given pod annotations
 func ExtractPodBandwidthResources(podAnnotations map[string]string) (ingress, egress *resource.Quantity, err error) {
 	if podAnnotations == nil {
 		return nil, nil, nil
 	}
-	str, found := podAnnotations["kubernetes.io/ingress-bandwidth"]
-	if found {
-		ingressValue, err := resource.ParseQuantity(str)
-		if err != nil {
-			return nil, nil, err
-		}
-		ingress = &ingressValue
-		if err := validateBandwidthIsReasonable(ingress); err != nil {
-			return nil, nil, err
-		}
+	ingress, err = extractBandwidthResource(podAnnotations, ingressBandwidthAnnotation)
+	if err != nil {
+		return nil, nil, err
 	}
-	str, found = podAnnotations["kubernetes.io/egress-bandwidth"]
-	if found {
-		egressValue, err := resource.ParseQuantity(str)
-		if err != nil {
-			return nil, nil, err
-		}
-		egress = &egressValue
-		if err := validateBandwidthIsReasonable(egress); err != nil {
-			return nil, nil, err
-		}
+	egress, err = extractBandwidthResource(podAnnotations, egressBandwidthAnnotation)
+	if err != nil {
+		return nil, nil, err
 	}
 	return ingress, egress, nil
 }
+
+// ExtractValidPodBandwidthResources extracts the ingress and egress from the given
+// pod annotations. Unlike ExtractPodBandwidthResources, a malformed or unreasonable
+// value only discards that value; the errors are returned alongside.
+func ExtractValidPodBandwidthResources(
+	podAnnotations map[string]string,
+) (ingress, egress *resource.Quantity, errs []error) {
+	ingress, err := extractBandwidthResource(podAnnotations, ingressBandwidthAnnotation)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s annotation: %v", ingressBandwidthAnnotation, err))
+	}
+	egress, err = extractBandwidthResource(podAnnotations, egressBandwidthAnnotation)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s annotation: %v", egressBandwidthAnnotation, err))
+	}
+	return ingress, egress, errs
+}
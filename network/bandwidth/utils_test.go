@@ -88,3 +88,46 @@ func TestExtractPodBandwidthResources(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractValidPodBandwidthResources(t *testing.T) {
+	ten, _ := resource.ParseQuantity("10M")
+
+	tests := []struct {
+		annotations     map[string]string
+		expectedIngress *resource.Quantity
+		expectedEgress  *resource.Quantity
+		expectedErrors  int
+	}{
+		{
+			annotations: nil,
+		},
+		{
+			annotations: map[string]string{
+				"kubernetes.io/ingress-bandwidth": "foo",
+				"kubernetes.io/egress-bandwidth":  "10M",
+			},
+			expectedEgress: &ten,
+			expectedErrors: 1,
+		},
+		{
+			annotations: map[string]string{
+				"kubernetes.io/ingress-bandwidth": "10M",
+				"kubernetes.io/egress-bandwidth":  "1",
+			},
+			expectedIngress: &ten,
+			expectedErrors:  1,
+		},
+	}
+	for _, test := range tests {
+		ingress, egress, errs := ExtractValidPodBandwidthResources(test.annotations)
+		if len(errs) != test.expectedErrors {
+			t.Errorf("expected %d errors, saw: %v", test.expectedErrors, errs)
+		}
+		if !reflect.DeepEqual(ingress, test.expectedIngress) {
+			t.Errorf("expected: %v, saw: %v", test.expectedIngress, ingress)
+		}
+		if !reflect.DeepEqual(egress, test.expectedEgress) {
+			t.Errorf("expected: %v, saw: %v", test.expectedEgress, egress)
+		}
+	}
+}
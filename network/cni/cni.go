@@ -61,6 +61,8 @@ var cniLogMessage sync.Once
 type cniNetworkPlugin struct {
 	network.NoopNetworkPlugin
 
+	// loNetwork brings up the loopback interface of the pods, if they are not
+	// left to the default network.
 	loNetwork *cniNetwork
 
 	sync.RWMutex
@@ -73,6 +75,9 @@ type cniNetworkPlugin struct {
 	binDirs     []string
 	cacheDir    string
 	podCidr     string
+
+	// operationTimeout is the deadline of each CNI operation.
+	operationTimeout time.Duration
 }
 
 type cniNetwork struct {
@@ -130,8 +135,16 @@ func SplitDirs(dirs string) []string {
 	return strings.Split(dirs, ",")
 }
 
-// ProbeNetworkPlugins : get the network plugin based on cni conf file and bin file
-func ProbeNetworkPlugins(confDir, cacheDir string, binDirs []string) []network.NetworkPlugin {
+// ProbeNetworkPlugins : get the network plugin based on cni conf file and bin file.
+// A zero operationTimeout defaults to network.CNITimeoutSec. Unless
+// manageLoopback is set, the loopback interface of the pods is left to the
+// CNI network config.
+func ProbeNetworkPlugins(
+	confDir, cacheDir string,
+	binDirs []string,
+	operationTimeout time.Duration,
+	manageLoopback bool,
+) []network.NetworkPlugin {
 	old := binDirs
 	binDirs = make([]string, 0, len(binDirs))
 	for _, dir := range old {
@@ -142,11 +155,18 @@ func ProbeNetworkPlugins(confDir, cacheDir string, binDirs []string) []network.N
 
 	plugin := &cniNetworkPlugin{
 		defaultNetwork: nil,
-		loNetwork:      getLoNetwork(binDirs),
 		execer:         utilexec.New(),
 		confDir:        confDir,
 		binDirs:        binDirs,
 		cacheDir:       cacheDir,
+
+		operationTimeout: operationTimeout,
+	}
+	if plugin.operationTimeout <= 0 {
+		plugin.operationTimeout = network.CNITimeoutSec * time.Second
+	}
+	if manageLoopback {
+		plugin.loNetwork = getLoNetwork(binDirs)
 	}
 
 	// sync NetworkConfig in best effort during probing.
@@ -335,13 +355,13 @@ func (plugin *cniNetworkPlugin) SetUpPod(
 	// Todo get the timeout from parent ctx
 	cniTimeoutCtx, cancelFunc := context.WithTimeout(
 		context.Background(),
-		network.CNITimeoutSec*time.Second,
+		plugin.operationTimeout,
 	)
 	defer cancelFunc()
 	// Windows doesn't have loNetwork. It comes only with Linux
 	if plugin.loNetwork != nil {
 		if _, err = plugin.addToNetwork(cniTimeoutCtx, plugin.loNetwork, name, namespace, id, netnsPath, annotations, options); err != nil {
-			return err
+			return plugin.setUpPodError(cniTimeoutCtx, namespace, name, id, netnsPath, annotations, err)
 		}
 	}
 
@@ -355,7 +375,48 @@ func (plugin *cniNetworkPlugin) SetUpPod(
 		annotations,
 		options,
 	)
-	return err
+	if err != nil {
+		return plugin.setUpPodError(cniTimeoutCtx, namespace, name, id, netnsPath, annotations, err)
+	}
+	return nil
+}
+
+// setUpPodError returns the error of a failed CNI ADD. If the ADD timed out,
+// the plugins may have left a partial setup behind, so the pod is removed from
+// the networks in a best effort before returning a timeout error.
+func (plugin *cniNetworkPlugin) setUpPodError(
+	ctx context.Context,
+	namespace string,
+	name string,
+	id config.ContainerID,
+	netnsPath string,
+	annotations map[string]string,
+	err error,
+) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	cleanupCtx, cancelFunc := context.WithTimeout(
+		context.Background(),
+		plugin.operationTimeout,
+	)
+	defer cancelFunc()
+	if err := plugin.deleteFromNetwork(cleanupCtx, plugin.getDefaultNetwork(), name, namespace, id, netnsPath, annotations); err != nil {
+		logrus.Errorf("CNI failed to clean up pod %s/%s after a timed out ADD: %v", namespace, name, err)
+	}
+	if plugin.loNetwork != nil {
+		if err := plugin.deleteFromNetwork(cleanupCtx, plugin.loNetwork, name, namespace, id, netnsPath, nil); err != nil {
+			logrus.Errorf("CNI failed to delete loopback network: %v", err)
+		}
+	}
+	return fmt.Errorf(
+		"CNI ADD of pod %s/%s timed out after %v: %v",
+		namespace,
+		name,
+		plugin.operationTimeout,
+		err,
+	)
 }
 
 func (plugin *cniNetworkPlugin) TearDownPod(
@@ -376,7 +437,7 @@ func (plugin *cniNetworkPlugin) TearDownPod(
 	// Todo get the timeout from parent ctx
 	cniTimeoutCtx, cancelFunc := context.WithTimeout(
 		context.Background(),
-		network.CNITimeoutSec*time.Second,
+		plugin.operationTimeout,
 	)
 	defer cancelFunc()
 	// Windows doesn't have loNetwork. It comes only with Linux
@@ -524,9 +585,10 @@ func (plugin *cniNetworkPlugin) buildCNIRuntimeConf(
 		portMappingsCapability: portMappingsParam,
 	}
 
-	ingress, egress, err := bandwidth.ExtractPodBandwidthResources(annotations)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get pod bandwidth from annotations: %v", err)
+	// Invalid bandwidth annotations should not prevent the pod from starting.
+	ingress, egress, errs := bandwidth.ExtractValidPodBandwidthResources(annotations)
+	for _, err := range errs {
+		logrus.Warningf("Ignoring bandwidth of pod %s/%s: %v", podNs, podName, err)
 	}
 	if ingress != nil || egress != nil {
 		bandwidthParam := cniBandwidthEntry{}
@@ -20,9 +20,11 @@ package cni
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net"
 	"os"
@@ -30,11 +32,14 @@ import (
 	"reflect"
 	"testing"
 	"text/template"
+	"time"
 
 	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
 
 	"github.com/Mirantis/cri-dockerd/config"
 
+	"github.com/containernetworking/cni/libcni"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 	types020 "github.com/containernetworking/cni/pkg/types/020"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -248,7 +253,7 @@ func TestCNIPlugin(t *testing.T) {
 		NetnsPath: "/proc/12345/ns/net",
 	}}
 
-	plugins := ProbeNetworkPlugins(testConfDir, testCacheDir, []string{testBinDir})
+	plugins := ProbeNetworkPlugins(testConfDir, testCacheDir, []string{testBinDir}, 0, true)
 	if len(plugins) != 1 {
 		t.Fatalf("Expected only one network plugin, got %d", len(plugins))
 	}
@@ -430,3 +435,167 @@ func TestLoNetNonNil(t *testing.T) {
 		t.Error("Expected non-nil lo network")
 	}
 }
+
+// TestManageLoopback tests that the loopback interface of the pods is brought
+// up and down along with their network only if the loopback is managed.
+func TestManageLoopback(t *testing.T) {
+	containerID := config.ContainerID{Type: "docker", ID: "test_infra_container"}
+	pods := []*containertest.FakePod{{
+		Pod: &kubecontainer.Pod{
+			Containers: []*kubecontainer.Container{
+				{ID: kubecontainer.ContainerID(containerID)},
+			},
+		},
+		NetnsPath: "/proc/12345/ns/net",
+	}}
+	for desc, manageLoopback := range map[string]bool{
+		"managed loopback":   true,
+		"unmanaged loopback": false,
+	} {
+		t.Logf("TestCase: %s", desc)
+		plugins := ProbeNetworkPlugins(t.TempDir(), t.TempDir(), nil, 0, manageLoopback)
+		require.Len(t, plugins, 1)
+		plugin := plugins[0].(*cniNetworkPlugin)
+		plugin.host = NewFakeHost(nil, pods, nil)
+
+		netConf := &libcni.NetworkConfigList{
+			Name:    "test",
+			Plugins: []*libcni.NetworkConfig{{Network: &cnitypes.NetConf{Type: "test"}}},
+		}
+		mockCNI := &mock_cni.MockCNI{}
+		plugin.defaultNetwork = &cniNetwork{
+			name:          netConf.Name,
+			NetworkConfig: netConf,
+			CNIConfig:     mockCNI,
+		}
+		mockCNI.On("AddNetworkList", mock.Anything, netConf, mock.Anything).
+			Return(&types020.Result{}, nil)
+		mockCNI.On("DelNetworkList", mock.Anything, netConf, mock.Anything).Return(nil)
+
+		mockLoCNI := &mock_cni.MockCNI{}
+		if manageLoopback {
+			require.NotNil(t, plugin.loNetwork)
+			plugin.loNetwork.CNIConfig = mockLoCNI
+			mockLoCNI.On("AddNetworkList", mock.Anything, plugin.loNetwork.NetworkConfig, mock.Anything).
+				Return(&types020.Result{}, nil)
+			mockLoCNI.On("DelNetworkList", mock.Anything, plugin.loNetwork.NetworkConfig, mock.Anything).
+				Return(nil)
+		} else {
+			require.Nil(t, plugin.loNetwork)
+		}
+
+		require.NoError(t, plugin.SetUpPod("podNamespace", "podName", containerID, nil, nil))
+		require.NoError(t, plugin.TearDownPod("podNamespace", "podName", containerID))
+		mockCNI.AssertExpectations(t)
+		mockLoCNI.AssertExpectations(t)
+	}
+}
+
+// TestSetUpPodTimeout tests that a CNI ADD exceeding the operation timeout
+// fails with a timeout error, after the pod is removed from the network.
+func TestSetUpPodTimeout(t *testing.T) {
+	containerID := config.ContainerID{Type: "docker", ID: "test_infra_container"}
+	pods := []*containertest.FakePod{{
+		Pod: &kubecontainer.Pod{
+			Containers: []*kubecontainer.Container{
+				{ID: kubecontainer.ContainerID(containerID)},
+			},
+		},
+		NetnsPath: "/proc/12345/ns/net",
+	}}
+	netConf := &libcni.NetworkConfigList{
+		Name:    "test",
+		Plugins: []*libcni.NetworkConfig{{Network: &cnitypes.NetConf{Type: "slow"}}},
+	}
+	mockCNI := &mock_cni.MockCNI{}
+	plugin := &cniNetworkPlugin{
+		defaultNetwork: &cniNetwork{
+			name:          netConf.Name,
+			NetworkConfig: netConf,
+			CNIConfig:     mockCNI,
+		},
+		host:             NewFakeHost(nil, pods, nil),
+		operationTimeout: 50 * time.Millisecond,
+	}
+
+	mockCNI.On(
+		"AddNetworkList",
+		mock.Anything,
+		netConf,
+		mock.AnythingOfType("*libcni.RuntimeConf"),
+	).Run(func(args mock.Arguments) {
+		// Hang until the deadline, like a misbehaving plugin.
+		ctx := args.Get(0).(context.Context)
+		<-ctx.Done()
+	}).Return((*types020.Result)(nil), context.DeadlineExceeded)
+	mockCNI.On(
+		"DelNetworkList",
+		mock.Anything,
+		netConf,
+		mock.AnythingOfType("*libcni.RuntimeConf"),
+	).Run(func(args mock.Arguments) {
+		// The cleanup must not inherit the expired deadline of the ADD.
+		ctx := args.Get(0).(context.Context)
+		if err := ctx.Err(); err != nil {
+			t.Errorf("Unexpected expired context for the DEL: %v", err)
+		}
+	}).Return(nil)
+
+	err := plugin.SetUpPod("podNamespace", "podName", containerID, nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out after 50ms")
+	mockCNI.AssertExpectations(t)
+}
+
+func TestBuildCNIRuntimeConfBandwidth(t *testing.T) {
+	plugin := &cniNetworkPlugin{host: NewFakeHost(nil, nil, nil)}
+	containerID := config.ContainerID{Type: "docker", ID: "test_infra_container"}
+
+	rt, err := plugin.buildCNIRuntimeConf(
+		"podName",
+		"podNamespace",
+		containerID,
+		"/proc/12345/ns/net",
+		map[string]string{
+			"kubernetes.io/ingress-bandwidth": "1M",
+			"kubernetes.io/egress-bandwidth":  "2M",
+		},
+		nil,
+	)
+	require.NoError(t, err)
+	require.Equal(t, cniBandwidthEntry{
+		IngressRate:  1000000,
+		IngressBurst: math.MaxInt32,
+		EgressRate:   2000000,
+		EgressBurst:  math.MaxInt32,
+	}, rt.CapabilityArgs[bandwidthCapability])
+
+	// A malformed value is skipped without failing the sandbox setup.
+	rt, err = plugin.buildCNIRuntimeConf(
+		"podName",
+		"podNamespace",
+		containerID,
+		"/proc/12345/ns/net",
+		map[string]string{
+			"kubernetes.io/ingress-bandwidth": "foo",
+			"kubernetes.io/egress-bandwidth":  "2M",
+		},
+		nil,
+	)
+	require.NoError(t, err)
+	require.Equal(t, cniBandwidthEntry{
+		EgressRate:  2000000,
+		EgressBurst: math.MaxInt32,
+	}, rt.CapabilityArgs[bandwidthCapability])
+
+	rt, err = plugin.buildCNIRuntimeConf(
+		"podName",
+		"podNamespace",
+		containerID,
+		"/proc/12345/ns/net",
+		map[string]string{"kubernetes.io/ingress-bandwidth": "foo"},
+		nil,
+	)
+	require.NoError(t, err)
+	require.NotContains(t, rt.CapabilityArgs, bandwidthCapability)
+}
@@ -23,7 +23,6 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"time"
 
 	cniTypes020 "github.com/containernetworking/cni/pkg/types/020"
 	"github.com/sirupsen/logrus"
@@ -63,7 +62,7 @@ func (plugin *cniNetworkPlugin) GetPodNetworkStatus(
 	// Todo get the timeout from parent ctx
 	cniTimeoutCtx, cancelFunc := context.WithTimeout(
 		context.Background(),
-		network.CNITimeoutSec*time.Second,
+		plugin.operationTimeout,
 	)
 	defer cancelFunc()
 	result, err := plugin.addToNetwork(
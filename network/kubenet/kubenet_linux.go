@@ -112,7 +112,9 @@ type kubenetNetworkPlugin struct {
 	binDirs           []string
 	nonMasqueradeCIDR string
 	cacheDir          string
-	podCIDRs          []*net.IPNet
+	// podCIDR is the pod CIDR last received from the runtime config
+	podCIDR  string
+	podCIDRs []*net.IPNet
 }
 
 func NewPlugin(networkPluginDirs []string, cacheDir string) network.NetworkPlugin {
@@ -255,8 +257,8 @@ func (plugin *kubenetNetworkPlugin) Event(name string, details map[string]interf
 		return
 	}
 
-	if plugin.netConfig != nil {
-		logrus.Infof("Ignoring subsequent pod CIDR update to new cidr %s", podCIDR)
+	if plugin.netConfig != nil && podCIDR == plugin.podCIDR {
+		logrus.Debugf("Ignoring unchanged pod CIDR %s", podCIDR)
 		return
 	}
 
@@ -271,6 +273,7 @@ func (plugin *kubenetNetworkPlugin) Event(name string, details map[string]interf
 		podCIDRs = podCIDRs[0:1]
 	}
 
+	cidrs := make([]*net.IPNet, 0, len(podCIDRs))
 	for idx, currentPodCIDR := range podCIDRs {
 		_, cidr, err := net.ParseCIDR(currentPodCIDR)
 		if nil != err {
@@ -283,9 +286,15 @@ func (plugin *kubenetNetworkPlugin) Event(name string, details map[string]interf
 			return
 		}
 		// create list of ips
-		plugin.podCIDRs = append(plugin.podCIDRs, cidr)
+		cidrs = append(cidrs, cidr)
 	}
 
+	// A changed pod CIDR replaces the previous ranges, so that subsequent
+	// sandboxes get addresses from the newly assigned range.
+	updated := plugin.netConfig != nil
+	plugin.podCIDR = podCIDR
+	plugin.podCIDRs = cidrs
+
 	//setup hairpinMode
 	setHairpin := plugin.hairpinMode == config.HairpinVeth
 
@@ -307,6 +316,12 @@ func (plugin *kubenetNetworkPlugin) Event(name string, details map[string]interf
 		// we bail out by clearing the *entire* list
 		// of addresses assigned to cbr0
 		plugin.clearUnusedBridgeAddresses()
+		return
+	}
+	if updated {
+		logrus.Infof("Kubenet: PodCIDR updated to %s", podCIDR)
+		// drop the gateway addresses of the previous ranges from cbr0
+		plugin.clearUnusedBridgeAddresses()
 	}
 }
 
@@ -17,6 +17,7 @@ limitations under the License.
 package kubenet
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
@@ -422,3 +423,41 @@ func TestGetRangesConfig(t *testing.T) {
 		assert.Equal(t, test.ranges, fakeKubenet.getRangesConfig())
 	}
 }
+
+func TestPodCIDRChangeUpdatesBridgeConfig(t *testing.T) {
+	kubenet := newFakeKubenetPlugin(map[config.ContainerID]utilsets.String{}, nil, nil)
+
+	rangesOf := func() []string {
+		var conf struct {
+			IPAM struct {
+				Ranges [][]struct {
+					Subnet string `json:"subnet"`
+				} `json:"ranges"`
+			} `json:"ipam"`
+		}
+		if !assert.NotNil(t, kubenet.netConfig) {
+			return nil
+		}
+		assert.NoError(t, json.Unmarshal(kubenet.netConfig.Bytes, &conf))
+		var subnets []string
+		for _, r := range conf.IPAM.Ranges {
+			for _, s := range r {
+				subnets = append(subnets, s.Subnet)
+			}
+		}
+		return subnets
+	}
+
+	for _, cidr := range []string{"10.0.1.0/24", "10.0.1.0/24", "10.0.2.0/24"} {
+		kubenet.Event(network.NET_PLUGIN_EVENT_POD_CIDR_CHANGE, map[string]interface{}{
+			network.NET_PLUGIN_EVENT_POD_CIDR_CHANGE_DETAIL_CIDR: cidr,
+		})
+		assert.Equal(t, []string{cidr}, rangesOf())
+	}
+
+	// An unparsable update keeps the previous config.
+	kubenet.Event(network.NET_PLUGIN_EVENT_POD_CIDR_CHANGE, map[string]interface{}{
+		network.NET_PLUGIN_EVENT_POD_CIDR_CHANGE_DETAIL_CIDR: "bogus",
+	})
+	assert.Equal(t, []string{"10.0.2.0/24"}, rangesOf())
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package streaming
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// maxBufferedExecOutput is how much exec output is buffered before it is
+// flushed without waiting for the flush interval.
+const maxBufferedExecOutput = 32 * 1024
+
+// flushingWriter coalesces the writes to an exec output stream: the output is
+// buffered and flushed once the interval elapsed since its first write, or as
+// soon as the buffer is full.
+type flushingWriter struct {
+	w        io.WriteCloser
+	interval time.Duration
+
+	// lock protects the fields below.
+	lock  sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	err   error
+}
+
+func newFlushingWriter(w io.WriteCloser, interval time.Duration) *flushingWriter {
+	return &flushingWriter{w: w, interval: interval}
+}
+
+func (f *flushingWriter) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.buf = append(f.buf, p...)
+	if len(f.buf) >= maxBufferedExecOutput {
+		if err := f.flushLocked(); err != nil {
+			return 0, err
+		}
+	} else if f.timer == nil {
+		f.timer = time.AfterFunc(f.interval, func() { f.Flush() })
+	}
+	return len(p), nil
+}
+
+// Flush writes the buffered output to the stream.
+func (f *flushingWriter) Flush() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.flushLocked()
+}
+
+func (f *flushingWriter) flushLocked() error {
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	if f.err != nil || len(f.buf) == 0 {
+		return f.err
+	}
+	_, f.err = f.w.Write(f.buf)
+	f.buf = f.buf[:0]
+	return f.err
+}
+
+// Close flushes the buffered output, then closes the stream.
+func (f *flushingWriter) Close() error {
+	err := f.Flush()
+	if closeErr := f.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
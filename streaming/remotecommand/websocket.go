@@ -18,6 +18,7 @@ package remotecommand
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -37,6 +38,11 @@ const (
 	preV4Base64WebsocketProtocol = wsstream.Base64ChannelWebSocketProtocol
 	v4BinaryWebsocketProtocol    = "v4." + wsstream.ChannelWebSocketProtocol
 	v4Base64WebsocketProtocol    = "v4." + wsstream.Base64ChannelWebSocketProtocol
+	v5BinaryWebsocketProtocol    = "v5." + wsstream.ChannelWebSocketProtocol
+
+	// streamCloseChannel is the channel on which the v5 protocol clients
+	// signal the close of a stream, the message carrying its channel.
+	streamCloseChannel = 255
 )
 
 // createChannels returns the standard channel types for a shell connection (STDIN 0, STDOUT 1, STDERR 2)
@@ -52,6 +58,15 @@ func createChannels(opts *Options) []wsstream.ChannelType {
 	return channels
 }
 
+// createV5Channels returns the channels of createChannels, along with the
+// stream close signal channel of the v5 protocol.
+func createV5Channels(opts *Options) []wsstream.ChannelType {
+	channels := make([]wsstream.ChannelType, streamCloseChannel+1)
+	copy(channels, createChannels(opts))
+	channels[streamCloseChannel] = wsstream.ReadChannel
+	return channels
+}
+
 // readChannel returns wsstream.ReadChannel if real is true, or wsstream.IgnoreChannel.
 func readChannel(real bool) wsstream.ChannelType {
 	if real {
@@ -68,9 +83,9 @@ func writeChannel(real bool) wsstream.ChannelType {
 	return wsstream.IgnoreChannel
 }
 
-// createWebSocketStreams returns a context containing the websocket connection and
+// createWebSocketStreams returns a connectionContext containing the websocket connection and
 // streams needed to perform an exec or an attach.
-func createWebSocketStreams(req *http.Request, w http.ResponseWriter, opts *Options, idleTimeout time.Duration) (*context, bool) {
+func createWebSocketStreams(req *http.Request, w http.ResponseWriter, opts *Options, idleTimeout time.Duration) (*connectionContext, bool) {
 	channels := createChannels(opts)
 	conn := wsstream.NewConn(map[string]wsstream.ChannelProtocolConfig{
 		"": {
@@ -93,6 +108,10 @@ func createWebSocketStreams(req *http.Request, w http.ResponseWriter, opts *Opti
 			Binary:   false,
 			Channels: channels,
 		},
+		v5BinaryWebsocketProtocol: {
+			Binary:   true,
+			Channels: createV5Channels(opts),
+		},
 	})
 	conn.SetIdleTimeout(idleTimeout)
 	negotiatedProtocol, streams, err := conn.Open(httplog.Unlogged(req, w), req)
@@ -112,7 +131,7 @@ func createWebSocketStreams(req *http.Request, w http.ResponseWriter, opts *Opti
 		streams[errorChannel].Write([]byte{})
 	}
 
-	ctx := &context{
+	ctx := &connectionContext{
 		conn:         conn,
 		stdinStream:  streams[stdinChannel],
 		stdoutStream: streams[stdoutChannel],
@@ -122,6 +141,9 @@ func createWebSocketStreams(req *http.Request, w http.ResponseWriter, opts *Opti
 	}
 
 	switch negotiatedProtocol {
+	case v5BinaryWebsocketProtocol:
+		ctx.writeStatus = v4WriteStatusFunc(streams[errorChannel])
+		go handleStreamCloseSignals(streams[streamCloseChannel], streams)
 	case v4BinaryWebsocketProtocol, v4Base64WebsocketProtocol:
 		ctx.writeStatus = v4WriteStatusFunc(streams[errorChannel])
 	default:
@@ -130,3 +152,23 @@ func createWebSocketStreams(req *http.Request, w http.ResponseWriter, opts *Opti
 
 	return ctx, true
 }
+
+// handleStreamCloseSignals closes the streams whose close is signaled by the
+// client, e.g. stdin once the client input is over, until the connection is
+// closed.
+func handleStreamCloseSignals(signals io.Reader, streams []io.ReadWriteCloser) {
+	defer runtime.HandleCrash()
+
+	buf := make([]byte, 16)
+	for {
+		n, err := signals.Read(buf)
+		for _, channel := range buf[:n] {
+			if channel != streamCloseChannel {
+				streams[channel].Close()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -30,14 +30,15 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	remotecommandserver "github.com/Mirantis/cri-dockerd/streaming/remotecommand"
 	restful "github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
 
 	"k8s.io/apimachinery/pkg/types"
 	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
 	"k8s.io/client-go/tools/remotecommand"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/kubernetes/pkg/kubelet/cri/streaming/portforward"
-	remotecommandserver "k8s.io/kubernetes/pkg/kubelet/cri/streaming/remotecommand"
 )
 
 // Server is the library interface to serve the stream requests.
@@ -93,6 +94,10 @@ type Config struct {
 
 	// The config for serving over TLS. If nil, TLS will not be used.
 	TLSConfig *tls.Config
+
+	// PortAutoSelect makes the server listen on an ephemeral port when the
+	// port of Addr is already in use, instead of failing.
+	PortAutoSelect bool
 }
 
 // DefaultConfig provides default values for server Config. The DefaultConfig is partial, so
@@ -237,18 +242,37 @@ func (s *server) Start(stayUp bool) error {
 		return errors.New("stayUp=false is not yet implemented")
 	}
 
-	listener, err := net.Listen("tcp", s.config.Addr)
+	listener, err := s.listen()
 	if err != nil {
 		return err
 	}
-	// Use the actual address as baseURL host. This handles the "0" port case.
-	s.config.BaseURL.Host = listener.Addr().String()
 	if s.config.TLSConfig != nil {
 		return s.server.ServeTLS(listener, "", "") // Use certs from TLSConfig.
 	}
 	return s.server.Serve(listener)
 }
 
+// listen listens on the address of the server, or on an ephemeral port if its
+// port is in use and the port may be auto selected.
+func (s *server) listen() (net.Listener, error) {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil && s.config.PortAutoSelect && isAddrInUse(err) {
+		host, _, splitErr := net.SplitHostPort(s.config.Addr)
+		if splitErr != nil {
+			return nil, err
+		}
+		logrus.Warnf("Streaming server address %s is in use, selecting another port", s.config.Addr)
+		listener, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Use the actual address as baseURL host. This handles the "0" port case,
+	// and the auto selected ports.
+	s.config.BaseURL.Host = listener.Addr().String()
+	return listener, nil
+}
+
 func (s *server) Stop() error {
 	return s.server.Close()
 }
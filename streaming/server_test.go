@@ -19,7 +19,9 @@ package streaming
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -30,9 +32,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
 	"k8s.io/client-go/tools/remotecommand"
 
 	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/transport/spdy"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
@@ -263,6 +267,50 @@ func TestGetPortForward(t *testing.T) {
 	}
 }
 
+func TestStartPortAutoSelect(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+	takenAddr := taken.Addr().String()
+
+	// Without auto selection, a port in use fails the server.
+	serv, err := NewServer(Config{Addr: takenAddr}, nil)
+	require.NoError(t, err)
+	_, err = serv.(*server).listen()
+	assert.Error(t, err)
+
+	serv, err = NewServer(Config{Addr: takenAddr, PortAutoSelect: true}, nil)
+	require.NoError(t, err)
+	listener, err := serv.(*server).listen()
+	require.NoError(t, err)
+	defer listener.Close()
+	boundAddr := listener.Addr().String()
+	assert.NotEqual(t, takenAddr, boundAddr)
+	host, _, err := net.SplitHostPort(boundAddr)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+
+	// The streaming URLs use the port bound.
+	execResp, err := serv.GetExec(&runtimeapi.ExecRequest{
+		ContainerId: testContainerID,
+		Cmd:         []string{"echo", "foo"},
+		Stdout:      true,
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(execResp.Url, "http://"+boundAddr+"/exec/"))
+	attachResp, err := serv.GetAttach(&runtimeapi.AttachRequest{
+		ContainerId: testContainerID,
+		Stdout:      true,
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(attachResp.Url, "http://"+boundAddr+"/attach/"))
+	portForwardResp, err := serv.GetPortForward(&runtimeapi.PortForwardRequest{
+		PodSandboxId: testPodSandboxID,
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(portForwardResp.Url, "http://"+boundAddr+"/portforward/"))
+}
+
 func TestServeExec(t *testing.T) {
 	runRemoteCommandTest(t, "exec")
 }
@@ -271,6 +319,53 @@ func TestServeAttach(t *testing.T) {
 	runRemoteCommandTest(t, "attach")
 }
 
+// TestServeExecWebSocket tests an exec session over the v5 websocket protocol,
+// with a resize event and the close of stdin signaled by the client.
+func TestServeExecWebSocket(t *testing.T) {
+	s, testServer := startTestServer(t)
+	defer testServer.Close()
+
+	resp, err := s.GetExec(&runtimeapi.ExecRequest{
+		ContainerId: testContainerID,
+		Cmd:         []string{"echo"},
+		Stdin:       true,
+		Stdout:      true,
+		Tty:         true,
+	})
+	require.NoError(t, err)
+	wsURL, err := url.Parse(resp.Url)
+	require.NoError(t, err)
+	wsURL.Scheme = "ws"
+	config, err := websocket.NewConfig(wsURL.String(), "http://localhost")
+	require.NoError(t, err)
+	config.Protocol = []string{"v5.channel.k8s.io"}
+	conn, err := websocket.DialConfig(config)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, []string{"v5.channel.k8s.io"}, conn.Config().Protocol)
+
+	// The server signals the session start on stdout.
+	var data []byte
+	require.NoError(t, websocket.Message.Receive(conn, &data))
+	assert.Equal(t, []byte{1}, data)
+
+	resize, err := json.Marshal(testTerminalSize)
+	require.NoError(t, err)
+	require.NoError(t, websocket.Message.Send(conn, append([]byte{4}, resize...)))
+	require.NoError(t, websocket.Message.Send(conn, append([]byte{0}, "exec"+testInput...)))
+	require.NoError(t, websocket.Message.Send(conn, []byte{255, 0}))
+
+	received := map[byte]string{}
+	for websocket.Message.Receive(conn, &data) == nil {
+		require.NotEmpty(t, data)
+		received[data[0]] += string(data[1:])
+	}
+	assert.Equal(t, "exec"+testOutput, received[1])
+	status := metav1.Status{}
+	require.NoError(t, json.Unmarshal([]byte(received[3]), &status))
+	assert.Equal(t, metav1.StatusSuccess, status.Status)
+}
+
 func TestServePortForward(t *testing.T) {
 	s, testServer := startTestServer(t)
 	defer testServer.Close()
@@ -404,6 +499,8 @@ const (
 	testPort   = 12345
 )
 
+var testTerminalSize = remotecommand.TerminalSize{Width: 80, Height: 24}
+
 func newFakeRuntime(t *testing.T) *fakeRuntime {
 	return &fakeRuntime{
 		t: t,
@@ -416,7 +513,15 @@ type fakeRuntime struct {
 
 func (f *fakeRuntime) Exec(ctx context.Context, containerID string, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
 	assert.Equal(f.t, testContainerID, containerID)
+	if tty {
+		assert.Equal(f.t, testTerminalSize, <-resize)
+	}
 	doServerStreams(f.t, "exec", stdin, stdout, stderr)
+	if tty {
+		// The client closes stdin once its input is sent.
+		_, err := stdin.Read(make([]byte, 1))
+		assert.Equal(f.t, io.EOF, err)
+	}
 	return nil
 }
 
@@ -21,8 +21,11 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/tools/remotecommand"
 
@@ -34,9 +37,22 @@ import (
 	"github.com/Mirantis/cri-dockerd/libdocker"
 )
 
+// initialResizeTimeout is how long attaching to a container with a TTY waits
+// for the initial terminal size, applied before the stream starts.
+const initialResizeTimeout = time.Second
+
 type StreamingRuntime struct {
 	Client      libdocker.DockerClientInterface
 	ExecHandler ExecHandler
+	// ExecOutputFlushInterval coalesces the output of the exec sessions
+	// without a TTY written within the interval. Zero disables the buffering.
+	ExecOutputFlushInterval time.Duration
+
+	// stdinOnceLock protects stdinOnceAttached.
+	stdinOnceLock sync.Mutex
+	// stdinOnceAttached holds the runs of the stdin_once containers whose
+	// stdin was attached, which docker closed once that attach ended.
+	stdinOnceAttached map[string]bool
 }
 
 // ExecHandler knows how to execute a command in a running Docker container.
@@ -65,6 +81,19 @@ func (r *StreamingRuntime) Exec(
 	tty bool,
 	resize <-chan remotecommand.TerminalSize,
 ) error {
+	// TTY sessions are interactive, their output is never delayed.
+	if !tty && r.ExecOutputFlushInterval > 0 {
+		if out != nil {
+			bufferedOut := newFlushingWriter(out, r.ExecOutputFlushInterval)
+			defer bufferedOut.Flush()
+			out = bufferedOut
+		}
+		if err != nil {
+			bufferedErr := newFlushingWriter(err, r.ExecOutputFlushInterval)
+			defer bufferedErr.Flush()
+			err = bufferedErr
+		}
+	}
 	return r.ExecWithContext(context.TODO(), containerID, cmd, in, out, err, tty, resize, 0)
 }
 
@@ -106,14 +135,47 @@ func (r *StreamingRuntime) Attach(
 	tty bool,
 	resize <-chan remotecommand.TerminalSize,
 ) error {
-	_, err := libdocker.CheckContainerStatus(r.Client, containerID)
+	container, err := libdocker.CheckContainerStatus(r.Client, containerID)
 	if err != nil {
 		return err
 	}
+	// The stdin of stdin_once containers can only be attached once per run: the
+	// write side of the stream is closed when the input ends, then docker
+	// closes the stdin of the container.
+	if in != nil && container.Config.StdinOnce && !r.claimStdinOnce(container) {
+		return fmt.Errorf("the stdin of container %q was already attached, and closed as stdin_once is set", containerID)
+	}
 
 	return attachContainer(r.Client, containerID, in, out, errw, tty, resize)
 }
 
+// claimStdinOnce records the attach of the stdin of the current run of the
+// container, returning false if it was already attached.
+func (r *StreamingRuntime) claimStdinOnce(container *dockertypes.ContainerJSON) bool {
+	key := container.ID + "/" + container.State.StartedAt
+	r.stdinOnceLock.Lock()
+	defer r.stdinOnceLock.Unlock()
+	if r.stdinOnceAttached[key] {
+		return false
+	}
+	if r.stdinOnceAttached == nil {
+		r.stdinOnceAttached = make(map[string]bool)
+	}
+	r.stdinOnceAttached[key] = true
+	return true
+}
+
+// ForgetContainer drops what is known about the removed container.
+func (r *StreamingRuntime) ForgetContainer(containerID string) {
+	r.stdinOnceLock.Lock()
+	defer r.stdinOnceLock.Unlock()
+	for key := range r.stdinOnceAttached {
+		if strings.HasPrefix(key, containerID+"/") {
+			delete(r.stdinOnceAttached, key)
+		}
+	}
+}
+
 func (r *StreamingRuntime) PortForward(
 	ctx context.Context,
 	podSandboxID string,
@@ -134,11 +196,25 @@ func attachContainer(
 	tty bool,
 	resize <-chan remotecommand.TerminalSize,
 ) error {
+	resizeFunc := func(size remotecommand.TerminalSize) {
+		if err := client.ResizeContainerTTY(containerID, uint(size.Height), uint(size.Width)); err != nil {
+			logrus.Debugf("Failed to resize the TTY of container %s: %v", containerID, err)
+		}
+	}
+	// The client sends the initial terminal size right away, apply it before
+	// attaching so that the first output is laid out for the right size.
+	if tty && resize != nil {
+		select {
+		case size, ok := <-resize:
+			if ok && size.Height > 0 && size.Width > 0 {
+				resizeFunc(size)
+			}
+		case <-time.After(initialResizeTimeout):
+		}
+	}
 	// Have to start this before the call to client.AttachToContainer because client.AttachToContainer is a blocking
 	// call :-( Otherwise, resize events don't get processed and the terminal never resizes.
-	handleResizing(resize, func(size remotecommand.TerminalSize) {
-		client.ResizeContainerTTY(containerID, uint(size.Height), uint(size.Width))
-	})
+	handleResizing(resize, resizeFunc)
 
 	opts := dockercontainer.AttachOptions{
 		Stream: true,
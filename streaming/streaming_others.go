@@ -20,14 +20,22 @@ package streaming
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os/exec"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 )
 
+// isAddrInUse tells whether the listen error is due to the address being in
+// use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
 func (r *StreamingRuntime) portForward(
 	podSandboxID string,
 	port int32,
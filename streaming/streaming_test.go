@@ -0,0 +1,197 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+func TestAttachContainerResize(t *testing.T) {
+	client := libdocker.NewFakeDockerClient()
+	resize := make(chan remotecommand.TerminalSize, 1)
+	defer close(resize)
+	resize <- remotecommand.TerminalSize{Width: 80, Height: 24}
+
+	require.NoError(t, attachContainer(client, testContainerID, nil, nil, nil, true, resize))
+	// The initial size is applied before attaching.
+	assert.NoError(t, client.AssertCallDetails(
+		libdocker.NewCalledDetail("resize_container", []interface{}{testContainerID, uint(24), uint(80)}),
+		libdocker.NewCalledDetail("attach", nil),
+	))
+
+	resize <- remotecommand.TerminalSize{Width: 120, Height: 40}
+	assert.Eventually(t, func() bool {
+		return client.AssertCallDetails(
+			libdocker.NewCalledDetail("resize_container", []interface{}{testContainerID, uint(24), uint(80)}),
+			libdocker.NewCalledDetail("attach", nil),
+			libdocker.NewCalledDetail("resize_container", []interface{}{testContainerID, uint(40), uint(120)}),
+		) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAttachStdinOnce(t *testing.T) {
+	client := libdocker.NewFakeDockerClient()
+	startedAt := time.Now()
+	client.SetFakeContainers([]*libdocker.FakeContainer{{
+		ID:        testContainerID,
+		Running:   true,
+		StartedAt: startedAt,
+		Config:    &dockercontainer.Config{OpenStdin: true, StdinOnce: true},
+	}})
+	r := &StreamingRuntime{Client: client}
+	attach := func(stdin io.Reader) error {
+		return r.Attach(context.Background(), testContainerID, stdin, nil, nil, false, nil)
+	}
+
+	// The first stdin attach streams the input until it ends.
+	stdin := strings.NewReader("input")
+	require.NoError(t, attach(stdin))
+	assert.Zero(t, stdin.Len())
+
+	// The stdin is then closed, and can't be attached again.
+	assert.Error(t, attach(strings.NewReader("more input")))
+	assert.NoError(t, attach(nil))
+
+	// The next run of the container has a new stdin.
+	client.SetFakeContainers([]*libdocker.FakeContainer{{
+		ID:        testContainerID,
+		Running:   true,
+		StartedAt: startedAt.Add(time.Minute),
+		Config:    &dockercontainer.Config{OpenStdin: true, StdinOnce: true},
+	}})
+	assert.NoError(t, attach(strings.NewReader("input")))
+
+	// Without stdin_once, the stdin can be attached again.
+	client.SetFakeContainers([]*libdocker.FakeContainer{{
+		ID:      testContainerID,
+		Running: true,
+		Config:  &dockercontainer.Config{OpenStdin: true},
+	}})
+	assert.NoError(t, attach(strings.NewReader("input")))
+	assert.NoError(t, attach(strings.NewReader("input")))
+}
+
+// burstyExecHandler writes its output in many small writes.
+type burstyExecHandler struct {
+	writes int
+}
+
+func (h *burstyExecHandler) ExecInContainer(
+	_ context.Context,
+	_ libdocker.DockerClientInterface,
+	_ *dockertypes.ContainerJSON,
+	_ []string,
+	_ io.Reader,
+	stdout, _ io.WriteCloser,
+	_ bool,
+	_ <-chan remotecommand.TerminalSize,
+	_ time.Duration,
+) error {
+	for i := 0; i < h.writes; i++ {
+		if _, err := stdout.Write([]byte("line\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// frameCounter records the frames written to an exec output stream.
+type frameCounter struct {
+	lock   sync.Mutex
+	frames int
+	data   bytes.Buffer
+}
+
+func (c *frameCounter) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.frames++
+	return c.data.Write(p)
+}
+
+func (c *frameCounter) Close() error {
+	return nil
+}
+
+func TestExecOutputBuffering(t *testing.T) {
+	const writes = 100
+	for desc, test := range map[string]struct {
+		flushInterval  time.Duration
+		tty            bool
+		expectedFrames int
+	}{
+		"unbuffered": {
+			expectedFrames: writes,
+		},
+		"buffered": {
+			flushInterval:  time.Minute,
+			expectedFrames: 1,
+		},
+		"buffering skipped for a TTY": {
+			flushInterval:  time.Minute,
+			tty:            true,
+			expectedFrames: writes,
+		},
+	} {
+		t.Logf("TestCase: %s", desc)
+		client := libdocker.NewFakeDockerClient()
+		client.SetFakeContainers([]*libdocker.FakeContainer{{ID: testContainerID, Running: true}})
+		r := &StreamingRuntime{
+			Client:                  client,
+			ExecHandler:             &burstyExecHandler{writes: writes},
+			ExecOutputFlushInterval: test.flushInterval,
+		}
+		stdout := &frameCounter{}
+		err := r.Exec(context.Background(), testContainerID, []string{"cmd"}, nil, stdout, nil, test.tty, nil)
+		require.NoError(t, err)
+		assert.Equal(t, test.expectedFrames, stdout.frames)
+		assert.Equal(t, strings.Repeat("line\n", writes), stdout.data.String())
+	}
+}
+
+func TestFlushingWriterFlushesOnIdle(t *testing.T) {
+	stdout := &frameCounter{}
+	w := newFlushingWriter(stdout, 10*time.Millisecond)
+	_, err := w.Write([]byte("one\n"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("two\n"))
+	require.NoError(t, err)
+
+	// The buffered output is flushed once the interval elapses, without
+	// waiting for more output.
+	assert.Eventually(t, func() bool {
+		stdout.lock.Lock()
+		defer stdout.lock.Unlock()
+		return stdout.frames == 1 && stdout.data.String() == "one\ntwo\n"
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, w.Close())
+	assert.Equal(t, 1, stdout.frames)
+}
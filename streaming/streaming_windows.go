@@ -21,12 +21,20 @@ package streaming
 import (
 	"bytes"
     "context"
+	"errors"
 	"fmt"
 	"io"
 
     "github.com/Mirantis/cri-dockerd/utils"
+	"golang.org/x/sys/windows"
 )
 
+// isAddrInUse tells whether the listen error is due to the address being in
+// use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, windows.WSAEADDRINUSE)
+}
+
 func (r *StreamingRuntime) portForward(
 	podSandboxID string,
 	port int32,